@@ -1,10 +1,18 @@
 package api
 
 import (
+	"core-auth/config"
+	"core-auth/handlers/admin"
 	"core-auth/handlers/auth"
 	"core-auth/handlers/health"
 	"core-auth/handlers/user"
+	"core-auth/internal/identifier"
+	"core-auth/internal/mailer"
+	"core-auth/internal/mfa"
 	"core-auth/internal/oauth2"
+	"core-auth/internal/passwordpolicy"
+	"core-auth/internal/verification"
+	"log"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
@@ -13,19 +21,43 @@ import (
 
 func SetupRoutes(router *gin.Engine, db *gorm.DB, rdb *redis.Client) {
 	// Initialize handlers
-	userHandler := user.NewUserHandler(db)
+	cfg, err := config.LoadFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	m, err := mailer.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to create mailer: %v", err)
+	}
+	passwordPolicy := passwordpolicy.New()
+	userHandler := user.NewUserHandler(db, verification.NewEmailVerifier(db, m), passwordPolicy, identifier.New())
 	healthHandler := health.NewHealthHandler(db, rdb)
-	authHandler := auth.NewAuthHandler(db)
-	oauth2Handler := auth.NewOAuth2ServerHandler(oauth2.NewServer(rdb, db), oauth2.NewManager(rdb, db), db, rdb)
+	mfaService := mfa.NewService(db, rdb)
+	authHandler := auth.NewAuthHandler(db, mfaService)
+	mfaHandler := auth.NewMFAHandler(mfaService)
+	oauth2Server := oauth2.NewServer(rdb, db)
+	oauth2Handler := auth.NewOAuth2ServerHandler(oauth2Server, oauth2.NewManager(rdb, db), db, rdb)
+	clientHandler := admin.NewClientHandler(oauth2Server)
+	adminMFAHandler := admin.NewMFAHandler(mfaService)
+	passwordHandler := auth.NewPasswordHandler(db, verification.NewPasswordResetter(db, m), oauth2Server, passwordPolicy)
 	// --- Health check ---
 	router.GET("/health", healthHandler.Check)
 	// --- Traditional Auth (Login, Refresh for UI/Direct Users) ---
 	authGroup := router.Group("/auth")
 	{
 		authGroup.POST("/login", authHandler.Login)
+		authGroup.POST("/login/mfa", authHandler.LoginMFA)
+		authGroup.POST("/login/mfa/webauthn/options", authHandler.BeginPasskeyMFA)
+		authGroup.POST("/login/mfa/webauthn", authHandler.LoginPasskeyMFA)
+		authGroup.POST("/login/passkey/options", authHandler.BeginPasskeyLogin)
+		authGroup.POST("/login/passkey", authHandler.LoginPasskey)
 		authGroup.POST("/register", userHandler.CreateUser)
+		authGroup.GET("/verify-email", userHandler.VerifyEmail)
+		authGroup.POST("/verify-email/resend", userHandler.ResendVerification)
+		authGroup.POST("/password/forgot", passwordHandler.ForgotPassword)
+		authGroup.POST("/password/reset", passwordHandler.ResetPassword)
 		authGroup.POST("/refresh", authHandler.RefreshToken)
-		// authGroup.POST("/logout", authHandler.Logout)
+		authGroup.POST("/logout", authHandler.RequireUser(), oauth2Handler.Logout)
 	}
 	// --- OAuth2 Server Endpoints ---
 	oauth2Group := router.Group("/oauth2")
@@ -36,11 +68,87 @@ func SetupRoutes(router *gin.Engine, db *gorm.DB, rdb *redis.Client) {
 		// Authorization callback (Step B)
 		oauth2Group.GET("/callback", oauth2Handler.Authorize)
 		
+		// Pushed authorization request endpoint (RFC 9126)
+		if oauth2Server.PAREnabled() {
+			oauth2Group.POST("/par", oauth2Handler.PushedAuthorizationRequest)
+		}
+
+		// Consent for authorization requests, decided by the signed in user
+		oauth2Group.GET("/consent", authHandler.RequireUser(), oauth2Handler.Consent)
+		oauth2Group.POST("/consent", authHandler.RequireUser(), oauth2Handler.ConsentDecision)
+
+		// RP-initiated logout (OpenID Connect RP-Initiated Logout)
+		oauth2Group.GET("/logout", oauth2Handler.EndSession)
+		oauth2Group.POST("/logout", oauth2Handler.EndSession)
+		oauth2Group.GET("/logout/request", authHandler.RequireUser(), oauth2Handler.LogoutConfirmation)
+
 		// Token endpoint (Step D)
 		oauth2Group.POST("/token", oauth2Handler.Token)
 		
+		// Token introspection (RFC 7662)
+		oauth2Group.POST("/introspect", oauth2Handler.Introspect)
+
+		// Keys verifying JWT access tokens (RFC 9068)
+		oauth2Group.GET("/jwks", oauth2Handler.JWKS)
+
+		// Claims about the user of an access token (OpenID Connect Core section 5.3)
+		oauth2Group.GET("/userinfo", oauth2Handler.RequireToken(), oauth2Handler.UserInfo)
+		oauth2Group.POST("/userinfo", oauth2Handler.RequireToken(), oauth2Handler.UserInfo)
+
 		// Token validation (Step F)
-		oauth2Group.GET("/validate", oauth2Handler.Token)
+		oauth2Group.GET("/validate", oauth2Handler.RequireToken(), oauth2Handler.Validate)
+	}
+
+	// --- Authorization server metadata (RFC 8414) ---
+	router.GET(oauth2.MetadataPath, oauth2Handler.Metadata(router))
+
+	// --- Signed in user ---
+	meGroup := router.Group("/users/me", authHandler.RequireUser())
+	{
+		// Applications holding tokens for the user
+		meGroup.GET("/connected-apps", oauth2Handler.ConnectedApps)
+		meGroup.DELETE("/connected-apps/:client_id", oauth2Handler.RevokeConnectedApp)
+
+		// Password change, other sessions are signed out
+		meGroup.POST("/password", passwordHandler.ChangePassword)
+
+		// TOTP multi-factor authentication, enabled once confirmed with a first code
+		meGroup.POST("/mfa/totp", mfaHandler.EnrollTOTP)
+		meGroup.POST("/mfa/totp/confirm", mfaHandler.ConfirmTOTP)
+		meGroup.DELETE("/mfa/totp", mfaHandler.DisableTOTP)
+
+		// WebAuthn passkeys, second factors that can also log in without a password
+		meGroup.GET("/passkeys", mfaHandler.ListPasskeys)
+		meGroup.POST("/passkeys/options", mfaHandler.BeginPasskeyRegistration)
+		meGroup.POST("/passkeys", mfaHandler.RegisterPasskey)
+		meGroup.DELETE("/passkeys/:id", mfaHandler.DeletePasskey)
+		// Passkey assertions re-authenticating before sensitive changes, in place of a code
+		meGroup.POST("/reauthentication/webauthn/options", mfaHandler.BeginPasskeyReauthentication)
+
+		// One-time MFA recovery codes, shown only when generated
+		meGroup.GET("/mfa/recovery-codes", mfaHandler.RecoveryCodes)
+		meGroup.POST("/mfa/recovery-codes", mfaHandler.RegenerateRecoveryCodes)
+	}
+
+	// --- Administration ---
+	adminGroup := router.Group("/admin", authHandler.RequireUser(), authHandler.RequireAdmin())
+	{
+		// OAuth2 client lifecycle, changes apply on every instance at once
+		adminGroup.GET("/oauth2/clients", clientHandler.ListClients)
+		adminGroup.POST("/oauth2/clients", clientHandler.CreateClient)
+		adminGroup.GET("/oauth2/clients/:client_id", clientHandler.GetClient)
+		adminGroup.PUT("/oauth2/clients/:client_id", clientHandler.UpdateClient)
+		adminGroup.DELETE("/oauth2/clients/:client_id", clientHandler.DeleteClient)
+		adminGroup.POST("/oauth2/clients/:client_id/disable", clientHandler.DisableClient)
+		adminGroup.POST("/oauth2/clients/:client_id/enable", clientHandler.EnableClient)
+		adminGroup.POST("/oauth2/clients/:client_id/secret", clientHandler.ResetClientSecret)
+
+		// MFA of users who lost their second factors, reset after verifying their identity
+		adminGroup.GET("/users/:user_id/mfa", adminMFAHandler.GetMFA)
+		adminGroup.POST("/users/:user_id/mfa/reset", adminMFAHandler.ResetMFA)
+
+		// Last run of each maintenance job
+		adminGroup.GET("/maintenance", healthHandler.Maintenance)
 	}
 
 	// // User routes
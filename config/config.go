@@ -2,8 +2,11 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"strconv"
+	"strings"
 )
 
 // Config holds all configuration for the application
@@ -44,14 +47,161 @@ type Config struct {
 	} `json:"jwt"`
 
 	OAuth2Server struct {
+		Issuer               string   `json:"issuer"`
+		GrantTypes           []string `json:"grant_types"`
+		ResponseTypes        []string `json:"response_types"`
+		Scopes               []string `json:"scopes"`
 		AccessTokenDuration  int    `json:"access_token_duration"`  // in minutes
 		RefreshTokenDuration int    `json:"refresh_token_duration"` // in hours
+		RefreshSessionDuration int  `json:"refresh_session_duration"` // in hours, absolute lifetime of a refresh token chain, 0 for unlimited
+		SlidingRefreshExpiry bool   `json:"sliding_refresh_expiry"` // restart the refresh token lifetime on every use
 		AuthorizationCode   struct {
 			Length     int `json:"length"`
 			ExpiresIn  int `json:"expires_in"` // in minutes
 		} `json:"authorization_code"`
+		ErrorURI string `json:"error_uri"` // base URL of the error documentation
+		PairwiseSalt string `json:"pairwise_salt"` // salts pairwise subject identifiers
+		ConsentURL      string `json:"consent_url"`      // page where users review and approve authorization requests
+		ConsentLifetime int    `json:"consent_lifetime"` // in seconds
+		LogoutURL       string `json:"logout_url"`       // page where users confirm RP-initiated logouts
+		LogoutLifetime  int    `json:"logout_lifetime"`  // in seconds
+		BackchannelLogout struct {
+			Timeout       int `json:"timeout"`        // in seconds
+			MaxAttempts   int `json:"max_attempts"`
+			RetryInterval int `json:"retry_interval"` // in seconds, doubled after every failed attempt
+			Workers       int `json:"workers"`        // concurrent deliveries
+			QueueSize     int `json:"queue_size"`     // deliveries pending or awaiting a retry, more are dropped
+		} `json:"backchannel_logout"`
+		PAR struct {
+			Enabled   bool `json:"enabled"`
+			Required  bool `json:"required"`   // require PAR for every client
+			ExpiresIn int  `json:"expires_in"` // in seconds
+		} `json:"par"`
+		DPoP struct {
+			Enabled       bool     `json:"enabled"`
+			RequireNonce  bool     `json:"require_nonce"`
+			ProofLifetime int      `json:"proof_lifetime"` // in seconds
+			NonceLifetime int      `json:"nonce_lifetime"` // in seconds
+			SigningAlgs   []string `json:"signing_algs"`
+		} `json:"dpop"`
+		JAR struct {
+			Enabled           bool     `json:"enabled"`
+			SigningAlgs       []string `json:"signing_algs"`
+			MaxLifetime       int      `json:"max_lifetime"`        // in seconds, longest accepted exp - nbf
+			RequestURITimeout int      `json:"request_uri_timeout"` // in seconds
+		} `json:"jar"`
+		JWTBearer struct {
+			SigningAlgs  []string `json:"signing_algs"`
+			MaxLifetime  int      `json:"max_lifetime"`   // in seconds, longest accepted exp - iat
+			JWKSCacheTTL int      `json:"jwks_cache_ttl"` // in seconds
+			JWKSTimeout  int      `json:"jwks_timeout"`   // in seconds
+		} `json:"jwt_bearer"`
+		JWTAccessToken struct {
+			SigningAlg          string `json:"signing_alg"`           // RS256 or ES256
+			DefaultAudience     string `json:"default_audience"`      // aud of tokens issued without a resource
+			KeyRotationInterval int    `json:"key_rotation_interval"` // in hours
+			KeyRetention        int    `json:"key_retention"`         // in hours a rotated key stays published
+			KeyEncryptionSecret string `json:"key_encryption_secret"` // encrypts signing keys at rest
+		} `json:"jwt_access_token"`
 	} `json:"oauth2_server"`
 
+	Mail struct {
+		Driver    string `json:"driver"`     // smtp or file
+		From      string `json:"from"`
+		OutboxDir string `json:"outbox_dir"` // where the file driver writes messages
+		Workers   int    `json:"workers"`    // concurrent sends
+		QueueSize int    `json:"queue_size"` // messages waiting to be sent, more are rejected
+		SMTP      struct {
+			Host     string `json:"host"`
+			Port     int    `json:"port"`
+			Username string `json:"username"`
+			Password string `json:"password"`
+		} `json:"smtp"`
+	} `json:"mail"`
+
+	EmailVerification struct {
+		Secret         string `json:"secret"`          // signs verification links
+		URL            string `json:"url"`             // target of verification links, receives the token parameter
+		LinkLifetime   int    `json:"link_lifetime"`   // in hours
+		ResendInterval int    `json:"resend_interval"` // in seconds between two links sent to an account
+	} `json:"email_verification"`
+
+	Usernames struct {
+		MinLength int      `json:"min_length"`
+		MaxLength int      `json:"max_length"`
+		Reserved  []string `json:"reserved"` // names no account may register, nor anything looking like them
+	} `json:"usernames"`
+
+	PasswordHash struct {
+		Algorithm     string `json:"algorithm"`      // argon2id, bcrypt or pbkdf2, older hashes are upgraded on login
+		MaxConcurrent int    `json:"max_concurrent"` // hashes computed at once, bounding the memory argon2id takes
+		Argon2        struct {
+			Time       int `json:"time"`   // passes over memory
+			Memory     int `json:"memory"` // in KiB
+			Threads    int `json:"threads"`
+			KeyLength  int `json:"key_length"`
+			SaltLength int `json:"salt_length"`
+		} `json:"argon2"`
+		BcryptCost int `json:"bcrypt_cost"`
+		PBKDF2     struct {
+			Digest     string `json:"digest"` // sha256 or sha512
+			Iterations int    `json:"iterations"`
+			KeyLength  int    `json:"key_length"`
+			SaltLength int    `json:"salt_length"`
+		} `json:"pbkdf2"`
+	} `json:"password_hash"`
+
+	PasswordPolicy struct {
+		MinLength           int    `json:"min_length"`
+		MaxLength           int    `json:"max_length"`
+		RequireUppercase    bool   `json:"require_uppercase"`
+		RequireLowercase    bool   `json:"require_lowercase"`
+		RequireDigit        bool   `json:"require_digit"`
+		RequireSymbol       bool   `json:"require_symbol"`
+		MinCharacterClasses int    `json:"min_character_classes"` // of uppercase, lowercase, digits and symbols
+		DenylistFile        string `json:"denylist_file"`         // common passwords, one per line, in addition to the built-in list
+		RejectSimilar       bool   `json:"reject_similar"`        // reject passwords containing the username or email
+		BreachedPath        string `json:"breached_path"`         // HIBP SHA-1 data: a directory of prefix files or one HASH:COUNT file ordered by hash, empty to disable
+		BreachedMinCount    int    `json:"breached_min_count"`    // breaches a password must appear in to be rejected
+	} `json:"password_policy"`
+
+	PasswordReset struct {
+		URL             string `json:"url"`              // target of reset links, receives the token parameter
+		TokenLifetime   int    `json:"token_lifetime"`   // in minutes
+		RequestInterval int    `json:"request_interval"` // in seconds between two tokens sent to an account
+	} `json:"password_reset"`
+
+	PasswordChange struct {
+		ReauthWindow int `json:"reauth_window"` // in seconds after login during which the current password is not asked again
+		MaxAttempts  int `json:"max_attempts"`  // wrong current passwords before the session is ended
+	} `json:"password_change"`
+
+	MFA struct {
+		EncryptionSecret  string `json:"encryption_secret"`  // encrypts TOTP secrets at rest
+		Issuer            string `json:"issuer"`             // issuer shown by authenticator apps
+		ChallengeLifetime int    `json:"challenge_lifetime"` // in seconds to complete the second login step
+		MaxAttempts       int    `json:"max_attempts"`       // codes accepted per challenge before it is void
+		TOTPSkew          int    `json:"totp_skew"`          // time steps accepted before and after the current one
+	} `json:"mfa"`
+
+	WebAuthn struct {
+		RPID    string   `json:"rp_id"`   // relying party ID, the domain passkeys are bound to
+		RPName  string   `json:"rp_name"` // relying party name shown by authenticators
+		Origins []string `json:"origins"` // origins allowed to run ceremonies
+		Timeout int      `json:"timeout"` // in seconds to complete a ceremony
+	} `json:"webauthn"`
+
+	Maintenance struct {
+		Enabled                bool `json:"enabled"`
+		Interval               int  `json:"interval"`                // in seconds
+		LeaseTTL               int  `json:"lease_ttl"`               // in seconds
+		BatchSize              int  `json:"batch_size"`
+		TokenRetention         int  `json:"token_retention"`         // in hours after expiry
+		AuthorizationRetention int  `json:"authorization_retention"` // in hours after expiry
+		SessionRetention       int  `json:"session_retention"`       // in hours after expiry
+		RefreshTokenRetention  int  `json:"refresh_token_retention"` // in hours after expiry
+	} `json:"maintenance"`
+
 	Redis struct {
 		Addr     string `json:"addr"`     // e.g., "localhost:6379"
 		Password string `json:"password"` // empty if no password
@@ -73,7 +223,75 @@ func Load(path string) (*Config, error) {
 		return nil, err
 	}
 
-	return config, nil
+	return config, config.Validate()
+}
+
+// Validate checks the settings that have no safe fallback
+func (c *Config) Validate() error {
+	if err := c.dedicatedSecret("OAUTH2_JWT_KEY_ENCRYPTION_SECRET", c.OAuth2Server.JWTAccessToken.KeyEncryptionSecret); err != nil {
+		return err
+	}
+	if err := c.dedicatedSecret("OAUTH2_PAIRWISE_SALT", c.OAuth2Server.PairwiseSalt); err != nil {
+		return err
+	}
+	if err := c.dedicatedSecret("EMAIL_VERIFICATION_SECRET", c.EmailVerification.Secret); err != nil {
+		return err
+	}
+	if err := c.dedicatedSecret("MFA_ENCRYPTION_SECRET", c.MFA.EncryptionSecret); err != nil {
+		return err
+	}
+	if c.PasswordChange.MaxAttempts <= 0 {
+		return errors.New("password change max attempts must be positive")
+	}
+	if c.PasswordHash.MaxConcurrent <= 0 {
+		return errors.New("password hash max concurrent must be positive")
+	}
+	argon := c.PasswordHash.Argon2
+	if argon.Time <= 0 || argon.Threads <= 0 || argon.Threads > 255 || argon.KeyLength <= 0 {
+		return errors.New("argon2 time, threads and key length must be positive, threads at most 255")
+	}
+	if argon.Memory < 8*argon.Threads || argon.Memory > 1<<20 {
+		return errors.New("argon2 memory must be between 8 KiB per thread and 1 GiB")
+	}
+	if c.PasswordHash.BcryptCost < 4 || c.PasswordHash.BcryptCost > 31 {
+		return errors.New("bcrypt cost must be between 4 and 31")
+	}
+	if c.OAuth2Server.AccessTokenDuration > c.OAuth2Server.JWTAccessToken.KeyRetention*60 {
+		return errors.New("JWT key retention must be at least the access token duration, tokens outliving their key stop verifying")
+	}
+	if c.Mail.Workers <= 0 || c.Mail.QueueSize <= 0 {
+		return errors.New("mail workers and queue size must be positive")
+	}
+	if c.OAuth2Server.BackchannelLogout.Workers <= 0 {
+		return errors.New("back-channel logout workers must be positive")
+	}
+	if c.OAuth2Server.BackchannelLogout.QueueSize <= 0 {
+		return errors.New("back-channel logout queue size must be positive")
+	}
+	if c.Maintenance.Enabled {
+		if c.Maintenance.Interval <= 0 {
+			return errors.New("maintenance interval must be positive")
+		}
+		if c.Maintenance.LeaseTTL <= 0 {
+			return errors.New("maintenance lease TTL must be positive")
+		}
+		if c.Maintenance.BatchSize <= 0 {
+			return errors.New("maintenance batch size must be positive")
+		}
+	}
+	return nil
+}
+
+// dedicatedSecret checks that a secret is set and is not the JWT secret, so that leaking
+// one key does not compromise what the other protects
+func (c *Config) dedicatedSecret(name string, secret string) error {
+	if secret == "" {
+		return fmt.Errorf("%s is required", name)
+	}
+	if secret == c.JWT.Secret {
+		return fmt.Errorf("%s must differ from JWT_SECRET", name)
+	}
+	return nil
 }
 
 func getEnvOrDefault(key, defaultValue string) string {
@@ -92,6 +310,19 @@ func getEnvAsIntOrDefault(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getEnvAsSliceOrDefault(key string, defaultValue []string) []string {
+	if value, exists := os.LookupEnv(key); exists && value != "" {
+		var values []string
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		return values
+	}
+	return defaultValue
+}
+
 func getEnvAsBoolOrDefault(key string, defaultValue bool) bool {
 	if value, exists := os.LookupEnv(key); exists && value != "" {
 		if boolVal, err := strconv.ParseBool(value); err == nil {
@@ -136,15 +367,142 @@ func LoadFromEnv() (*Config, error) {
 	config.JWT.RefreshHours = getEnvAsIntOrDefault("JWT_REFRESH_HOURS", 168)
 
 	// OAuth2 server config
+	config.OAuth2Server.Issuer = getEnvOrDefault("OAUTH2_ISSUER", "http://localhost:8080")
+	config.OAuth2Server.GrantTypes = getEnvAsSliceOrDefault("OAUTH2_GRANT_TYPES", []string{"authorization_code", "client_credentials", "refresh_token", "urn:ietf:params:oauth:grant-type:jwt-bearer"})
+	config.OAuth2Server.ResponseTypes = getEnvAsSliceOrDefault("OAUTH2_RESPONSE_TYPES", []string{"code", "token"})
+	config.OAuth2Server.Scopes = getEnvAsSliceOrDefault("OAUTH2_SCOPES", []string{"openid", "profile", "email"})
 	config.OAuth2Server.AccessTokenDuration = getEnvAsIntOrDefault("OAUTH2_ACCESS_TOKEN_DURATION", 15)
 	config.OAuth2Server.RefreshTokenDuration = getEnvAsIntOrDefault("OAUTH2_REFRESH_TOKEN_DURATION", 24)
+	config.OAuth2Server.RefreshSessionDuration = getEnvAsIntOrDefault("OAUTH2_REFRESH_SESSION_DURATION", 0)
+	config.OAuth2Server.SlidingRefreshExpiry = getEnvAsBoolOrDefault("OAUTH2_SLIDING_REFRESH_EXPIRY", false)
 	config.OAuth2Server.AuthorizationCode.Length = getEnvAsIntOrDefault("OAUTH2_AUTHORIZATION_CODE_LENGTH", 16)
 	config.OAuth2Server.AuthorizationCode.ExpiresIn = getEnvAsIntOrDefault("OAUTH2_AUTHORIZATION_CODE_EXPIRES_IN", 15)
+	config.OAuth2Server.ErrorURI = getEnvOrDefault("OAUTH2_ERROR_URI", "")
+	config.OAuth2Server.PairwiseSalt = getEnvOrDefault("OAUTH2_PAIRWISE_SALT", "")
+	config.OAuth2Server.ConsentURL = getEnvOrDefault("OAUTH2_CONSENT_URL", "http://localhost:3000/consent")
+	config.OAuth2Server.ConsentLifetime = getEnvAsIntOrDefault("OAUTH2_CONSENT_LIFETIME", 600)
+	config.OAuth2Server.LogoutURL = getEnvOrDefault("OAUTH2_LOGOUT_URL", "http://localhost:3000/logout")
+	config.OAuth2Server.LogoutLifetime = getEnvAsIntOrDefault("OAUTH2_LOGOUT_LIFETIME", 600)
+	config.OAuth2Server.BackchannelLogout.Timeout = getEnvAsIntOrDefault("OAUTH2_BACKCHANNEL_LOGOUT_TIMEOUT", 5)
+	config.OAuth2Server.BackchannelLogout.MaxAttempts = getEnvAsIntOrDefault("OAUTH2_BACKCHANNEL_LOGOUT_MAX_ATTEMPTS", 5)
+	config.OAuth2Server.BackchannelLogout.RetryInterval = getEnvAsIntOrDefault("OAUTH2_BACKCHANNEL_LOGOUT_RETRY_INTERVAL", 2)
+	config.OAuth2Server.BackchannelLogout.Workers = getEnvAsIntOrDefault("OAUTH2_BACKCHANNEL_LOGOUT_WORKERS", 4)
+	config.OAuth2Server.BackchannelLogout.QueueSize = getEnvAsIntOrDefault("OAUTH2_BACKCHANNEL_LOGOUT_QUEUE_SIZE", 1000)
+	config.OAuth2Server.PAR.Enabled = getEnvAsBoolOrDefault("OAUTH2_PAR_ENABLED", true)
+	config.OAuth2Server.PAR.Required = getEnvAsBoolOrDefault("OAUTH2_PAR_REQUIRED", false)
+	config.OAuth2Server.PAR.ExpiresIn = getEnvAsIntOrDefault("OAUTH2_PAR_EXPIRES_IN", 60)
+	config.OAuth2Server.DPoP.Enabled = getEnvAsBoolOrDefault("OAUTH2_DPOP_ENABLED", true)
+	config.OAuth2Server.DPoP.RequireNonce = getEnvAsBoolOrDefault("OAUTH2_DPOP_REQUIRE_NONCE", false)
+	config.OAuth2Server.DPoP.ProofLifetime = getEnvAsIntOrDefault("OAUTH2_DPOP_PROOF_LIFETIME", 60)
+	config.OAuth2Server.DPoP.NonceLifetime = getEnvAsIntOrDefault("OAUTH2_DPOP_NONCE_LIFETIME", 300)
+	config.OAuth2Server.DPoP.SigningAlgs = getEnvAsSliceOrDefault("OAUTH2_DPOP_SIGNING_ALGS", []string{"ES256", "RS256", "PS256", "EdDSA"})
+	config.OAuth2Server.JAR.Enabled = getEnvAsBoolOrDefault("OAUTH2_JAR_ENABLED", true)
+	config.OAuth2Server.JAR.SigningAlgs = getEnvAsSliceOrDefault("OAUTH2_JAR_SIGNING_ALGS", []string{"RS256", "PS256", "ES256", "EdDSA"})
+	config.OAuth2Server.JAR.MaxLifetime = getEnvAsIntOrDefault("OAUTH2_JAR_MAX_LIFETIME", 3600)
+	config.OAuth2Server.JAR.RequestURITimeout = getEnvAsIntOrDefault("OAUTH2_JAR_REQUEST_URI_TIMEOUT", 5)
+	config.OAuth2Server.JWTBearer.SigningAlgs = getEnvAsSliceOrDefault("OAUTH2_JWT_BEARER_SIGNING_ALGS", []string{"RS256", "PS256", "ES256", "EdDSA"})
+	config.OAuth2Server.JWTBearer.MaxLifetime = getEnvAsIntOrDefault("OAUTH2_JWT_BEARER_MAX_LIFETIME", 3600)
+	config.OAuth2Server.JWTBearer.JWKSCacheTTL = getEnvAsIntOrDefault("OAUTH2_JWT_BEARER_JWKS_CACHE_TTL", 300)
+	config.OAuth2Server.JWTBearer.JWKSTimeout = getEnvAsIntOrDefault("OAUTH2_JWT_BEARER_JWKS_TIMEOUT", 5)
+	config.OAuth2Server.JWTAccessToken.SigningAlg = getEnvOrDefault("OAUTH2_JWT_SIGNING_ALG", "RS256")
+	config.OAuth2Server.JWTAccessToken.DefaultAudience = getEnvOrDefault("OAUTH2_JWT_DEFAULT_AUDIENCE", config.OAuth2Server.Issuer)
+	config.OAuth2Server.JWTAccessToken.KeyRotationInterval = getEnvAsIntOrDefault("OAUTH2_JWT_KEY_ROTATION_INTERVAL", 720)
+	config.OAuth2Server.JWTAccessToken.KeyRetention = getEnvAsIntOrDefault("OAUTH2_JWT_KEY_RETENTION", 24)
+	config.OAuth2Server.JWTAccessToken.KeyEncryptionSecret = getEnvOrDefault("OAUTH2_JWT_KEY_ENCRYPTION_SECRET", "")
+
+	// Mail config
+	config.Mail.Driver = getEnvOrDefault("MAIL_DRIVER", "file")
+	config.Mail.From = getEnvOrDefault("MAIL_FROM", "no-reply@localhost")
+	config.Mail.OutboxDir = getEnvOrDefault("MAIL_OUTBOX_DIR", "outbox")
+	config.Mail.Workers = getEnvAsIntOrDefault("MAIL_WORKERS", 2)
+	config.Mail.QueueSize = getEnvAsIntOrDefault("MAIL_QUEUE_SIZE", 1000)
+	config.Mail.SMTP.Host = getEnvOrDefault("MAIL_SMTP_HOST", "localhost")
+	config.Mail.SMTP.Port = getEnvAsIntOrDefault("MAIL_SMTP_PORT", 587)
+	config.Mail.SMTP.Username = getEnvOrDefault("MAIL_SMTP_USERNAME", "")
+	config.Mail.SMTP.Password = getEnvOrDefault("MAIL_SMTP_PASSWORD", "")
+
+	// Email verification config
+	config.EmailVerification.Secret = getEnvOrDefault("EMAIL_VERIFICATION_SECRET", "")
+	config.EmailVerification.URL = getEnvOrDefault("EMAIL_VERIFICATION_URL", strings.TrimRight(config.OAuth2Server.Issuer, "/")+"/auth/verify-email")
+	config.EmailVerification.LinkLifetime = getEnvAsIntOrDefault("EMAIL_VERIFICATION_LINK_LIFETIME", 48)
+	config.EmailVerification.ResendInterval = getEnvAsIntOrDefault("EMAIL_VERIFICATION_RESEND_INTERVAL", 300)
+
+	// Username config
+	config.Usernames.MinLength = getEnvAsIntOrDefault("USERNAME_MIN_LENGTH", 3)
+	config.Usernames.MaxLength = getEnvAsIntOrDefault("USERNAME_MAX_LENGTH", 32)
+	config.Usernames.Reserved = getEnvAsSliceOrDefault("USERNAME_RESERVED", []string{
+		"admin", "administrator", "root", "system", "support", "security", "abuse", "postmaster",
+		"hostmaster", "webmaster", "noreply", "no-reply", "api", "auth", "oauth", "login", "logout",
+		"me", "help", "null", "undefined",
+	})
+
+	// Password hash config
+	config.PasswordHash.Algorithm = getEnvOrDefault("PASSWORD_HASH_ALGORITHM", "argon2id")
+	config.PasswordHash.MaxConcurrent = getEnvAsIntOrDefault("PASSWORD_HASH_MAX_CONCURRENT", 4)
+	config.PasswordHash.Argon2.Time = getEnvAsIntOrDefault("PASSWORD_HASH_ARGON2_TIME", 3)
+	config.PasswordHash.Argon2.Memory = getEnvAsIntOrDefault("PASSWORD_HASH_ARGON2_MEMORY", 64*1024)
+	config.PasswordHash.Argon2.Threads = getEnvAsIntOrDefault("PASSWORD_HASH_ARGON2_THREADS", 2)
+	config.PasswordHash.Argon2.KeyLength = getEnvAsIntOrDefault("PASSWORD_HASH_ARGON2_KEY_LENGTH", 32)
+	config.PasswordHash.Argon2.SaltLength = getEnvAsIntOrDefault("PASSWORD_HASH_ARGON2_SALT_LENGTH", 16)
+	config.PasswordHash.BcryptCost = getEnvAsIntOrDefault("PASSWORD_HASH_BCRYPT_COST", 12)
+	config.PasswordHash.PBKDF2.Digest = getEnvOrDefault("PASSWORD_HASH_PBKDF2_DIGEST", "sha256")
+	config.PasswordHash.PBKDF2.Iterations = getEnvAsIntOrDefault("PASSWORD_HASH_PBKDF2_ITERATIONS", 600000)
+	config.PasswordHash.PBKDF2.KeyLength = getEnvAsIntOrDefault("PASSWORD_HASH_PBKDF2_KEY_LENGTH", 32)
+	config.PasswordHash.PBKDF2.SaltLength = getEnvAsIntOrDefault("PASSWORD_HASH_PBKDF2_SALT_LENGTH", 16)
+
+	// Password policy config
+	config.PasswordPolicy.MinLength = getEnvAsIntOrDefault("PASSWORD_MIN_LENGTH", 8)
+	config.PasswordPolicy.MaxLength = getEnvAsIntOrDefault("PASSWORD_MAX_LENGTH", 64)
+	config.PasswordPolicy.RequireUppercase = getEnvAsBoolOrDefault("PASSWORD_REQUIRE_UPPERCASE", false)
+	config.PasswordPolicy.RequireLowercase = getEnvAsBoolOrDefault("PASSWORD_REQUIRE_LOWERCASE", false)
+	config.PasswordPolicy.RequireDigit = getEnvAsBoolOrDefault("PASSWORD_REQUIRE_DIGIT", false)
+	config.PasswordPolicy.RequireSymbol = getEnvAsBoolOrDefault("PASSWORD_REQUIRE_SYMBOL", false)
+	config.PasswordPolicy.MinCharacterClasses = getEnvAsIntOrDefault("PASSWORD_MIN_CHARACTER_CLASSES", 0)
+	config.PasswordPolicy.DenylistFile = getEnvOrDefault("PASSWORD_DENYLIST_FILE", "")
+	config.PasswordPolicy.RejectSimilar = getEnvAsBoolOrDefault("PASSWORD_REJECT_SIMILAR", true)
+	config.PasswordPolicy.BreachedPath = getEnvOrDefault("PASSWORD_BREACHED_PATH", "")
+	config.PasswordPolicy.BreachedMinCount = getEnvAsIntOrDefault("PASSWORD_BREACHED_MIN_COUNT", 1)
+
+	// Password reset config
+	config.PasswordReset.URL = getEnvOrDefault("PASSWORD_RESET_URL", strings.TrimRight(config.OAuth2Server.Issuer, "/")+"/auth/password/reset")
+	config.PasswordReset.TokenLifetime = getEnvAsIntOrDefault("PASSWORD_RESET_TOKEN_LIFETIME", 30)
+	config.PasswordReset.RequestInterval = getEnvAsIntOrDefault("PASSWORD_RESET_REQUEST_INTERVAL", 60)
+
+	// Password change config
+	config.PasswordChange.ReauthWindow = getEnvAsIntOrDefault("PASSWORD_CHANGE_REAUTH_WINDOW", 300)
+	config.PasswordChange.MaxAttempts = getEnvAsIntOrDefault("PASSWORD_CHANGE_MAX_ATTEMPTS", 5)
+
+	// MFA config
+	config.MFA.EncryptionSecret = getEnvOrDefault("MFA_ENCRYPTION_SECRET", "")
+	config.MFA.Issuer = getEnvOrDefault("MFA_ISSUER", "core-auth")
+	config.MFA.ChallengeLifetime = getEnvAsIntOrDefault("MFA_CHALLENGE_LIFETIME", 300)
+	config.MFA.MaxAttempts = getEnvAsIntOrDefault("MFA_MAX_ATTEMPTS", 5)
+	config.MFA.TOTPSkew = getEnvAsIntOrDefault("MFA_TOTP_SKEW", 1)
+
+	// WebAuthn config, bound to the issuer's host by default
+	scheme, hostport, _ := strings.Cut(config.OAuth2Server.Issuer, "://")
+	hostport, _, _ = strings.Cut(hostport, "/")
+	issuerHost, _, _ := strings.Cut(hostport, ":")
+	issuerOrigin := scheme + "://" + hostport
+	config.WebAuthn.RPID = getEnvOrDefault("WEBAUTHN_RP_ID", issuerHost)
+	config.WebAuthn.RPName = getEnvOrDefault("WEBAUTHN_RP_NAME", config.MFA.Issuer)
+	config.WebAuthn.Origins = getEnvAsSliceOrDefault("WEBAUTHN_ORIGINS", []string{issuerOrigin})
+	config.WebAuthn.Timeout = getEnvAsIntOrDefault("WEBAUTHN_TIMEOUT", 300)
+
+	// Maintenance config
+	config.Maintenance.Enabled = getEnvAsBoolOrDefault("MAINTENANCE_ENABLED", true)
+	config.Maintenance.Interval = getEnvAsIntOrDefault("MAINTENANCE_INTERVAL", 900)
+	config.Maintenance.LeaseTTL = getEnvAsIntOrDefault("MAINTENANCE_LEASE_TTL", 300)
+	config.Maintenance.BatchSize = getEnvAsIntOrDefault("MAINTENANCE_BATCH_SIZE", 500)
+	config.Maintenance.TokenRetention = getEnvAsIntOrDefault("MAINTENANCE_TOKEN_RETENTION", 24)
+	config.Maintenance.AuthorizationRetention = getEnvAsIntOrDefault("MAINTENANCE_AUTHORIZATION_RETENTION", 24)
+	config.Maintenance.SessionRetention = getEnvAsIntOrDefault("MAINTENANCE_SESSION_RETENTION", 168)
+	config.Maintenance.RefreshTokenRetention = getEnvAsIntOrDefault("MAINTENANCE_REFRESH_TOKEN_RETENTION", 0)
 
 	// Redis config
 	config.Redis.Addr = getEnvOrDefault("REDIS_ADDR", "localhost:6379")
 	config.Redis.Password = getEnvOrDefault("REDIS_PASSWORD", "")
 	config.Redis.DB = getEnvAsIntOrDefault("REDIS_DB", 0)
 
-	return config, nil
+	return config, config.Validate()
 }
\ No newline at end of file
@@ -0,0 +1,84 @@
+package config
+
+import "testing"
+
+// setSecrets sets the secrets that have no default to test values
+func setSecrets(t *testing.T) {
+	t.Helper()
+	t.Setenv("OAUTH2_JWT_KEY_ENCRYPTION_SECRET", "test-key-encryption-secret")
+	t.Setenv("OAUTH2_PAIRWISE_SALT", "test-pairwise-salt")
+	t.Setenv("EMAIL_VERIFICATION_SECRET", "test-email-verification-secret")
+	t.Setenv("MFA_ENCRYPTION_SECRET", "test-mfa-encryption-secret")
+}
+
+func TestLoadFromEnvRejectsInvalidMaintenanceSettings(t *testing.T) {
+	setSecrets(t)
+	for _, key := range []string{"MAINTENANCE_INTERVAL", "MAINTENANCE_LEASE_TTL", "MAINTENANCE_BATCH_SIZE"} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, "0")
+			if _, err := LoadFromEnv(); err == nil {
+				t.Fatalf("%s=0 was accepted", key)
+			}
+		})
+	}
+}
+
+func TestLoadFromEnvAllowsDisabledMaintenance(t *testing.T) {
+	setSecrets(t)
+	t.Setenv("MAINTENANCE_ENABLED", "false")
+	t.Setenv("MAINTENANCE_INTERVAL", "0")
+	if _, err := LoadFromEnv(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadFromEnvRequiresDedicatedSecrets(t *testing.T) {
+	t.Setenv("JWT_SECRET", "jwt-secret")
+	for _, key := range []string{"OAUTH2_JWT_KEY_ENCRYPTION_SECRET", "OAUTH2_PAIRWISE_SALT", "EMAIL_VERIFICATION_SECRET", "MFA_ENCRYPTION_SECRET"} {
+		t.Run(key, func(t *testing.T) {
+			setSecrets(t)
+			t.Setenv(key, "")
+			if _, err := LoadFromEnv(); err == nil {
+				t.Fatalf("missing %s was accepted", key)
+			}
+			t.Setenv(key, "jwt-secret")
+			if _, err := LoadFromEnv(); err == nil {
+				t.Fatalf("%s equal to JWT_SECRET was accepted", key)
+			}
+		})
+	}
+}
+
+func TestLoadFromEnvRequiresKeyRetentionCoveringAccessTokens(t *testing.T) {
+	setSecrets(t)
+	t.Setenv("OAUTH2_JWT_KEY_RETENTION", "1")
+	t.Setenv("OAUTH2_ACCESS_TOKEN_DURATION", "60")
+	if _, err := LoadFromEnv(); err != nil {
+		t.Fatalf("access tokens expiring with their key rejected: %v", err)
+	}
+	t.Setenv("OAUTH2_ACCESS_TOKEN_DURATION", "61")
+	if _, err := LoadFromEnv(); err == nil {
+		t.Fatal("access tokens outliving their key accepted")
+	}
+}
+
+func TestLoadFromEnvRejectsInvalidPasswordHashSettings(t *testing.T) {
+	setSecrets(t)
+	for _, setting := range [][2]string{
+		{"PASSWORD_HASH_MAX_CONCURRENT", "0"},
+		{"PASSWORD_HASH_ARGON2_TIME", "0"},
+		{"PASSWORD_HASH_ARGON2_THREADS", "0"},
+		{"PASSWORD_HASH_ARGON2_MEMORY", "8"},
+		{"PASSWORD_HASH_ARGON2_KEY_LENGTH", "0"},
+		{"PASSWORD_HASH_BCRYPT_COST", "3"},
+		{"PASSWORD_HASH_BCRYPT_COST", "32"},
+	} {
+		key, value := setting[0], setting[1]
+		t.Run(key+"="+value, func(t *testing.T) {
+			t.Setenv(key, value)
+			if _, err := LoadFromEnv(); err == nil {
+				t.Fatalf("%s=%s was accepted", key, value)
+			}
+		})
+	}
+}
@@ -175,6 +175,11 @@ func InitDB() (*gorm.DB, error) {
 		panic(err)
 	}
 
+	// Run the one-off data migrations
+	if err := RunMigrations(db); err != nil {
+		panic(err)
+	}
+
 	// Initialize default roles
 	if err := InitializeRoles(db); err != nil {
 		panic(err)
@@ -1,21 +1,13 @@
 package database
 
 import (
-	"golang.org/x/crypto/bcrypt"
+	"core-auth/internal/passwordhash"
+
 	"gorm.io/gorm"
 )
-// HashPassword hashes a password using bcrypt
-func HashPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
-	}
-	return string(hashedPassword), nil
-}
-
 // UpdatePassword updates user's password with a new hashed password
 func UpdatePassword(db *gorm.DB, userID uint, newPassword string) error {
-	hashedPassword, err := HashPassword(newPassword)
+	hashedPassword, err := passwordhash.Hash(newPassword)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,129 @@
+package database
+
+import (
+	"core-auth/internal/identifier"
+	"errors"
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// identifierBatchSize is the number of users normalized at once by the backfill
+const identifierBatchSize = 500
+
+// setIdentifierKeys stores the normalized forms of a user's username and email address
+func setIdentifierKeys(user *User) {
+	username := identifier.UsernameKey(user.Username)
+	skeleton := identifier.Skeleton(user.Username)
+	email := identifier.EmailKey(user.Email)
+	user.UsernameCanonical = &username
+	user.UsernameSkeleton = &skeleton
+	user.EmailCanonical = &email
+}
+
+// GetUserByLogin retrieves a user by the identifier they log in with, their username or email
+// address in any case or Unicode variant. Identifiers with "@" are looked up as usernames too,
+// which could contain it before usernames were validated.
+func GetUserByLogin(db *gorm.DB, login string) (*User, error) {
+	if identifier.IsEmail(login) {
+		user, err := getUserByIdentifier(db, "email", login, identifier.EmailKey(login))
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return user, err
+		}
+	}
+	return getUserByIdentifier(db, "username", login, identifier.UsernameKey(login))
+}
+
+// getUserByIdentifier retrieves a user by the normalized form of an identifier. Users whose
+// identifier could not be normalized, because it conflicts with another account's, only match
+// the identifier as stored.
+func getUserByIdentifier(db *gorm.DB, column string, value string, key string) (*User, error) {
+	var user User
+	err := db.Where(column+"_canonical = ?", key).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = db.Where(column+"_canonical IS NULL AND "+column+" = ?", value).First(&user).Error
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// backfillIdentifiers stores the normalized usernames and email addresses of users registered
+// before identifiers were normalized. An identifier whose normalized form is already taken by
+// another account is left unset and recorded as an IdentifierConflict, the account keeps
+// logging in with the identifier exactly as stored until the conflict is resolved.
+func backfillIdentifiers(tx *gorm.DB) error {
+	var users []User
+	var failed error
+	result := tx.Where("username_canonical IS NULL OR username_skeleton IS NULL OR email_canonical IS NULL").
+		FindInBatches(&users, identifierBatchSize, func(_ *gorm.DB, _ int) error {
+			for i := range users {
+				if err := backfillUser(tx, &users[i]); err != nil {
+					failed = err
+					return err
+				}
+			}
+			return nil
+		})
+	if failed != nil {
+		return failed
+	}
+	return result.Error
+}
+
+// backfillUser stores each missing normalized identifier of a user that no other user has
+func backfillUser(tx *gorm.DB, user *User) error {
+	normalized := *user
+	setIdentifierKeys(&normalized)
+	columns := []struct {
+		name    string
+		current *string
+		key     string
+	}{
+		{"username_canonical", user.UsernameCanonical, *normalized.UsernameCanonical},
+		{"username_skeleton", user.UsernameSkeleton, *normalized.UsernameSkeleton},
+		{"email_canonical", user.EmailCanonical, *normalized.EmailCanonical},
+	}
+	for _, column := range columns {
+		if column.current != nil {
+			continue
+		}
+		stored, err := storeIdentifierKey(tx, user.ID, column.name, column.key)
+		if err != nil {
+			return err
+		}
+		if stored {
+			continue
+		}
+		log.Printf("Identifier backfill: %s %q of user %d conflicts with another user, left unset", column.name, column.key, user.ID)
+		if err := tx.Create(&IdentifierConflict{UserID: user.ID, Column: column.name, Key: column.key}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeIdentifierKey sets a normalized identifier of a user and reports whether it was free. The
+// update runs in a savepoint, so that losing the race for the unique key to a user registering
+// meanwhile leaves the backfill's transaction usable.
+func storeIdentifierKey(tx *gorm.DB, userID uint, column string, key string) (bool, error) {
+	taken := func() (bool, error) {
+		var count int64
+		err := tx.Model(&User{}).Where(column+" = ? AND id <> ?", key, userID).Count(&count).Error
+		return count > 0, err
+	}
+	if conflict, err := taken(); err != nil || conflict {
+		return false, err
+	}
+	err := tx.Transaction(func(sp *gorm.DB) error {
+		return sp.Model(&User{}).Where("id = ?", userID).UpdateColumn(column, key).Error
+	})
+	if err == nil {
+		return true, nil
+	}
+	if conflict, cerr := taken(); cerr == nil && conflict {
+		return false, nil
+	}
+	return false, err
+}
@@ -0,0 +1,117 @@
+package database_test
+
+import (
+	database "core-auth/db"
+	"core-auth/internal/testutil"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// createLegacyUser stores a user as registered before identifiers were normalized
+func createLegacyUser(t *testing.T, db *gorm.DB, username string, email string) *database.User {
+	t.Helper()
+	user := &database.User{Username: username, Email: email, Password: "-", IsActive: true}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestGetUserByLogin(t *testing.T) {
+	db := testutil.NewDB(t)
+	alice := createLegacyUser(t, db, "Alice", "Alice@Example.com")
+	legacy := createLegacyUser(t, db, "bob@home", "bob@example.com")
+	if err := database.RunMigrations(db); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		login string
+		want  uint
+	}{
+		{"alice", alice.ID},
+		{"ＡＬＩＣＥ", alice.ID},
+		{"alice@example.COM", alice.ID},
+		{"bob@example.com", legacy.ID},
+		// A username with "@", from before usernames were validated, is no email address
+		{"bob@home", legacy.ID},
+		{"BOB@HOME", legacy.ID},
+	}
+	for _, tt := range tests {
+		user, err := database.GetUserByLogin(db, tt.login)
+		if err != nil {
+			t.Errorf("GetUserByLogin(%q): %v", tt.login, err)
+			continue
+		}
+		if user.ID != tt.want {
+			t.Errorf("GetUserByLogin(%q) = user %d, want %d", tt.login, user.ID, tt.want)
+		}
+	}
+	if _, err := database.GetUserByLogin(db, "carol@example.com"); err != gorm.ErrRecordNotFound {
+		t.Errorf("unknown login error = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+}
+
+func TestIdentifierBackfillRecordsConflicts(t *testing.T) {
+	db := testutil.NewDB(t)
+	first := createLegacyUser(t, db, "Alice", "alice@example.com")
+	second := createLegacyUser(t, db, "alice", "ALICE@example.com")
+	third := createLegacyUser(t, db, "раураl", "paypal@example.com") // Cyrillic, looks like paypal
+	fourth := createLegacyUser(t, db, "paypal", "other@example.com")
+
+	// A second run, as on every later start, changes nothing
+	for i := 0; i < 2; i++ {
+		if err := database.RunMigrations(db); err != nil {
+			t.Fatalf("run %d: %v", i+1, err)
+		}
+	}
+
+	var conflicts []database.IdentifierConflict
+	if err := db.Order("id").Find(&conflicts).Error; err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		userID uint
+		column string
+	}{
+		{second.ID, "username_canonical"},
+		{second.ID, "username_skeleton"},
+		{second.ID, "email_canonical"},
+		{fourth.ID, "username_skeleton"},
+	}
+	if len(conflicts) != len(want) {
+		t.Fatalf("conflicts = %+v, want %+v", conflicts, want)
+	}
+	for i, conflict := range conflicts {
+		if conflict.UserID != want[i].userID || conflict.Column != want[i].column {
+			t.Errorf("conflict %d = user %d %s, want user %d %s", i, conflict.UserID, conflict.Column, want[i].userID, want[i].column)
+		}
+	}
+
+	// Identifiers not in conflict are normalized, those of the second alice name the first
+	// until the conflict is resolved
+	for _, user := range []*database.User{first, third, fourth} {
+		found, err := database.GetUserByLogin(db, user.Username)
+		if err != nil || found.ID != user.ID {
+			t.Errorf("GetUserByLogin(%q) = %v, %v, want user %d", user.Username, found, err, user.ID)
+		}
+	}
+}
+
+func TestLookAlikeUsernameTaken(t *testing.T) {
+	testutil.SetSecrets(t)
+	db := testutil.NewDB(t)
+	if err := database.CreateUser(db, &database.User{Username: "paypal", Email: "paypal@example.com", Password: "correct horse battery"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, username := range []string{"PayPal", "paypa1", "раураl", "paypaI"} {
+		if !database.CheckUsernameDB(db, username) {
+			t.Errorf("CheckUsernameDB(%q) = false, want taken", username)
+		}
+	}
+	if database.CheckUsernameDB(db, "paypals") {
+		t.Error("CheckUsernameDB(\"paypals\") = true, want free")
+	}
+}
@@ -0,0 +1,59 @@
+package database
+
+import (
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Migration records a one-off data migration that has run
+type Migration struct {
+	Name  string `gorm:"type:varchar(100);primaryKey"`
+	RunAt time.Time
+}
+
+// migrations are the one-off data migrations, run in order after the schema migration
+var migrations = []struct {
+	name string
+	run  func(tx *gorm.DB) error
+}{
+	{"hash_client_secrets", hashClientSecrets},
+	{"normalize_identifiers", backfillIdentifiers},
+}
+
+// RunMigrations runs the one-off data migrations not run yet. Each runs in a transaction
+// holding its record, so that concurrently starting instances run it only once.
+func RunMigrations(db *gorm.DB) error {
+	for _, migration := range migrations {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).
+				Create(&Migration{Name: migration.name, RunAt: time.Now()})
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			log.Printf("Running data migration %s", migration.name)
+			return migration.run(tx)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hashClientSecrets replaces the plaintext client secrets stored before secrets were hashed
+func hashClientSecrets(tx *gorm.DB) error {
+	var clients []OAuth2Client
+	if err := tx.Where("client_secret <> ''").Find(&clients).Error; err != nil {
+		return err
+	}
+	for _, client := range clients {
+		if err := tx.Model(&OAuth2Client{}).Where("id = ?", client.ID).
+			UpdateColumn("client_secret", HashClientSecret(client.ClientSecret)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
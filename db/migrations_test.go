@@ -0,0 +1,36 @@
+package database_test
+
+import (
+	database "core-auth/db"
+	"core-auth/internal/testutil"
+	"testing"
+)
+
+func TestRunMigrationsHashesClientSecretsOnce(t *testing.T) {
+	db := testutil.NewDB(t)
+	for _, client := range []database.OAuth2Client{
+		{ClientID: "confidential", ClientSecret: "plaintext-secret", IsActive: true},
+		{ClientID: "public", IsActive: true},
+	} {
+		if err := db.Create(&client).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A second run, as on every later start, must not hash the hash
+	for i := 0; i < 2; i++ {
+		if err := database.RunMigrations(db); err != nil {
+			t.Fatalf("run %d: %v", i+1, err)
+		}
+	}
+
+	var confidential, public database.OAuth2Client
+	db.Where("client_id = ?", "confidential").First(&confidential)
+	db.Where("client_id = ?", "public").First(&public)
+	if confidential.ClientSecret != database.HashClientSecret("plaintext-secret") {
+		t.Errorf("stored secret = %q, want the hash of the secret", confidential.ClientSecret)
+	}
+	if public.ClientSecret != "" {
+		t.Errorf("public client got secret %q", public.ClientSecret)
+	}
+}
@@ -0,0 +1,56 @@
+package database_test
+
+import (
+	database "core-auth/db"
+	"core-auth/internal/testutil"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+func TestCheckPasswordUpgradesLegacyHash(t *testing.T) {
+	testutil.SetSecrets(t)
+	db := testutil.NewDB(t)
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &database.User{Username: "alice", Email: "alice@example.com", Password: string(legacy), IsActive: true}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// A wrong password leaves the hash alone
+	if database.CheckPasswordDB(db, "alice", "wrong horse") {
+		t.Fatal("wrong password accepted")
+	}
+	if stored := storedPassword(t, db, user.ID); stored != string(legacy) {
+		t.Fatalf("hash replaced after a wrong password: %q", stored)
+	}
+
+	if !database.CheckPasswordDB(db, "alice", "correct horse") {
+		t.Fatal("password rejected")
+	}
+	upgraded := storedPassword(t, db, user.ID)
+	if !strings.HasPrefix(upgraded, "$argon2id$") {
+		t.Fatalf("hash not upgraded to argon2id: %q", upgraded)
+	}
+	if !database.CheckPasswordDB(db, "alice", "correct horse") {
+		t.Fatal("password rejected after the upgrade")
+	}
+	if stored := storedPassword(t, db, user.ID); stored != upgraded {
+		t.Error("current hash rehashed again")
+	}
+}
+
+// storedPassword returns the stored password hash of a user
+func storedPassword(t *testing.T, db *gorm.DB, userID uint) string {
+	t.Helper()
+	var user database.User
+	if err := db.First(&user, userID).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user.Password
+}
@@ -1,44 +1,43 @@
 package database
 
 import (
+	"core-auth/internal/identifier"
+	"core-auth/internal/passwordhash"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"log"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // CreateUser creates a new user in the database
 func CreateUser(db *gorm.DB, user *User) error {
 	// Hash the password before creating the user
-	hashedPassword, err := HashPassword(user.Password)
+	hashedPassword, err := passwordhash.Hash(user.Password)
 	if err != nil {
 		return err
 	}
 	user.Password = hashedPassword
+	setIdentifierKeys(user)
 	
 	return db.Create(user).Error
 }
 
-// GetUserByUsername retrieves a user by their username	
+// GetUserByUsername retrieves a user by their username, in any case or Unicode variant	
 func GetUserByUsername(db *gorm.DB, username string) (*User, error) {
-	var user User
-	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
-		return nil, err
-	}
-	return &user, nil
+	return getUserByIdentifier(db, "username", username, identifier.UsernameKey(username))
 }
 
-// GetUserByEmail retrieves a user by their email
+// GetUserByEmail retrieves a user by their email, in any case or Unicode variant
 func GetUserByEmail(db *gorm.DB, email string) (*User, error) {
-	var user User
-	if err := db.Where("email = ?", email).First(&user).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("user not found")
-		}
-		return nil, err
+	user, err := getUserByIdentifier(db, "email", email, identifier.EmailKey(email))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.New("user not found")
 	}
-	return &user, nil
+	return user, err
 }
 
 // GetUserByID retrieves a user by their ID
@@ -70,17 +69,37 @@ func CheckPasswordDB(db *gorm.DB, username, password string) bool {
 		return false
 	}
 	// fmt.Println("user.Password is ", user.Password)
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+	ok, rehash, err := passwordhash.Verify(password, user.Password)
+	if err != nil || !ok {
 		// fmt.Println("Error comparing passwords:", err)
 		return false
 	}
+	if rehash {
+		rehashPassword(db, &user, password)
+	}
 	return true
 }
 
+// rehashPassword upgrades the stored hash of a verified password to the configured algorithm
+// and parameters. The hash is only replaced if the password was not changed meanwhile, and a
+// failed upgrade is retried on the next login.
+func rehashPassword(db *gorm.DB, user *User, password string) {
+	hashedPassword, err := passwordhash.Hash(password)
+	if err != nil {
+		log.Printf("Failed to rehash password of user %d: %v", user.ID, err)
+		return
+	}
+	if err := db.Model(&User{}).Where("id = ? AND password = ?", user.ID, user.Password).
+		Update("password", hashedPassword).Error; err != nil {
+		log.Printf("Failed to store rehashed password of user %d: %v", user.ID, err)
+	}
+}
+
+// CheckUsernameDB reports whether a username, or one looking like it, is taken
 func CheckUsernameDB(db *gorm.DB, username string) bool {
-	var user User
-	db.First(&user, "username = ?", username)
-	return user.Username == username
+	var count int64
+	db.Model(&User{}).Where("username_skeleton = ? OR username = ?", identifier.Skeleton(username), username).Count(&count)
+	return count > 0
 }
 
 func UpdateRefreshToken(db *gorm.DB, username, refreshToken string, tokenExpiry time.Time) error {
@@ -117,13 +136,331 @@ func ValidateAccessToken(db *gorm.DB, accessToken string) (bool, error) {
 }
 
 func DeleteRefreshToken(db *gorm.DB, refreshToken string) error {
-	return db.Model(&User{}).Where("refresh_token = ?", refreshToken).Updates(User{
-		RefreshToken: "",
-		RefreshTokenExpiry: nil,
+	return db.Model(&User{}).Where("refresh_token = ?", refreshToken).Updates(map[string]interface{}{
+		"refresh_token":        "",
+		"refresh_token_expiry": nil,
+	}).Error
+}
+
+// MarkEmailVerified activates the account of a verified email address
+func MarkEmailVerified(db *gorm.DB, userID uint, email string) error {
+	now := time.Now()
+	return db.Model(&User{}).Where("id = ? AND email = ?", userID, email).Updates(map[string]interface{}{
+		"pending_email_verification": false,
+		"email_verified_at":          now,
 	}).Error
 }
 
+// ClaimVerificationSend records that a verification link is sent to a pending account, unless
+// one was sent less than interval ago. It reports whether the link may be sent.
+func ClaimVerificationSend(db *gorm.DB, userID uint, interval time.Duration) (bool, error) {
+	now := time.Now()
+	result := db.Model(&User{}).
+		Where("id = ? AND pending_email_verification = ? AND (verification_sent_at IS NULL OR verification_sent_at < ?)",
+			userID, true, now.Add(-interval)).
+		Update("verification_sent_at", now)
+	return result.RowsAffected == 1, result.Error
+}
+
+// CreatePasswordResetToken stores a password reset token and supersedes the unused tokens of
+// the user, unless one was created less than interval ago. It reports whether it was stored.
+// The user row stays locked until the token is stored, so that concurrent requests cannot
+// both pass the interval check.
+func CreatePasswordResetToken(db *gorm.DB, token *PasswordResetToken, interval time.Duration) (bool, error) {
+	created := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var user User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&user, token.UserID).Error; err != nil {
+			return err
+		}
+		var recent int64
+		if err := tx.Model(&PasswordResetToken{}).
+			Where("user_id = ? AND created_at > ?", token.UserID, time.Now().Add(-interval)).
+			Count(&recent).Error; err != nil {
+			return err
+		}
+		if recent > 0 {
+			return nil
+		}
+		if err := tx.Model(&PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL", token.UserID).
+			Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(token).Error; err != nil {
+			return err
+		}
+		created = true
+		return nil
+	})
+	return created, err
+}
+
+// GetPasswordResetToken retrieves an unused, unexpired password reset token by its hash
+func GetPasswordResetToken(db *gorm.DB, tokenHash string) (*PasswordResetToken, error) {
+	var token PasswordResetToken
+	err := db.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, time.Now()).First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// ConsumePasswordResetToken redeems an unused, unexpired password reset token by its hash.
+// Concurrent redemptions of the same token cannot both succeed.
+func ConsumePasswordResetToken(db *gorm.DB, tokenHash string) (*PasswordResetToken, error) {
+	now := time.Now()
+	result := db.Model(&PasswordResetToken{}).
+		Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, now).
+		Update("used_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	var token PasswordResetToken
+	if err := db.Where("token_hash = ?", tokenHash).First(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// GetTOTPCredential retrieves the TOTP authenticator of a user, confirmed or not
+func GetTOTPCredential(db *gorm.DB, userID uint) (*TOTPCredential, error) {
+	var credential TOTPCredential
+	if err := db.Where("user_id = ?", userID).First(&credential).Error; err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+// SaveTOTPCredential replaces the unconfirmed TOTP authenticator of a user
+func SaveTOTPCredential(db *gorm.DB, credential *TOTPCredential) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ? AND confirmed_at IS NULL", credential.UserID).
+			Delete(&TOTPCredential{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(credential).Error
+	})
+}
+
+// ClaimTOTPStep records that a code of the given time step was accepted for a user. It reports
+// false when a code of this or a later step was already accepted, so that no code is used twice.
+func ClaimTOTPStep(db *gorm.DB, userID uint, step int64) (bool, error) {
+	result := db.Model(&TOTPCredential{}).
+		Where("user_id = ? AND last_used_step < ?", userID, step).
+		Update("last_used_step", step)
+	return result.RowsAffected == 1, result.Error
+}
+
+// UpdateTOTPSecret replaces the encrypted secret of a user's TOTP authenticator
+func UpdateTOTPSecret(db *gorm.DB, userID uint, secret string) error {
+	return db.Model(&TOTPCredential{}).Where("user_id = ?", userID).Update("secret", secret).Error
+}
+
+// ConfirmTOTPCredential enables the TOTP authenticator of a user and MFA on the account
+func ConfirmTOTPCredential(db *gorm.DB, userID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&TOTPCredential{}).Where("user_id = ?", userID).
+			Update("confirmed_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return refreshMFAEnabled(tx, userID)
+	})
+}
+
+// DeleteTOTPCredential removes the TOTP authenticator of a user and disables MFA on the account
+func DeleteTOTPCredential(db *gorm.DB, userID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&TOTPCredential{}).Error; err != nil {
+			return err
+		}
+		return refreshMFAEnabled(tx, userID)
+	})
+}
+
+// refreshMFAEnabled enables MFA on an account while it has a confirmed authenticator or a passkey
+func refreshMFAEnabled(tx *gorm.DB, userID uint) error {
+	var factors int64
+	if err := tx.Model(&TOTPCredential{}).Where("user_id = ? AND confirmed_at IS NOT NULL", userID).
+		Count(&factors).Error; err != nil {
+		return err
+	}
+	if factors == 0 {
+		if err := tx.Model(&WebAuthnCredential{}).Where("user_id = ?", userID).Count(&factors).Error; err != nil {
+			return err
+		}
+	}
+	if factors == 0 {
+		// Recovery codes stand in for a second factor, they go with the last one
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Model(&User{}).Where("id = ?", userID).Update("mfa_enabled", factors > 0).Error
+}
+
+// ReplaceRecoveryCodes replaces the recovery codes of a user, used or not, with new ones
+func ReplaceRecoveryCodes(db *gorm.DB, userID uint, codeHashes []string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		codes := make([]RecoveryCode, 0, len(codeHashes))
+		for _, hash := range codeHashes {
+			codes = append(codes, RecoveryCode{UserID: userID, CodeHash: hash})
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+// CountRecoveryCodes counts the unused recovery codes of a user
+func CountRecoveryCodes(db *gorm.DB, userID uint) (int64, error) {
+	var count int64
+	err := db.Model(&RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+// UseRecoveryCode marks an unused recovery code of a user used. It reports false when the
+// user has no such unused code.
+func UseRecoveryCode(db *gorm.DB, userID uint, codeHash string) (bool, error) {
+	result := db.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now())
+	return result.RowsAffected > 0, result.Error
+}
+
+// ResetMFA removes every second factor and recovery code of a user and disables MFA on the account
+func ResetMFA(db *gorm.DB, userID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&TOTPCredential{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&WebAuthnCredential{}).Error; err != nil {
+			return err
+		}
+		return refreshMFAEnabled(tx, userID)
+	})
+}
+
+// GetWebAuthnCredentials retrieves the passkeys of a user
+func GetWebAuthnCredentials(db *gorm.DB, userID uint) ([]WebAuthnCredential, error) {
+	var credentials []WebAuthnCredential
+	err := db.Where("user_id = ?", userID).Order("created_at").Find(&credentials).Error
+	return credentials, err
+}
+
+// GetWebAuthnCredential retrieves a passkey by its credential ID
+func GetWebAuthnCredential(db *gorm.DB, credentialID string) (*WebAuthnCredential, error) {
+	var credential WebAuthnCredential
+	if err := db.Where("credential_id = ?", credentialID).First(&credential).Error; err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+// CreateWebAuthnCredential stores a passkey and enables MFA on the account
+func CreateWebAuthnCredential(db *gorm.DB, credential *WebAuthnCredential) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(credential).Error; err != nil {
+			return err
+		}
+		return refreshMFAEnabled(tx, credential.UserID)
+	})
+}
+
+// UpdateWebAuthnSignCount records the use of a passkey. It reports false when the signature
+// counter was already moved past signCount by a concurrent use.
+func UpdateWebAuthnSignCount(db *gorm.DB, id uint, previous uint32, signCount uint32) (bool, error) {
+	result := db.Model(&WebAuthnCredential{}).Where("id = ? AND sign_count = ?", id, previous).
+		Updates(map[string]interface{}{"sign_count": signCount, "last_used_at": time.Now()})
+	return result.RowsAffected == 1, result.Error
+}
+
+// DeleteWebAuthnCredential removes a passkey of a user, MFA stays enabled while other factors remain
+func DeleteWebAuthnCredential(db *gorm.DB, userID uint, id uint) (bool, error) {
+	deleted := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Where("id = ? AND user_id = ?", id, userID).Delete(&WebAuthnCredential{})
+		if result.Error != nil {
+			return result.Error
+		}
+		deleted = result.RowsAffected == 1
+		return refreshMFAEnabled(tx, userID)
+	})
+	return deleted, err
+}
+
+// CreateWebAuthnChallenge stores a pending WebAuthn ceremony
+func CreateWebAuthnChallenge(db *gorm.DB, challenge *WebAuthnChallenge) error {
+	return db.Create(challenge).Error
+}
+
+// ConsumeWebAuthnChallenge completes an unused, unexpired ceremony by the hash of its challenge
+func ConsumeWebAuthnChallenge(db *gorm.DB, challengeHash string, ceremony string) (*WebAuthnChallenge, error) {
+	now := time.Now()
+	result := db.Model(&WebAuthnChallenge{}).
+		Where("challenge_hash = ? AND ceremony = ? AND used_at IS NULL AND expires_at > ?", challengeHash, ceremony, now).
+		Update("used_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	var challenge WebAuthnChallenge
+	if err := db.Where("challenge_hash = ?", challengeHash).First(&challenge).Error; err != nil {
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+// GetMFAChallenge retrieves an unused, unexpired challenge with attempts left by its hash
+func GetMFAChallenge(db *gorm.DB, tokenHash string, maxAttempts int) (*MFAChallenge, error) {
+	var challenge MFAChallenge
+	err := db.Where("token_hash = ? AND used_at IS NULL AND expires_at > ? AND attempts < ?", tokenHash, time.Now(), maxAttempts).
+		First(&challenge).Error
+	if err != nil {
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+// CreateMFAChallenge stores the second login step of a user
+func CreateMFAChallenge(db *gorm.DB, challenge *MFAChallenge) error {
+	return db.Create(challenge).Error
+}
+
+// AttemptMFAChallenge counts an attempt at an unused, unexpired challenge by its hash. It fails
+// with gorm.ErrRecordNotFound once the challenge has been used, expired or exhausted its attempts.
+func AttemptMFAChallenge(db *gorm.DB, tokenHash string, maxAttempts int) (*MFAChallenge, error) {
+	result := db.Model(&MFAChallenge{}).
+		Where("token_hash = ? AND used_at IS NULL AND expires_at > ? AND attempts < ?", tokenHash, time.Now(), maxAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	var challenge MFAChallenge
+	if err := db.Where("token_hash = ?", tokenHash).First(&challenge).Error; err != nil {
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+// CompleteMFAChallenge marks a challenge used. It reports false when it was already used.
+func CompleteMFAChallenge(db *gorm.DB, challengeID uint) (bool, error) {
+	result := db.Model(&MFAChallenge{}).Where("id = ? AND used_at IS NULL", challengeID).Update("used_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+// CreateSession stores a new session, its refresh token is stored hashed in place
 func CreateSession(db *gorm.DB, session *Session) error {
+	session.Token = hashSessionToken(session.Token)
 	return db.Create(session).Error
 }
 
@@ -137,6 +474,49 @@ func GetActiveSession(db *gorm.DB, sessionID string) (*Session, error) {
 	return &session, nil
 }
 
+// GetActiveSessionByToken retrieves the active session started by a login's refresh token
+func GetActiveSessionByToken(db *gorm.DB, refreshToken string) (*Session, error) {
+	var session Session
+	err := db.Where("token = ? AND is_active = ? AND expires_at > ?",
+		hashSessionToken(refreshToken), true, time.Now()).First(&session).Error
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// GetActiveSessionByAccessToken retrieves the active session an unexpired access token was issued in
+func GetActiveSessionByAccessToken(db *gorm.DB, accessToken string) (*Session, error) {
+	var session Session
+	now := time.Now()
+	err := db.Where("access_token = ? AND access_token_expiry > ? AND is_active = ? AND expires_at > ?",
+		hashSessionToken(accessToken), now, true, now).First(&session).Error
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// StoreSessionAccessToken replaces the access token of a session, the previous one stops working
+func StoreSessionAccessToken(db *gorm.DB, sessionID string, accessToken string, tokenExpiry time.Time) error {
+	return db.Model(&Session{}).Where("session_id = ?", sessionID).Updates(map[string]interface{}{
+		"access_token":        hashSessionToken(accessToken),
+		"access_token_expiry": tokenExpiry,
+	}).Error
+}
+
+// HashClientSecret returns the value a client secret is stored under
+func HashClientSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// hashSessionToken returns the value a session refresh or access token is stored under
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 func UpdateSessionActivity(db *gorm.DB, sessionID string) error {
 	return db.Model(&Session{}).
 		Where("session_id = ?", sessionID).
@@ -149,6 +529,41 @@ func InvalidateSession(db *gorm.DB, sessionID string) error {
 		Update("is_active", false).Error
 }
 
+// RecordReauthFailure counts a failed re-authentication in a login session and ends the
+// session once maxAttempts have failed. It reports whether the session was ended.
+func RecordReauthFailure(db *gorm.DB, sessionID string, maxAttempts int) (bool, error) {
+	if err := db.Model(&Session{}).Where("session_id = ?", sessionID).
+		UpdateColumn("reauth_failures", gorm.Expr("reauth_failures + 1")).Error; err != nil {
+		return false, err
+	}
+	result := db.Model(&Session{}).
+		Where("session_id = ? AND is_active = ? AND reauth_failures >= ?", sessionID, true, maxAttempts).
+		Update("is_active", false)
+	return result.RowsAffected == 1, result.Error
+}
+
+// ResetReauthFailures clears the failed re-authentications of a login session
+func ResetReauthFailures(db *gorm.DB, sessionID string) error {
+	return db.Model(&Session{}).Where("session_id = ? AND reauth_failures > 0", sessionID).
+		UpdateColumn("reauth_failures", 0).Error
+}
+
+// GetActiveUserSessions retrieves the active login sessions of a user
+func GetActiveUserSessions(db *gorm.DB, userID uint) ([]Session, error) {
+	var sessions []Session
+	err := db.Where("user_id = ? AND is_active = ? AND expires_at > ?", userID, true, time.Now()).
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// RevokeUserSessions ends the login sessions of a user other than keepSessionID, their refresh
+// and access tokens stop working with them
+func RevokeUserSessions(db *gorm.DB, userID uint, keepSessionID string) error {
+	return db.Model(&Session{}).
+		Where("user_id = ? AND session_id <> ?", userID, keepSessionID).
+		Update("is_active", false).Error
+}
+
 // OAuth2 Functions
 func CreateAuthorizationCode(db *gorm.DB, auth *OAuth2Authorization) error {
 	return db.Create(auth).Error
@@ -172,10 +587,21 @@ func DeleteAuthorizationCode(db *gorm.DB, code string) error {
 	return db.Where("code = ?", code).Delete(&OAuth2Authorization{}).Error
 }
 
+// ErrAuthorizationCodeUsed is returned when an authorization code was already redeemed
+var ErrAuthorizationCodeUsed = errors.New("authorization code already used")
+
+// MarkAuthorizationCodeUsed flips the used flag of an unused code, only one caller can succeed
 func MarkAuthorizationCodeUsed(db *gorm.DB, code string) error {
-	return db.Model(&OAuth2Authorization{}).
-		Where("code = ?", code).
-		Update("used", true).Error
+	result := db.Model(&OAuth2Authorization{}).
+		Where("code = ? AND used = ?", code, false).
+		Update("used", true)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAuthorizationCodeUsed
+	}
+	return nil
 }
 
 func UpsertToken(db *gorm.DB, token *OAuth2Token) error {
@@ -242,10 +668,164 @@ func (q *OAuth2Queries) GetAuthorizationCode(code string) (*OAuth2Authorization,
 
 // MarkAuthorizationCodeAsUsed marks an authorization code as used
 func (q *OAuth2Queries) MarkAuthorizationCodeAsUsed(code string) error {
-	return q.db.Model(&OAuth2Authorization{}).
-		Where("code = ?", code).
-		Update("used", true).
-		Error
+	return MarkAuthorizationCodeUsed(q.db, code)
+}
+
+// FindAuthorizationCode retrieves an authorization code whether or not it was already used
+func (q *OAuth2Queries) FindAuthorizationCode(code string) (*OAuth2Authorization, error) {
+	var auth OAuth2Authorization
+	if err := q.db.Where("code = ?", code).First(&auth).Error; err != nil {
+		return nil, err
+	}
+	return &auth, nil
+}
+
+// ConsumeAuthorization flips the used flag of an unused authorization, only one caller can succeed.
+// ErrAuthorizationCodeUsed is returned when the code was already redeemed.
+func (q *OAuth2Queries) ConsumeAuthorization(authorizationID uint) error {
+	result := q.db.Model(&OAuth2Authorization{}).
+		Where("id = ? AND used = ?", authorizationID, false).
+		Update("used", true)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAuthorizationCodeUsed
+	}
+	return nil
+}
+
+// RevokeAuthorization flags an authorization as revoked and deletes every token issued from it.
+// The deleted tokens are returned so that cached copies can be evicted.
+func (q *OAuth2Queries) RevokeAuthorization(authorizationID uint) ([]OAuth2Token, error) {
+	var tokens []OAuth2Token
+	err := q.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&OAuth2Authorization{}).
+			Where("id = ?", authorizationID).
+			Update("revoked", true).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("authorization_id = ?", authorizationID).Find(&tokens).Error; err != nil {
+			return err
+		}
+		return tx.Where("authorization_id = ?", authorizationID).Delete(&OAuth2Token{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// GetSessionClientIDs retrieves the clients the user authorized during a login session
+func (q *OAuth2Queries) GetSessionClientIDs(sessionID string) ([]string, error) {
+	var clientIDs []string
+	err := q.db.Model(&OAuth2Authorization{}).Where("session_id = ?", sessionID).Distinct().Pluck("client_id", &clientIDs).Error
+	return clientIDs, err
+}
+
+// RevokeSession revokes the authorizations of a login session so that no more tokens are
+// issued from them and deletes the tokens already issued. The deleted tokens are returned.
+func (q *OAuth2Queries) RevokeSession(sessionID string) ([]OAuth2Token, error) {
+	var tokens []OAuth2Token
+	err := q.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&OAuth2Authorization{}).Where("session_id = ?", sessionID).Update("revoked", true).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("session_id = ?", sessionID).Find(&tokens).Error; err != nil {
+			return err
+		}
+		return tx.Where("session_id = ?", sessionID).Delete(&OAuth2Token{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// ClientAuthorization is when a user first authorized a client
+type ClientAuthorization struct {
+	ClientID          string
+	FirstAuthorizedAt time.Time
+}
+
+// GetActiveUserTokens retrieves the tokens of a user whose access or refresh token has not expired
+func (q *OAuth2Queries) GetActiveUserTokens(userID uint, now time.Time) ([]OAuth2Token, error) {
+	var tokens []OAuth2Token
+	err := q.db.Where("user_id = ? AND (access_expires_at > ? OR refresh_expires_at > ?)", userID, now, now).
+		Order("created_at").Find(&tokens).Error
+	return tokens, err
+}
+
+// GetClientAuthorizations retrieves when a user first authorized each client, ignoring revoked authorizations
+func (q *OAuth2Queries) GetClientAuthorizations(userID uint) ([]ClientAuthorization, error) {
+	var authorizations []ClientAuthorization
+	err := q.db.Model(&OAuth2Authorization{}).
+		Select("client_id, MIN(created_at) AS first_authorized_at").
+		Where("user_id = ? AND revoked = ?", userID, false).
+		Group("client_id").Scan(&authorizations).Error
+	return authorizations, err
+}
+
+// RevokeUserClient revokes every authorization a user granted a client and deletes the tokens
+// issued to the client for the user. The deleted tokens are returned.
+func (q *OAuth2Queries) RevokeUserClient(userID uint, clientID string) ([]OAuth2Token, error) {
+	var tokens []OAuth2Token
+	err := q.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&OAuth2Authorization{}).
+			Where("user_id = ? AND client_id = ?", userID, clientID).
+			Update("revoked", true).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ? AND client_id = ?", userID, clientID).Find(&tokens).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ? AND client_id = ?", userID, clientID).Delete(&OAuth2Token{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// RevokeUserTokens revokes the authorizations of a user and deletes the tokens issued for the
+// user, except those of the login session keepSessionID. The deleted tokens are returned.
+func (q *OAuth2Queries) RevokeUserTokens(userID uint, keepSessionID string) ([]OAuth2Token, error) {
+	var tokens []OAuth2Token
+	err := q.db.Transaction(func(tx *gorm.DB) error {
+		scope := func(db *gorm.DB) *gorm.DB {
+			if keepSessionID == "" {
+				return db.Where("user_id = ?", userID)
+			}
+			return db.Where("user_id = ? AND (session_id IS NULL OR session_id <> ?)", userID, keepSessionID)
+		}
+		if err := tx.Model(&OAuth2Authorization{}).Scopes(scope).Update("revoked", true).Error; err != nil {
+			return err
+		}
+		if err := tx.Scopes(scope).Find(&tokens).Error; err != nil {
+			return err
+		}
+		return tx.Scopes(scope).Delete(&OAuth2Token{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// TouchToken records that an access token was used, unless a use was recorded within the interval
+func (q *OAuth2Queries) TouchToken(accessToken string, now time.Time, interval time.Duration) error {
+	return q.db.Model(&OAuth2Token{}).
+		Where("access_token = ? AND (last_used_at IS NULL OR last_used_at < ?)", accessToken, now.Add(-interval)).
+		Update("last_used_at", now).Error
+}
+
+// IsAuthorizationRevoked reports whether tokens may no longer be issued from an authorization
+func (q *OAuth2Queries) IsAuthorizationRevoked(authorizationID uint) (bool, error) {
+	var auth OAuth2Authorization
+	if err := q.db.Select("revoked").First(&auth, authorizationID).Error; err != nil {
+		return false, err
+	}
+	return auth.Revoked, nil
 }
 
 // StoreToken stores an OAuth2 token
@@ -288,14 +868,167 @@ func (q *OAuth2Queries) GetClient(clientID string) (*OAuth2Client, error) {
 	return &client, nil
 }
 
-// CleanupExpiredTokens removes expired tokens
-func (q *OAuth2Queries) CleanupExpiredTokens() error {
-	now := time.Now()
-	return q.db.Where("access_expires_at < ?", now).Delete(&OAuth2Token{}).Error
+// GetClients retrieves the active clients among the given client IDs
+func (q *OAuth2Queries) GetClients(clientIDs []string) ([]OAuth2Client, error) {
+	var clients []OAuth2Client
+	if len(clientIDs) == 0 {
+		return clients, nil
+	}
+	err := q.db.Where("client_id IN ? AND is_active = ?", clientIDs, true).Find(&clients).Error
+	return clients, err
 }
 
-// CleanupExpiredAuthorizationCodes removes expired authorization codes
-func (q *OAuth2Queries) CleanupExpiredAuthorizationCodes() error {
-	now := time.Now()
-	return q.db.Where("expires_at < ?", now).Delete(&OAuth2Authorization{}).Error
-}
\ No newline at end of file
+// ListClients retrieves every client, active or not, ordered by creation
+func (q *OAuth2Queries) ListClients() ([]OAuth2Client, error) {
+	var clients []OAuth2Client
+	err := q.db.Order("id").Find(&clients).Error
+	return clients, err
+}
+
+// CreateClient stores a new client. IsActive is written again as gorm replaces a false
+// value with the default on insert.
+func (q *OAuth2Queries) CreateClient(client *OAuth2Client) error {
+	return q.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(client).Error; err != nil {
+			return err
+		}
+		return tx.Model(client).Select("IsActive").Updates(client).Error
+	})
+}
+
+// UpdateClient saves every field of a client
+func (q *OAuth2Queries) UpdateClient(client *OAuth2Client) error {
+	return q.db.Save(client).Error
+}
+
+// DeleteClient deletes a client with its authorizations and tokens. The deleted tokens are returned.
+func (q *OAuth2Queries) DeleteClient(clientID string) ([]OAuth2Token, error) {
+	var tokens []OAuth2Token
+	err := q.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("client_id = ?", clientID).Find(&tokens).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("client_id = ?", clientID).Delete(&OAuth2Token{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&OAuth2Authorization{}).Where("client_id = ?", clientID).Update("revoked", true).Error; err != nil {
+			return err
+		}
+		return tx.Where("client_id = ?", clientID).Delete(&OAuth2Client{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// GetResourceServer retrieves an active resource server by its identifier
+func (q *OAuth2Queries) GetResourceServer(identifier string) (*OAuth2ResourceServer, error) {
+	var rs OAuth2ResourceServer
+	err := q.db.Where("identifier = ? AND is_active = ?", identifier, true).First(&rs).Error
+	if err != nil {
+		return nil, err
+	}
+	return &rs, nil
+}
+
+// GetResourceServerByClientID retrieves the active resource server authenticating as the given client
+func (q *OAuth2Queries) GetResourceServerByClientID(clientID string) (*OAuth2ResourceServer, error) {
+	var rs OAuth2ResourceServer
+	err := q.db.Where("client_id = ? AND is_active = ?", clientID, true).First(&rs).Error
+	if err != nil {
+		return nil, err
+	}
+	return &rs, nil
+}
+
+// GetAuthorizationDetailType retrieves an active authorization details type
+func (q *OAuth2Queries) GetAuthorizationDetailType(detailType string) (*OAuth2AuthorizationDetailType, error) {
+	var t OAuth2AuthorizationDetailType
+	err := q.db.Where("type = ? AND is_active = ?", detailType, true).First(&t).Error
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// GetTrustedIssuer retrieves an active trusted issuer by its iss value
+func (q *OAuth2Queries) GetTrustedIssuer(issuer string) (*OAuth2TrustedIssuer, error) {
+	var ti OAuth2TrustedIssuer
+	err := q.db.Where("issuer = ? AND is_active = ?", issuer, true).First(&ti).Error
+	if err != nil {
+		return nil, err
+	}
+	return &ti, nil
+}
+
+// GetTrustPolicies retrieves the active trust policies of a trusted issuer in creation order
+func (q *OAuth2Queries) GetTrustPolicies(trustedIssuerID uint) ([]OAuth2TrustPolicy, error) {
+	var policies []OAuth2TrustPolicy
+	err := q.db.Where("trusted_issuer_id = ? AND is_active = ?", trustedIssuerID, true).Order("id").Find(&policies).Error
+	return policies, err
+}
+
+// GetSigningKeys retrieves the unexpired signing keys, newest first
+func (q *OAuth2Queries) GetSigningKeys(now time.Time) ([]OAuth2SigningKey, error) {
+	var keys []OAuth2SigningKey
+	err := q.db.Where("expires_at > ?", now).Order("active_at DESC").Find(&keys).Error
+	return keys, err
+}
+
+// CreateSigningKey stores a new signing key
+func (q *OAuth2Queries) CreateSigningKey(key *OAuth2SigningKey) error {
+	return q.db.Create(key).Error
+}
+
+// DeleteExpiredSigningKeys permanently removes the signing keys that expired before the given time
+func (q *OAuth2Queries) DeleteExpiredSigningKeys(before time.Time) error {
+	return q.db.Unscoped().Where("expires_at < ?", before).Delete(&OAuth2SigningKey{}).Error
+}
+
+// CleanupExpiredTokens permanently removes up to batchSize tokens whose access and refresh tokens expired before the given time
+func (q *OAuth2Queries) CleanupExpiredTokens(before time.Time, batchSize int) (int64, error) {
+	return deleteBatch(q.db, &OAuth2Token{}, batchSize,
+		"access_expires_at < ? AND (refresh_expires_at IS NULL OR refresh_expires_at < ?)", before, before)
+}
+
+// CleanupExpiredAuthorizationCodes permanently removes up to batchSize authorization codes that expired before the given time
+func (q *OAuth2Queries) CleanupExpiredAuthorizationCodes(before time.Time, batchSize int) (int64, error) {
+	return deleteBatch(q.db, &OAuth2Authorization{}, batchSize, "expires_at < ?", before)
+}
+
+// CleanupExpiredSessions permanently removes up to batchSize sessions that expired before the given time
+func CleanupExpiredSessions(db *gorm.DB, before time.Time, batchSize int) (int64, error) {
+	return deleteBatch(db, &Session{}, batchSize, "expires_at < ?", before)
+}
+
+// CleanupExpiredRefreshTokens clears up to batchSize user refresh tokens that expired before the given time
+func CleanupExpiredRefreshTokens(db *gorm.DB, before time.Time, batchSize int) (int64, error) {
+	var ids []uint
+	if err := db.Model(&User{}).
+		Where("refresh_token <> '' AND refresh_token_expiry < ?", before).
+		Limit(batchSize).Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	result := db.Model(&User{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+		"refresh_token":        "",
+		"refresh_token_expiry": nil,
+	})
+	return result.RowsAffected, result.Error
+}
+
+// deleteBatch permanently deletes up to batchSize rows of model matching the query
+func deleteBatch(db *gorm.DB, model interface{}, batchSize int, query string, args ...interface{}) (int64, error) {
+	var ids []uint
+	if err := db.Unscoped().Model(model).Where(query, args...).Limit(batchSize).Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	result := db.Unscoped().Delete(model, ids)
+	return result.RowsAffected, result.Error
+}
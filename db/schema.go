@@ -11,6 +11,9 @@ type User struct {
 	gorm.Model
 	Username     string     `gorm:"type:varchar(100);unique;not null"`
 	Email        string     `gorm:"type:varchar(100);unique;not null"`
+	UsernameCanonical        *string    `gorm:"type:varchar(100);unique"` // normalized username, accounts are looked up by it
+	UsernameSkeleton         *string    `gorm:"type:varchar(100);unique"` // look-alike usernames share it
+	EmailCanonical           *string    `gorm:"type:varchar(255);unique"` // normalized email address, accounts are looked up by it
 	Password     string     `gorm:"not null"`
 	RoleID       uint       `gorm:"not null"`
 	Role         Role       `gorm:"foreignKey:RoleID"`
@@ -20,6 +23,20 @@ type User struct {
 	RefreshTokenExpiry  *time.Time `gorm:"default:null"`
 	AccessToken  string     `gorm:"type:varchar(255)"`
 	AccessTokenExpiry  *time.Time `gorm:"default:null"`
+	PendingEmailVerification bool       `gorm:"default:false"` // registered accounts cannot log in until the email is confirmed
+	EmailVerifiedAt          *time.Time
+	VerificationSentAt       *time.Time // last verification link sent, throttles resends
+	MFAEnabled               bool       `gorm:"default:false"` // login requires a second factor
+}
+
+// IdentifierConflict records an identifier the identifier backfill left unset because another
+// user already has its normalized form, the user logs in with the identifier as stored until
+// the conflict is resolved
+type IdentifierConflict struct {
+	gorm.Model
+	UserID uint   `gorm:"not null;index"`
+	Column string `gorm:"type:varchar(50);not null"` // username_canonical, username_skeleton or email_canonical
+	Key    string `gorm:"type:varchar(255);not null"`
 }
 
 // Role represents user roles in the system
@@ -36,25 +53,161 @@ type Permission struct {
 	Description string `gorm:"type:varchar(255)"`
 }
 
+// PasswordResetToken is a single-use token emailed to reset a forgotten password, only its hash is stored
+type PasswordResetToken struct {
+	gorm.Model
+	UserID    uint       `gorm:"not null;index"`
+	TokenHash string     `gorm:"type:varchar(64);unique;not null"` // hex SHA-256 of the emailed token
+	ExpiresAt time.Time  `gorm:"not null"`
+	UsedAt    *time.Time // set when the token is redeemed or superseded
+}
+
+// TOTPCredential is the RFC 6238 authenticator of a user, enabled once confirmed with a first code
+type TOTPCredential struct {
+	gorm.Model
+	UserID       uint   `gorm:"not null;unique"`
+	Secret       string `gorm:"type:text;not null"` // encrypted base32 secret
+	ConfirmedAt  *time.Time
+	LastUsedStep int64 // time step of the last accepted code, codes of this or earlier steps are replays
+}
+
+// WebAuthnCredential is a passkey of a user, usable as a second factor or to log in without a password
+type WebAuthnCredential struct {
+	gorm.Model
+	UserID       uint   `gorm:"not null;index"`
+	CredentialID string `gorm:"type:varchar(1400);not null;uniqueIndex:idx_webauthn_credential_id,length:255"` // base64url
+	PublicKey    string `gorm:"type:text;not null"` // base64url COSE key
+	SignCount    uint32
+	Transports   string `gorm:"type:varchar(255)"` // JSON array of authenticator transports
+	AAGUID       string `gorm:"type:varchar(36)"`
+	Name         string `gorm:"type:varchar(100)"` // friendly name chosen by the user
+	LastUsedAt   *time.Time
+}
+
+// WebAuthnChallenge is a pending WebAuthn ceremony, only the hash of its challenge is stored
+type WebAuthnChallenge struct {
+	gorm.Model
+	ChallengeHash string     `gorm:"type:varchar(64);unique;not null"` // hex SHA-256 of the challenge
+	Ceremony      string     `gorm:"type:varchar(20);not null"`        // registration, mfa or reauthentication
+	UserID        uint       `gorm:"index"`                            // zero for ceremonies of no particular user
+	ExpiresAt     time.Time  `gorm:"not null"`
+	UsedAt        *time.Time // set when the ceremony completes
+}
+
+// RecoveryCode is a one-time code completing an MFA challenge without the second factor, only its hash is stored
+type RecoveryCode struct {
+	gorm.Model
+	UserID   uint       `gorm:"not null;index"`
+	CodeHash string     `gorm:"type:varchar(64);not null"` // hex SHA-256 of the normalized code
+	UsedAt   *time.Time
+}
+
+// MFAChallenge is the second step of a login whose password was accepted, only its hash is stored
+type MFAChallenge struct {
+	gorm.Model
+	UserID    uint       `gorm:"not null;index"`
+	TokenHash string     `gorm:"type:varchar(64);unique;not null"` // hex SHA-256 of the challenge token
+	ExpiresAt time.Time  `gorm:"not null"`
+	Attempts  int        `gorm:"default:0"`
+	UsedAt    *time.Time // set when the login completes
+}
+
 // Session represents user sessions
 type Session struct {
 	gorm.Model
-	UserID    uint      `gorm:"not null"`
-	Token     string    `gorm:"type:varchar(255);not null"`
-	ExpiresAt time.Time `gorm:"not null"`
-	IP        string    `gorm:"type:varchar(45)"`
-	UserAgent string    `gorm:"type:varchar(255)"`
+	SessionID    string    `gorm:"type:varchar(64);unique;not null"`
+	UserID       uint      `gorm:"not null;index"`
+	Token        string    `gorm:"type:varchar(255);not null;index"` // hex SHA-256 of the refresh token of the login
+	AccessToken       string     `gorm:"type:varchar(64);index"` // hex SHA-256 of the access token last issued in the session
+	AccessTokenExpiry *time.Time
+	ExpiresAt    time.Time `gorm:"not null"`
+	IsActive     bool      `gorm:"default:true"`
+	ReauthFailures int     `gorm:"default:0"` // failed re-authentications since the last successful one
+	LastActivity *time.Time
+	IP           string    `gorm:"type:varchar(45)"`
+	UserAgent    string    `gorm:"type:varchar(255)"`
 }
 // OAuth2Client represents registered applications
 type OAuth2Client struct {
 	gorm.Model
 	ClientID     string `gorm:"type:varchar(100);unique;not null"`
-	ClientSecret string `gorm:"type:varchar(100);not null"`
+	ClientSecret string `gorm:"type:varchar(100);not null"` // SHA-256 hash of the secret, empty for public clients
 	Name         string `gorm:"type:varchar(200);not null"`
 	RedirectURIs string `gorm:"type:text;not null"` // JSON array of allowed redirect URIs
 	GrantTypes   string `gorm:"type:text;not null"` // JSON array of allowed grant types
 	Scopes       string `gorm:"type:text;not null"` // JSON array of allowed scopes
 	IsActive     bool   `gorm:"default:true"`
+	RequirePushedAuthorizationRequests bool `gorm:"default:false"`
+	// Token policy, zero lifetimes fall back to the server configuration
+	AccessTokenLifetime    int  `gorm:"default:0"` // in seconds
+	RefreshTokenLifetime   int  `gorm:"default:0"` // in seconds
+	RefreshSessionLifetime int  `gorm:"default:0"` // in seconds, absolute lifetime of a refresh token chain
+	SlidingRefreshExpiry   *bool                   // restart the refresh token lifetime on every use, nil for the server default
+	IssueRefreshTokens     *bool `gorm:"default:true"`     // nil stores the default, a pointer so that false is written on insert
+	AccessTokenFormat      string `gorm:"type:varchar(10);default:opaque"` // opaque or jwt (RFC 9068)
+	// Signed authorization requests (RFC 9101)
+	JWKS                       string `gorm:"type:text"` // JSON Web Key Set verifying request objects
+	RequestURIs                string `gorm:"type:text"` // JSON array of allowed request_uri values
+	RequireSignedRequestObject bool   `gorm:"default:false"`
+	AuthorizationDetailsTypes  string `gorm:"type:text"` // JSON array of the authorization details types the client may request
+	// Subject identifiers (OpenID Connect Core section 8)
+	SubjectType      string `gorm:"type:varchar(10);default:public"` // public or pairwise
+	SectorIdentifier string `gorm:"type:varchar(255)"`                // host or sector_identifier_uri, the redirect URI host when empty
+	Internal         bool   `gorm:"default:false"`                    // trusted internal client, may resolve pairwise subjects
+	// Logout (OpenID Connect RP-Initiated, Back-Channel and Front-Channel Logout)
+	PostLogoutRedirectURIs string `gorm:"type:text"`         // JSON array of allowed post_logout_redirect_uri values
+	BackchannelLogoutURI   string `gorm:"type:varchar(500)"` // receives logout tokens
+	FrontchannelLogoutURI  string `gorm:"type:varchar(500)"` // rendered in an iframe on logout
+}
+
+// OAuth2AuthorizationDetailType represents the registered authorization details types (RFC 9396)
+type OAuth2AuthorizationDetailType struct {
+	gorm.Model
+	Type        string `gorm:"type:varchar(100);unique;not null"`
+	Description string `gorm:"type:varchar(255)"`
+	Fields      string `gorm:"type:text"` // JSON object describing the type specific fields a detail may contain
+	IsActive    bool   `gorm:"default:true"`
+}
+
+// OAuth2SigningKey represents the keys signing JWT access tokens
+type OAuth2SigningKey struct {
+	gorm.Model
+	KID        string    `gorm:"type:varchar(64);unique;not null"`
+	Algorithm  string    `gorm:"type:varchar(10);not null"`
+	PrivateKey string    `gorm:"type:text;not null"` // encrypted PKCS #8 private key
+	ActiveAt   time.Time `gorm:"not null"`           // signs new tokens from this time
+	ExpiresAt  time.Time `gorm:"not null;index"`     // removed from the key set after this time
+}
+
+// OAuth2ResourceServer represents APIs that tokens can be issued for (RFC 8707)
+type OAuth2ResourceServer struct {
+	gorm.Model
+	Identifier string `gorm:"type:varchar(255);unique;not null"` // absolute URI requested as resource
+	Name       string `gorm:"type:varchar(200);not null"`
+	Scopes     string `gorm:"type:text;not null"`      // JSON array of accepted scopes
+	ClientID   string `gorm:"type:varchar(100);index"` // client the resource server introspects tokens with
+	IsActive   bool   `gorm:"default:true"`
+}
+
+// OAuth2TrustedIssuer represents the issuers whose JWT assertions are exchanged for tokens (RFC 7523)
+type OAuth2TrustedIssuer struct {
+	gorm.Model
+	Issuer   string `gorm:"type:varchar(255);unique;not null"` // iss of the assertions
+	JWKSURI  string `gorm:"type:varchar(255)"`                 // keys fetched from a URL
+	JWKSFile string `gorm:"type:varchar(255)"`                 // or read from a static file
+	Audience string `gorm:"type:varchar(255)"`                 // expected aud, the issuer of this server when empty
+	IsActive bool   `gorm:"default:true"`
+}
+
+// OAuth2TrustPolicy maps the claims of a trusted issuer's assertions to a client and scopes
+type OAuth2TrustPolicy struct {
+	gorm.Model
+	TrustedIssuerID uint   `gorm:"index;not null"`
+	Name            string `gorm:"type:varchar(200);not null"`
+	Claims          string `gorm:"type:text;not null"` // JSON object of claim names to the accepted values, sub is required
+	ClientID        string `gorm:"type:varchar(100);not null"`
+	Scopes          string `gorm:"type:text;not null"` // JSON array of the scopes that may be granted
+	IsActive        bool   `gorm:"default:true"`
 }
 
 // OAuth2ation represents authorization codes
@@ -67,29 +220,51 @@ type OAuth2Authorization struct {
 	Scope       string    `gorm:"type:varchar(500)"`
 	ExpiresAt   time.Time `gorm:"not null"`
 	Used        bool      `gorm:"default:false"`
+	Revoked     bool      `gorm:"default:false"` // set when the code is replayed or the session logs out
+	SessionID   string    `gorm:"type:varchar(64);index"` // login session the user approved the request in
+	AuthorizationDetails string `gorm:"type:text"` // JSON array of authorization details (RFC 9396)
+	Data        string    `gorm:"type:text"`      // JSON encoded oauth2 token info
 }
 
 // OAuth2Token represents access and refresh tokens
 type OAuth2Token struct {
 	gorm.Model
 	AccessToken      string     `gorm:"type:varchar(100);unique;not null"`
-	RefreshToken    string     `gorm:"type:varchar(100);unique"`
+	RefreshToken    string     `gorm:"type:varchar(100);unique;default:null"`
 	ClientID        string     `gorm:"type:varchar(100);not null"`
 	UserID          uint       `gorm:"not null"`
 	Scope           string     `gorm:"type:varchar(500)"`
 	AccessExpiresAt time.Time  `gorm:"not null"`
 	RefreshExpiresAt *time.Time
+	AuthorizationID *uint      `gorm:"index"` // authorization code the token was issued from
+	SessionID       string     `gorm:"type:varchar(64);index"` // login session of the authorization
+	LastUsedAt      *time.Time // last time the access token was presented, updated at most every five minutes
+	AuthorizationDetails string `gorm:"type:text"` // JSON array of authorization details (RFC 9396)
+	Data            string     `gorm:"type:text"` // JSON encoded oauth2 token info
 } 
 
 // AutoMigrate performs database auto migration for the schema
 func AutoMigrate(db *gorm.DB) error {
 	return db.AutoMigrate(
 		&User{},
+		&IdentifierConflict{},
 		&Role{},
 		&Permission{},
 		&Session{},
+		&PasswordResetToken{},
+		&TOTPCredential{},
+		&MFAChallenge{},
+		&RecoveryCode{},
+		&WebAuthnCredential{},
+		&WebAuthnChallenge{},
 		&OAuth2Client{},
+		&OAuth2ResourceServer{},
+		&OAuth2SigningKey{},
+		&OAuth2AuthorizationDetailType{},
+		&OAuth2TrustedIssuer{},
+		&OAuth2TrustPolicy{},
 		&OAuth2Authorization{},
 		&OAuth2Token{},
+		&Migration{},
 	)
 }
@@ -0,0 +1,322 @@
+package admin
+
+import (
+	database "core-auth/db"
+	"core-auth/internal/oauth2"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ClientHandler struct {
+	server *oauth2.Server
+}
+
+func NewClientHandler(server *oauth2.Server) *ClientHandler {
+	return &ClientHandler{server: server}
+}
+
+// ClientRequest is the configuration of a client, an update replaces the whole configuration
+type ClientRequest struct {
+	Name                               string          `json:"name" binding:"required"`
+	Confidential                       *bool           `json:"confidential"` // only on creation, defaults to true
+	RedirectURIs                       []string        `json:"redirect_uris"`
+	GrantTypes                         []string        `json:"grant_types"`
+	Scopes                             []string        `json:"scopes"`
+	IsActive                           *bool           `json:"is_active"`
+	RequirePushedAuthorizationRequests bool            `json:"require_pushed_authorization_requests"`
+	AccessTokenLifetime                int             `json:"access_token_lifetime" binding:"min=0"`
+	RefreshTokenLifetime               int             `json:"refresh_token_lifetime" binding:"min=0"`
+	RefreshSessionLifetime             int             `json:"refresh_session_lifetime" binding:"min=0"`
+	SlidingRefreshExpiry               *bool           `json:"sliding_refresh_expiry"`
+	IssueRefreshTokens                 *bool           `json:"issue_refresh_tokens"`
+	AccessTokenFormat                  string          `json:"access_token_format" binding:"omitempty,oneof=opaque jwt"`
+	JWKS                               json.RawMessage `json:"jwks"`
+	RequestURIs                        []string        `json:"request_uris"`
+	RequireSignedRequestObject         bool            `json:"require_signed_request_object"`
+	AuthorizationDetailsTypes          []string        `json:"authorization_details_types"`
+	SubjectType                        string          `json:"subject_type" binding:"omitempty,oneof=public pairwise"`
+	SectorIdentifier                   string          `json:"sector_identifier"`
+	Internal                           bool            `json:"internal"`
+	PostLogoutRedirectURIs             []string        `json:"post_logout_redirect_uris"`
+	BackchannelLogoutURI               string          `json:"backchannel_logout_uri"`
+	FrontchannelLogoutURI              string          `json:"frontchannel_logout_uri"`
+}
+
+// ClientResponse is a client as shown to administrators, the secret is only shown when generated
+type ClientResponse struct {
+	ClientID                           string          `json:"client_id"`
+	ClientSecret                       string          `json:"client_secret,omitempty"`
+	Confidential                       bool            `json:"confidential"`
+	Name                               string          `json:"name"`
+	RedirectURIs                       []string        `json:"redirect_uris"`
+	GrantTypes                         []string        `json:"grant_types"`
+	Scopes                             []string        `json:"scopes"`
+	IsActive                           bool            `json:"is_active"`
+	RequirePushedAuthorizationRequests bool            `json:"require_pushed_authorization_requests"`
+	AccessTokenLifetime                int             `json:"access_token_lifetime"`
+	RefreshTokenLifetime               int             `json:"refresh_token_lifetime"`
+	RefreshSessionLifetime             int             `json:"refresh_session_lifetime"`
+	SlidingRefreshExpiry               *bool           `json:"sliding_refresh_expiry"`
+	IssueRefreshTokens                 bool            `json:"issue_refresh_tokens"`
+	AccessTokenFormat                  string          `json:"access_token_format"`
+	JWKS                               json.RawMessage `json:"jwks,omitempty"`
+	RequestURIs                        []string        `json:"request_uris"`
+	RequireSignedRequestObject         bool            `json:"require_signed_request_object"`
+	AuthorizationDetailsTypes          []string        `json:"authorization_details_types"`
+	SubjectType                        string          `json:"subject_type"`
+	SectorIdentifier                   string          `json:"sector_identifier"`
+	Internal                           bool            `json:"internal"`
+	PostLogoutRedirectURIs             []string        `json:"post_logout_redirect_uris"`
+	BackchannelLogoutURI               string          `json:"backchannel_logout_uri"`
+	FrontchannelLogoutURI              string          `json:"frontchannel_logout_uri"`
+	CreatedAt                          time.Time       `json:"created_at"`
+	UpdatedAt                          time.Time       `json:"updated_at"`
+}
+
+// ListClients returns every registered client
+func (h *ClientHandler) ListClients(c *gin.Context) {
+	clients, err := h.server.ListClients()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list clients"})
+		return
+	}
+	response := make([]ClientResponse, 0, len(clients))
+	for i := range clients {
+		response = append(response, clientResponse(&clients[i], ""))
+	}
+	c.JSON(http.StatusOK, gin.H{"clients": response})
+}
+
+// GetClient returns a client
+func (h *ClientHandler) GetClient(c *gin.Context) {
+	client, err := h.server.Client(c.Param("client_id"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, clientResponse(client, ""))
+}
+
+// CreateClient registers a client, its generated secret is returned once
+func (h *ClientHandler) CreateClient(c *gin.Context) {
+	var req ClientRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	client := &database.OAuth2Client{IsActive: true}
+	if err := applyClientRequest(client, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	confidential := req.Confidential == nil || *req.Confidential
+	secret, err := h.server.CreateClient(c.Request.Context(), client, confidential)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, clientResponse(client, secret))
+}
+
+// UpdateClient replaces the configuration of a client
+func (h *ClientHandler) UpdateClient(c *gin.Context) {
+	var req ClientRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	client, err := h.server.Client(c.Param("client_id"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	if err := applyClientRequest(client, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.server.UpdateClient(c.Request.Context(), client); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, clientResponse(client, ""))
+}
+
+// DisableClient stops a client from obtaining and using tokens
+func (h *ClientHandler) DisableClient(c *gin.Context) {
+	h.setActive(c, false)
+}
+
+// EnableClient restores a disabled client
+func (h *ClientHandler) EnableClient(c *gin.Context) {
+	h.setActive(c, true)
+}
+
+func (h *ClientHandler) setActive(c *gin.Context, active bool) {
+	client, err := h.server.SetClientActive(c.Request.Context(), c.Param("client_id"), active)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, clientResponse(client, ""))
+}
+
+// ResetClientSecret generates a new secret for a client, returned once
+func (h *ClientHandler) ResetClientSecret(c *gin.Context) {
+	client, secret, err := h.server.ResetClientSecret(c.Request.Context(), c.Param("client_id"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, clientResponse(client, secret))
+}
+
+// DeleteClient deletes a client and revokes its tokens
+func (h *ClientHandler) DeleteClient(c *gin.Context) {
+	if err := h.server.DeleteClient(c.Request.Context(), c.Param("client_id")); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// applyClientRequest validates a client configuration and copies it to the client
+func applyClientRequest(client *database.OAuth2Client, req *ClientRequest) error {
+	for _, uris := range [][]string{req.RedirectURIs, req.RequestURIs, req.PostLogoutRedirectURIs} {
+		for _, uri := range uris {
+			if !isAbsoluteURI(uri) {
+				return errors.New("invalid URI " + uri)
+			}
+		}
+	}
+	for _, uri := range []string{req.BackchannelLogoutURI, req.FrontchannelLogoutURI} {
+		if uri != "" && !isAbsoluteURI(uri) {
+			return errors.New("invalid URI " + uri)
+		}
+	}
+	if req.SubjectType == oauth2.SubjectTypePairwise && req.SectorIdentifier == "" && len(oauth2.RedirectHosts(req.RedirectURIs)) > 1 {
+		return errors.New("pairwise clients with redirect URIs on several hosts require a sector_identifier")
+	}
+	if len(req.JWKS) > 0 {
+		var jwks struct {
+			Keys []json.RawMessage `json:"keys"`
+		}
+		if err := json.Unmarshal(req.JWKS, &jwks); err != nil {
+			return errors.New("jwks must be a JSON Web Key Set")
+		}
+	}
+
+	client.Name = req.Name
+	client.RedirectURIs = jsonArray(req.RedirectURIs)
+	client.GrantTypes = jsonArray(req.GrantTypes)
+	client.Scopes = jsonArray(req.Scopes)
+	if req.IsActive != nil {
+		client.IsActive = *req.IsActive
+	}
+	client.RequirePushedAuthorizationRequests = req.RequirePushedAuthorizationRequests
+	client.AccessTokenLifetime = req.AccessTokenLifetime
+	client.RefreshTokenLifetime = req.RefreshTokenLifetime
+	client.RefreshSessionLifetime = req.RefreshSessionLifetime
+	client.SlidingRefreshExpiry = req.SlidingRefreshExpiry
+	if req.IssueRefreshTokens != nil {
+		client.IssueRefreshTokens = req.IssueRefreshTokens
+	}
+	client.AccessTokenFormat = req.AccessTokenFormat
+	if client.AccessTokenFormat == "" {
+		client.AccessTokenFormat = oauth2.AccessTokenFormatOpaque
+	}
+	client.JWKS = string(req.JWKS)
+	client.RequestURIs = jsonArray(req.RequestURIs)
+	client.RequireSignedRequestObject = req.RequireSignedRequestObject
+	client.AuthorizationDetailsTypes = jsonArray(req.AuthorizationDetailsTypes)
+	client.SubjectType = req.SubjectType
+	if client.SubjectType == "" {
+		client.SubjectType = oauth2.SubjectTypePublic
+	}
+	client.SectorIdentifier = req.SectorIdentifier
+	client.Internal = req.Internal
+	client.PostLogoutRedirectURIs = jsonArray(req.PostLogoutRedirectURIs)
+	client.BackchannelLogoutURI = req.BackchannelLogoutURI
+	client.FrontchannelLogoutURI = req.FrontchannelLogoutURI
+	return nil
+}
+
+// clientResponse converts a client for the admin API, with its secret when just generated
+func clientResponse(client *database.OAuth2Client, secret string) ClientResponse {
+	response := ClientResponse{
+		ClientID:                           client.ClientID,
+		Confidential:                       client.ClientSecret != "",
+		Name:                               client.Name,
+		RedirectURIs:                       stringArray(client.RedirectURIs),
+		GrantTypes:                         stringArray(client.GrantTypes),
+		Scopes:                             stringArray(client.Scopes),
+		IsActive:                           client.IsActive,
+		RequirePushedAuthorizationRequests: client.RequirePushedAuthorizationRequests,
+		AccessTokenLifetime:                client.AccessTokenLifetime,
+		RefreshTokenLifetime:               client.RefreshTokenLifetime,
+		RefreshSessionLifetime:             client.RefreshSessionLifetime,
+		SlidingRefreshExpiry:               client.SlidingRefreshExpiry,
+		IssueRefreshTokens:                 client.IssueRefreshTokens == nil || *client.IssueRefreshTokens,
+		AccessTokenFormat:                  client.AccessTokenFormat,
+		RequestURIs:                        stringArray(client.RequestURIs),
+		RequireSignedRequestObject:         client.RequireSignedRequestObject,
+		AuthorizationDetailsTypes:          stringArray(client.AuthorizationDetailsTypes),
+		SubjectType:                        client.SubjectType,
+		SectorIdentifier:                   client.SectorIdentifier,
+		Internal:                           client.Internal,
+		PostLogoutRedirectURIs:             stringArray(client.PostLogoutRedirectURIs),
+		BackchannelLogoutURI:               client.BackchannelLogoutURI,
+		FrontchannelLogoutURI:              client.FrontchannelLogoutURI,
+		CreatedAt:                          client.CreatedAt,
+		UpdatedAt:                          client.UpdatedAt,
+	}
+	if client.JWKS != "" {
+		response.JWKS = json.RawMessage(client.JWKS)
+	}
+	response.ClientSecret = secret
+	return response
+}
+
+// writeError writes the response of a failed client operation
+func writeError(c *gin.Context, err error) {
+	if errors.Is(err, oauth2.ErrClientNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Client not found"})
+		return
+	}
+	if errors.Is(err, oauth2.ErrAccessTokenOutlivesKey) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Client management failed: %v", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to manage client"})
+}
+
+func isAbsoluteURI(uri string) bool {
+	u, err := url.Parse(uri)
+	return err == nil && u.IsAbs() && u.Host != "" && u.Fragment == ""
+}
+
+// jsonArray encodes values as the JSON arrays stored on clients
+func jsonArray(values []string) string {
+	if values == nil {
+		values = []string{}
+	}
+	data, _ := json.Marshal(values)
+	return string(data)
+}
+
+// stringArray decodes a JSON array stored on a client
+func stringArray(data string) []string {
+	values := []string{}
+	if data != "" {
+		json.Unmarshal([]byte(data), &values)
+	}
+	return values
+}
@@ -0,0 +1,29 @@
+package admin
+
+import (
+	database "core-auth/db"
+	"core-auth/internal/oauth2"
+	"testing"
+)
+
+func TestPairwiseClientsRequireSectorForSeveralHosts(t *testing.T) {
+	uris := []string{"https://app.example.com/callback", "https://admin.example.com/callback"}
+	tests := []struct {
+		name    string
+		req     ClientRequest
+		wantErr bool
+	}{
+		{"several hosts", ClientRequest{SubjectType: oauth2.SubjectTypePairwise, RedirectURIs: uris}, true},
+		{"sector registered", ClientRequest{SubjectType: oauth2.SubjectTypePairwise, RedirectURIs: uris, SectorIdentifier: "https://example.com/sector.json"}, false},
+		{"single host", ClientRequest{SubjectType: oauth2.SubjectTypePairwise, RedirectURIs: uris[:1]}, false},
+		{"public", ClientRequest{SubjectType: oauth2.SubjectTypePublic, RedirectURIs: uris}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := applyClientRequest(&database.OAuth2Client{}, &tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
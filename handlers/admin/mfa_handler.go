@@ -0,0 +1,80 @@
+package admin
+
+import (
+	database "core-auth/db"
+	"core-auth/internal/mfa"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MFAHandler struct {
+	mfa *mfa.Service
+}
+
+func NewMFAHandler(mfaService *mfa.Service) *MFAHandler {
+	return &MFAHandler{mfa: mfaService}
+}
+
+// ResetMFARequest records why an administrator resets a user's MFA
+type ResetMFARequest struct {
+	Reason string `json:"reason" binding:"required"` // how the owner's identity was verified
+}
+
+// GetMFA shows the second factors of a user and how many unused recovery codes remain
+func (h *MFAHandler) GetMFA(c *gin.Context) {
+	userID, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+	status, err := h.mfa.Status(userID)
+	if err != nil {
+		writeMFAError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// ResetMFA removes every second factor and recovery code of a user who lost access to them,
+// once the administrator verified the owner's identity
+func (h *MFAHandler) ResetMFA(c *gin.Context) {
+	userID, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+	var req ResetMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.mfa.ResetMFA(userID); err != nil {
+		writeMFAError(c, err)
+		return
+	}
+	admin := c.MustGet("user").(*database.User)
+	log.Printf("MFA of user %d reset by admin %d: %s", userID, admin.ID, req.Reason)
+	c.Status(http.StatusNoContent)
+}
+
+// userIDParam parses the user ID of the route, rendering a 404 when it is not one
+func userIDParam(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("user_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// writeMFAError renders an error of the MFA service
+func writeMFAError(c *gin.Context, err error) {
+	if errors.Is(err, mfa.ErrUserNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to manage MFA"})
+}
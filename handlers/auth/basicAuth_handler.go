@@ -3,6 +3,10 @@ package auth
 import (
 	database "core-auth/db"
 	token "core-auth/internal/tokens"
+	"core-auth/internal/mfa"
+	"core-auth/internal/utils"
+	"core-auth/internal/webauthn"
+	"errors"
 	"log"
 	"net/http"
 	"time"
@@ -11,15 +15,16 @@ import (
 )
 
 type AuthHandler struct {
-	db *gorm.DB
+	db  *gorm.DB
+	mfa *mfa.Service
 }
 
-func NewAuthHandler(db *gorm.DB) *AuthHandler {
-	return &AuthHandler{db: db}
+func NewAuthHandler(db *gorm.DB, mfaService *mfa.Service) *AuthHandler {
+	return &AuthHandler{db: db, mfa: mfaService}
 }
 
 type LoginRequest struct {
-	Username string `json:"username" binding:"required"`
+	Username string `json:"username" binding:"required"` // username or email address
 	Password string `json:"password" binding:"required"`
 }
 
@@ -28,6 +33,33 @@ type LoginResponse struct {
 	ExpiresIn    int    `json:"expires_in"` // in seconds
 }
 
+// MFAChallengeResponse is the first step of a login requiring a second factor
+type MFAChallengeResponse struct {
+	MFARequired bool     `json:"mfa_required"`
+	MFAToken    string   `json:"mfa_token"`
+	ExpiresIn   int      `json:"expires_in"` // in seconds
+	Methods     []string `json:"methods"`    // second factors the challenge can be completed with
+}
+
+type MFALoginRequest struct {
+	MFAToken     string `json:"mfa_token" binding:"required"`
+	Code         string `json:"code" binding:"required_without=RecoveryCode"`
+	RecoveryCode string `json:"recovery_code" binding:"required_without=Code"` // one-time code in place of the authenticator's
+}
+
+type PasskeyChallengeRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+}
+
+type PasskeyMFALoginRequest struct {
+	MFAToken   string                      `json:"mfa_token" binding:"required"`
+	Credential *webauthn.AssertionResponse `json:"credential" binding:"required"`
+}
+
+type PasskeyLoginRequest struct {
+	Credential *webauthn.AssertionResponse `json:"credential" binding:"required"`
+}
+
 // Login generates only refresh token
 func (h *AuthHandler) Login(c *gin.Context) {
 	var req LoginRequest
@@ -36,35 +68,178 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	// Get user by username
-	if !database.CheckUsernameDB(h.db, req.Username) {
-		log.Printf("Login failed: username %s not found", req.Username)
+	// Get user by username or email address
+	user, err := database.GetUserByLogin(h.db, req.Username)
+	if err != nil {
+		log.Printf("Login failed: account %s not found", req.Username)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
 	// Check password
-	if !database.CheckPasswordDB(h.db, req.Username, req.Password) {
-		log.Printf("Login failed: invalid password for user %s", req.Username)
+	if !database.CheckPasswordDB(h.db, user.Username, req.Password) {
+		log.Printf("Login failed: invalid password for user %s", user.Username)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
 	// Check if user is active
-	user, err := database.GetUserByUsername(h.db, req.Username)
+	if !user.CheckActive(h.db) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User account is not active"})
+		return
+	}
+	if user.PendingEmailVerification {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Email address not verified"})
+		return
+	}
+
+	// Accounts with MFA complete the login with a code in LoginMFA
+	if user.MFAEnabled {
+		mfaToken, lifetime, err := h.mfa.StartChallenge(user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start MFA challenge"})
+			return
+		}
+		c.JSON(http.StatusOK, MFAChallengeResponse{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+			ExpiresIn:   int(lifetime.Seconds()),
+			Methods:     h.mfa.Methods(user.ID),
+		})
+		return
+	}
+	h.startSession(c, user)
+}
+
+// LoginMFA completes a login requiring a second factor with a code of the user's authenticator
+// or a recovery code
+func (h *AuthHandler) LoginMFA(c *gin.Context) {
+	var req MFALoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user *database.User
+	var err error
+	if req.RecoveryCode != "" {
+		user, err = h.mfa.CompleteChallengeWithRecoveryCode(req.MFAToken, req.RecoveryCode)
+	} else {
+		user, err = h.mfa.CompleteChallenge(req.MFAToken, req.Code)
+	}
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		switch {
+		case errors.Is(err, mfa.ErrInvalidChallenge):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA challenge"})
+		case errors.Is(err, mfa.ErrInvalidCode), errors.Is(err, mfa.ErrNotEnrolled):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authentication code"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify authentication code"})
+		}
 		return
 	}
-	// Check password is correct CheckPasswordDB
-	if !database.CheckPasswordDB(h.db, req.Username, req.Password) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+	if req.RecoveryCode != "" {
+		remaining, _ := h.mfa.RemainingRecoveryCodes(user.ID)
+		log.Printf("MFA recovery code used by user %d from %s, %d remaining", user.ID, c.ClientIP(), remaining)
+	}
+	if !user.IsActive {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User account is not active"})
 		return
 	}
-	if !user.CheckActive(h.db) {
+	h.startSession(c, user)
+}
+
+// BeginPasskeyMFA starts completing a login requiring a second factor with a passkey
+func (h *AuthHandler) BeginPasskeyMFA(c *gin.Context) {
+	var req PasskeyChallengeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	options, err := h.mfa.BeginPasskeyChallenge(req.MFAToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, mfa.ErrInvalidChallenge):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA challenge"})
+		case errors.Is(err, mfa.ErrNotEnrolled):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No passkey registered"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start passkey challenge"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"publicKey": options})
+}
+
+// LoginPasskeyMFA completes a login requiring a second factor with a passkey assertion
+func (h *AuthHandler) LoginPasskeyMFA(c *gin.Context) {
+	var req PasskeyMFALoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.mfa.CompletePasskeyChallenge(req.MFAToken, req.Credential)
+	if err != nil {
+		switch {
+		case errors.Is(err, mfa.ErrInvalidChallenge):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA challenge"})
+		case errors.Is(err, mfa.ErrInvalidPasskey):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid passkey"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify passkey"})
+		}
+		return
+	}
+	if !user.IsActive {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User account is not active"})
 		return
 	}
+	h.startSession(c, user)
+}
+
+// BeginPasskeyLogin starts a passwordless login with a discoverable passkey
+func (h *AuthHandler) BeginPasskeyLogin(c *gin.Context) {
+	options, err := h.mfa.BeginPasskeyLogin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start passkey login"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"publicKey": options})
+}
+
+// LoginPasskey logs in without a password with a user-verifying passkey, which stands for
+// both factors of accounts with MFA
+func (h *AuthHandler) LoginPasskey(c *gin.Context) {
+	var req PasskeyLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.mfa.FinishPasskeyLogin(req.Credential)
+	if err != nil {
+		if errors.Is(err, mfa.ErrInvalidPasskey) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid passkey"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify passkey"})
+		return
+	}
+	if !user.IsActive {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User account is not active"})
+		return
+	}
+	if user.PendingEmailVerification {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Email address not verified"})
+		return
+	}
+	h.startSession(c, user)
+}
+
+// startSession issues the refresh token of a completed login and starts its session
+func (h *AuthHandler) startSession(c *gin.Context, user *database.User) {
 	// Generate refresh token
 	refreshToken, tokenExpiry, err := token.GenerateRefreshToken()
 	if err != nil {
@@ -72,9 +247,21 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	// Store refresh token in database
-	if err := database.StoreRefreshToken(h.db, req.Username, refreshToken, tokenExpiry); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store refresh token"})
+	// Start the login session holding the refresh token, OAuth2 authorizations approved in it end with it
+	sessionID, err := utils.GenerateRandomString(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return
+	}
+	if err := database.CreateSession(h.db, &database.Session{
+		SessionID: sessionID,
+		UserID:    user.ID,
+		Token:     refreshToken,
+		ExpiresAt: tokenExpiry,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
 		return
 	}
 
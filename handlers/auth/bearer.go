@@ -4,6 +4,7 @@ import (
 	database "core-auth/db"
 	token "core-auth/internal/tokens"
 	"net/http"
+	"strings"
 	"time"
 	"github.com/gin-gonic/gin"
 )
@@ -17,7 +18,8 @@ type RefreshResponse struct {
 	ExpiresIn   int    `json:"expires_in"` // in seconds
 }
 
-// RefreshToken validates refresh token and generates access token
+// RefreshToken validates refresh token and generates access token. Every login session has its
+// own tokens, so that refreshing on one device does not sign out the others.
 func (h *AuthHandler) RefreshToken(c *gin.Context) {
 	var req RefreshRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -26,7 +28,12 @@ func (h *AuthHandler) RefreshToken(c *gin.Context) {
 	}
 
 	// Validate refresh token
-	if !database.CheckRefreshToken(h.db, req.RefreshToken) {
+	session, err := database.GetActiveSessionByToken(h.db, req.RefreshToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+		return
+	}
+	if user, err := database.GetUserByID(h.db, session.UserID); err != nil || !user.IsActive {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
 		return
 	}
@@ -38,8 +45,52 @@ func (h *AuthHandler) RefreshToken(c *gin.Context) {
 		return
 	}
 
+	// Store access token so that it authenticates the user's requests in this session
+	if err := database.StoreSessionAccessToken(h.db, session.SessionID, accessToken, tokenExpiry); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store access token"})
+		return
+	}
+
 	c.JSON(http.StatusOK, RefreshResponse{
 		AccessToken: accessToken,
 		ExpiresIn:  int(time.Until(tokenExpiry).Seconds()),
 	})
 }
+
+// RequireUser authenticates the user with the access token issued by RefreshToken, along with
+// the login session the token was issued in
+func (h *AuthHandler) RequireUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		accessToken, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || accessToken == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing access token"})
+			return
+		}
+
+		session, err := database.GetActiveSessionByAccessToken(h.db, accessToken)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired access token"})
+			return
+		}
+		user, err := database.GetUserByID(h.db, session.UserID)
+		if err != nil || !user.IsActive {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired access token"})
+			return
+		}
+		c.Set("user", user)
+		c.Set("session", session)
+		c.Next()
+	}
+}
+
+// RequireAdmin allows only administrators, it must follow RequireUser
+func (h *AuthHandler) RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(*database.User)
+		if user.RoleID != database.RoleAdmin {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+			return
+		}
+		c.Next()
+	}
+}
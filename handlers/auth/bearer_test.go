@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"bytes"
+	database "core-auth/db"
+	"core-auth/internal/testutil"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newTestUser stores an active user
+func newTestUser(t *testing.T, db *gorm.DB, username string) *database.User {
+	t.Helper()
+	user := &database.User{Username: username, Email: username + "@example.com", Password: "-", IsActive: true}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// newTestSession starts a login session of the user holding the given refresh token
+func newTestSession(t *testing.T, db *gorm.DB, user *database.User, sessionID string, refreshToken string) {
+	t.Helper()
+	if err := database.CreateSession(db, &database.Session{
+		SessionID: sessionID,
+		UserID:    user.ID,
+		Token:     refreshToken,
+		ExpiresAt: time.Now().Add(time.Hour),
+		IsActive:  true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// refresh exchanges a refresh token for an access token
+func refresh(t *testing.T, h *AuthHandler, refreshToken string) string {
+	t.Helper()
+	body, _ := json.Marshal(RefreshRequest{RefreshToken: refreshToken})
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/auth/refresh", bytes.NewReader(body))
+	h.RefreshToken(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("refresh status = %d: %s", w.Code, w.Body)
+	}
+	var resp RefreshResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.AccessToken
+}
+
+// authenticate runs RequireUser with an access token and returns the session it resolved
+func authenticate(h *AuthHandler, accessToken string) (int, string) {
+	router := gin.New()
+	sid := ""
+	router.GET("/me", h.RequireUser(), func(c *gin.Context) {
+		sid = c.MustGet("session").(*database.Session).SessionID
+		c.Status(http.StatusOK)
+	})
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	router.ServeHTTP(w, req)
+	return w.Code, sid
+}
+
+func TestAccessTokensArePerSession(t *testing.T) {
+	db := testutil.NewDB(t)
+	h := &AuthHandler{db: db}
+	user := newTestUser(t, db, "alice")
+	newTestSession(t, db, user, "laptop", "refresh-laptop")
+	newTestSession(t, db, user, "phone", "refresh-phone")
+
+	laptop := refresh(t, h, "refresh-laptop")
+	phone := refresh(t, h, "refresh-phone")
+
+	// Signing in on the phone leaves the laptop signed in, each token resolves its own session
+	for token, want := range map[string]string{laptop: "laptop", phone: "phone"} {
+		code, sid := authenticate(h, token)
+		if code != http.StatusOK {
+			t.Fatalf("session %s: status = %d", want, code)
+		}
+		if sid != want {
+			t.Errorf("token of session %s resolved session %s", want, sid)
+		}
+	}
+}
+
+func TestRefreshReplacesSessionAccessToken(t *testing.T) {
+	db := testutil.NewDB(t)
+	h := &AuthHandler{db: db}
+	user := newTestUser(t, db, "alice")
+	newTestSession(t, db, user, "laptop", "refresh-laptop")
+
+	first := refresh(t, h, "refresh-laptop")
+	second := refresh(t, h, "refresh-laptop")
+	if code, _ := authenticate(h, first); code != http.StatusUnauthorized {
+		t.Errorf("replaced access token status = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := authenticate(h, second); code != http.StatusOK {
+		t.Errorf("current access token status = %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestSessionTokensAreStoredHashed(t *testing.T) {
+	db := testutil.NewDB(t)
+	h := &AuthHandler{db: db}
+	user := newTestUser(t, db, "alice")
+	newTestSession(t, db, user, "laptop", "refresh-laptop")
+	accessToken := refresh(t, h, "refresh-laptop")
+
+	session, err := database.GetActiveSession(db, "laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Token == "refresh-laptop" || session.AccessToken == accessToken {
+		t.Errorf("session tokens stored in plaintext: %q, %q", session.Token, session.AccessToken)
+	}
+}
+
+func TestEndedSessionRejectsTokens(t *testing.T) {
+	db := testutil.NewDB(t)
+	h := &AuthHandler{db: db}
+	user := newTestUser(t, db, "alice")
+	newTestSession(t, db, user, "laptop", "refresh-laptop")
+	accessToken := refresh(t, h, "refresh-laptop")
+
+	if err := database.InvalidateSession(db, "laptop"); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := authenticate(h, accessToken); code != http.StatusUnauthorized {
+		t.Errorf("access token of ended session status = %d, want %d", code, http.StatusUnauthorized)
+	}
+	body, _ := json.Marshal(RefreshRequest{RefreshToken: "refresh-laptop"})
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/auth/refresh", bytes.NewReader(body))
+	h.RefreshToken(c)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("refresh of ended session status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestRevokingOtherSessionsKeepsCurrentTokens(t *testing.T) {
+	db := testutil.NewDB(t)
+	h := &AuthHandler{db: db}
+	user := newTestUser(t, db, "alice")
+	newTestSession(t, db, user, "laptop", "refresh-laptop")
+	newTestSession(t, db, user, "phone", "refresh-phone")
+	laptop := refresh(t, h, "refresh-laptop")
+	phone := refresh(t, h, "refresh-phone")
+
+	if err := database.RevokeUserSessions(db, user.ID, "laptop"); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := authenticate(h, laptop); code != http.StatusOK {
+		t.Errorf("kept session status = %d, want %d", code, http.StatusOK)
+	}
+	if code, _ := authenticate(h, phone); code != http.StatusUnauthorized {
+		t.Errorf("revoked session status = %d, want %d", code, http.StatusUnauthorized)
+	}
+	refresh(t, h, "refresh-laptop")
+}
@@ -0,0 +1,272 @@
+package auth
+
+import (
+	database "core-auth/db"
+	"core-auth/internal/mfa"
+	"core-auth/internal/webauthn"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MFAHandler struct {
+	mfa *mfa.Service
+}
+
+func NewMFAHandler(mfaService *mfa.Service) *MFAHandler {
+	return &MFAHandler{mfa: mfaService}
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// EnrollmentResponse confirms a second factor. Recovery codes are present, and shown this
+// once, when it is the user's first.
+type EnrollmentResponse struct {
+	Message       string   `json:"message"`
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+type PasskeyResponse struct {
+	*mfa.Passkey
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+type RegisterPasskeyRequest struct {
+	Name       string                        `json:"name" binding:"max=100"`
+	Credential *webauthn.AttestationResponse `json:"credential" binding:"required"`
+}
+
+// EnrollTOTP generates a TOTP secret for the signed in user and returns its provisioning URI,
+// usually rendered as a QR code. MFA is enabled once ConfirmTOTP accepts a first code.
+func (h *MFAHandler) EnrollTOTP(c *gin.Context) {
+	user := c.MustGet("user").(*database.User)
+	enrollment, err := h.mfa.EnrollTOTP(user)
+	if err != nil {
+		if errors.Is(err, mfa.ErrAlreadyEnrolled) {
+			c.JSON(http.StatusConflict, gin.H{"error": "An authenticator is already enrolled"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enroll authenticator"})
+		return
+	}
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// ConfirmTOTP enables MFA for the signed in user with a first code of the enrolled authenticator
+func (h *MFAHandler) ConfirmTOTP(c *gin.Context) {
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := c.MustGet("user").(*database.User)
+	session := c.MustGet("session").(*database.Session)
+	codes, err := h.mfa.ConfirmTOTP(user.ID, session.SessionID, req.Code)
+	if err != nil {
+		writeMFAError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, EnrollmentResponse{Message: "Multi-factor authentication enabled", RecoveryCodes: codes})
+}
+
+// DisableTOTP removes the authenticator of the signed in user after checking one of its codes
+func (h *MFAHandler) DisableTOTP(c *gin.Context) {
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := c.MustGet("user").(*database.User)
+	session := c.MustGet("session").(*database.Session)
+	if err := h.mfa.DisableTOTP(user.ID, session.SessionID, req.Code); err != nil {
+		writeMFAError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Multi-factor authentication disabled"})
+}
+
+// BeginPasskeyReauthentication starts re-authenticating the signed in user with a passkey, the
+// assertion is then given in place of a password or code
+func (h *MFAHandler) BeginPasskeyReauthentication(c *gin.Context) {
+	user := c.MustGet("user").(*database.User)
+	options, err := h.mfa.BeginPasskeyReauthentication(user.ID)
+	if err != nil {
+		if errors.Is(err, mfa.ErrNotEnrolled) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No passkey registered"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start re-authentication"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"publicKey": options})
+}
+
+// BeginPasskeyRegistration starts registering a passkey for the signed in user, who confirms
+// their password, or a second factor when MFA is enabled, first
+func (h *MFAHandler) BeginPasskeyRegistration(c *gin.Context) {
+	var req mfa.Reauthentication
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := c.MustGet("user").(*database.User)
+	session := c.MustGet("session").(*database.Session)
+	options, err := h.mfa.BeginPasskeyRegistration(user, session.SessionID, &req)
+	if err != nil {
+		if reauthenticationFailed(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start passkey registration"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"publicKey": options})
+}
+
+// RegisterPasskey stores the passkey created by the registration ceremony of the signed in user
+func (h *MFAHandler) RegisterPasskey(c *gin.Context) {
+	var req RegisterPasskeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := c.MustGet("user").(*database.User)
+	passkey, codes, err := h.mfa.FinishPasskeyRegistration(user, req.Name, req.Credential)
+	if err != nil {
+		switch {
+		case errors.Is(err, mfa.ErrInvalidPasskey):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid passkey registration"})
+		case errors.Is(err, mfa.ErrPasskeyExists):
+			c.JSON(http.StatusConflict, gin.H{"error": "Passkey already registered"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register passkey"})
+		}
+		return
+	}
+	c.JSON(http.StatusCreated, PasskeyResponse{Passkey: passkey, RecoveryCodes: codes})
+}
+
+// ListPasskeys lists the passkeys of the signed in user
+func (h *MFAHandler) ListPasskeys(c *gin.Context) {
+	user := c.MustGet("user").(*database.User)
+	passkeys, err := h.mfa.Passkeys(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list passkeys"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"passkeys": passkeys})
+}
+
+// DeletePasskey removes a passkey of the signed in user, who re-authenticates as when
+// registering one
+func (h *MFAHandler) DeletePasskey(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Passkey not found"})
+		return
+	}
+	var req mfa.Reauthentication
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := c.MustGet("user").(*database.User)
+	session := c.MustGet("session").(*database.Session)
+	if err := h.mfa.DeletePasskey(user, session.SessionID, uint(id), &req); err != nil {
+		if reauthenticationFailed(c, err) {
+			return
+		}
+		if errors.Is(err, mfa.ErrPasskeyNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Passkey not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove passkey"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// RecoveryCodes reports how many unused recovery codes the signed in user has left
+func (h *MFAHandler) RecoveryCodes(c *gin.Context) {
+	user := c.MustGet("user").(*database.User)
+	remaining, err := h.mfa.RemainingRecoveryCodes(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count recovery codes"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"remaining": remaining})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the signed in user, who confirms a
+// second factor first. The new codes are shown this once.
+func (h *MFAHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	var req mfa.Reauthentication
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := c.MustGet("user").(*database.User)
+	session := c.MustGet("session").(*database.Session)
+	codes, err := h.mfa.RegenerateRecoveryCodes(user, session.SessionID, &req)
+	if err != nil {
+		if errors.Is(err, mfa.ErrNotEnrolled) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Multi-factor authentication is not enabled"})
+			return
+		}
+		if reauthenticationFailed(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+	log.Printf("MFA recovery codes regenerated for user %d", user.ID)
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// reauthenticationFailed renders the error of a failed re-authentication and reports whether
+// err was one
+func reauthenticationFailed(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, mfa.ErrReauthenticationRequired):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Current password, or a second factor when MFA is enabled, required"})
+	case errors.Is(err, mfa.ErrInvalidPassword):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+	case errors.Is(err, mfa.ErrInvalidCode):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid authentication code"})
+	case errors.Is(err, mfa.ErrInvalidPasskey):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid passkey response"})
+	case errors.Is(err, mfa.ErrNotEnrolled):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No authenticator enrolled"})
+	case errors.Is(err, mfa.ErrTooManyAttempts):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Too many failed attempts, signed out"})
+	default:
+		return false
+	}
+	return true
+}
+
+// writeMFAError renders an error of the MFA service
+func writeMFAError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, mfa.ErrNotEnrolled):
+		c.JSON(http.StatusNotFound, gin.H{"error": "No authenticator enrolled"})
+	case errors.Is(err, mfa.ErrAlreadyEnrolled):
+		c.JSON(http.StatusConflict, gin.H{"error": "An authenticator is already enrolled"})
+	case errors.Is(err, mfa.ErrInvalidCode):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid authentication code"})
+	case errors.Is(err, mfa.ErrTooManyAttempts):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Too many invalid codes, signed out"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify authentication code"})
+	}
+}
@@ -1,12 +1,16 @@
 package auth
 
 import (
+	"log"
 	"net/http"
+	"strconv"
+
+	database "core-auth/db"
 
 	"core-auth/internal/oauth2"
 
 	"github.com/gin-gonic/gin"
-	"github.com/go-oauth2/oauth2/v4/errors"
+	goauth2 "github.com/go-oauth2/oauth2/v4"
 	"github.com/go-redis/redis/v8"
 	"gorm.io/gorm"
 )
@@ -27,50 +31,228 @@ func NewOAuth2ServerHandler(server *oauth2.Server, manager *oauth2.Manager, db *
 	}
 }
 
+// Authorize handles the authorization endpoint. Errors that were not redirected
+// to the client are rendered directly.
 func (h *OAuth2ServerHandler) Authorize(c *gin.Context) {
-	err := h.server.HandleAuthorizeRequest(c.Writer, c.Request)
+	if err := h.server.HandleAuthorizeRequest(c.Writer, c.Request); err != nil {
+		if werr := h.server.WriteError(c.Writer, err); werr != nil {
+			log.Printf("Failed to write authorize error response: %v", werr)
+		}
+	}
+}
+
+// Token handles the token endpoint. The oauth2 server writes both token and
+// error responses, so only a failure to write is left to report.
+func (h *OAuth2ServerHandler) Token(c *gin.Context) {
+	if err := h.server.HandleTokenRequest(c.Writer, c.Request); err != nil {
+		log.Printf("Failed to write token response: %v", err)
+	}
+}
+
+type ConsentDecisionRequest struct {
+	ConsentChallenge string `json:"consent_challenge" binding:"required"`
+	Approve          bool   `json:"approve"`
+}
+
+// Consent returns the authorization request the user is asked to approve
+func (h *OAuth2ServerHandler) Consent(c *gin.Context) {
+	data, err := h.server.ConsentRequest(c.Request.Context(), c.Query("consent_challenge"))
 	if err != nil {
-		switch err {
-		case errors.ErrInvalidRequest:
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
-		case errors.ErrUnauthorizedClient:
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized_client"})
-		case errors.ErrAccessDenied:
-			c.JSON(http.StatusForbidden, gin.H{"error": "access_denied"})
-		case errors.ErrUnsupportedResponseType:
-			c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported_response_type"})
-		case errors.ErrInvalidScope:
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_scope"})
-		case errors.ErrServerError:
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
-		case errors.ErrTemporarilyUnavailable:
-			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "temporarily_unavailable"})
-		default:
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal_error"})
+		if werr := h.server.WriteError(c.Writer, err); werr != nil {
+			log.Printf("Failed to write consent error response: %v", werr)
 		}
 		return
 	}
+	c.JSON(http.StatusOK, data)
 }
 
-func (h *OAuth2ServerHandler) Token(c *gin.Context) {
-	err := h.server.HandleTokenRequest(c.Writer, c.Request)
+// ConsentDecision records the user's decision on an authorization request and
+// returns where the user agent must be redirected
+func (h *OAuth2ServerHandler) ConsentDecision(c *gin.Context) {
+	var req ConsentDecisionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := c.MustGet("user").(*database.User)
+	redirectTo, err := h.server.HandleConsentDecision(c.Request.Context(), req.ConsentChallenge, strconv.FormatUint(uint64(user.ID), 10), sessionID(c), req.Approve)
+	if err != nil {
+		if werr := h.server.WriteError(c.Writer, err); werr != nil {
+			log.Printf("Failed to write consent error response: %v", werr)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"redirect_to": redirectTo})
+}
+
+type LogoutRequest struct {
+	LogoutChallenge string `json:"logout_challenge"`
+	Confirm         bool   `json:"confirm"` // the user confirmed the RP-initiated logout
+}
+
+// EndSession handles the RP-initiated logout endpoint, sending the user to the logout page
+func (h *OAuth2ServerHandler) EndSession(c *gin.Context) {
+	if err := h.server.HandleEndSessionRequest(c.Writer, c.Request); err != nil {
+		if werr := h.server.WriteError(c.Writer, err); werr != nil {
+			log.Printf("Failed to write end session error response: %v", werr)
+		}
+	}
+}
+
+// LogoutConfirmation returns the details of an RP-initiated logout the logout page asks the
+// user to confirm
+func (h *OAuth2ServerHandler) LogoutConfirmation(c *gin.Context) {
+	data, err := h.server.LogoutRequest(c.Request.Context(), c.Query("logout_challenge"))
+	if err != nil {
+		if werr := h.server.WriteError(c.Writer, err); werr != nil {
+			log.Printf("Failed to write logout error response: %v", werr)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, data)
+}
+
+// Logout ends the user's login session and the OAuth2 authorizations approved in it. The
+// response lists the front-channel logout URIs to render and, for RP-initiated logouts,
+// where to redirect the user agent.
+func (h *OAuth2ServerHandler) Logout(c *gin.Context) {
+	var req LogoutRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	// An RP-initiated logout the user declined leaves the session untouched
+	if req.LogoutChallenge != "" && !req.Confirm {
+		if err := h.server.RejectLogout(c.Request.Context(), req.LogoutChallenge); err != nil {
+			if werr := h.server.WriteError(c.Writer, err); werr != nil {
+				log.Printf("Failed to write logout error response: %v", werr)
+			}
+			return
+		}
+		c.JSON(http.StatusOK, oauth2.LogoutResult{FrontchannelLogoutURIs: []string{}})
+		return
+	}
+
+	// Only the session the access token was issued to is ended
+	user := c.MustGet("user").(*database.User)
+	session := c.MustGet("session").(*database.Session)
+	if err := database.InvalidateSession(h.db, session.SessionID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to end session"})
+		return
+	}
+
+	result, err := h.server.Logout(c.Request.Context(), strconv.FormatUint(uint64(user.ID), 10), session.SessionID, req.LogoutChallenge)
 	if err != nil {
-		switch err {
-		case errors.ErrInvalidRequest:
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
-		case errors.ErrInvalidClient:
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_client"})
-		case errors.ErrInvalidGrant:
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant"})
-		case errors.ErrUnauthorizedClient:
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized_client"})
-		case errors.ErrUnsupportedGrantType:
-			c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported_grant_type"})
-		case errors.ErrInvalidScope:
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_scope"})
-		default:
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal_error"})
+		if werr := h.server.WriteError(c.Writer, err); werr != nil {
+			log.Printf("Failed to write logout error response: %v", werr)
 		}
 		return
 	}
+	c.JSON(http.StatusOK, result)
+}
+
+// ConnectedApps lists the applications holding tokens for the signed in user
+func (h *OAuth2ServerHandler) ConnectedApps(c *gin.Context) {
+	user := c.MustGet("user").(*database.User)
+	apps, err := h.server.ConnectedApps(c.Request.Context(), user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load connected apps"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"connected_apps": apps})
+}
+
+// RevokeConnectedApp revokes every token and grant the signed in user gave an application
+func (h *OAuth2ServerHandler) RevokeConnectedApp(c *gin.Context) {
+	user := c.MustGet("user").(*database.User)
+	if err := h.server.RevokeConnectedApp(c.Request.Context(), user.ID, c.Param("client_id")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke connected app"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// sessionID returns the login session of the authenticated user, if any
+func sessionID(c *gin.Context) string {
+	if session, ok := c.Get("session"); ok {
+		return session.(*database.Session).SessionID
+	}
+	return ""
+}
+
+// PushedAuthorizationRequest handles the PAR endpoint (RFC 9126)
+func (h *OAuth2ServerHandler) PushedAuthorizationRequest(c *gin.Context) {
+	if err := h.server.HandlePushedAuthorizationRequest(c.Writer, c.Request); err != nil {
+		log.Printf("Failed to write pushed authorization response: %v", err)
+	}
+}
+
+// Introspect handles the token introspection endpoint (RFC 7662)
+func (h *OAuth2ServerHandler) Introspect(c *gin.Context) {
+	if err := h.server.HandleIntrospectionRequest(c.Writer, c.Request); err != nil {
+		log.Printf("Failed to write introspection response: %v", err)
+	}
+}
+
+// JWKS serves the public keys verifying JWT access tokens
+func (h *OAuth2ServerHandler) JWKS(c *gin.Context) {
+	jwks, err := h.server.JWKS()
+	if err != nil {
+		log.Printf("Failed to load signing keys: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load signing keys"})
+		return
+	}
+	c.JSON(http.StatusOK, jwks)
+}
+
+// RequireToken protects a route with an OAuth2 access token, verifying
+// the DPoP proof of sender-constrained tokens
+func (h *OAuth2ServerHandler) RequireToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ti, err := h.server.ValidationAccessToken(c.Request)
+		if err != nil {
+			if werr := h.server.WriteResourceError(c.Writer, c.Request, err); werr != nil {
+				log.Printf("Failed to write resource error response: %v", werr)
+			}
+			c.Abort()
+			return
+		}
+		c.Set("oauth2_token", ti)
+		c.Next()
+	}
+}
+
+// Validate returns the details of the access token presented to a protected route
+func (h *OAuth2ServerHandler) Validate(c *gin.Context) {
+	ti := c.MustGet("oauth2_token").(goauth2.TokenInfo)
+	c.JSON(http.StatusOK, h.server.TokenData(c.Request.Context(), ti))
+}
+
+// UserInfo returns the claims about the user of the access token
+func (h *OAuth2ServerHandler) UserInfo(c *gin.Context) {
+	ti := c.MustGet("oauth2_token").(goauth2.TokenInfo)
+	claims, err := h.server.UserInfo(c.Request.Context(), ti)
+	if err != nil {
+		if werr := h.server.WriteResourceError(c.Writer, c.Request, err); werr != nil {
+			log.Printf("Failed to write userinfo error response: %v", werr)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, claims)
+}
+
+// Metadata serves the authorization server metadata document (RFC 8414)
+// for the endpoints registered on the router
+func (h *OAuth2ServerHandler) Metadata(router *gin.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var paths []string
+		for _, route := range router.Routes() {
+			paths = append(paths, route.Path)
+		}
+		c.JSON(http.StatusOK, h.server.Metadata(paths))
+	}
 }
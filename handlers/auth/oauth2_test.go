@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"core-auth/internal/oauth2"
+	"core-auth/internal/testutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLogoutEndsOnlyCallerSession(t *testing.T) {
+	testutil.SetSecrets(t)
+	db := testutil.NewDB(t)
+	rdb, _ := testutil.NewRedis(t)
+	h := &AuthHandler{db: db}
+	oauth2Handler := NewOAuth2ServerHandler(oauth2.NewServer(rdb, db), oauth2.NewManager(rdb, db), db, rdb)
+	user := newTestUser(t, db, "alice")
+	newTestSession(t, db, user, "laptop", "refresh-laptop")
+	newTestSession(t, db, user, "phone", "refresh-phone")
+	laptop := refresh(t, h, "refresh-laptop")
+	phone := refresh(t, h, "refresh-phone")
+
+	// Logging out on the phone ends the session of the phone's access token only
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/auth/logout", nil)
+	req.Header.Set("Authorization", "Bearer "+phone)
+	router := gin.New()
+	router.POST("/auth/logout", h.RequireUser(), oauth2Handler.Logout)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("logout status = %d: %s", w.Code, w.Body)
+	}
+
+	if code, _ := authenticate(h, phone); code != http.StatusUnauthorized {
+		t.Errorf("logged out session status = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := authenticate(h, laptop); code != http.StatusOK {
+		t.Errorf("other session status = %d, want %d", code, http.StatusOK)
+	}
+}
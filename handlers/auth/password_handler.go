@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"core-auth/config"
+	database "core-auth/db"
+	"core-auth/internal/oauth2"
+	"core-auth/internal/passwordpolicy"
+	"core-auth/internal/verification"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type PasswordHandler struct {
+	db       *gorm.DB
+	resetter *verification.PasswordResetter
+	server   *oauth2.Server
+	policy   *passwordpolicy.Policy
+	config   *config.Config
+}
+
+func NewPasswordHandler(db *gorm.DB, resetter *verification.PasswordResetter, server *oauth2.Server, policy *passwordpolicy.Policy) *PasswordHandler {
+	config, err := config.LoadFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	return &PasswordHandler{
+		db:       db,
+		resetter: resetter,
+		server:   server,
+		policy:   policy,
+		config:   config,
+	}
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword    string `json:"current_password"` // may be omitted shortly after logging in
+	NewPassword        string `json:"new_password" binding:"required"`
+	KeepCurrentSession bool   `json:"keep_current_session"`
+}
+
+// ForgotPassword emails a password reset link. The response is the same whether or not the
+// address belongs to an account, and the email is queued so that the response does not wait
+// for the mail server only when it does.
+func (h *PasswordHandler) ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.resetter.Request(c.Request.Context(), req.Email); err != nil {
+		log.Printf("Failed to send password reset email: %v", err)
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the address belongs to an account, a password reset link has been sent"})
+}
+
+// ResetPassword sets a new password with a reset token and signs the account out everywhere
+func (h *PasswordHandler) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, err := h.resetter.Lookup(req.Token)
+	if err == nil {
+		// Checked before redeeming so that a rejected password does not burn the token
+		if violations := h.policy.Check(req.Password, passwordpolicy.Account{Username: user.Username, Email: user.Email}); len(violations) > 0 {
+			writePolicyViolations(c, violations)
+			return
+		}
+		user, err = h.resetter.Redeem(req.Token)
+	}
+	if err != nil {
+		if errors.Is(err, verification.ErrInvalidResetToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired password reset token"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+
+	if err := database.UpdatePassword(h.db, user.ID, req.Password); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+	if err := h.server.RevokeUserSessions(c.Request.Context(), user.ID, ""); err != nil {
+		log.Printf("Failed to revoke sessions of user %d after password reset: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Password changed but existing sessions could not be revoked"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset"})
+}
+
+// ChangePassword changes the password of the signed in user. The current password is required
+// unless the caller's session logged in within the re-authentication window, and the session
+// is ended after too many wrong ones. Every other session is signed out, and the current one
+// too unless it asks to be kept.
+func (h *PasswordHandler) ChangePassword(c *gin.Context) {
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := c.MustGet("user").(*database.User)
+	session := c.MustGet("session").(*database.Session)
+	if req.CurrentPassword != "" {
+		if !database.CheckPasswordDB(h.db, user.Username, req.CurrentPassword) {
+			h.reauthFailed(c, user, session)
+			return
+		}
+		if err := database.ResetReauthFailures(h.db, session.SessionID); err != nil {
+			log.Printf("Failed to reset re-authentication failures of session %s: %v", session.SessionID, err)
+		}
+	} else if !h.recentlyAuthenticated(session) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password or recent re-authentication required"})
+		return
+	}
+
+	if violations := h.policy.Check(req.NewPassword, passwordpolicy.Account{Username: user.Username, Email: user.Email}); len(violations) > 0 {
+		writePolicyViolations(c, violations)
+		return
+	}
+	if database.CheckPasswordDB(h.db, user.Username, req.NewPassword) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "New password must differ from the current password"})
+		return
+	}
+
+	if err := database.UpdatePassword(h.db, user.ID, req.NewPassword); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		return
+	}
+	keep := ""
+	if req.KeepCurrentSession {
+		keep = session.SessionID
+	}
+	if err := h.server.RevokeUserSessions(c.Request.Context(), user.ID, keep); err != nil {
+		log.Printf("Failed to revoke sessions of user %d after password change: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Password changed but other sessions could not be revoked"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been changed"})
+}
+
+// recentlyAuthenticated reports whether the caller's session logged in within the re-authentication window
+func (h *PasswordHandler) recentlyAuthenticated(session *database.Session) bool {
+	window := time.Duration(h.config.PasswordChange.ReauthWindow) * time.Second
+	return time.Since(session.CreatedAt) <= window
+}
+
+// reauthFailed counts a wrong current password against the caller's session, which is signed
+// out once too many were given
+func (h *PasswordHandler) reauthFailed(c *gin.Context, user *database.User, session *database.Session) {
+	ended, err := database.RecordReauthFailure(h.db, session.SessionID, h.config.PasswordChange.MaxAttempts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		return
+	}
+	if !ended {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+	if _, err := h.server.Logout(c.Request.Context(), strconv.FormatUint(uint64(user.ID), 10), session.SessionID, ""); err != nil {
+		log.Printf("Failed to end the OAuth2 authorizations of session %s: %v", session.SessionID, err)
+	}
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "Too many incorrect passwords, signed out"})
+}
+
+// writePolicyViolations renders the reasons a new password was rejected
+func writePolicyViolations(c *gin.Context, violations []passwordpolicy.Violation) {
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Password does not meet the password policy", "reasons": violations})
+}
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"bytes"
+	database "core-auth/db"
+	"core-auth/internal/oauth2"
+	"core-auth/internal/passwordpolicy"
+	"core-auth/internal/testutil"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// newTestPasswordHandler returns a password handler and the bearer handler authenticating its callers
+func newTestPasswordHandler(t *testing.T) (*PasswordHandler, *AuthHandler, *gorm.DB) {
+	t.Helper()
+	testutil.SetSecrets(t)
+	db := testutil.NewDB(t)
+	rdb, _ := testutil.NewRedis(t)
+	return NewPasswordHandler(db, nil, oauth2.NewServer(rdb, db), passwordpolicy.New()), &AuthHandler{db: db}, db
+}
+
+// changePassword calls ChangePassword with an access token and returns the response status
+func changePassword(h *PasswordHandler, auth *AuthHandler, accessToken string, req ChangePasswordRequest) int {
+	router := gin.New()
+	router.POST("/me/password", auth.RequireUser(), h.ChangePassword)
+	body, _ := json.Marshal(req)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/me/password", bytes.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+accessToken)
+	router.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestWrongCurrentPasswordsEndSession(t *testing.T) {
+	t.Setenv("PASSWORD_CHANGE_MAX_ATTEMPTS", "3")
+	h, auth, db := newTestPasswordHandler(t)
+	user := newTestUser(t, db, "alice")
+	if err := database.UpdatePassword(db, user.ID, "current-Passw0rd!"); err != nil {
+		t.Fatal(err)
+	}
+	newTestSession(t, db, user, "laptop", "refresh-laptop")
+	accessToken := refresh(t, auth, "refresh-laptop")
+
+	req := ChangePasswordRequest{CurrentPassword: "wrong-Passw0rd!", NewPassword: "brand-new-Passw0rd!"}
+	for i := 1; i <= 3; i++ {
+		if code := changePassword(h, auth, accessToken, req); code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d status = %d, want %d", i, code, http.StatusUnauthorized)
+		}
+		if i < 3 {
+			if code, _ := authenticate(auth, accessToken); code != http.StatusOK {
+				t.Fatalf("session ended after %d attempts", i)
+			}
+		}
+	}
+	if code, _ := authenticate(auth, accessToken); code != http.StatusUnauthorized {
+		t.Errorf("session still active after the last attempt, status = %d", code)
+	}
+}
+
+func TestCorrectCurrentPasswordResetsFailures(t *testing.T) {
+	t.Setenv("PASSWORD_CHANGE_MAX_ATTEMPTS", "2")
+	h, auth, db := newTestPasswordHandler(t)
+	user := newTestUser(t, db, "alice")
+	if err := database.UpdatePassword(db, user.ID, "current-Passw0rd!"); err != nil {
+		t.Fatal(err)
+	}
+	newTestSession(t, db, user, "laptop", "refresh-laptop")
+	accessToken := refresh(t, auth, "refresh-laptop")
+
+	changePassword(h, auth, accessToken, ChangePasswordRequest{CurrentPassword: "wrong-Passw0rd!", NewPassword: "brand-new-Passw0rd!"})
+	if code := changePassword(h, auth, accessToken, ChangePasswordRequest{
+		CurrentPassword:    "current-Passw0rd!",
+		NewPassword:        "brand-new-Passw0rd!",
+		KeepCurrentSession: true,
+	}); code != http.StatusOK {
+		t.Fatalf("change status = %d", code)
+	}
+	changePassword(h, auth, accessToken, ChangePasswordRequest{CurrentPassword: "wrong-Passw0rd!", NewPassword: "another-Passw0rd!"})
+	if code, _ := authenticate(auth, accessToken); code != http.StatusOK {
+		t.Error("failures before a correct password still counted")
+	}
+}
+
+func TestReauthWindowIsPerSession(t *testing.T) {
+	h, auth, db := newTestPasswordHandler(t)
+	user := newTestUser(t, db, "alice")
+	newTestSession(t, db, user, "laptop", "refresh-laptop")
+	newTestSession(t, db, user, "phone", "refresh-phone")
+	if err := db.Model(&database.Session{}).Where("session_id = ?", "laptop").
+		Update("created_at", time.Now().Add(-time.Hour)).Error; err != nil {
+		t.Fatal(err)
+	}
+	laptop := refresh(t, auth, "refresh-laptop")
+	phone := refresh(t, auth, "refresh-phone")
+
+	// The phone logged in just now, that does not spare the laptop the current password
+	req := ChangePasswordRequest{NewPassword: "brand-new-Passw0rd!", KeepCurrentSession: true}
+	if code := changePassword(h, auth, laptop, req); code != http.StatusUnauthorized {
+		t.Errorf("old session status = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := changePassword(h, auth, phone, req); code != http.StatusOK {
+		t.Errorf("recent session status = %d, want %d", code, http.StatusOK)
+	}
+}
@@ -2,6 +2,7 @@ package health
 
 import (
 	"core-auth/internal/core"
+	"core-auth/internal/maintenance"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -37,4 +38,20 @@ func (h *HealthHandler) Check(c *gin.Context) {
 	}
 	
 	c.JSON(http.StatusOK, status)
-}
\ No newline at end of file
+}
+
+// Maintenance returns the status of the last run of each maintenance job
+func (h *HealthHandler) Maintenance(c *gin.Context) {
+	if h.rdb == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Redis is unavailable"})
+		return
+	}
+
+	statuses, err := maintenance.GetStatus(c.Request.Context(), h.rdb)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to load maintenance status"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"jobs": statuses})
+}
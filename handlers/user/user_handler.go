@@ -2,28 +2,33 @@ package user
 
 import (
 	database "core-auth/db"
-	token "core-auth/internal/tokens"
+	"core-auth/internal/identifier"
+	"core-auth/internal/passwordpolicy"
+	"core-auth/internal/verification"
+	"errors"
+	"log"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type UserHandler struct {
-	db *gorm.DB
+	db          *gorm.DB
+	verifier    *verification.EmailVerifier
+	policy      *passwordpolicy.Policy
+	identifiers *identifier.Policy
 }
 
-func NewUserHandler(db *gorm.DB) *UserHandler {
-	return &UserHandler{db: db}
+func NewUserHandler(db *gorm.DB, verifier *verification.EmailVerifier, policy *passwordpolicy.Policy, identifiers *identifier.Policy) *UserHandler {
+	return &UserHandler{db: db, verifier: verifier, policy: policy, identifiers: identifiers}
 }
 
 type CreateUserRequest struct {
 	Username string `json:"username" binding:"required"`
 	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=8"`
-	RoleID   *uint  `json:"role_id"`
+	Password string `json:"password" binding:"required"` // checked against the password policy
 }
 
 type UserResponse struct {
@@ -31,8 +36,11 @@ type UserResponse struct {
 	Username string `json:"username"`
 	Email    string `json:"email"`
 	RoleID   uint   `json:"role_id"`
-	RefreshToken string `json:"refresh_token"`
-	RefreshTokenExpiry *time.Time `json:"refresh_token_expiry"`
+	PendingEmailVerification bool `json:"pending_email_verification"`
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email" binding:"required,email"`
 }
 
 func (h *UserHandler) CreateUser(c *gin.Context) {
@@ -42,38 +50,48 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 		return
 	}
 
-	// Check if username already exists
-	exists := database.CheckUsernameDB(h.db, req.Username)
-	if exists {
-		c.JSON(http.StatusConflict, gin.H{"error": "Username already exists"})
+	// Usernames are stored PRECIS-normalized, look-alike and reserved names are rejected
+	username, err := h.identifiers.Username(req.Username)
+	if err != nil {
+		switch {
+		case errors.Is(err, identifier.ErrReservedUsername):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Username is reserved"})
+		case errors.Is(err, identifier.ErrConfusableUsername):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Username mixes characters of different scripts"})
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	email, err := h.identifiers.Email(req.Email)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email address"})
 		return
 	}
+	req.Username, req.Email = username, email
 
-	// Set default role if none provided
-	roleID := database.GetDefaultUserRole()
-	if req.RoleID != nil {
-		// Validate role ID if provided
-		if err := database.ValidateRoleID(h.db, *req.RoleID); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role ID"})
-			return
-		}
-		roleID = *req.RoleID
+	if violations := h.policy.Check(req.Password, passwordpolicy.Account{Username: req.Username, Email: req.Email}); len(violations) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password does not meet the password policy", "reasons": violations})
+		return
 	}
 
-	refreshToken, tokenExpiry, err := token.GenerateRefreshToken()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate refresh token"})
+	// Check if username, or one looking like it, already exists
+	exists := database.CheckUsernameDB(h.db, req.Username)
+	if exists {
+		c.JSON(http.StatusConflict, gin.H{"error": "Username already exists"})
 		return
 	}
 
-	// Create new user
+	// Self-registered users always get the default role
+	roleID := database.GetDefaultUserRole()
+
+	// Create new user, pending until the email address is confirmed
 	user := &database.User{
 		Username: req.Username,
 		Email:    req.Email,
 		Password: req.Password,
 		RoleID:   roleID,
-		RefreshToken: refreshToken,
-		RefreshTokenExpiry: &tokenExpiry,
+		PendingEmailVerification: true,
 	}
 
 	// Use the database function to create user
@@ -93,14 +111,47 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 		return
 	}
 
+	// The link is sent in the background. The account is created even if it cannot be sent,
+	// it can be resent.
+	if err := h.verifier.Send(c.Request.Context(), user); err != nil {
+		log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
+	}
+
 	response := UserResponse{
 		ID:       user.ID,
 		Username: user.Username,
 		Email:    user.Email,
 		RoleID:   user.RoleID,
-		RefreshToken: refreshToken,
-		RefreshTokenExpiry: &tokenExpiry,
+		PendingEmailVerification: user.PendingEmailVerification,
 	}
 
 	c.JSON(http.StatusCreated, response)
 }
+
+// VerifyEmail confirms the email address of an account from its verification link
+func (h *UserHandler) VerifyEmail(c *gin.Context) {
+	user, err := h.verifier.Verify(c.Query("token"))
+	if err != nil {
+		if errors.Is(err, verification.ErrInvalidLink) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification link"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified", "username": user.Username})
+}
+
+// ResendVerification sends a new verification link. The response is the same whether or
+// not the address belongs to a pending account, and links are throttled per account.
+func (h *UserHandler) ResendVerification(c *gin.Context) {
+	var req ResendVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.verifier.Resend(c.Request.Context(), req.Email); err != nil {
+		log.Printf("Failed to resend verification email: %v", err)
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the address belongs to an unverified account, a verification link has been sent"})
+}
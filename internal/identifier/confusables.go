@@ -0,0 +1,107 @@
+package identifier
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+//go:generate curl -sSfo confusables.txt https://www.unicode.org/Public/security/latest/confusables.txt
+
+// confusablesData is the confusables.txt data of UTS #39 skeletons are computed with. Stored
+// username skeletons are computed from it, replacing it needs a data migration recomputing them.
+//
+//go:embed confusables.txt
+var confusablesData []byte
+
+// prototypes maps characters to the string they are confusable with
+var prototypes = parseConfusables(confusablesData)
+
+// parseConfusables reads the "source ; prototype ; type" lines of confusables.txt, whose code
+// points are hexadecimal
+func parseConfusables(data []byte) map[rune]string {
+	prototypes := make(map[rune]string)
+	scanner := bufio.NewScanner(bytes.NewReader(bytes.TrimPrefix(data, []byte("\ufeff"))))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Split(line, ";")
+		if len(fields) < 2 {
+			continue
+		}
+		source, err := strconv.ParseUint(strings.TrimSpace(fields[0]), 16, 32)
+		if err != nil {
+			continue
+		}
+		var prototype strings.Builder
+		for _, cp := range strings.Fields(fields[1]) {
+			r, err := strconv.ParseUint(cp, 16, 32)
+			if err != nil {
+				prototype.Reset()
+				break
+			}
+			prototype.WriteRune(rune(r))
+		}
+		if prototype.Len() > 0 {
+			prototypes[rune(source)] = prototype.String()
+		}
+	}
+	return prototypes
+}
+
+// Skeleton returns the form of a username in which confusable names are equal: the skeleton
+// of UTS #39, the NFD form with each character replaced by its prototype, of the username as
+// displayed, so that a capital I looks like an l. Usernames are compared case-insensitively,
+// so the skeleton is case folded, after the mapping whose prototypes include capitals.
+func Skeleton(username string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(username) {
+		if p, ok := prototypes[r]; ok {
+			b.WriteString(p)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return norm.NFD.String(fold.String(b.String()))
+}
+
+// scriptSets are the combinations of scripts a username may mix, the Highly Restrictive
+// level of UTS #39: Latin with the scripts of Japanese, Chinese or Korean writing
+var scriptSets = [][]string{
+	{"Latin", "Han", "Hiragana", "Katakana"},
+	{"Latin", "Han", "Bopomofo"},
+	{"Latin", "Han", "Hangul"},
+}
+
+// singleScript reports whether the characters of a username belong to one script, or to one
+// of the combinations used to write a single language
+func singleScript(username string) bool {
+	scripts := make(map[string]bool)
+	for _, r := range username {
+		for name, table := range unicode.Scripts {
+			if name != "Common" && name != "Inherited" && unicode.Is(table, r) {
+				scripts[name] = true
+				break
+			}
+		}
+	}
+	if len(scripts) <= 1 {
+		return true
+	}
+	for _, set := range scriptSets {
+		allowed := 0
+		for _, name := range set {
+			if scripts[name] {
+				allowed++
+			}
+		}
+		if allowed == len(scripts) {
+			return true
+		}
+	}
+	return false
+}
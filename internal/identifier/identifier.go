@@ -0,0 +1,116 @@
+// Package identifier normalizes the usernames and email addresses accounts are identified by,
+// so that case and Unicode variants of an identifier name the same account
+package identifier
+
+import (
+	"core-auth/config"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/secure/precis"
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	// ErrInvalidUsername is returned for usernames of disallowed characters or length
+	ErrInvalidUsername = errors.New("invalid username")
+	// ErrConfusableUsername is returned for usernames mixing scripts, which can look like other names
+	ErrConfusableUsername = errors.New("username mixes characters of different scripts")
+	// ErrReservedUsername is returned for reserved usernames and names looking like them
+	ErrReservedUsername = errors.New("username is reserved")
+	// ErrInvalidEmail is returned for email addresses that cannot be normalized
+	ErrInvalidEmail = errors.New("invalid email address")
+)
+
+// fold case folds compatibility-normalized text
+var fold = cases.Fold()
+
+// UsernameKey returns the form usernames are compared in: the PRECIS UsernameCaseMapped
+// profile of RFC 8265. Usernames the profile rejects, of accounts registered before usernames
+// were validated, are compared NFKC-normalized and case folded.
+func UsernameKey(username string) string {
+	if key, err := precis.UsernameCaseMapped.String(username); err == nil {
+		return key
+	}
+	return fold.String(norm.NFKC.String(strings.TrimSpace(username)))
+}
+
+// EmailKey returns the form email addresses are compared in: the local part NFKC-normalized
+// and case folded, the domain in lowercase ASCII
+func EmailKey(email string) string {
+	email = strings.TrimSpace(email)
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return fold.String(norm.NFKC.String(email))
+	}
+	local, domain := email[:at], email[at+1:]
+	if ascii, err := idna.Lookup.ToASCII(domain); err == nil {
+		domain = strings.ToLower(ascii)
+	} else {
+		domain = fold.String(norm.NFKC.String(domain))
+	}
+	return fold.String(norm.NFKC.String(local)) + "@" + domain
+}
+
+// IsEmail reports whether a login identifier is an email address rather than a username,
+// usernames cannot contain "@"
+func IsEmail(login string) bool {
+	return strings.Contains(login, "@")
+}
+
+// Policy checks the identifiers of new accounts
+type Policy struct {
+	config   *config.Config
+	reserved map[string]bool // skeletons of the reserved usernames
+}
+
+// New creates the identifier policy of the configuration
+func New() *Policy {
+	config, err := config.LoadFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	reserved := make(map[string]bool, len(config.Usernames.Reserved))
+	for _, name := range config.Usernames.Reserved {
+		reserved[Skeleton(name)] = true
+	}
+	return &Policy{config: config, reserved: reserved}
+}
+
+// Username checks a new username and returns the form it is stored in, the PRECIS
+// UsernameCasePreserved profile, which keeps the case the user chose
+func (p *Policy) Username(username string) (string, error) {
+	display, err := precis.UsernameCasePreserved.String(username)
+	if err != nil || IsEmail(display) {
+		return "", fmt.Errorf("%w: only letters, digits and symbols without spaces or @ are allowed", ErrInvalidUsername)
+	}
+	if n := utf8.RuneCountInString(display); n < p.config.Usernames.MinLength || n > p.config.Usernames.MaxLength {
+		return "", fmt.Errorf("%w: must be between %d and %d characters long", ErrInvalidUsername,
+			p.config.Usernames.MinLength, p.config.Usernames.MaxLength)
+	}
+	if !singleScript(display) {
+		return "", ErrConfusableUsername
+	}
+	if p.reserved[Skeleton(display)] {
+		return "", ErrReservedUsername
+	}
+	return display, nil
+}
+
+// Email checks the email address of a new account and returns the form it is stored in
+func (p *Policy) Email(email string) (string, error) {
+	email = norm.NFC.String(strings.TrimSpace(email))
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || at == len(email)-1 {
+		return "", ErrInvalidEmail
+	}
+	if _, err := idna.Lookup.ToASCII(email[at+1:]); err != nil {
+		return "", ErrInvalidEmail
+	}
+	return email, nil
+}
@@ -0,0 +1,117 @@
+package identifier
+
+import (
+	"errors"
+	"testing"
+)
+
+// newTestPolicy returns the identifier policy of the default configuration
+func newTestPolicy(t *testing.T) *Policy {
+	t.Helper()
+	t.Setenv("OAUTH2_JWT_KEY_ENCRYPTION_SECRET", "test-key-encryption-secret")
+	t.Setenv("OAUTH2_PAIRWISE_SALT", "test-pairwise-salt")
+	t.Setenv("EMAIL_VERIFICATION_SECRET", "test-email-verification-secret")
+	t.Setenv("MFA_ENCRYPTION_SECRET", "test-mfa-encryption-secret")
+	return New()
+}
+
+func TestUsernameKey(t *testing.T) {
+	tests := []struct {
+		username string
+		want     string
+	}{
+		{"Alice", "alice"},
+		{"ＡＬＩＣＥ", "alice"}, // fullwidth
+		{"Straße", "straße"},
+		{"ÉLODIE", "élodie"},
+		{"élodie", "élodie"}, // decomposed accent
+		{" Alice Smith ", "alice smith"},
+	}
+	for _, tt := range tests {
+		if got := UsernameKey(tt.username); got != tt.want {
+			t.Errorf("UsernameKey(%q) = %q, want %q", tt.username, got, tt.want)
+		}
+	}
+}
+
+func TestEmailKey(t *testing.T) {
+	tests := []struct {
+		email string
+		want  string
+	}{
+		{"Alice@Example.COM", "alice@example.com"},
+		{" alice@example.com ", "alice@example.com"},
+		{"Ａlice@example.com", "alice@example.com"},
+		{"josé@Bücher.example", "josé@xn--bcher-kva.example"},
+		{"weird@local@example.com", "weird@local@example.com"},
+	}
+	for _, tt := range tests {
+		if got := EmailKey(tt.email); got != tt.want {
+			t.Errorf("EmailKey(%q) = %q, want %q", tt.email, got, tt.want)
+		}
+	}
+}
+
+func TestSkeleton(t *testing.T) {
+	same := [][2]string{
+		{"paypal", "раураl"}, // Cyrillic а, р, у
+		{"apple", "αpple"},   // Greek alpha
+		{"google", "gօօgle"}, // Armenian oh
+		{"admin", "admіn"},   // Cyrillic і
+		{"paypal", "paypa1"}, // digit one
+		{"paypal", "paypaI"}, // capital I
+		{"PayPal", "paypal"},
+		{"google", "g00gle"}, // digit zero, whose prototype is a capital O
+		{"modern", "rnodern"},
+	}
+	for _, pair := range same {
+		if Skeleton(pair[0]) != Skeleton(pair[1]) {
+			t.Errorf("Skeleton(%q) = %q, Skeleton(%q) = %q, want equal", pair[0], Skeleton(pair[0]), pair[1], Skeleton(pair[1]))
+		}
+	}
+	// Distinct names the confusable data does not map together
+	different := [][2]string{
+		{"bob", "bot"},
+		{"café", "cafe"},
+	}
+	for _, pair := range different {
+		if Skeleton(pair[0]) == Skeleton(pair[1]) {
+			t.Errorf("Skeleton(%q) = Skeleton(%q) = %q, want different", pair[0], pair[1], Skeleton(pair[0]))
+		}
+	}
+}
+
+func TestParseConfusables(t *testing.T) {
+	data := "\ufeff# comment\n\n" +
+		"0430 ;\t0061 ;\tMA\t# ( а → a )\n" +
+		"33A1 ;\t006D 00B2 ;\tMA\t# ( ㎡ → m² )\n" +
+		"XYZ ;\t0061 ;\tMA\n"
+	prototypes := parseConfusables([]byte(data))
+	if len(prototypes) != 2 || prototypes['а'] != "a" || prototypes['㎡'] != "m²" {
+		t.Errorf("prototypes = %q", prototypes)
+	}
+}
+
+func TestPolicyUsername(t *testing.T) {
+	p := newTestPolicy(t)
+	if got, err := p.Username("Élodie"); err != nil || got != "Élodie" {
+		t.Errorf("Username(Élodie) = %q, %v", got, err)
+	}
+	tests := []struct {
+		username string
+		want     error
+	}{
+		{"al", ErrInvalidUsername},
+		{"alice@example.com", ErrInvalidUsername},
+		{"alice smith", ErrInvalidUsername},
+		{"pаypal", ErrConfusableUsername}, // Latin and Cyrillic
+		{"Admin", ErrReservedUsername},
+		{"аdmin", ErrConfusableUsername},
+		{"һеӀр", ErrReservedUsername}, // all Cyrillic, looks like help
+	}
+	for _, tt := range tests {
+		if _, err := p.Username(tt.username); !errors.Is(err, tt.want) {
+			t.Errorf("Username(%q) error = %v, want %v", tt.username, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package mailer
+
+import (
+	"context"
+	"core-auth/config"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FileMailer writes emails to an outbox directory instead of sending them, for local testing
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+// NewFileMailer creates a mailer writing to the configured outbox directory
+func NewFileMailer(cfg *config.Config) *FileMailer {
+	return &FileMailer{
+		dir:  cfg.Mail.OutboxDir,
+		from: cfg.Mail.From,
+	}
+}
+
+// Send implements Mailer, each message is written to its own .eml file
+func (m *FileMailer) Send(ctx context.Context, msg *Message) error {
+	if !validHeader(msg.To) || !validHeader(msg.Subject) {
+		return fmt.Errorf("invalid message header")
+	}
+	if err := os.MkdirAll(m.dir, 0o700); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405Z"), uuid.New().String())
+	return os.WriteFile(filepath.Join(m.dir, name), format(m.from, msg), 0o600)
+}
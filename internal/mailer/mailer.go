@@ -0,0 +1,54 @@
+package mailer
+
+import (
+	"context"
+	"core-auth/config"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// New creates the mailer selected by the configuration, sending from a background queue
+func New(cfg *config.Config) (Mailer, error) {
+	var m Mailer
+	switch cfg.Mail.Driver {
+	case "smtp":
+		m = NewSMTPMailer(cfg)
+	case "file":
+		m = NewFileMailer(cfg)
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", cfg.Mail.Driver)
+	}
+	return NewQueue(m, cfg.Mail.Workers, cfg.Mail.QueueSize), nil
+}
+
+// format renders a message in the Internet Message Format (RFC 5322)
+func format(from string, msg *Message) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + msg.To + "\r\n")
+	b.WriteString("Subject: " + msg.Subject + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// validHeader rejects header values that would inject other headers
+func validHeader(value string) bool {
+	return !strings.ContainsAny(value, "\r\n")
+}
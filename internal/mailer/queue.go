@@ -0,0 +1,48 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"log"
+)
+
+// ErrQueueFull is returned when a message cannot be queued
+var ErrQueueFull = errors.New("mail queue is full")
+
+// Queue sends emails in the background through another mailer, so that requests neither wait
+// for the mail server nor reveal through their timing whether an email was sent
+type Queue struct {
+	mailer   Mailer
+	messages chan *Message
+}
+
+// NewQueue creates a queue sending with the given mailer from a fixed number of workers
+func NewQueue(m Mailer, workers int, size int) *Queue {
+	q := &Queue{
+		mailer:   m,
+		messages: make(chan *Message, size),
+	}
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+// Send implements Mailer, the message is queued and sent later
+func (q *Queue) Send(ctx context.Context, msg *Message) error {
+	select {
+	case q.messages <- msg:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// work sends queued messages until the queue is closed
+func (q *Queue) work() {
+	for msg := range q.messages {
+		if err := q.mailer.Send(context.Background(), msg); err != nil {
+			log.Printf("Failed to send email %q: %v", msg.Subject, err)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// blockingMailer hands every message to the test and waits until released
+type blockingMailer struct {
+	sent    chan *Message
+	release chan struct{}
+}
+
+// Send implements Mailer
+func (m *blockingMailer) Send(ctx context.Context, msg *Message) error {
+	m.sent <- msg
+	<-m.release
+	return nil
+}
+
+func TestQueueSendsInBackground(t *testing.T) {
+	m := &blockingMailer{sent: make(chan *Message, 10), release: make(chan struct{})}
+	defer close(m.release)
+	q := NewQueue(m, 1, 1)
+
+	// The mail server hangs, queueing still returns at once
+	done := make(chan error)
+	go func() { done <- q.Send(context.Background(), &Message{To: "a@example.com", Subject: "first"}) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("send: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("send waited for the mail server")
+	}
+	if msg := <-m.sent; msg.Subject != "first" {
+		t.Errorf("sent %q, want first", msg.Subject)
+	}
+
+	// The worker is busy and the queue holds one message
+	if err := q.Send(context.Background(), &Message{Subject: "second"}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if err := q.Send(context.Background(), &Message{Subject: "third"}); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("send to a full queue error = %v, want %v", err, ErrQueueFull)
+	}
+}
@@ -0,0 +1,37 @@
+package mailer
+
+import (
+	"context"
+	"core-auth/config"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+)
+
+// SMTPMailer sends emails through an SMTP relay, upgrading to TLS when the server supports it
+type SMTPMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// NewSMTPMailer creates a mailer for the configured SMTP relay
+func NewSMTPMailer(cfg *config.Config) *SMTPMailer {
+	m := &SMTPMailer{
+		addr: net.JoinHostPort(cfg.Mail.SMTP.Host, strconv.Itoa(cfg.Mail.SMTP.Port)),
+		from: cfg.Mail.From,
+	}
+	if cfg.Mail.SMTP.Username != "" {
+		m.auth = smtp.PlainAuth("", cfg.Mail.SMTP.Username, cfg.Mail.SMTP.Password, cfg.Mail.SMTP.Host)
+	}
+	return m
+}
+
+// Send implements Mailer
+func (m *SMTPMailer) Send(ctx context.Context, msg *Message) error {
+	if !validHeader(msg.To) || !validHeader(msg.Subject) {
+		return fmt.Errorf("invalid message header")
+	}
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, format(m.from, msg))
+}
@@ -0,0 +1,81 @@
+package maintenance
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const redisLeasePrefix = "maintenance:lease:"
+
+// renewScript takes the lease when it is free or already held by the caller
+var renewScript = redis.NewScript(`
+local holder = redis.call("GET", KEYS[1])
+if holder == false then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 1
+end
+if holder == ARGV[1] then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// holdScript moves the expiry of the lease only if it is still held by the caller
+var holdScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIREAT", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseScript deletes the lease only if it is still held by the caller
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Lease is a Redis lock that elects a single instance to run a job
+type Lease struct {
+	rdb    *redis.Client
+	key    string
+	holder string
+	ttl    time.Duration
+}
+
+// NewLease creates a lease for the named job held by the given instance
+func NewLease(rdb *redis.Client, job, holder string, ttl time.Duration) *Lease {
+	return &Lease{
+		rdb:    rdb,
+		key:    redisLeasePrefix + job,
+		holder: holder,
+		ttl:    ttl,
+	}
+}
+
+// Acquire takes the lease, returning false if another instance holds it
+func (l *Lease) Acquire(ctx context.Context) (bool, error) {
+	return l.rdb.SetNX(ctx, l.key, l.holder, l.ttl).Result()
+}
+
+// AcquireOrRenew takes the lease for its TTL unless another instance holds it, so that the
+// instance that ran a job last keeps running it. Unlike Acquire it does not exclude other
+// callers of the same instance.
+func (l *Lease) AcquireOrRenew(ctx context.Context) (bool, error) {
+	return renewScript.Run(ctx, l.rdb, []string{l.key}, l.holder, l.ttl.Milliseconds()).Bool()
+}
+
+// HoldUntil keeps the lease until the given time if it is still held by this instance, so
+// that no other instance runs the job before then. A time in the past releases it.
+func (l *Lease) HoldUntil(ctx context.Context, until time.Time) error {
+	return holdScript.Run(ctx, l.rdb, []string{l.key}, l.holder, until.UnixMilli()).Err()
+}
+
+// Release gives up the lease if it is still held by this instance
+func (l *Lease) Release(ctx context.Context) error {
+	return releaseScript.Run(ctx, l.rdb, []string{l.key}, l.holder).Err()
+}
@@ -0,0 +1,200 @@
+package maintenance
+
+import (
+	"context"
+	"core-auth/config"
+	database "core-auth/db"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const redisStatusPrefix = "maintenance:status:"
+
+// Job names
+const (
+	JobOAuth2Tokens         = "oauth2_tokens"
+	JobOAuth2Authorizations = "oauth2_authorizations"
+	JobSessions             = "sessions"
+	JobUserRefreshTokens    = "user_refresh_tokens"
+)
+
+// JobStatus describes the last run of a job
+type JobStatus struct {
+	Job        string    `json:"job"`
+	Instance   string    `json:"instance"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Purged     int64     `json:"purged"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// errLeaseLost stops a job whose lease another instance took over while it ran
+var errLeaseLost = errors.New("maintenance lease lost")
+
+// job purges a batch of expired rows, returning how many were removed
+type job struct {
+	name      string
+	retention time.Duration
+	run       func(before time.Time, batchSize int) (int64, error)
+}
+
+// Sweeper periodically purges expired tokens, authorization codes and sessions.
+// Each job runs on a single instance at a time, elected with a Redis lease.
+type Sweeper struct {
+	rdb      *redis.Client
+	config   *config.Config
+	instance string
+	jobs     []job
+}
+
+// NewSweeper creates a new maintenance sweeper
+func NewSweeper(db *gorm.DB, rdb *redis.Client) *Sweeper {
+	cfg, err := config.LoadFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	hostname, _ := os.Hostname()
+	queries := database.NewOAuth2Queries(db)
+	hours := func(h int) time.Duration { return time.Duration(h) * time.Hour }
+
+	return &Sweeper{
+		rdb:      rdb,
+		config:   cfg,
+		instance: fmt.Sprintf("%s-%s", hostname, uuid.New().String()[:8]),
+		jobs: []job{
+			{JobOAuth2Tokens, hours(cfg.Maintenance.TokenRetention), queries.CleanupExpiredTokens},
+			{JobOAuth2Authorizations, hours(cfg.Maintenance.AuthorizationRetention), queries.CleanupExpiredAuthorizationCodes},
+			{JobSessions, hours(cfg.Maintenance.SessionRetention), func(before time.Time, batchSize int) (int64, error) {
+				return database.CleanupExpiredSessions(db, before, batchSize)
+			}},
+			{JobUserRefreshTokens, hours(cfg.Maintenance.RefreshTokenRetention), func(before time.Time, batchSize int) (int64, error) {
+				return database.CleanupExpiredRefreshTokens(db, before, batchSize)
+			}},
+		},
+	}
+}
+
+// Start runs the jobs on every interval until the context is cancelled
+func (s *Sweeper) Start(ctx context.Context) {
+	interval := time.Duration(s.config.Maintenance.Interval) * time.Second
+	log.Printf("Starting maintenance sweeper %s, running every %v", s.instance, interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.RunOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce runs every job whose lease this instance can acquire. A job's lease is kept for an
+// interval after the job started, so each job runs once per interval across all instances.
+func (s *Sweeper) RunOnce(ctx context.Context) {
+	if s.rdb == nil {
+		log.Println("Warning: Redis unavailable, skipping maintenance run")
+		return
+	}
+
+	interval := time.Duration(s.config.Maintenance.Interval) * time.Second
+	leaseTTL := time.Duration(s.config.Maintenance.LeaseTTL) * time.Second
+	for _, j := range s.jobs {
+		lease := NewLease(s.rdb, j.name, s.instance, leaseTTL)
+		acquired, err := lease.AcquireOrRenew(ctx)
+		if err != nil {
+			log.Printf("Warning: failed to acquire maintenance lease for %s: %v", j.name, err)
+			continue
+		} else if !acquired {
+			continue
+		}
+
+		status := s.runJob(ctx, j, lease)
+		if err := s.saveStatus(ctx, status); err != nil {
+			log.Printf("Warning: failed to save maintenance status for %s: %v", j.name, err)
+		}
+		if err := lease.HoldUntil(ctx, status.StartedAt.Add(interval)); err != nil {
+			log.Printf("Warning: failed to hold maintenance lease for %s: %v", j.name, err)
+		}
+	}
+}
+
+// runJob purges the rows that expired before the job's retention period one batch at a time,
+// renewing the lease between batches. The job stops if the lease cannot be renewed, so that it
+// never runs on two instances at once.
+func (s *Sweeper) runJob(ctx context.Context, j job, lease *Lease) *JobStatus {
+	status := &JobStatus{
+		Job:       j.name,
+		Instance:  s.instance,
+		StartedAt: time.Now().UTC(),
+	}
+
+	before := status.StartedAt.Add(-j.retention)
+	batchSize := s.config.Maintenance.BatchSize
+	var purged int64
+	var err error
+	for {
+		var n int64
+		n, err = j.run(before, batchSize)
+		purged += n
+		if err != nil || n < int64(batchSize) {
+			break
+		}
+		var renewed bool
+		if renewed, err = lease.AcquireOrRenew(ctx); err == nil && !renewed {
+			err = errLeaseLost
+		}
+		if err != nil {
+			break
+		}
+	}
+	status.Purged = purged
+	status.FinishedAt = time.Now().UTC()
+	if err != nil {
+		status.Error = err.Error()
+		log.Printf("Maintenance job %s failed after purging %d rows: %v", j.name, purged, err)
+	} else if purged > 0 {
+		log.Printf("Maintenance job %s purged %d rows", j.name, purged)
+	}
+	return status
+}
+
+// saveStatus stores the job status so that it is visible from every instance
+func (s *Sweeper) saveStatus(ctx context.Context, status *JobStatus) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	return s.rdb.Set(ctx, redisStatusPrefix+status.Job, data, 0).Err()
+}
+
+// GetStatus returns the last run of every job, omitting jobs that never ran
+func GetStatus(ctx context.Context, rdb *redis.Client) ([]JobStatus, error) {
+	statuses := []JobStatus{}
+	for _, name := range []string{JobOAuth2Tokens, JobOAuth2Authorizations, JobSessions, JobUserRefreshTokens} {
+		data, err := rdb.Get(ctx, redisStatusPrefix+name).Bytes()
+		if err == redis.Nil {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		var status JobStatus
+		if err := json.Unmarshal(data, &status); err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
@@ -0,0 +1,188 @@
+package maintenance
+
+import (
+	"context"
+	"core-auth/config"
+	"core-auth/internal/testutil"
+	"testing"
+	"time"
+)
+
+// newTestSweeper returns a sweeper of the given instance counting the runs of one job
+func newTestSweeper(t *testing.T, cfg *config.Config, instance string, runs *int) *Sweeper {
+	t.Helper()
+	return &Sweeper{
+		config:   cfg,
+		instance: instance,
+		jobs: []job{{name: JobSessions, run: func(before time.Time, batchSize int) (int64, error) {
+			*runs++
+			return 0, nil
+		}}},
+	}
+}
+
+func testConfig(t *testing.T) *config.Config {
+	t.Helper()
+	testutil.SetSecrets(t)
+	cfg, err := config.LoadFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Maintenance.Interval = 900
+	cfg.Maintenance.LeaseTTL = 300
+	return cfg
+}
+
+func TestRunOnceRunsEachJobOncePerInterval(t *testing.T) {
+	rdb, server := testutil.NewRedis(t)
+	cfg := testConfig(t)
+	ctx := context.Background()
+
+	var runsA, runsB int
+	a := newTestSweeper(t, cfg, "a", &runsA)
+	b := newTestSweeper(t, cfg, "b", &runsB)
+	a.rdb, b.rdb = rdb, rdb
+
+	a.RunOnce(ctx)
+	if runsA != 1 {
+		t.Fatalf("leader runs = %d, want 1", runsA)
+	}
+
+	// The lease outlives the run, another instance skips the job for the rest of the interval
+	server.FastForward(10 * time.Minute)
+	b.RunOnce(ctx)
+	if runsB != 0 {
+		t.Fatalf("second instance ran the job within the interval")
+	}
+
+	// The leader keeps running the job on its next tick
+	a.RunOnce(ctx)
+	if runsA != 2 {
+		t.Fatalf("leader runs = %d, want 2", runsA)
+	}
+
+	// Once the leader stops, another instance takes over after the interval
+	server.FastForward(16 * time.Minute)
+	b.RunOnce(ctx)
+	if runsB != 1 {
+		t.Fatalf("second instance runs = %d, want 1 after the lease expired", runsB)
+	}
+}
+
+func TestRunOnceRecordsStatus(t *testing.T) {
+	rdb, _ := testutil.NewRedis(t)
+	var runs int
+	s := newTestSweeper(t, testConfig(t), "a", &runs)
+	s.rdb = rdb
+
+	s.RunOnce(context.Background())
+	statuses, err := GetStatus(context.Background(), rdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 1 || statuses[0].Job != JobSessions || statuses[0].Instance != "a" {
+		t.Fatalf("statuses = %+v", statuses)
+	}
+}
+
+func TestLeaseAcquireIsExclusive(t *testing.T) {
+	rdb, server := testutil.NewRedis(t)
+	ctx := context.Background()
+	a := NewLease(rdb, "job", "a", time.Minute)
+	b := NewLease(rdb, "job", "b", time.Minute)
+
+	if ok, err := a.Acquire(ctx); err != nil || !ok {
+		t.Fatalf("first acquire = %v, %v", ok, err)
+	}
+	if ok, _ := a.Acquire(ctx); ok {
+		t.Fatal("Acquire is not exclusive within an instance")
+	}
+	if ok, _ := b.AcquireOrRenew(ctx); ok {
+		t.Fatal("another instance renewed a held lease")
+	}
+	if ok, _ := a.AcquireOrRenew(ctx); !ok {
+		t.Fatal("holder could not renew its lease")
+	}
+
+	if err := b.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !server.Exists(redisLeasePrefix + "job") {
+		t.Fatal("another instance released the lease")
+	}
+	if err := a.HoldUntil(ctx, time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := b.Acquire(ctx); !ok {
+		t.Fatal("holding until a past time did not release the lease")
+	}
+}
+
+func TestJobRenewsLeaseBetweenBatches(t *testing.T) {
+	rdb, server := testutil.NewRedis(t)
+	cfg := testConfig(t)
+	cfg.Maintenance.BatchSize = 10
+	ctx := context.Background()
+
+	// Each batch outlasts most of the lease, three full batches run past its TTL
+	var batches int
+	s := &Sweeper{rdb: rdb, config: cfg, instance: "a", jobs: []job{{name: JobSessions, run: func(before time.Time, batchSize int) (int64, error) {
+		batches++
+		if holder, _ := server.Get(redisLeasePrefix + JobSessions); holder != "a" {
+			t.Fatalf("batch %d ran without the lease, holder = %q", batches, holder)
+		}
+		server.FastForward(4 * time.Minute)
+		if batches == 4 {
+			return 3, nil
+		}
+		return int64(batchSize), nil
+	}}}}
+
+	s.RunOnce(ctx)
+	if batches != 4 {
+		t.Fatalf("batches = %d, want 4", batches)
+	}
+	statuses, err := GetStatus(ctx, rdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 1 || statuses[0].Purged != 33 || statuses[0].Error != "" {
+		t.Fatalf("statuses = %+v", statuses)
+	}
+}
+
+func TestJobStopsWhenLeaseLost(t *testing.T) {
+	rdb, server := testutil.NewRedis(t)
+	cfg := testConfig(t)
+	cfg.Maintenance.BatchSize = 10
+	ctx := context.Background()
+
+	// The lease expires during the first batch and another instance takes the job over
+	var batches int
+	s := &Sweeper{rdb: rdb, config: cfg, instance: "a", jobs: []job{{name: JobSessions, run: func(before time.Time, batchSize int) (int64, error) {
+		batches++
+		if batches > 1 {
+			return 0, nil
+		}
+		server.FastForward(6 * time.Minute)
+		if ok, err := NewLease(rdb, JobSessions, "b", time.Hour).Acquire(ctx); err != nil || !ok {
+			t.Fatalf("other instance acquire = %v, %v", ok, err)
+		}
+		return int64(batchSize), nil
+	}}}}
+
+	s.RunOnce(ctx)
+	if batches != 1 {
+		t.Fatalf("batches = %d, want 1 after losing the lease", batches)
+	}
+	statuses, err := GetStatus(ctx, rdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 1 || statuses[0].Purged != 10 || statuses[0].Error != errLeaseLost.Error() {
+		t.Fatalf("statuses = %+v", statuses)
+	}
+	if holder, _ := rdb.Get(ctx, redisLeasePrefix+JobSessions).Result(); holder != "b" {
+		t.Errorf("lease holder = %q, want b", holder)
+	}
+}
@@ -0,0 +1,277 @@
+package mfa
+
+import (
+	"core-auth/config"
+	database "core-auth/db"
+	"core-auth/internal/utils"
+	"core-auth/internal/webauthn"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrAlreadyEnrolled is returned when enrolling a user whose authenticator is already confirmed
+	ErrAlreadyEnrolled = errors.New("authenticator already enrolled")
+	// ErrNotEnrolled is returned when the user has no authenticator to confirm or verify
+	ErrNotEnrolled = errors.New("no authenticator enrolled")
+	// ErrInvalidCode is returned for wrong, expired and replayed codes
+	ErrInvalidCode = errors.New("invalid authentication code")
+	// ErrInvalidChallenge is returned for MFA challenges that are unknown, expired, completed or
+	// out of attempts
+	ErrInvalidChallenge = errors.New("invalid or expired MFA challenge")
+	// ErrUserNotFound is returned when administering the MFA of a user that does not exist
+	ErrUserNotFound = errors.New("user not found")
+	// ErrTooManyAttempts is returned when a session gave too many wrong codes and was ended
+	ErrTooManyAttempts = errors.New("too many failed attempts")
+)
+
+// challengeTokenLength is the length of generated MFA challenge tokens
+const challengeTokenLength = 43
+
+// Enrollment is what an authenticator app needs to enroll a TOTP secret
+type Enrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// Second factors offered by an MFA challenge
+const (
+	MethodTOTP     = "totp"
+	MethodWebAuthn = "webauthn"
+)
+
+// Service enrolls second factors and runs the second step of logins
+type Service struct {
+	db     *gorm.DB
+	rdb    *redis.Client
+	config *config.Config
+	rp     *webauthn.RelyingParty
+}
+
+// NewService creates an MFA service
+func NewService(db *gorm.DB, rdb *redis.Client) *Service {
+	config, err := config.LoadFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	return &Service{
+		db:     db,
+		rdb:    rdb,
+		config: config,
+		rp: &webauthn.RelyingParty{
+			ID:      config.WebAuthn.RPID,
+			Name:    config.WebAuthn.RPName,
+			Origins: config.WebAuthn.Origins,
+			Timeout: time.Duration(config.WebAuthn.Timeout) * time.Second,
+		},
+	}
+}
+
+// EnrollTOTP generates a TOTP secret for a user, replacing an enrollment not yet confirmed.
+// MFA is enabled once ConfirmTOTP accepts a first code.
+func (s *Service) EnrollTOTP(user *database.User) (*Enrollment, error) {
+	if credential, err := database.GetTOTPCredential(s.db, user.ID); err == nil && credential.ConfirmedAt != nil {
+		return nil, ErrAlreadyEnrolled
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := utils.Encrypt(s.config.MFA.EncryptionSecret, []byte(secret))
+	if err != nil {
+		return nil, err
+	}
+	if err := database.SaveTOTPCredential(s.db, &database.TOTPCredential{
+		UserID: user.ID,
+		Secret: encrypted,
+	}); err != nil {
+		return nil, err
+	}
+	return &Enrollment{
+		Secret:          secret,
+		ProvisioningURI: provisioningURI(s.config.MFA.Issuer, user.Username, secret),
+	}, nil
+}
+
+// ConfirmTOTP enables MFA for a user once a first code of the enrolled secret is accepted. The
+// recovery codes generated when this is the user's first second factor are returned. Wrong
+// codes count against the login session, which is ended after the configured maximum.
+func (s *Service) ConfirmTOTP(userID uint, sessionID string, code string) ([]string, error) {
+	credential, err := database.GetTOTPCredential(s.db, userID)
+	if err != nil {
+		return nil, ErrNotEnrolled
+	}
+	if credential.ConfirmedAt != nil {
+		return nil, ErrAlreadyEnrolled
+	}
+	if err := s.reauthenticated(sessionID, s.verifyTOTP(credential, code)); err != nil {
+		return nil, err
+	}
+	if err := database.ConfirmTOTPCredential(s.db, userID); err != nil {
+		return nil, err
+	}
+	return s.recoveryCodesOnEnrollment(userID)
+}
+
+// DisableTOTP removes the authenticator of a user after checking one of its codes. Wrong codes
+// count against the login session, which is ended after the configured maximum.
+func (s *Service) DisableTOTP(userID uint, sessionID string, code string) error {
+	if err := s.reauthenticated(sessionID, s.VerifyTOTP(userID, code)); err != nil {
+		return err
+	}
+	return database.DeleteTOTPCredential(s.db, userID)
+}
+
+// VerifyTOTP checks a code of the confirmed authenticator of a user
+func (s *Service) VerifyTOTP(userID uint, code string) error {
+	credential, err := database.GetTOTPCredential(s.db, userID)
+	if err != nil || credential.ConfirmedAt == nil {
+		return ErrNotEnrolled
+	}
+	return s.verifyTOTP(credential, code)
+}
+
+// StartChallenge creates the second login step of a user whose password was accepted. The
+// returned token is presented with a code to CompleteChallenge.
+func (s *Service) StartChallenge(userID uint) (string, time.Duration, error) {
+	token, err := utils.GenerateRandomString(challengeTokenLength)
+	if err != nil {
+		return "", 0, err
+	}
+	lifetime := time.Duration(s.config.MFA.ChallengeLifetime) * time.Second
+	if err := database.CreateMFAChallenge(s.db, &database.MFAChallenge{
+		UserID:    userID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(lifetime),
+	}); err != nil {
+		return "", 0, err
+	}
+	return token, lifetime, nil
+}
+
+// Methods lists the ways a user can complete an MFA challenge
+func (s *Service) Methods(userID uint) []string {
+	methods := s.factors(userID)
+	if remaining, err := database.CountRecoveryCodes(s.db, userID); err == nil && remaining > 0 {
+		methods = append(methods, MethodRecoveryCode)
+	}
+	return methods
+}
+
+// factors lists the second factors a user has enrolled
+func (s *Service) factors(userID uint) []string {
+	factors := []string{}
+	if credential, err := database.GetTOTPCredential(s.db, userID); err == nil && credential.ConfirmedAt != nil {
+		factors = append(factors, MethodTOTP)
+	}
+	if credentials, err := database.GetWebAuthnCredentials(s.db, userID); err == nil && len(credentials) > 0 {
+		factors = append(factors, MethodWebAuthn)
+	}
+	return factors
+}
+
+// CompleteChallenge checks the code of a login's second step and returns the user logging in.
+// Every attempt counts against the challenge, which is void after the configured maximum.
+func (s *Service) CompleteChallenge(token string, code string) (*database.User, error) {
+	return s.completeChallenge(token, func(userID uint) error {
+		return s.VerifyTOTP(userID, code)
+	})
+}
+
+// completeChallenge counts an attempt at a login's second step and completes it once verify
+// accepts the second factor of its user
+func (s *Service) completeChallenge(token string, verify func(userID uint) error) (*database.User, error) {
+	challenge, err := database.AttemptMFAChallenge(s.db, hashToken(token), s.config.MFA.MaxAttempts)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidChallenge
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := verify(challenge.UserID); err != nil {
+		return nil, err
+	}
+	completed, err := database.CompleteMFAChallenge(s.db, challenge.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !completed {
+		return nil, ErrInvalidChallenge
+	}
+	return database.GetUserByID(s.db, challenge.UserID)
+}
+
+// verifyTOTP checks a code of a TOTP authenticator and claims its time step, so that the code
+// and any earlier one cannot be used again
+func (s *Service) verifyTOTP(credential *database.TOTPCredential, code string) error {
+	secret, err := s.decryptTOTPSecret(credential)
+	if err != nil {
+		return err
+	}
+	step, ok := matchTOTP(string(secret), code, time.Now(), s.config.MFA.TOTPSkew)
+	if !ok {
+		return ErrInvalidCode
+	}
+	claimed, err := database.ClaimTOTPStep(s.db, credential.UserID, step)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// decryptTOTPSecret decrypts the secret of a TOTP authenticator. Secrets encrypted under the
+// JWT secret, the key used before MFA had its own, are re-encrypted under the MFA key.
+func (s *Service) decryptTOTPSecret(credential *database.TOTPCredential) ([]byte, error) {
+	secret, err := utils.Decrypt(s.config.MFA.EncryptionSecret, credential.Secret)
+	if err == nil {
+		return secret, nil
+	}
+	secret, lerr := utils.Decrypt(s.config.JWT.Secret, credential.Secret)
+	if lerr != nil {
+		return nil, err
+	}
+	encrypted, err := utils.Encrypt(s.config.MFA.EncryptionSecret, secret)
+	if err != nil {
+		return nil, err
+	}
+	if err := database.UpdateTOTPSecret(s.db, credential.UserID, encrypted); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// reauthenticated records the outcome of a password or second factor check made by a signed in
+// session. A wrong code, password or passkey counts against the session, which is ended once the
+// configured maximum failed.
+func (s *Service) reauthenticated(sessionID string, err error) error {
+	if err == nil {
+		return database.ResetReauthFailures(s.db, sessionID)
+	}
+	if !errors.Is(err, ErrInvalidCode) && !errors.Is(err, ErrInvalidPassword) && !errors.Is(err, ErrInvalidPasskey) {
+		return err
+	}
+	ended, rerr := database.RecordReauthFailure(s.db, sessionID, s.config.MFA.MaxAttempts)
+	if rerr != nil {
+		return rerr
+	}
+	if ended {
+		return ErrTooManyAttempts
+	}
+	return err
+}
+
+// hashToken returns the stored form of a challenge token or WebAuthn challenge
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,154 @@
+package mfa
+
+import (
+	database "core-auth/db"
+	"core-auth/internal/testutil"
+	"core-auth/internal/utils"
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const testPassword = "correct horse battery staple"
+
+// newTestService returns an MFA service and a user with the password testPassword and an
+// active login session "laptop"
+func newTestService(t *testing.T) (*Service, *database.User, *gorm.DB) {
+	t.Helper()
+	testutil.SetSecrets(t)
+	db := testutil.NewDB(t)
+	user := &database.User{Username: "alice", Email: "alice@example.com", Password: "-", IsActive: true}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := database.UpdatePassword(db, user.ID, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.CreateSession(db, &database.Session{
+		SessionID: "laptop",
+		UserID:    user.ID,
+		Token:     "refresh-laptop",
+		ExpiresAt: time.Now().Add(time.Hour),
+		IsActive:  true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	rdb, _ := testutil.NewRedis(t)
+	return NewService(db, rdb), user, db
+}
+
+// currentCode returns the code of a TOTP secret at a step offset from now
+func currentCode(t *testing.T, secret string, offset int64) string {
+	t.Helper()
+	code, err := totpCode(secret, totpStep(time.Now())+offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// sessionActive reports whether the login session "laptop" is still active
+func sessionActive(t *testing.T, db *gorm.DB) bool {
+	t.Helper()
+	var session database.Session
+	if err := db.Where("session_id = ?", "laptop").First(&session).Error; err != nil {
+		t.Fatal(err)
+	}
+	return session.IsActive
+}
+
+// enroll enrolls and confirms a TOTP authenticator and returns its secret
+func enroll(t *testing.T, s *Service, user *database.User) string {
+	t.Helper()
+	enrollment, err := s.EnrollTOTP(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ConfirmTOTP(user.ID, "laptop", currentCode(t, enrollment.Secret, -1)); err != nil {
+		t.Fatalf("confirm: %v", err)
+	}
+	return enrollment.Secret
+}
+
+func TestTOTPCodeCannotBeReplayed(t *testing.T) {
+	s, user, _ := newTestService(t)
+	secret := enroll(t, s, user)
+
+	code := currentCode(t, secret, 0)
+	if err := s.VerifyTOTP(user.ID, code); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if err := s.VerifyTOTP(user.ID, code); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("replayed code error = %v, want %v", err, ErrInvalidCode)
+	}
+	// A code of an earlier step than the one accepted is refused too
+	if err := s.VerifyTOTP(user.ID, currentCode(t, secret, -1)); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("earlier step error = %v, want %v", err, ErrInvalidCode)
+	}
+}
+
+func TestConfirmTOTPAttemptsAreLimited(t *testing.T) {
+	t.Setenv("MFA_MAX_ATTEMPTS", "3")
+	s, user, db := newTestService(t)
+	if _, err := s.EnrollTOTP(user); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i < 3; i++ {
+		if _, err := s.ConfirmTOTP(user.ID, "laptop", "000000"); !errors.Is(err, ErrInvalidCode) {
+			t.Fatalf("attempt %d error = %v, want %v", i, err, ErrInvalidCode)
+		}
+	}
+	if _, err := s.ConfirmTOTP(user.ID, "laptop", "000000"); !errors.Is(err, ErrTooManyAttempts) {
+		t.Fatalf("last attempt error = %v, want %v", err, ErrTooManyAttempts)
+	}
+	if sessionActive(t, db) {
+		t.Error("session still active after too many wrong codes")
+	}
+}
+
+func TestDisableTOTPAttemptsAreLimited(t *testing.T) {
+	t.Setenv("MFA_MAX_ATTEMPTS", "2")
+	s, user, db := newTestService(t)
+	enroll(t, s, user)
+
+	if err := s.DisableTOTP(user.ID, "laptop", "000000"); !errors.Is(err, ErrInvalidCode) {
+		t.Fatalf("first attempt error = %v, want %v", err, ErrInvalidCode)
+	}
+	if err := s.DisableTOTP(user.ID, "laptop", "000000"); !errors.Is(err, ErrTooManyAttempts) {
+		t.Fatalf("second attempt error = %v, want %v", err, ErrTooManyAttempts)
+	}
+	if sessionActive(t, db) {
+		t.Error("session still active after too many wrong codes")
+	}
+	if _, err := database.GetTOTPCredential(db, user.ID); err != nil {
+		t.Error("authenticator removed without a valid code")
+	}
+}
+
+func TestLegacyTOTPSecretIsReencrypted(t *testing.T) {
+	s, user, db := newTestService(t)
+	secret := enroll(t, s, user)
+
+	// Encrypted under the JWT secret, the key used before MFA had its own
+	legacy, err := utils.Encrypt(s.config.JWT.Secret, []byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := database.UpdateTOTPSecret(db, user.ID, legacy); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.VerifyTOTP(user.ID, currentCode(t, secret, 0)); err != nil {
+		t.Fatalf("verify with a legacy secret: %v", err)
+	}
+
+	credential, err := database.GetTOTPCredential(db, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := utils.Decrypt(s.config.MFA.EncryptionSecret, credential.Secret); err != nil {
+		t.Errorf("secret not re-encrypted under the MFA key: %v", err)
+	}
+}
@@ -0,0 +1,328 @@
+package mfa
+
+import (
+	"context"
+	database "core-auth/db"
+	"core-auth/internal/webauthn"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// WebAuthn ceremonies, a challenge completes only the ceremony it was issued for
+const (
+	ceremonyRegistration     = "registration"
+	ceremonyMFA              = "mfa"
+	ceremonyLogin            = "login"
+	ceremonyReauthentication = "reauthentication"
+)
+
+// redisLoginChallengePrefix keys the challenges of passwordless logins. Anyone can start one,
+// so they are kept in Redis until they expire rather than stored as rows.
+const redisLoginChallengePrefix = "webauthn:login:"
+
+var (
+	// ErrInvalidPasskey is returned for WebAuthn responses that do not verify, belong to another
+	// ceremony or account, or whose ceremony expired
+	ErrInvalidPasskey = errors.New("invalid passkey response")
+	// ErrPasskeyExists is returned when registering a credential that is already registered
+	ErrPasskeyExists = errors.New("passkey already registered")
+	// ErrPasskeyNotFound is returned when removing a passkey the user does not have
+	ErrPasskeyNotFound = errors.New("passkey not found")
+)
+
+// Passkey is a registered passkey as shown to its owner
+type Passkey struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Transports []string   `json:"transports"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// BeginPasskeyRegistration starts registering a passkey for a user, who must re-authenticate
+// first. Only the ceremony started here completes the registration.
+func (s *Service) BeginPasskeyRegistration(user *database.User, sessionID string, proof *Reauthentication) (*webauthn.CreationOptions, error) {
+	if err := s.Reauthenticate(user, sessionID, proof); err != nil {
+		return nil, err
+	}
+	credentials, err := database.GetWebAuthnCredentials(s.db, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	challenge, err := s.startCeremony(ceremonyRegistration, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return s.rp.CreationOptions(challenge, userHandle(user.ID), user.Username, descriptors(credentials)), nil
+}
+
+// FinishPasskeyRegistration verifies a registration response and stores the passkey under a
+// friendly name. The passkey enables MFA on the account, the recovery codes generated when it
+// is the user's first second factor are returned.
+func (s *Service) FinishPasskeyRegistration(user *database.User, name string, resp *webauthn.AttestationResponse) (*Passkey, []string, error) {
+	challenge, err := s.consumeCeremony(resp.Response.ClientDataJSON, ceremonyRegistration, user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	credential, err := s.rp.VerifyRegistration(challenge, resp, false)
+	if err != nil {
+		return nil, nil, ErrInvalidPasskey
+	}
+	credentialID := webauthn.EncodeID(credential.ID)
+	if _, err := database.GetWebAuthnCredential(s.db, credentialID); err == nil {
+		return nil, nil, ErrPasskeyExists
+	}
+
+	transports, err := json.Marshal(credential.Transports)
+	if err != nil {
+		return nil, nil, err
+	}
+	if name == "" {
+		name = "Passkey"
+	}
+	stored := &database.WebAuthnCredential{
+		UserID:       user.ID,
+		CredentialID: credentialID,
+		PublicKey:    webauthn.EncodeID(credential.PublicKey),
+		SignCount:    credential.SignCount,
+		Transports:   string(transports),
+		AAGUID:       formatAAGUID(credential.AAGUID),
+		Name:         name,
+	}
+	if err := database.CreateWebAuthnCredential(s.db, stored); err != nil {
+		return nil, nil, err
+	}
+	codes, err := s.recoveryCodesOnEnrollment(user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return toPasskey(stored), codes, nil
+}
+
+// Passkeys lists the passkeys of a user
+func (s *Service) Passkeys(userID uint) ([]Passkey, error) {
+	credentials, err := database.GetWebAuthnCredentials(s.db, userID)
+	if err != nil {
+		return nil, err
+	}
+	passkeys := make([]Passkey, 0, len(credentials))
+	for i := range credentials {
+		passkeys = append(passkeys, *toPasskey(&credentials[i]))
+	}
+	return passkeys, nil
+}
+
+// DeletePasskey removes a passkey of a user, who must re-authenticate first
+func (s *Service) DeletePasskey(user *database.User, sessionID string, id uint, proof *Reauthentication) error {
+	if err := s.Reauthenticate(user, sessionID, proof); err != nil {
+		return err
+	}
+	deleted, err := database.DeleteWebAuthnCredential(s.db, user.ID, id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrPasskeyNotFound
+	}
+	return nil
+}
+
+// BeginPasskeyChallenge starts completing the second step of a login with one of the user's passkeys
+func (s *Service) BeginPasskeyChallenge(token string) (*webauthn.RequestOptions, error) {
+	challenge, err := database.GetMFAChallenge(s.db, hashToken(token), s.config.MFA.MaxAttempts)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidChallenge
+		}
+		return nil, err
+	}
+	credentials, err := database.GetWebAuthnCredentials(s.db, challenge.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if len(credentials) == 0 {
+		return nil, ErrNotEnrolled
+	}
+	ceremony, err := s.startCeremony(ceremonyMFA, challenge.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return s.rp.RequestOptions(ceremony, descriptors(credentials), webauthn.UserVerificationPreferred), nil
+}
+
+// CompletePasskeyChallenge completes the second step of a login with a passkey assertion and
+// returns the user logging in. Every attempt counts against the MFA challenge.
+func (s *Service) CompletePasskeyChallenge(token string, resp *webauthn.AssertionResponse) (*database.User, error) {
+	return s.completeChallenge(token, func(userID uint) error {
+		_, err := s.verifyPasskey(resp, ceremonyMFA, userID, false)
+		return err
+	})
+}
+
+// BeginPasskeyLogin starts a passwordless login with any discoverable passkey
+func (s *Service) BeginPasskeyLogin() (*webauthn.RequestOptions, error) {
+	challenge, err := s.startCeremony(ceremonyLogin, 0)
+	if err != nil {
+		return nil, err
+	}
+	return s.rp.RequestOptions(challenge, nil, webauthn.UserVerificationRequired), nil
+}
+
+// FinishPasskeyLogin verifies a passwordless login and returns the user logging in. The
+// authenticator must have verified the user, so the passkey stands for both factors.
+func (s *Service) FinishPasskeyLogin(resp *webauthn.AssertionResponse) (*database.User, error) {
+	userID, err := s.verifyPasskey(resp, ceremonyLogin, 0, true)
+	if err != nil {
+		return nil, err
+	}
+	return database.GetUserByID(s.db, userID)
+}
+
+// verifyPasskey completes an authentication ceremony with a passkey of userID, of any user
+// when zero, and returns the owner of the passkey
+func (s *Service) verifyPasskey(resp *webauthn.AssertionResponse, ceremony string, userID uint, requireUV bool) (uint, error) {
+	challenge, err := s.consumeCeremony(resp.Response.ClientDataJSON, ceremony, userID)
+	if err != nil {
+		return 0, err
+	}
+	rawID, err := webauthn.DecodeID(resp.RawID)
+	if err != nil {
+		return 0, ErrInvalidPasskey
+	}
+	credential, err := database.GetWebAuthnCredential(s.db, webauthn.EncodeID(rawID))
+	if err != nil || (userID != 0 && credential.UserID != userID) {
+		return 0, ErrInvalidPasskey
+	}
+	if resp.Response.UserHandle != "" {
+		handle, err := webauthn.DecodeID(resp.Response.UserHandle)
+		if err != nil || string(handle) != string(userHandle(credential.UserID)) {
+			return 0, ErrInvalidPasskey
+		}
+	}
+	publicKey, err := webauthn.DecodeID(credential.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	signCount, err := s.rp.VerifyAssertion(challenge, resp, &webauthn.Credential{
+		ID:        rawID,
+		PublicKey: publicKey,
+		SignCount: credential.SignCount,
+	}, requireUV)
+	if err != nil {
+		return 0, ErrInvalidPasskey
+	}
+	updated, err := database.UpdateWebAuthnSignCount(s.db, credential.ID, credential.SignCount, signCount)
+	if err != nil {
+		return 0, err
+	}
+	if !updated {
+		return 0, ErrInvalidPasskey
+	}
+	return credential.UserID, nil
+}
+
+// startCeremony stores a new WebAuthn challenge for a ceremony of userID
+func (s *Service) startCeremony(ceremony string, userID uint) (string, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return "", err
+	}
+	if ceremony == ceremonyLogin {
+		if err := s.rdb.Set(context.Background(), redisLoginChallengePrefix+hashToken(challenge), 1, s.rp.Timeout).Err(); err != nil {
+			return "", err
+		}
+		return challenge, nil
+	}
+	if err := database.CreateWebAuthnChallenge(s.db, &database.WebAuthnChallenge{
+		ChallengeHash: hashToken(challenge),
+		Ceremony:      ceremony,
+		UserID:        userID,
+		ExpiresAt:     time.Now().Add(s.rp.Timeout),
+	}); err != nil {
+		return "", err
+	}
+	return challenge, nil
+}
+
+// consumeCeremony completes the ceremony a response was made for and returns its challenge.
+// The ceremony must have been started for userID, unless it was started for no user.
+func (s *Service) consumeCeremony(clientDataJSON string, ceremony string, userID uint) (string, error) {
+	challenge, err := webauthn.ChallengeOf(clientDataJSON)
+	if err != nil {
+		return "", ErrInvalidPasskey
+	}
+	if ceremony == ceremonyLogin {
+		deleted, err := s.rdb.Del(context.Background(), redisLoginChallengePrefix+hashToken(challenge)).Result()
+		if err != nil {
+			return "", err
+		}
+		if deleted == 0 {
+			return "", ErrInvalidPasskey
+		}
+		return challenge, nil
+	}
+	stored, err := database.ConsumeWebAuthnChallenge(s.db, hashToken(challenge), ceremony)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", ErrInvalidPasskey
+		}
+		return "", err
+	}
+	if stored.UserID != 0 && stored.UserID != userID {
+		return "", ErrInvalidPasskey
+	}
+	return challenge, nil
+}
+
+// userHandle is the WebAuthn user handle of a user, the big-endian user ID
+func userHandle(userID uint) []byte {
+	handle := make([]byte, 8)
+	binary.BigEndian.PutUint64(handle, uint64(userID))
+	return handle
+}
+
+// descriptors lists credentials for ceremony options
+func descriptors(credentials []database.WebAuthnCredential) []webauthn.CredentialDescriptor {
+	list := make([]webauthn.CredentialDescriptor, 0, len(credentials))
+	for _, credential := range credentials {
+		var transports []string
+		json.Unmarshal([]byte(credential.Transports), &transports)
+		list = append(list, webauthn.CredentialDescriptor{
+			Type:       "public-key",
+			ID:         credential.CredentialID,
+			Transports: transports,
+		})
+	}
+	return list
+}
+
+// toPasskey returns the view of a stored credential shown to its owner
+func toPasskey(credential *database.WebAuthnCredential) *Passkey {
+	transports := []string{}
+	json.Unmarshal([]byte(credential.Transports), &transports)
+	if transports == nil {
+		transports = []string{}
+	}
+	return &Passkey{
+		ID:         credential.ID,
+		Name:       credential.Name,
+		Transports: transports,
+		CreatedAt:  credential.CreatedAt,
+		LastUsedAt: credential.LastUsedAt,
+	}
+}
+
+// formatAAGUID formats the authenticator model identifier as a UUID
+func formatAAGUID(aaguid []byte) string {
+	if len(aaguid) != 16 {
+		return ""
+	}
+	h := hex.EncodeToString(aaguid)
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
@@ -0,0 +1,157 @@
+package mfa
+
+import (
+	database "core-auth/db"
+	"core-auth/internal/testutil"
+	"core-auth/internal/webauthn"
+	"errors"
+	"testing"
+)
+
+// newTestAuthenticator returns an authenticator for the relying party of a service
+func newTestAuthenticator(t *testing.T, s *Service) *testutil.Authenticator {
+	t.Helper()
+	return testutil.NewAuthenticator(t, s.rp.ID, s.rp.Origins[0])
+}
+
+// registerPasskey registers the passkey of an authenticator, re-authenticating with proof
+func registerPasskey(t *testing.T, s *Service, user *database.User, a *testutil.Authenticator, proof *Reauthentication) *Passkey {
+	t.Helper()
+	options, err := s.BeginPasskeyRegistration(user, "laptop", proof)
+	if err != nil {
+		t.Fatalf("begin registration: %v", err)
+	}
+	passkey, _, err := s.FinishPasskeyRegistration(user, "Laptop", a.Register(options.Challenge))
+	if err != nil {
+		t.Fatalf("finish registration: %v", err)
+	}
+	return passkey
+}
+
+func TestPasskeyRegistrationRequiresReauthentication(t *testing.T) {
+	s, user, _ := newTestService(t)
+
+	if _, err := s.BeginPasskeyRegistration(user, "laptop", &Reauthentication{}); !errors.Is(err, ErrReauthenticationRequired) {
+		t.Errorf("no proof error = %v, want %v", err, ErrReauthenticationRequired)
+	}
+	if _, err := s.BeginPasskeyRegistration(user, "laptop", &Reauthentication{Password: "wrong"}); !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("wrong password error = %v, want %v", err, ErrInvalidPassword)
+	}
+	registerPasskey(t, s, user, newTestAuthenticator(t, s), &Reauthentication{Password: testPassword})
+
+	// With MFA enabled by the passkey, the password alone is no longer enough
+	if _, err := s.BeginPasskeyRegistration(user, "laptop", &Reauthentication{Password: testPassword}); !errors.Is(err, ErrReauthenticationRequired) {
+		t.Errorf("password only error = %v, want %v", err, ErrReauthenticationRequired)
+	}
+}
+
+func TestPasskeyRegistrationWithTOTP(t *testing.T) {
+	s, user, _ := newTestService(t)
+	secret := enroll(t, s, user)
+
+	if _, err := s.BeginPasskeyRegistration(user, "laptop", &Reauthentication{Password: testPassword}); !errors.Is(err, ErrReauthenticationRequired) {
+		t.Errorf("password only error = %v, want %v", err, ErrReauthenticationRequired)
+	}
+	registerPasskey(t, s, user, newTestAuthenticator(t, s), &Reauthentication{Code: currentCode(t, secret, 0)})
+}
+
+func TestDeletePasskeyWithPasskeyAssertion(t *testing.T) {
+	s, user, db := newTestService(t)
+	a := newTestAuthenticator(t, s)
+	passkey := registerPasskey(t, s, user, a, &Reauthentication{Password: testPassword})
+
+	if err := s.DeletePasskey(user, "laptop", passkey.ID, &Reauthentication{Password: testPassword}); !errors.Is(err, ErrReauthenticationRequired) {
+		t.Fatalf("password only error = %v, want %v", err, ErrReauthenticationRequired)
+	}
+
+	// An assertion made for another ceremony does not re-authenticate
+	login, err := s.BeginPasskeyLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeletePasskey(user, "laptop", passkey.ID, &Reauthentication{Assertion: a.Assert(login.Challenge)}); !errors.Is(err, ErrInvalidPasskey) {
+		t.Fatalf("login assertion error = %v, want %v", err, ErrInvalidPasskey)
+	}
+
+	options, err := s.BeginPasskeyReauthentication(user.ID)
+	if err != nil {
+		t.Fatalf("begin re-authentication: %v", err)
+	}
+	if err := s.DeletePasskey(user, "laptop", passkey.ID, &Reauthentication{Assertion: a.Assert(options.Challenge)}); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if credentials, _ := database.GetWebAuthnCredentials(db, user.ID); len(credentials) != 0 {
+		t.Errorf("%d passkeys left, want 0", len(credentials))
+	}
+}
+
+func TestReauthenticationAttemptsAreLimited(t *testing.T) {
+	t.Setenv("MFA_MAX_ATTEMPTS", "2")
+	s, user, db := newTestService(t)
+
+	if _, err := s.BeginPasskeyRegistration(user, "laptop", &Reauthentication{Password: "wrong"}); !errors.Is(err, ErrInvalidPassword) {
+		t.Fatalf("first attempt error = %v, want %v", err, ErrInvalidPassword)
+	}
+	if _, err := s.BeginPasskeyRegistration(user, "laptop", &Reauthentication{Password: "wrong"}); !errors.Is(err, ErrTooManyAttempts) {
+		t.Fatalf("second attempt error = %v, want %v", err, ErrTooManyAttempts)
+	}
+	if sessionActive(t, db) {
+		t.Error("session still active after too many wrong passwords")
+	}
+}
+
+func TestPasskeyLoginChallengesExpireInRedis(t *testing.T) {
+	testutil.SetSecrets(t)
+	db := testutil.NewDB(t)
+	rdb, mr := testutil.NewRedis(t)
+	s := NewService(db, rdb)
+	user := &database.User{Username: "alice", Email: "alice@example.com", Password: "-", IsActive: true}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	a := newTestAuthenticator(t, s)
+	a.UserHandle = userHandle(user.ID)
+	if err := database.CreateWebAuthnCredential(db, &database.WebAuthnCredential{
+		UserID:       user.ID,
+		CredentialID: webauthn.EncodeID(a.CredentialID),
+		PublicKey:    webauthn.EncodeID(a.PublicKey()),
+		Name:         "Laptop",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unauthenticated logins store nothing in the database
+	options, err := s.BeginPasskeyLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows int64
+	db.Model(&database.WebAuthnChallenge{}).Count(&rows)
+	if rows != 0 {
+		t.Errorf("%d challenge rows stored, want 0", rows)
+	}
+	if ttl := mr.TTL(redisLoginChallengePrefix + hashToken(options.Challenge)); ttl != s.rp.Timeout {
+		t.Errorf("challenge TTL = %v, want %v", ttl, s.rp.Timeout)
+	}
+
+	resp := a.Assert(options.Challenge)
+	loggedIn, err := s.FinishPasskeyLogin(resp)
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if loggedIn.ID != user.ID {
+		t.Errorf("logged in user %d, want %d", loggedIn.ID, user.ID)
+	}
+	if _, err := s.FinishPasskeyLogin(a.Assert(options.Challenge)); !errors.Is(err, ErrInvalidPasskey) {
+		t.Errorf("reused challenge error = %v, want %v", err, ErrInvalidPasskey)
+	}
+
+	expired, err := s.BeginPasskeyLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr.FastForward(s.rp.Timeout)
+	if _, err := s.FinishPasskeyLogin(a.Assert(expired.Challenge)); !errors.Is(err, ErrInvalidPasskey) {
+		t.Errorf("expired challenge error = %v, want %v", err, ErrInvalidPasskey)
+	}
+}
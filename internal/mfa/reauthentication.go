@@ -0,0 +1,60 @@
+package mfa
+
+import (
+	database "core-auth/db"
+	"core-auth/internal/webauthn"
+	"errors"
+)
+
+var (
+	// ErrReauthenticationRequired is returned when a sensitive change is requested without proof
+	// that the owner of the session is present
+	ErrReauthenticationRequired = errors.New("re-authentication required")
+	// ErrInvalidPassword is returned when re-authenticating with a wrong password
+	ErrInvalidPassword = errors.New("invalid password")
+)
+
+// Reauthentication proves that the owner of a signed in session is present before a sensitive
+// change: the current password, or one of the second factors of an account with MFA.
+type Reauthentication struct {
+	Password  string                      `json:"password"`
+	Code      string                      `json:"code"`      // code of the TOTP authenticator
+	Assertion *webauthn.AssertionResponse `json:"assertion"` // answers BeginPasskeyReauthentication
+}
+
+// BeginPasskeyReauthentication starts re-authenticating a signed in user with one of their passkeys
+func (s *Service) BeginPasskeyReauthentication(userID uint) (*webauthn.RequestOptions, error) {
+	credentials, err := database.GetWebAuthnCredentials(s.db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(credentials) == 0 {
+		return nil, ErrNotEnrolled
+	}
+	challenge, err := s.startCeremony(ceremonyReauthentication, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.rp.RequestOptions(challenge, descriptors(credentials), webauthn.UserVerificationPreferred), nil
+}
+
+// Reauthenticate checks that the owner of a signed in session is present. Accounts with MFA
+// must give a second factor, their password alone is not enough. Failures count against the
+// session, which is ended after the configured maximum.
+func (s *Service) Reauthenticate(user *database.User, sessionID string, proof *Reauthentication) error {
+	mfa := len(s.factors(user.ID)) > 0
+	var err error
+	switch {
+	case mfa && proof.Code != "":
+		err = s.VerifyTOTP(user.ID, proof.Code)
+	case mfa && proof.Assertion != nil:
+		_, err = s.verifyPasskey(proof.Assertion, ceremonyReauthentication, user.ID, false)
+	case !mfa && proof.Password != "":
+		if !database.CheckPasswordDB(s.db, user.Username, proof.Password) {
+			err = ErrInvalidPassword
+		}
+	default:
+		return ErrReauthenticationRequired
+	}
+	return s.reauthenticated(sessionID, err)
+}
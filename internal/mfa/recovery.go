@@ -0,0 +1,137 @@
+package mfa
+
+import (
+	database "core-auth/db"
+	"crypto/rand"
+	"strings"
+)
+
+const (
+	// MethodRecoveryCode completes an MFA challenge with a one-time recovery code
+	MethodRecoveryCode = "recovery_code"
+	// recoveryCodeCount is the number of recovery codes generated at once
+	recoveryCodeCount = 10
+	// recoveryCodeLength is the number of characters of a recovery code, shown in two groups
+	recoveryCodeLength = 10
+	// recoveryCodeAlphabet avoids characters that are easily confused when copied by hand
+	recoveryCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+// RegenerateRecoveryCodes replaces the recovery codes of a user with a new set, returned only
+// here. The user must have MFA enabled and re-authenticate with a second factor first.
+func (s *Service) RegenerateRecoveryCodes(user *database.User, sessionID string, proof *Reauthentication) ([]string, error) {
+	if len(s.factors(user.ID)) == 0 {
+		return nil, ErrNotEnrolled
+	}
+	if err := s.Reauthenticate(user, sessionID, proof); err != nil {
+		return nil, err
+	}
+	return s.generateRecoveryCodes(user.ID)
+}
+
+// RemainingRecoveryCodes counts the unused recovery codes of a user
+func (s *Service) RemainingRecoveryCodes(userID uint) (int64, error) {
+	return database.CountRecoveryCodes(s.db, userID)
+}
+
+// CompleteChallengeWithRecoveryCode completes the second step of a login with a recovery code
+// in place of a second factor and returns the user logging in. The code cannot be used again.
+func (s *Service) CompleteChallengeWithRecoveryCode(token string, code string) (*database.User, error) {
+	return s.completeChallenge(token, func(userID uint) error {
+		used, err := database.UseRecoveryCode(s.db, userID, hashToken(normalizeRecoveryCode(code)))
+		if err != nil {
+			return err
+		}
+		if !used {
+			return ErrInvalidCode
+		}
+		return nil
+	})
+}
+
+// Status is the MFA configuration of a user as shown to administrators
+type Status struct {
+	UserID                 uint     `json:"user_id"`
+	MFAEnabled             bool     `json:"mfa_enabled"`
+	Factors                []string `json:"factors"`
+	RecoveryCodesRemaining int64    `json:"recovery_codes_remaining"`
+}
+
+// Status returns the MFA configuration of a user
+func (s *Service) Status(userID uint) (*Status, error) {
+	user, err := database.GetUserByID(s.db, userID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	remaining, err := database.CountRecoveryCodes(s.db, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &Status{
+		UserID:                 user.ID,
+		MFAEnabled:             user.MFAEnabled,
+		Factors:                s.factors(userID),
+		RecoveryCodesRemaining: remaining,
+	}, nil
+}
+
+// ResetMFA removes every second factor and recovery code of a user, for administrators
+// restoring access to an account after verifying its owner's identity
+func (s *Service) ResetMFA(userID uint) error {
+	if _, err := database.GetUserByID(s.db, userID); err != nil {
+		return ErrUserNotFound
+	}
+	return database.ResetMFA(s.db, userID)
+}
+
+// recoveryCodesOnEnrollment generates recovery codes when a user enrolls a first second
+// factor, none are generated while unused codes remain
+func (s *Service) recoveryCodesOnEnrollment(userID uint) ([]string, error) {
+	remaining, err := database.CountRecoveryCodes(s.db, userID)
+	if err != nil || remaining > 0 {
+		return nil, err
+	}
+	return s.generateRecoveryCodes(userID)
+}
+
+// generateRecoveryCodes stores the hashes of a new set of recovery codes and returns the codes
+func (s *Service) generateRecoveryCodes(userID uint) ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	seen := make(map[string]bool, recoveryCodeCount)
+	for len(codes) < recoveryCodeCount {
+		code, err := randomRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		if seen[code] {
+			continue
+		}
+		seen[code] = true
+		codes = append(codes, code[:recoveryCodeLength/2]+"-"+code[recoveryCodeLength/2:])
+		hashes = append(hashes, hashToken(code))
+	}
+	if err := database.ReplaceRecoveryCodes(s.db, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// randomRecoveryCode returns a random code drawn uniformly from the recovery code alphabet
+func randomRecoveryCode() (string, error) {
+	b := make([]byte, recoveryCodeLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := make([]byte, recoveryCodeLength)
+	for i := range b {
+		// The alphabet has 32 characters, so the low five bits are uniform
+		code[i] = recoveryCodeAlphabet[b[i]&0x1f]
+	}
+	return string(code), nil
+}
+
+// normalizeRecoveryCode ignores case, spaces and dashes in a recovery code typed by the user
+func normalizeRecoveryCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
@@ -0,0 +1,59 @@
+package mfa
+
+import (
+	database "core-auth/db"
+	"errors"
+	"testing"
+)
+
+func TestRegenerateRecoveryCodesRequiresSecondFactor(t *testing.T) {
+	s, user, db := newTestService(t)
+	if _, err := s.RegenerateRecoveryCodes(user, "laptop", &Reauthentication{Password: testPassword}); !errors.Is(err, ErrNotEnrolled) {
+		t.Errorf("without MFA error = %v, want %v", err, ErrNotEnrolled)
+	}
+
+	secret := enroll(t, s, user)
+	for _, proof := range []*Reauthentication{{}, {Password: testPassword}} {
+		if _, err := s.RegenerateRecoveryCodes(user, "laptop", proof); !errors.Is(err, ErrReauthenticationRequired) {
+			t.Errorf("proof %+v error = %v, want %v", proof, err, ErrReauthenticationRequired)
+		}
+	}
+	if _, err := s.RegenerateRecoveryCodes(user, "laptop", &Reauthentication{Code: "000000"}); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("wrong code error = %v, want %v", err, ErrInvalidCode)
+	}
+
+	var old database.RecoveryCode
+	if err := db.Where("user_id = ?", user.ID).First(&old).Error; err != nil {
+		t.Fatal(err)
+	}
+	codes, err := s.RegenerateRecoveryCodes(user, "laptop", &Reauthentication{Code: currentCode(t, secret, 0)})
+	if err != nil {
+		t.Fatalf("regenerate: %v", err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Errorf("%d codes, want %d", len(codes), recoveryCodeCount)
+	}
+	var stale int64
+	db.Model(&database.RecoveryCode{}).Where("code_hash = ?", old.CodeHash).Count(&stale)
+	if stale != 0 {
+		t.Error("previous recovery codes still valid")
+	}
+}
+
+func TestRegenerateRecoveryCodesWithPasskey(t *testing.T) {
+	s, user, _ := newTestService(t)
+	a := newTestAuthenticator(t, s)
+	registerPasskey(t, s, user, a, &Reauthentication{Password: testPassword})
+
+	options, err := s.BeginPasskeyReauthentication(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RegenerateRecoveryCodes(user, "laptop", &Reauthentication{Assertion: a.Assert(options.Challenge)}); err != nil {
+		t.Fatalf("regenerate: %v", err)
+	}
+	// The assertion completed its ceremony and cannot be used again
+	if _, err := s.RegenerateRecoveryCodes(user, "laptop", &Reauthentication{Assertion: a.Assert(options.Challenge)}); !errors.Is(err, ErrInvalidPasskey) {
+		t.Errorf("replayed ceremony error = %v, want %v", err, ErrInvalidPasskey)
+	}
+}
@@ -0,0 +1,141 @@
+package oauth2
+
+import (
+	"context"
+	database "core-auth/db"
+	"core-auth/internal/utils"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// clientSecretLength is the length of generated client secrets
+const clientSecretLength = 48
+
+// ErrClientNotFound is returned when managing a client that does not exist
+var ErrClientNotFound = errors.New("client not found")
+
+// ErrAccessTokenOutlivesKey is returned when saving a client whose JWT access tokens would
+// outlive the retention of their signing key, and stop verifying while still unexpired
+var ErrAccessTokenOutlivesKey = errors.New("access token lifetime exceeds the JWT signing key retention")
+
+// ListClients returns every registered client, active or not
+func (s *Server) ListClients() ([]database.OAuth2Client, error) {
+	return s.queries.ListClients()
+}
+
+// Client returns a registered client, active or not
+func (s *Server) Client(clientID string) (*database.OAuth2Client, error) {
+	client, err := database.GetClientByID(s.db, clientID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrClientNotFound
+	}
+	return client, err
+}
+
+// CreateClient registers a client under a generated ID. Confidential clients get a generated
+// secret, only its hash is stored and the secret is returned only here and by ResetClientSecret.
+func (s *Server) CreateClient(ctx context.Context, client *database.OAuth2Client, confidential bool) (string, error) {
+	client.ClientID = uuid.New().String()
+	client.ClientSecret = ""
+	secret := ""
+	if confidential {
+		var err error
+		if secret, err = utils.GenerateRandomString(clientSecretLength); err != nil {
+			return "", err
+		}
+		client.ClientSecret = database.HashClientSecret(secret)
+	}
+	if err := s.validateClient(client); err != nil {
+		return "", err
+	}
+	if err := s.queries.CreateClient(client); err != nil {
+		return "", err
+	}
+	s.invalidateClient(ctx, client.ClientID)
+	return secret, nil
+}
+
+// UpdateClient saves a client's configuration and applies it on every instance at once
+func (s *Server) UpdateClient(ctx context.Context, client *database.OAuth2Client) error {
+	if err := s.validateClient(client); err != nil {
+		return err
+	}
+	if err := s.queries.UpdateClient(client); err != nil {
+		return err
+	}
+	s.invalidateClient(ctx, client.ClientID)
+	return nil
+}
+
+// validateClient checks the client settings that depend on the server configuration. A rotated
+// signing key stays published for the key retention, the JWT access tokens it signed must
+// expire by then.
+func (s *Server) validateClient(client *database.OAuth2Client) error {
+	retention := time.Duration(s.config.OAuth2Server.JWTAccessToken.KeyRetention) * time.Hour
+	if client.AccessTokenFormat == AccessTokenFormatJWT && time.Duration(client.AccessTokenLifetime)*time.Second > retention {
+		return ErrAccessTokenOutlivesKey
+	}
+	return nil
+}
+
+// SetClientActive enables or disables a client. The tokens of a disabled client stop
+// validating but are kept, so that enabling it again restores them.
+func (s *Server) SetClientActive(ctx context.Context, clientID string, active bool) (*database.OAuth2Client, error) {
+	client, err := s.Client(clientID)
+	if err != nil {
+		return nil, err
+	}
+	client.IsActive = active
+	if err := s.UpdateClient(ctx, client); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// ResetClientSecret replaces the secret of a client, the previous secret stops working at once.
+// The new secret is returned only here.
+func (s *Server) ResetClientSecret(ctx context.Context, clientID string) (*database.OAuth2Client, string, error) {
+	client, err := s.Client(clientID)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := utils.GenerateRandomString(clientSecretLength)
+	if err != nil {
+		return nil, "", err
+	}
+	client.ClientSecret = database.HashClientSecret(secret)
+	if err := s.UpdateClient(ctx, client); err != nil {
+		return nil, "", err
+	}
+	return client, secret, nil
+}
+
+// DeleteClient deletes a client and revokes its authorizations and tokens
+func (s *Server) DeleteClient(ctx context.Context, clientID string) error {
+	if _, err := s.Client(clientID); err != nil {
+		return err
+	}
+	tokens, err := s.queries.DeleteClient(clientID)
+	if err != nil {
+		return err
+	}
+	evictTokens(ctx, s.rdb, tokens)
+	s.invalidateClient(ctx, clientID)
+	return nil
+}
+
+// invalidateClient bumps the cache version of a client in the Redis cache shared by all
+// instances. Entries cached under the previous version, even by a concurrent cache miss that
+// read the old row, are no longer read.
+func (s *Server) invalidateClient(ctx context.Context, clientID string) {
+	if s.rdb == nil {
+		return
+	}
+	if err := s.rdb.Incr(ctx, redisClientVersionPrefix+clientID).Err(); err != nil {
+		log.Printf("Failed to invalidate cached client %s: %v", clientID, err)
+	}
+}
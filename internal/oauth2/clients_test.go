@@ -0,0 +1,77 @@
+package oauth2
+
+import (
+	"context"
+	database "core-auth/db"
+	"testing"
+)
+
+func TestClientSecretIsStoredHashed(t *testing.T) {
+	s, _ := newTestServer(t)
+	ctx := context.Background()
+	client := &database.OAuth2Client{Name: "app", IsActive: true}
+	secret, err := s.CreateClient(ctx, client, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret == "" || client.ClientSecret == secret {
+		t.Fatalf("secret %q stored as %q", secret, client.ClientSecret)
+	}
+
+	cli, err := s.Manager.GetClient(ctx, client.ClientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verifyClientSecret(cli, secret) {
+		t.Error("generated secret rejected")
+	}
+	if verifyClientSecret(cli, client.ClientSecret) {
+		t.Error("stored hash accepted as the secret")
+	}
+
+	_, reset, err := s.ResetClientSecret(ctx, client.ClientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cli, err = s.Manager.GetClient(ctx, client.ClientID); err != nil {
+		t.Fatal(err)
+	}
+	if verifyClientSecret(cli, secret) {
+		t.Error("previous secret accepted after a reset")
+	}
+	if !verifyClientSecret(cli, reset) {
+		t.Error("new secret rejected after a reset")
+	}
+}
+
+func TestPublicClientHasNoSecret(t *testing.T) {
+	s, _ := newTestServer(t)
+	secret, err := s.CreateClient(context.Background(), &database.OAuth2Client{Name: "spa", IsActive: true}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret != "" {
+		t.Errorf("public client got secret %q", secret)
+	}
+}
+
+func TestClientChangeSupersedesStaleCacheEntry(t *testing.T) {
+	s, redisServer := newTestServer(t)
+	ctx := context.Background()
+	storage := NewStorage(s.rdb, s.db)
+	client := &database.OAuth2Client{Name: "app", IsActive: true}
+	if _, err := s.CreateClient(ctx, client, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// A lookup reads the row, the client is disabled before the lookup caches what it read
+	staleKey := storage.clientCacheKey(client.ClientID)
+	if _, err := s.SetClientActive(ctx, client.ClientID, false); err != nil {
+		t.Fatal(err)
+	}
+	redisServer.Set(staleKey, `{"ID":"`+client.ClientID+`","TokenPolicy":{"IssueRefreshTokens":true}}`)
+
+	if _, err := storage.GetClient(client.ClientID); err == nil {
+		t.Error("disabled client served from a stale cache entry")
+	}
+}
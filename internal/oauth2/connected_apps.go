@@ -0,0 +1,112 @@
+package oauth2
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// tokenUseInterval is how often the last use of an access token is recorded
+const tokenUseInterval = 5 * time.Minute
+
+// ConnectedApp is a client holding tokens for a user
+type ConnectedApp struct {
+	ClientID          string    `json:"client_id"`
+	Name              string    `json:"name"`
+	Scopes            []string  `json:"scopes"`
+	FirstAuthorizedAt time.Time `json:"first_authorized_at"`
+	LastUsedAt        time.Time `json:"last_used_at"`
+}
+
+// ConnectedApps lists the clients holding active tokens for a user with the scopes granted
+// to them, when the user first authorized them and when they last used or refreshed a token
+func (s *Server) ConnectedApps(ctx context.Context, userID uint) ([]ConnectedApp, error) {
+	tokens, err := s.queries.GetActiveUserTokens(userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	authorizations, err := s.queries.GetClientAuthorizations(userID)
+	if err != nil {
+		return nil, err
+	}
+	firstAuthorized := make(map[string]time.Time, len(authorizations))
+	for _, auth := range authorizations {
+		firstAuthorized[auth.ClientID] = auth.FirstAuthorizedAt
+	}
+
+	apps := make(map[string]*ConnectedApp)
+	for _, token := range tokens {
+		app, ok := apps[token.ClientID]
+		if !ok {
+			app = &ConnectedApp{ClientID: token.ClientID, Scopes: []string{}, FirstAuthorizedAt: token.CreatedAt}
+			if first, ok := firstAuthorized[token.ClientID]; ok && first.Before(app.FirstAuthorizedAt) {
+				app.FirstAuthorizedAt = first
+			}
+			apps[token.ClientID] = app
+		}
+
+		for _, scope := range strings.Fields(token.Scope) {
+			if !contains(app.Scopes, scope) {
+				app.Scopes = append(app.Scopes, scope)
+			}
+		}
+		if token.CreatedAt.Before(app.FirstAuthorizedAt) {
+			app.FirstAuthorizedAt = token.CreatedAt
+		}
+		if token.CreatedAt.After(app.LastUsedAt) {
+			app.LastUsedAt = token.CreatedAt
+		}
+		if token.LastUsedAt != nil && token.LastUsedAt.After(app.LastUsedAt) {
+			app.LastUsedAt = *token.LastUsedAt
+		}
+	}
+
+	clientIDs := make([]string, 0, len(apps))
+	for clientID := range apps {
+		clientIDs = append(clientIDs, clientID)
+	}
+	clients, err := s.queries.GetClients(clientIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, client := range clients {
+		apps[client.ClientID].Name = client.Name
+	}
+
+	result := make([]ConnectedApp, 0, len(apps))
+	for _, app := range apps {
+		result = append(result, *app)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].LastUsedAt.After(result[j].LastUsedAt)
+	})
+	return result, nil
+}
+
+// RevokeConnectedApp revokes every token and authorization a user granted a client, in the
+// database and in the Redis cache. Codes not yet redeemed can no longer be exchanged.
+func (s *Server) RevokeConnectedApp(ctx context.Context, userID uint, clientID string) error {
+	tokens, err := s.queries.RevokeUserClient(userID, clientID)
+	if err != nil {
+		return err
+	}
+	evictTokens(ctx, s.rdb, tokens)
+	return nil
+}
+
+// touchToken records the use of an access token, at most once per tokenUseInterval. Redis
+// spares the database most checks, the update itself skips recently recorded uses.
+func (s *Server) touchToken(ctx context.Context, access string) {
+	key := accessTokenKey(access)
+	if s.rdb != nil {
+		fresh, err := s.rdb.SetNX(ctx, redisTokenUsedPrefix+key, 1, tokenUseInterval).Result()
+		if err == nil && !fresh {
+			return
+		}
+	}
+	if err := s.queries.TouchToken(key, time.Now(), tokenUseInterval); err != nil {
+		log.Printf("Failed to record use of access token: %v", err)
+	}
+}
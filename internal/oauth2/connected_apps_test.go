@@ -0,0 +1,105 @@
+package oauth2
+
+import (
+	"context"
+	database "core-auth/db"
+	"testing"
+	"time"
+)
+
+// newTestToken stores an access token of user 1 for a client
+func newTestToken(t *testing.T, s *Server, access string, clientID string) {
+	t.Helper()
+	if err := s.db.Create(&database.OAuth2Token{
+		AccessToken:     access,
+		ClientID:        clientID,
+		UserID:          1,
+		Scope:           "openid",
+		AccessExpiresAt: time.Now().Add(time.Hour),
+	}).Error; err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+}
+
+// lastUsed returns when an access token was last recorded as used
+func lastUsed(t *testing.T, s *Server, access string) *time.Time {
+	t.Helper()
+	var token database.OAuth2Token
+	if err := s.db.Where("access_token = ?", access).First(&token).Error; err != nil {
+		t.Fatal(err)
+	}
+	return token.LastUsedAt
+}
+
+func TestTouchTokenIsThrottled(t *testing.T) {
+	s, _ := newTestServer(t)
+	newTestToken(t, s, "access-1", testClientID)
+
+	if err := s.queries.TouchToken("access-1", time.Now(), tokenUseInterval); err != nil {
+		t.Fatal(err)
+	}
+	first := lastUsed(t, s, "access-1")
+	if first == nil {
+		t.Fatal("first use not recorded")
+	}
+
+	// A use within the interval is not written, one after it is
+	if err := s.queries.TouchToken("access-1", first.Add(time.Minute), tokenUseInterval); err != nil {
+		t.Fatal(err)
+	}
+	if got := lastUsed(t, s, "access-1"); !got.Equal(*first) {
+		t.Errorf("use within the interval recorded: %v", got)
+	}
+	later := first.Add(tokenUseInterval + time.Minute)
+	if err := s.queries.TouchToken("access-1", later, tokenUseInterval); err != nil {
+		t.Fatal(err)
+	}
+	if got := lastUsed(t, s, "access-1"); !got.Equal(later) {
+		t.Errorf("last use = %v, want %v", got, later)
+	}
+}
+
+func TestTouchTokenSkipsRecentUsesWithoutRedis(t *testing.T) {
+	s, redisServer := newTestServer(t)
+	newTestToken(t, s, "access-1", testClientID)
+
+	s.touchToken(context.Background(), "access-1")
+	first := lastUsed(t, s, "access-1")
+	if first == nil {
+		t.Fatal("use not recorded")
+	}
+
+	// Redis forgot the use, the database still holds back the write
+	redisServer.FlushAll()
+	s.touchToken(context.Background(), "access-1")
+	if got := lastUsed(t, s, "access-1"); !got.Equal(*first) {
+		t.Errorf("recent use rewritten: %v, want %v", got, first)
+	}
+}
+
+func TestConnectedAppsNamesClients(t *testing.T) {
+	s, _ := newTestServer(t)
+	for _, client := range []*database.OAuth2Client{
+		{ClientID: "mail", Name: "Mail", IsActive: true},
+		{ClientID: "calendar", Name: "Calendar", IsActive: true},
+	} {
+		if err := s.queries.CreateClient(client); err != nil {
+			t.Fatalf("create client: %v", err)
+		}
+	}
+	newTestToken(t, s, "access-1", "mail")
+	newTestToken(t, s, "access-2", "mail")
+	newTestToken(t, s, "access-3", "calendar")
+
+	apps, err := s.ConnectedApps(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]string)
+	for _, app := range apps {
+		names[app.ClientID] = app.Name
+	}
+	if len(apps) != 2 || names["mail"] != "Mail" || names["calendar"] != "Calendar" {
+		t.Errorf("connected apps = %+v", apps)
+	}
+}
@@ -0,0 +1,157 @@
+package oauth2
+
+import (
+	"context"
+	"core-auth/internal/utils"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4/errors"
+)
+
+// consentRequest is a validated authorization request waiting for the user's decision
+type consentRequest struct {
+	ClientID  string
+	Params    url.Values
+	ExpiresAt time.Time
+}
+
+// userAuthorizationHandler parks the validated authorization request and sends the user to
+// the consent page. The authorization response is issued by HandleConsentDecision.
+func (s *Server) userAuthorizationHandler(w http.ResponseWriter, r *http.Request) (string, error) {
+	if s.rdb == nil {
+		return "", errors.ErrTemporarilyUnavailable
+	}
+
+	challenge, err := utils.GenerateRandomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	lifetime := time.Duration(s.config.OAuth2Server.ConsentLifetime) * time.Second
+	data, err := json.Marshal(&consentRequest{
+		ClientID:  r.Form.Get("client_id"),
+		Params:    r.Form,
+		ExpiresAt: time.Now().Add(lifetime),
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := s.rdb.SetEX(r.Context(), redisConsentPrefix+challenge, data, lifetime).Err(); err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(s.config.OAuth2Server.ConsentURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("consent_challenge", challenge)
+	u.RawQuery = q.Encode()
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Location", u.String())
+	w.WriteHeader(http.StatusFound)
+	return "", nil
+}
+
+// ConsentRequest returns what the user is asked to approve: the client, the scopes,
+// the resources and the authorization details of the parked request
+func (s *Server) ConsentRequest(ctx context.Context, challenge string) (map[string]interface{}, error) {
+	consent, err := s.loadConsent(ctx, challenge, false)
+	if err != nil {
+		return nil, err
+	}
+	client, err := s.queries.GetClient(consent.ClientID)
+	if err != nil {
+		return nil, errors.ErrInvalidClient
+	}
+
+	data := map[string]interface{}{
+		"consent_challenge": challenge,
+		"client_id":         client.ClientID,
+		"client_name":       client.Name,
+		"redirect_uri":      consent.Params.Get("redirect_uri"),
+		"scopes":            strings.Fields(consent.Params.Get("scope")),
+		"expires_at":        consent.ExpiresAt,
+	}
+	if resources := requestedResources(consent.Params); len(resources) > 0 {
+		data["resources"] = resources
+	}
+	if details := consent.Params.Get("authorization_details"); details != "" {
+		data["authorization_details"] = json.RawMessage(details)
+	}
+	return data, nil
+}
+
+// HandleConsentDecision completes a parked authorization request with the user's decision
+// and returns the redirect URI carrying the authorization response. A challenge can be
+// decided only once. The authorization is tied to the user's login session, if any.
+func (s *Server) HandleConsentDecision(ctx context.Context, challenge string, userID string, sessionID string, approved bool) (string, error) {
+	consent, err := s.loadConsent(ctx, challenge, true)
+	if err != nil {
+		return "", err
+	}
+	if sessionID != "" {
+		ctx = context.WithValue(ctx, sessionContextKey, sessionID)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, "/oauth2/authorize", nil)
+	if err != nil {
+		return "", err
+	}
+	r.Form = consent.Params
+
+	req, err := s.validateAuthorizeRequest(r)
+	if err == nil && !approved {
+		err = errors.ErrAccessDenied
+	}
+	if err != nil {
+		if uri, ok := s.errorRedirectURI(req, err); ok {
+			return uri, nil
+		}
+		return "", err
+	}
+
+	req.UserID = userID
+	ti, err := s.GetAuthorizeToken(ctx, req)
+	if err != nil {
+		if uri, ok := s.errorRedirectURI(req, err); ok {
+			return uri, nil
+		}
+		return "", err
+	}
+
+	redirectURI, ok := s.registeredRedirectURI(r, req.ClientID, req.RedirectURI)
+	if !ok {
+		return "", errors.ErrInvalidRedirectURI
+	}
+	req.RedirectURI = redirectURI
+	return s.GetRedirectURI(req, s.GetAuthorizeData(req.ResponseType, ti))
+}
+
+// loadConsent reads a parked authorization request, removing it when consumed
+func (s *Server) loadConsent(ctx context.Context, challenge string, consume bool) (*consentRequest, error) {
+	if s.rdb == nil || challenge == "" {
+		return nil, ErrInvalidConsent
+	}
+
+	key := redisConsentPrefix + challenge
+	cmd := s.rdb.Get(ctx, key)
+	if consume {
+		cmd = s.rdb.GetDel(ctx, key)
+	}
+	data, err := cmd.Bytes()
+	if err != nil {
+		return nil, ErrInvalidConsent
+	}
+
+	var consent consentRequest
+	if err := json.Unmarshal(data, &consent); err != nil || time.Now().After(consent.ExpiresAt) {
+		return nil, ErrInvalidConsent
+	}
+	return &consent, nil
+}
@@ -0,0 +1,213 @@
+package oauth2
+
+import (
+	"context"
+	"core-auth/internal/utils"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/errors"
+)
+
+const (
+	// dpopProofType is the required typ header of a DPoP proof (RFC 9449)
+	dpopProofType = "dpop+jwt"
+	// extensionDPoPJKT is the token extension holding the confirmed key thumbprint (cnf.jkt)
+	extensionDPoPJKT = "cnf_jkt"
+)
+
+type contextKey string
+
+const dpopJKTContextKey contextKey = "dpop_jkt"
+
+// DPoP errors
+var (
+	ErrInvalidDPoPProof = errors.New("invalid_dpop_proof")
+	ErrUseDPoPNonce     = errors.New("use_dpop_nonce")
+)
+
+func init() {
+	errors.Descriptions[ErrInvalidDPoPProof] = "The DPoP proof is missing, malformed, replayed or does not match the request"
+	errors.StatusCodes[ErrInvalidDPoPProof] = http.StatusBadRequest
+	errors.Descriptions[ErrUseDPoPNonce] = "Authorization server requires nonce in DPoP proof"
+	errors.StatusCodes[ErrUseDPoPNonce] = http.StatusBadRequest
+}
+
+// dpopClaims are the claims of a DPoP proof
+type dpopClaims struct {
+	JTI   string `json:"jti"`
+	HTM   string `json:"htm"`
+	HTU   string `json:"htu"`
+	IAT   int64  `json:"iat"`
+	ATH   string `json:"ath,omitempty"`
+	Nonce string `json:"nonce,omitempty"`
+}
+
+// DPoPEnabled reports whether DPoP sender-constrained tokens are enabled
+func (s *Server) DPoPEnabled() bool {
+	return s.config.OAuth2Server.DPoP.Enabled
+}
+
+// verifyDPoPProof verifies the DPoP proof header of the request and returns the JWK thumbprint
+// of the proof key. accessToken is set when the proof accompanies an access token (ath claim).
+func (s *Server) verifyDPoPProof(r *http.Request, accessToken string) (string, error) {
+	proofs := r.Header.Values("DPoP")
+	if len(proofs) != 1 {
+		return "", ErrInvalidDPoPProof
+	}
+
+	algs := make([]jose.SignatureAlgorithm, 0, len(s.config.OAuth2Server.DPoP.SigningAlgs))
+	for _, alg := range s.config.OAuth2Server.DPoP.SigningAlgs {
+		algs = append(algs, jose.SignatureAlgorithm(alg))
+	}
+
+	jws, err := jose.ParseSignedCompact(proofs[0], algs)
+	if err != nil || len(jws.Signatures) != 1 {
+		return "", ErrInvalidDPoPProof
+	}
+
+	header := jws.Signatures[0].Protected
+	if typ, _ := header.ExtraHeaders[jose.HeaderType].(string); typ != dpopProofType {
+		return "", ErrInvalidDPoPProof
+	}
+	jwk := header.JSONWebKey
+	if jwk == nil || !jwk.Valid() || !jwk.IsPublic() {
+		return "", ErrInvalidDPoPProof
+	}
+
+	payload, err := jws.Verify(jwk)
+	if err != nil {
+		return "", ErrInvalidDPoPProof
+	}
+
+	var claims dpopClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", ErrInvalidDPoPProof
+	}
+	if claims.JTI == "" || claims.HTM != r.Method || !sameHTU(claims.HTU, s.requestURL(r)) {
+		return "", ErrInvalidDPoPProof
+	}
+
+	lifetime := time.Duration(s.config.OAuth2Server.DPoP.ProofLifetime) * time.Second
+	if iat := time.Unix(claims.IAT, 0); time.Since(iat) > lifetime || time.Until(iat) > lifetime {
+		return "", ErrInvalidDPoPProof
+	}
+
+	if accessToken != "" {
+		hash := sha256.Sum256([]byte(accessToken))
+		if claims.ATH != base64.RawURLEncoding.EncodeToString(hash[:]) {
+			return "", ErrInvalidDPoPProof
+		}
+	}
+
+	if s.rdb == nil {
+		return "", errors.ErrTemporarilyUnavailable
+	}
+
+	if s.config.OAuth2Server.DPoP.RequireNonce {
+		if claims.Nonce == "" {
+			return "", ErrUseDPoPNonce
+		}
+		exists, err := s.rdb.Exists(r.Context(), redisDPoPNoncePrefix+claims.Nonce).Result()
+		if err != nil {
+			return "", err
+		} else if exists == 0 {
+			return "", ErrUseDPoPNonce
+		}
+	}
+
+	thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", ErrInvalidDPoPProof
+	}
+	jkt := base64.RawURLEncoding.EncodeToString(thumbprint)
+
+	// Reject replayed proofs; the jti is kept for as long as the proof could be accepted
+	fresh, err := s.rdb.SetNX(r.Context(), redisDPoPJTIPrefix+jkt+":"+claims.JTI, 1, 2*lifetime).Result()
+	if err != nil {
+		return "", err
+	} else if !fresh {
+		return "", ErrInvalidDPoPProof
+	}
+
+	return jkt, nil
+}
+
+// newDPoPNonce issues a server nonce to be included in subsequent DPoP proofs
+func (s *Server) newDPoPNonce(ctx context.Context) (string, error) {
+	if s.rdb == nil {
+		return "", errors.ErrTemporarilyUnavailable
+	}
+	nonce, err := utils.GenerateRandomString(32)
+	if err != nil {
+		return "", err
+	}
+	lifetime := time.Duration(s.config.OAuth2Server.DPoP.NonceLifetime) * time.Second
+	if err := s.rdb.SetEX(ctx, redisDPoPNoncePrefix+nonce, 1, lifetime).Err(); err != nil {
+		return "", err
+	}
+	return nonce, nil
+}
+
+// setDPoPNonce attaches a fresh nonce to the response when a nonce error is returned
+func (s *Server) setDPoPNonce(w http.ResponseWriter, r *http.Request, err error) {
+	if err != ErrUseDPoPNonce {
+		return
+	}
+	if nonce, nerr := s.newDPoPNonce(r.Context()); nerr == nil {
+		w.Header().Set("DPoP-Nonce", nonce)
+	}
+}
+
+// requestURL returns the request URL as seen by the client, without query and fragment
+func (s *Server) requestURL(r *http.Request) string {
+	return strings.TrimRight(s.config.OAuth2Server.Issuer, "/") + r.URL.Path
+}
+
+// sameHTU compares the htu claim with the request URL, ignoring query and fragment (RFC 9449 section 4.3)
+func sameHTU(htu, requestURL string) bool {
+	u, err := url.Parse(htu)
+	if err != nil {
+		return false
+	}
+	expected, err := url.Parse(requestURL)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Scheme, expected.Scheme) &&
+		strings.EqualFold(u.Host, expected.Host) &&
+		u.Path == expected.Path
+}
+
+// dpopExtensionHandler binds newly issued tokens to the key of the verified DPoP proof
+func dpopExtensionHandler(tgr *oauth2.TokenGenerateRequest, ti oauth2.ExtendableTokenInfo) {
+	if tgr.Request == nil {
+		return
+	}
+	jkt, _ := tgr.Request.Context().Value(dpopJKTContextKey).(string)
+	if jkt == "" {
+		return
+	}
+	ext := ti.GetExtension()
+	if ext == nil {
+		ext = url.Values{}
+	}
+	ext.Set(extensionDPoPJKT, jkt)
+	ti.SetExtension(ext)
+}
+
+// tokenJKT returns the key thumbprint a token is bound to, if any
+func tokenJKT(ti oauth2.TokenInfo) string {
+	if eti, ok := ti.(oauth2.ExtendableTokenInfo); ok && eti.GetExtension() != nil {
+		return eti.GetExtension().Get(extensionDPoPJKT)
+	}
+	return ""
+}
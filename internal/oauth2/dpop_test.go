@@ -0,0 +1,209 @@
+package oauth2
+
+import (
+	"context"
+	database "core-auth/db"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// dpopKey returns a DPoP proof key and its JWK thumbprint
+func dpopKey(t *testing.T) (*ecdsa.PrivateKey, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thumbprint, err := (&jose.JSONWebKey{Key: key.Public()}).Thumbprint(crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, base64.RawURLEncoding.EncodeToString(thumbprint)
+}
+
+// signDPoPProof signs a DPoP proof with the key embedded in its header
+func signDPoPProof(t *testing.T, key *ecdsa.PrivateKey, typ string, claims dpopClaims) string {
+	t.Helper()
+	options := &jose.SignerOptions{EmbedJWK: true}
+	if typ != "" {
+		options = options.WithType(jose.ContentType(typ))
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := jws.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proof
+}
+
+// tokenProofClaims returns the claims of a fresh proof for a token request
+func tokenProofClaims(s *Server, jti string) dpopClaims {
+	return dpopClaims{
+		JTI: jti,
+		HTM: http.MethodPost,
+		HTU: strings.TrimRight(s.config.OAuth2Server.Issuer, "/") + "/oauth2/token",
+		IAT: time.Now().Unix(),
+	}
+}
+
+// dpopRequest returns a token request carrying the DPoP proof
+func dpopRequest(proof string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/oauth2/token", nil)
+	r.Header.Set("DPoP", proof)
+	return r
+}
+
+func TestDPoPProofVerified(t *testing.T) {
+	s, _ := newTestServer(t)
+	key, jkt := dpopKey(t)
+
+	proof := signDPoPProof(t, key, dpopProofType, tokenProofClaims(s, "jti-1"))
+	got, err := s.verifyDPoPProof(dpopRequest(proof), "")
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if got != jkt {
+		t.Errorf("jkt = %q, want %q", got, jkt)
+	}
+	if _, err := s.verifyDPoPProof(dpopRequest(proof), ""); !errors.Is(err, ErrInvalidDPoPProof) {
+		t.Errorf("replay error = %v, want %v", err, ErrInvalidDPoPProof)
+	}
+}
+
+func TestDPoPProofRejected(t *testing.T) {
+	s, _ := newTestServer(t)
+	key, _ := dpopKey(t)
+
+	tests := []struct {
+		name   string
+		typ    string
+		modify func(*dpopClaims)
+	}{
+		{"missing typ", "", func(*dpopClaims) {}},
+		{"other typ", "JWT", func(*dpopClaims) {}},
+		{"missing jti", dpopProofType, func(c *dpopClaims) { c.JTI = "" }},
+		{"other method", dpopProofType, func(c *dpopClaims) { c.HTM = http.MethodGet }},
+		{"other URL", dpopProofType, func(c *dpopClaims) { c.HTU = "https://evil.example.com/oauth2/token" }},
+		{"stale", dpopProofType, func(c *dpopClaims) { c.IAT = time.Now().Add(-time.Hour).Unix() }},
+		{"issued in the future", dpopProofType, func(c *dpopClaims) { c.IAT = time.Now().Add(time.Hour).Unix() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := tokenProofClaims(s, tt.name)
+			tt.modify(&claims)
+			proof := signDPoPProof(t, key, tt.typ, claims)
+			if _, err := s.verifyDPoPProof(dpopRequest(proof), ""); !errors.Is(err, ErrInvalidDPoPProof) {
+				t.Errorf("error = %v, want %v", err, ErrInvalidDPoPProof)
+			}
+		})
+	}
+
+	// Proofs presented with an access token must hash it
+	claims := tokenProofClaims(s, "ath")
+	sum := sha256.Sum256([]byte("other-token"))
+	claims.ATH = base64.RawURLEncoding.EncodeToString(sum[:])
+	if _, err := s.verifyDPoPProof(dpopRequest(signDPoPProof(t, key, dpopProofType, claims)), "access-token"); !errors.Is(err, ErrInvalidDPoPProof) {
+		t.Errorf("other ath error = %v, want %v", err, ErrInvalidDPoPProof)
+	}
+}
+
+func TestDPoPNonceRequired(t *testing.T) {
+	t.Setenv("OAUTH2_DPOP_REQUIRE_NONCE", "true")
+	s, _ := newTestServer(t)
+	key, _ := dpopKey(t)
+
+	r := dpopRequest(signDPoPProof(t, key, dpopProofType, tokenProofClaims(s, "jti-1")))
+	_, err := s.verifyDPoPProof(r, "")
+	if !errors.Is(err, ErrUseDPoPNonce) {
+		t.Fatalf("error = %v, want %v", err, ErrUseDPoPNonce)
+	}
+	w := httptest.NewRecorder()
+	s.setDPoPNonce(w, r, err)
+	nonce := w.Header().Get("DPoP-Nonce")
+	if nonce == "" {
+		t.Fatal("no nonce issued")
+	}
+
+	claims := tokenProofClaims(s, "jti-2")
+	claims.Nonce = "unknown"
+	if _, err := s.verifyDPoPProof(dpopRequest(signDPoPProof(t, key, dpopProofType, claims)), ""); !errors.Is(err, ErrUseDPoPNonce) {
+		t.Errorf("unknown nonce error = %v, want %v", err, ErrUseDPoPNonce)
+	}
+	claims.Nonce = nonce
+	if _, err := s.verifyDPoPProof(dpopRequest(signDPoPProof(t, key, dpopProofType, claims)), ""); err != nil {
+		t.Errorf("issued nonce: %v", err)
+	}
+}
+
+func TestDPoPBoundTokenRequiresProof(t *testing.T) {
+	s, _ := newTestServer(t)
+	clientID, _ := newConfidentialClient(t, s, &database.OAuth2Client{})
+	key, jkt := dpopKey(t)
+	storeAccessToken(t, s, clientID, "access-1", url.Values{extensionDPoPJKT: {jkt}})
+
+	resourceRequest := func(scheme string, key *ecdsa.PrivateKey, jti string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/oauth2/userinfo", nil)
+		r.Header.Set("Authorization", scheme+" access-1")
+		sum := sha256.Sum256([]byte("access-1"))
+		r.Header.Set("DPoP", signDPoPProof(t, key, dpopProofType, dpopClaims{
+			JTI: jti,
+			HTM: http.MethodGet,
+			HTU: strings.TrimRight(s.config.OAuth2Server.Issuer, "/") + "/oauth2/userinfo",
+			IAT: time.Now().Unix(),
+			ATH: base64.RawURLEncoding.EncodeToString(sum[:]),
+		}))
+		return r
+	}
+
+	ti, err := s.ValidationAccessToken(resourceRequest("DPoP", key, "jti-1"))
+	if err != nil {
+		t.Fatalf("DPoP validation: %v", err)
+	}
+	if data := s.TokenData(context.Background(), ti); data["token_type"] != "DPoP" {
+		t.Errorf("token_type = %v, want DPoP", data["token_type"])
+	}
+
+	// A bound token is not a bearer token
+	if _, err := s.ValidationAccessToken(resourceRequest("Bearer", key, "jti-2")); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("bearer error = %v, want %v", err, ErrInvalidToken)
+	}
+
+	other, _ := dpopKey(t)
+	r := resourceRequest("DPoP", other, "jti-3")
+	_, err = s.ValidationAccessToken(r)
+	if !errors.Is(err, ErrInvalidDPoPProof) {
+		t.Fatalf("other key error = %v, want %v", err, ErrInvalidDPoPProof)
+	}
+	w := httptest.NewRecorder()
+	if err := s.WriteResourceError(w, r, err); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusUnauthorized || !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), `DPoP error="invalid_dpop_proof", algs="`) {
+		t.Errorf("resource error = %d %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+}
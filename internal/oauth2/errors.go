@@ -0,0 +1,177 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/errors"
+	"github.com/go-oauth2/oauth2/v4/server"
+	"github.com/google/uuid"
+)
+
+// Extension errors
+var (
+	ErrInvalidToken                 = errors.New("invalid_token")
+	ErrInvalidRequestURI            = errors.New("invalid_request_uri")
+	ErrPushedAuthorizationRequired  = errors.New("invalid_request")
+	ErrInvalidTarget                = errors.New("invalid_target")
+	ErrInvalidRequestObject         = errors.New("invalid_request_object")
+	ErrRequestObjectRequired        = errors.New("invalid_request")
+	ErrInvalidAuthorizationDetails  = errors.New("invalid_authorization_details")
+	ErrInvalidConsent               = errors.New("invalid_request")
+	ErrInvalidLogoutRequest         = errors.New("invalid_request")
+	ErrInvalidPostLogoutRedirectURI = errors.New("invalid_request")
+)
+
+func init() {
+	// RFC 6749 section 5.2: every token error except invalid_client is a 400
+	errors.StatusCodes[errors.ErrUnauthorizedClient] = http.StatusBadRequest
+	errors.StatusCodes[errors.ErrUnsupportedResponseType] = http.StatusBadRequest
+	errors.StatusCodes[errors.ErrInvalidGrant] = http.StatusBadRequest
+	errors.StatusCodes[errors.ErrUnsupportedGrantType] = http.StatusBadRequest
+
+	errors.Descriptions[ErrInvalidToken] = "The access token provided is expired, revoked, malformed, or invalid"
+	errors.StatusCodes[ErrInvalidToken] = http.StatusUnauthorized
+	errors.Descriptions[ErrInvalidRequestURI] = "The request_uri is invalid, expired, already used or was issued to another client"
+	errors.StatusCodes[ErrInvalidRequestURI] = http.StatusBadRequest
+	errors.Descriptions[ErrPushedAuthorizationRequired] = "Pushed authorization request is required, request_uri is missing"
+	errors.StatusCodes[ErrPushedAuthorizationRequired] = http.StatusBadRequest
+	errors.Descriptions[ErrInvalidTarget] = "The requested resource is invalid, unknown, or not accepted for the requested scope"
+	errors.StatusCodes[ErrInvalidTarget] = http.StatusBadRequest
+	errors.Descriptions[ErrInvalidRequestObject] = "The request object is malformed, its signature or claims are invalid"
+	errors.StatusCodes[ErrInvalidRequestObject] = http.StatusBadRequest
+	errors.Descriptions[ErrRequestObjectRequired] = "A signed request object is required, request is missing"
+	errors.StatusCodes[ErrRequestObjectRequired] = http.StatusBadRequest
+	errors.Descriptions[ErrInvalidAuthorizationDetails] = "The authorization details are malformed, of an unknown type or not allowed for the client"
+	errors.StatusCodes[ErrInvalidAuthorizationDetails] = http.StatusBadRequest
+	errors.Descriptions[ErrInvalidConsent] = "The consent challenge is invalid, expired or already used"
+	errors.StatusCodes[ErrInvalidConsent] = http.StatusBadRequest
+	errors.Descriptions[ErrInvalidLogoutRequest] = "The logout challenge is invalid, expired or already used"
+	errors.StatusCodes[ErrInvalidLogoutRequest] = http.StatusBadRequest
+	errors.Descriptions[ErrInvalidPostLogoutRedirectURI] = "The post_logout_redirect_uri is not registered for the client"
+	errors.StatusCodes[ErrInvalidPostLogoutRedirectURI] = http.StatusBadRequest
+}
+
+// specErrors maps go-oauth2 internal errors to their RFC 6749 / RFC 6750 error codes
+var specErrors = map[error]error{
+	errors.ErrInvalidRedirectURI:   errors.ErrInvalidRequest,
+	errors.ErrInvalidAuthorizeCode: errors.ErrInvalidGrant,
+	errors.ErrInvalidRefreshToken:  errors.ErrInvalidGrant,
+	errors.ErrExpiredRefreshToken:  errors.ErrInvalidGrant,
+	errors.ErrMissingCodeVerifier:  errors.ErrInvalidGrant,
+	errors.ErrMissingCodeChallenge: errors.ErrInvalidGrant,
+	errors.ErrInvalidCodeChallenge: errors.ErrInvalidGrant,
+	errors.ErrInvalidAccessToken:   ErrInvalidToken,
+	errors.ErrExpiredAccessToken:   ErrInvalidToken,
+}
+
+// internalErrorHandler translates errors that are not defined by the spec.
+// Unexpected errors are logged with a correlation ID that is also returned to the client.
+func internalErrorHandler(err error) *errors.Response {
+	if specErr, ok := specErrors[err]; ok {
+		re := errors.NewResponse(specErr, errors.StatusCodes[specErr])
+		re.Description = err.Error()
+		if specErr == ErrInvalidToken {
+			re.SetHeader("WWW-Authenticate", `Bearer error="invalid_token"`)
+		}
+		return re
+	}
+
+	correlationID := uuid.New().String()
+	log.Printf("OAuth2 internal error [correlation_id=%s]: %v", correlationID, err)
+
+	re := errors.NewResponse(errors.ErrServerError, http.StatusInternalServerError)
+	re.Description = errors.Descriptions[errors.ErrServerError] + " (correlation_id: " + correlationID + ")"
+	re.SetHeader("X-Correlation-ID", correlationID)
+	return re
+}
+
+// responseErrorHandler completes every error response with its error_uri
+func (s *Server) responseErrorHandler(re *errors.Response) {
+	if re.URI == "" && re.Error != nil && s.config.OAuth2Server.ErrorURI != "" {
+		re.URI = strings.TrimRight(s.config.OAuth2Server.ErrorURI, "#") + "#" + re.Error.Error()
+	}
+}
+
+// WriteError writes an error response for errors that cannot be sent to the client's redirect_uri
+func (s *Server) WriteError(w http.ResponseWriter, err error) error {
+	data, statusCode, header := s.GetErrorData(err)
+	return writeJSON(w, data, header, statusCode)
+}
+
+// redirectError sends an authorization error to the client's redirect_uri (RFC 6749 section 4.1.2.1).
+// The error is returned unhandled when the client or redirect_uri cannot be trusted.
+func (s *Server) redirectError(w http.ResponseWriter, req *server.AuthorizeRequest, err error) error {
+	uri, ok := s.errorRedirectURI(req, err)
+	if !ok {
+		return err
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Location", uri)
+	w.WriteHeader(http.StatusFound)
+	return nil
+}
+
+// errorRedirectURI builds the redirect_uri carrying an authorization error, if it can be trusted
+func (s *Server) errorRedirectURI(req *server.AuthorizeRequest, err error) (string, bool) {
+	if req == nil {
+		return "", false
+	}
+
+	redirectURI, ok := s.registeredRedirectURI(req.Request, req.ClientID, req.RedirectURI)
+	if !ok {
+		return "", false
+	}
+	req.RedirectURI = redirectURI
+	if req.ResponseType != oauth2.Token {
+		req.ResponseType = oauth2.Code
+	}
+
+	data, _, _ := s.GetErrorData(err)
+	uri, uriErr := s.GetRedirectURI(req, data)
+	if uriErr != nil {
+		return "", false
+	}
+	return uri, true
+}
+
+// registeredRedirectURI returns the redirect URI to use for the client if it is registered.
+// An empty redirectURI resolves to the client's only registered URI.
+func (s *Server) registeredRedirectURI(r *http.Request, clientID, redirectURI string) (string, bool) {
+	if clientID == "" {
+		return "", false
+	}
+	cli, err := s.Manager.GetClient(r.Context(), clientID)
+	if err != nil {
+		return "", false
+	}
+
+	if redirectURI == "" {
+		var uris []string
+		if err := json.Unmarshal([]byte(cli.GetDomain()), &uris); err != nil || len(uris) != 1 {
+			return "", false
+		}
+		return uris[0], true
+	}
+
+	if err := validateRedirectURI(cli.GetDomain(), redirectURI); err != nil {
+		return "", false
+	}
+	return redirectURI, true
+}
+
+// writeJSON writes a non-cacheable JSON response
+func writeJSON(w http.ResponseWriter, data map[string]interface{}, header http.Header, statusCode int) error {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	for key := range header {
+		w.Header().Set(key, header.Get(key))
+	}
+	w.WriteHeader(statusCode)
+	return json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,129 @@
+package oauth2
+
+import (
+	database "core-auth/db"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-oauth2/oauth2/v4"
+	oerrors "github.com/go-oauth2/oauth2/v4/errors"
+	"github.com/go-oauth2/oauth2/v4/server"
+)
+
+// writeError writes the error response of err and returns its status, header and body
+func writeError(t *testing.T, s *Server, err error) (int, http.Header, map[string]interface{}) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	if err := s.WriteError(w, err); err != nil {
+		t.Fatal(err)
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, w.Header(), data
+}
+
+func TestErrorsUseSpecCodes(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	tests := []struct {
+		err    error
+		status int
+		error  string
+	}{
+		{oerrors.ErrInvalidAuthorizeCode, http.StatusBadRequest, "invalid_grant"},
+		{oerrors.ErrInvalidRefreshToken, http.StatusBadRequest, "invalid_grant"},
+		{oerrors.ErrInvalidCodeChallenge, http.StatusBadRequest, "invalid_grant"},
+		{oerrors.ErrInvalidRedirectURI, http.StatusBadRequest, "invalid_request"},
+		{oerrors.ErrInvalidGrant, http.StatusBadRequest, "invalid_grant"},
+		{oerrors.ErrUnauthorizedClient, http.StatusBadRequest, "unauthorized_client"},
+		{oerrors.ErrInvalidClient, http.StatusUnauthorized, "invalid_client"},
+		{oerrors.ErrExpiredAccessToken, http.StatusUnauthorized, "invalid_token"},
+		{ErrInvalidTarget, http.StatusBadRequest, "invalid_target"},
+	}
+	for _, tt := range tests {
+		status, header, data := writeError(t, s, tt.err)
+		if status != tt.status || data["error"] != tt.error {
+			t.Errorf("%v: response = %d %v, want %d %s", tt.err, status, data, tt.status, tt.error)
+		}
+		if header.Get("Cache-Control") != "no-store" {
+			t.Errorf("%v: Cache-Control = %q, want no-store", tt.err, header.Get("Cache-Control"))
+		}
+	}
+
+	if _, header, _ := writeError(t, s, oerrors.ErrInvalidAccessToken); header.Get("WWW-Authenticate") != `Bearer error="invalid_token"` {
+		t.Errorf("WWW-Authenticate = %q", header.Get("WWW-Authenticate"))
+	}
+}
+
+func TestUnexpectedErrorIsCorrelated(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	status, header, data := writeError(t, s, errors.New("connection refused"))
+	if status != http.StatusInternalServerError || data["error"] != "server_error" {
+		t.Fatalf("response = %d %v", status, data)
+	}
+	correlationID := header.Get("X-Correlation-ID")
+	if correlationID == "" {
+		t.Fatal("no correlation ID")
+	}
+	description, _ := data["error_description"].(string)
+	if !strings.Contains(description, correlationID) || strings.Contains(description, "connection refused") {
+		t.Errorf("error_description = %q", description)
+	}
+}
+
+func TestErrorURI(t *testing.T) {
+	t.Setenv("OAUTH2_ERROR_URI", "https://docs.example.com/errors")
+	s, _ := newTestServer(t)
+
+	if _, _, data := writeError(t, s, oerrors.ErrInvalidAuthorizeCode); data["error_uri"] != "https://docs.example.com/errors#invalid_grant" {
+		t.Errorf("error_uri = %v", data["error_uri"])
+	}
+}
+
+func TestAuthorizationErrorRedirected(t *testing.T) {
+	s, _ := newTestServer(t)
+	clientID, _ := newConfidentialClient(t, s, &database.OAuth2Client{})
+	authorizeRequest := func(redirectURI string) *server.AuthorizeRequest {
+		return &server.AuthorizeRequest{
+			ResponseType: oauth2.Code,
+			ClientID:     clientID,
+			RedirectURI:  redirectURI,
+			State:        "xyz",
+			Request:      httptest.NewRequest(http.MethodGet, "/oauth2/authorize", nil),
+		}
+	}
+
+	// An omitted redirect_uri resolves to the client's only registered one
+	for _, redirectURI := range []string{testRedirectURI, ""} {
+		w := httptest.NewRecorder()
+		if err := s.redirectError(w, authorizeRequest(redirectURI), oerrors.ErrInvalidScope); err != nil {
+			t.Fatalf("redirect error: %v", err)
+		}
+		location, err := url.Parse(w.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		query := location.Query()
+		if w.Code != http.StatusFound || !strings.HasPrefix(location.String(), testRedirectURI+"?") ||
+			query.Get("error") != "invalid_scope" || query.Get("state") != "xyz" {
+			t.Errorf("redirect_uri %q: response = %d %s", redirectURI, w.Code, location)
+		}
+	}
+
+	// Errors are never sent to a redirect_uri that is not registered
+	w := httptest.NewRecorder()
+	if err := s.redirectError(w, authorizeRequest("https://evil.example.com/"), oerrors.ErrInvalidScope); !errors.Is(err, oerrors.ErrInvalidScope) {
+		t.Errorf("unregistered redirect_uri error = %v, want %v", err, oerrors.ErrInvalidScope)
+	}
+	if w.Header().Get("Location") != "" {
+		t.Errorf("redirected to %q", w.Header().Get("Location"))
+	}
+}
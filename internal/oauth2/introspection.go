@@ -0,0 +1,177 @@
+package oauth2
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/errors"
+)
+
+// ValidationAccessToken validates the access token of a protected resource request.
+// DPoP-bound tokens must be presented with the DPoP scheme and a proof for the
+// same key, other tokens with the Bearer scheme (RFC 9449 section 7).
+func (s *Server) ValidationAccessToken(r *http.Request) (oauth2.TokenInfo, error) {
+	scheme, accessToken, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || accessToken == "" {
+		return nil, ErrInvalidToken
+	}
+
+	ti, err := s.Manager.LoadAccessToken(r.Context(), accessToken)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	// Tokens of disabled clients stop validating
+	if _, err := s.Manager.GetClient(r.Context(), ti.GetClientID()); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	jkt := tokenJKT(ti)
+	switch {
+	case jkt == "" && strings.EqualFold(scheme, "Bearer"):
+	case jkt != "" && strings.EqualFold(scheme, "DPoP") && s.DPoPEnabled():
+		proofJKT, err := s.verifyDPoPProof(r, accessToken)
+		if err != nil {
+			return nil, err
+		}
+		if proofJKT != jkt {
+			return nil, ErrInvalidDPoPProof
+		}
+	default:
+		return nil, ErrInvalidToken
+	}
+
+	s.touchToken(r.Context(), accessToken)
+	return ti, nil
+}
+
+// WriteResourceError writes the error response of a protected resource request
+// with the matching WWW-Authenticate challenge (RFC 6750 section 3, RFC 9449 section 7.1)
+func (s *Server) WriteResourceError(w http.ResponseWriter, r *http.Request, err error) error {
+	data, statusCode, header := s.GetErrorData(err)
+	if header == nil {
+		header = make(http.Header)
+	}
+
+	scheme := "Bearer"
+	if strings.HasPrefix(strings.ToLower(r.Header.Get("Authorization")), "dpop ") {
+		scheme = "DPoP"
+	}
+	challenge := scheme + ` error="` + data["error"].(string) + `"`
+	if scheme == "DPoP" {
+		challenge += `, algs="` + strings.Join(s.config.OAuth2Server.DPoP.SigningAlgs, " ") + `"`
+	}
+
+	switch err {
+	case ErrInvalidDPoPProof, ErrUseDPoPNonce:
+		statusCode = http.StatusUnauthorized
+		s.setDPoPNonce(w, r, err)
+	}
+	header.Set("WWW-Authenticate", challenge)
+
+	return writeJSON(w, data, header, statusCode)
+}
+
+// HandleIntrospectionRequest handles token introspection (RFC 7662) for authenticated confidential clients.
+// Clients see the tokens issued to them and resource servers the tokens issued for them (RFC 8707),
+// other tokens are reported inactive.
+func (s *Server) HandleIntrospectionRequest(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return s.WriteError(w, errors.ErrInvalidRequest)
+	}
+	if err := r.ParseForm(); err != nil {
+		return s.WriteError(w, errors.ErrInvalidRequest)
+	}
+
+	cli, err := s.authenticateClient(r)
+	if err != nil {
+		return s.WriteError(w, err)
+	} else if cli.GetSecret() == "" {
+		return s.WriteError(w, errors.ErrInvalidClient)
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		return s.WriteError(w, errors.ErrInvalidRequest)
+	}
+
+	var ti oauth2.TokenInfo
+	isRefresh := r.PostForm.Get("token_type_hint") == "refresh_token"
+	if !isRefresh {
+		ti, err = s.Manager.LoadAccessToken(r.Context(), token)
+	}
+	if isRefresh || err != nil {
+		if ti, err = s.Manager.LoadRefreshToken(r.Context(), token); err == nil {
+			isRefresh = true
+		}
+	}
+	if err == nil {
+		_, err = s.Manager.GetClient(r.Context(), ti.GetClientID())
+	}
+	if err != nil || (ti.GetClientID() != cli.GetID() && !s.issuedFor(ti, cli.GetID())) {
+		return writeJSON(w, map[string]interface{}{"active": false}, nil, http.StatusOK)
+	}
+
+	if !isRefresh {
+		s.touchToken(r.Context(), token)
+	}
+
+	data := s.introspectionData(r.Context(), ti, isRefresh)
+	if client, ok := cli.(*Client); ok && client.Internal && ti.GetUserID() != "" {
+		// Trusted internal clients may resolve pairwise subjects to the user
+		data["user_id"] = ti.GetUserID()
+	}
+	return writeJSON(w, data, nil, http.StatusOK)
+}
+
+// introspectionData builds the introspection response of an active token
+func (s *Server) introspectionData(ctx context.Context, ti oauth2.TokenInfo, isRefresh bool) map[string]interface{} {
+	data := map[string]interface{}{
+		"active":    true,
+		"client_id": ti.GetClientID(),
+		"iss":       strings.TrimRight(s.config.OAuth2Server.Issuer, "/"),
+	}
+
+	if scope := ti.GetScope(); scope != "" {
+		data["scope"] = scope
+	}
+	if sub := s.subject(ctx, ti.GetClientID(), ti.GetUserID()); sub != "" {
+		data["sub"] = sub
+	}
+
+	if resources := tokenResources(ti); len(resources) == 1 {
+		data["aud"] = resources[0]
+	} else if len(resources) > 1 {
+		data["aud"] = resources
+	}
+
+	createdAt, expiresIn := ti.GetAccessCreateAt(), ti.GetAccessExpiresIn()
+	if isRefresh {
+		createdAt, expiresIn = ti.GetRefreshCreateAt(), ti.GetRefreshExpiresIn()
+	}
+	data["iat"] = createdAt.Unix()
+	if expiresIn > 0 {
+		data["exp"] = createdAt.Add(expiresIn).Unix()
+	}
+
+	if details := tokenAuthorizationDetails(ti); details != nil {
+		data["authorization_details"] = details
+	}
+
+	data["token_type"] = "Bearer"
+	if jkt := tokenJKT(ti); jkt != "" {
+		data["token_type"] = "DPoP"
+		data["cnf"] = map[string]interface{}{"jkt": jkt}
+	}
+
+	return data
+}
+
+// TokenData returns the introspection view of a validated access token
+func (s *Server) TokenData(ctx context.Context, ti oauth2.TokenInfo) map[string]interface{} {
+	data := s.introspectionData(ctx, ti, false)
+	data["expires_in"] = int64(time.Until(ti.GetAccessCreateAt().Add(ti.GetAccessExpiresIn())) / time.Second)
+	return data
+}
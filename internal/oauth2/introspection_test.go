@@ -0,0 +1,113 @@
+package oauth2
+
+import (
+	"context"
+	database "core-auth/db"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4/models"
+)
+
+// storeAccessToken stores an access token of user 1 for a client with the given token extensions
+func storeAccessToken(t *testing.T, s *Server, clientID, access string, ext url.Values) {
+	t.Helper()
+	ti := models.NewToken()
+	ti.SetClientID(clientID)
+	ti.SetUserID("1")
+	ti.SetScope("openid")
+	ti.SetAccess(access)
+	ti.SetAccessCreateAt(time.Now())
+	ti.SetAccessExpiresIn(time.Hour)
+	ti.SetExtension(ext)
+	if err := NewStorage(s.rdb, s.db).Create(context.Background(), ti); err != nil {
+		t.Fatalf("store token: %v", err)
+	}
+}
+
+// introspect introspects a token as the client and returns the response
+func introspect(t *testing.T, s *Server, clientID, secret, token string) (int, map[string]interface{}) {
+	t.Helper()
+	w := postForm(t, s.HandleIntrospectionRequest, "/oauth2/introspect", url.Values{
+		"client_id":     {clientID},
+		"client_secret": {secret},
+		"token":         {token},
+	})
+	var data map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, data
+}
+
+func TestIntrospectionOfOwnToken(t *testing.T) {
+	s, _ := newTestServer(t)
+	clientID, secret := newConfidentialClient(t, s, &database.OAuth2Client{})
+	storeAccessToken(t, s, clientID, "access-1", url.Values{extensionDPoPJKT: {"thumbprint"}})
+
+	status, data := introspect(t, s, clientID, secret, "access-1")
+	if status != http.StatusOK || data["active"] != true || data["client_id"] != clientID || data["scope"] != "openid" {
+		t.Fatalf("response = %d %v", status, data)
+	}
+	if data["token_type"] != "DPoP" || data["cnf"].(map[string]interface{})["jkt"] != "thumbprint" {
+		t.Errorf("token_type = %v, cnf = %v", data["token_type"], data["cnf"])
+	}
+
+	if _, data := introspect(t, s, clientID, secret, "unknown"); data["active"] != false {
+		t.Errorf("unknown token response = %v", data)
+	}
+}
+
+func TestIntrospectionLimitedToClientAndResourceServers(t *testing.T) {
+	s, _ := newTestServer(t)
+	clientID, _ := newConfidentialClient(t, s, &database.OAuth2Client{})
+	otherID, otherSecret := newConfidentialClient(t, s, &database.OAuth2Client{})
+	apiID, apiSecret := newConfidentialClient(t, s, &database.OAuth2Client{})
+	if err := s.db.Create(&database.OAuth2ResourceServer{
+		Identifier: "https://api.example.com",
+		Name:       "API",
+		Scopes:     `["openid"]`,
+		ClientID:   apiID,
+		IsActive:   true,
+	}).Error; err != nil {
+		t.Fatal(err)
+	}
+	storeAccessToken(t, s, clientID, "unrestricted", nil)
+	storeAccessToken(t, s, clientID, "for-api", url.Values{extensionResource: {"https://api.example.com"}})
+	storeAccessToken(t, s, clientID, "for-other", url.Values{extensionResource: {"https://other.example.com"}})
+
+	tests := []struct {
+		clientID, secret, token string
+		active                  bool
+	}{
+		{otherID, otherSecret, "unrestricted", false},
+		{apiID, apiSecret, "unrestricted", true},
+		{apiID, apiSecret, "for-api", true},
+		{apiID, apiSecret, "for-other", false},
+	}
+	for _, tt := range tests {
+		if _, data := introspect(t, s, tt.clientID, tt.secret, tt.token); data["active"] != tt.active {
+			t.Errorf("%s introspecting %s: response = %v, want active %v", tt.clientID, tt.token, data, tt.active)
+		}
+	}
+
+	if _, data := introspect(t, s, apiID, apiSecret, "for-api"); data["aud"] != "https://api.example.com" {
+		t.Errorf("aud = %v", data["aud"])
+	}
+}
+
+func TestIntrospectionRequiresConfidentialClient(t *testing.T) {
+	s, _ := newTestServer(t)
+	client := &database.OAuth2Client{Name: "spa", IsActive: true, RedirectURIs: `["` + testRedirectURI + `"]`}
+	if _, err := s.CreateClient(context.Background(), client, false); err != nil {
+		t.Fatal(err)
+	}
+	storeAccessToken(t, s, client.ClientID, "access-1", nil)
+
+	if status, data := introspect(t, s, client.ClientID, "", "access-1"); status != http.StatusUnauthorized || data["error"] != "invalid_client" {
+		t.Errorf("public client response = %d %v", status, data)
+	}
+}
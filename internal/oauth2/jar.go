@@ -0,0 +1,223 @@
+package oauth2
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+// maxRequestObjectSize is the largest request object fetched from a request_uri
+const maxRequestObjectSize = 64 << 10
+
+// JAREnabled reports whether signed authorization requests are accepted
+func (s *Server) JAREnabled() bool {
+	return s.config.OAuth2Server.JAR.Enabled
+}
+
+// resolveRequestObject verifies the request object passed by value in request or by
+// reference in request_uri and merges its claims into the request form, the claims
+// taking precedence (RFC 9101 section 6). It reports whether a request object was used.
+func (s *Server) resolveRequestObject(r *http.Request) (bool, error) {
+	request, requestURI := r.Form.Get("request"), r.Form.Get("request_uri")
+	if request == "" && requestURI == "" {
+		return false, nil
+	}
+	if !s.JAREnabled() || (request != "" && requestURI != "") {
+		return false, ErrInvalidRequestObject
+	}
+
+	clientID := r.Form.Get("client_id")
+	client, err := s.queries.GetClient(clientID)
+	if err != nil {
+		return false, ErrInvalidRequestObject
+	}
+
+	if requestURI != "" {
+		var allowed []string
+		if client.RequestURIs != "" {
+			if err := json.Unmarshal([]byte(client.RequestURIs), &allowed); err != nil {
+				return false, ErrInvalidRequestURI
+			}
+		}
+		if !contains(allowed, requestURI) {
+			return false, ErrInvalidRequestURI
+		}
+		if request, err = s.fetchRequestObject(r, requestURI); err != nil {
+			return false, ErrInvalidRequestURI
+		}
+	}
+
+	claims, err := s.verifyRequestObject(r.Context(), request, clientID, client.JWKS)
+	if err != nil {
+		return false, err
+	}
+
+	form := url.Values{}
+	for key, values := range r.Form {
+		if key != "request" && key != "request_uri" {
+			form[key] = values
+		}
+	}
+	for key, value := range claims {
+		switch key {
+		case "iss", "aud", "exp", "nbf", "iat", "jti":
+			continue
+		case "request", "request_uri":
+			return false, ErrInvalidRequestObject
+		}
+		values, err := claimValues(value)
+		if err != nil {
+			return false, ErrInvalidRequestObject
+		}
+		form[key] = values
+	}
+
+	r.Form = form
+	return true, nil
+}
+
+// verifyRequestObject checks the signature of a request object against the client's
+// registered keys and validates its iss, aud, exp and nbf claims. Its jti is remembered until
+// it expires, so that a request object is accepted once.
+func (s *Server) verifyRequestObject(ctx context.Context, request string, clientID string, clientJWKS string) (map[string]interface{}, error) {
+	algs := make([]jose.SignatureAlgorithm, 0, len(s.config.OAuth2Server.JAR.SigningAlgs))
+	for _, alg := range s.config.OAuth2Server.JAR.SigningAlgs {
+		algs = append(algs, jose.SignatureAlgorithm(alg))
+	}
+	token, err := jwt.ParseSigned(request, algs)
+	if err != nil {
+		return nil, ErrInvalidRequestObject
+	}
+
+	var jwks jose.JSONWebKeySet
+	if clientJWKS == "" || json.Unmarshal([]byte(clientJWKS), &jwks) != nil {
+		return nil, ErrInvalidRequestObject
+	}
+	keys := jwks.Keys
+	if kid := token.Headers[0].KeyID; kid != "" {
+		keys = jwks.Key(kid)
+	}
+
+	var claims map[string]interface{}
+	var standard jwt.Claims
+	verified := false
+	for _, key := range keys {
+		if err := token.Claims(key.Public(), &claims, &standard); err == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrInvalidRequestObject
+	}
+
+	// exp and jti are required, nbf is validated when present and bounds the lifetime of the request
+	if standard.Expiry == nil || standard.ID == "" {
+		return nil, ErrInvalidRequestObject
+	}
+	issuer := strings.TrimRight(s.config.OAuth2Server.Issuer, "/")
+	if err := standard.ValidateWithLeeway(jwt.Expected{
+		Issuer:      clientID,
+		AnyAudience: jwt.Audience{issuer, issuer + "/"},
+		Time:        time.Now(),
+	}, 30*time.Second); err != nil {
+		return nil, ErrInvalidRequestObject
+	}
+	start := time.Now()
+	if standard.NotBefore != nil {
+		start = standard.NotBefore.Time()
+	}
+	if standard.Expiry.Time().Sub(start) > time.Duration(s.config.OAuth2Server.JAR.MaxLifetime)*time.Second {
+		return nil, ErrInvalidRequestObject
+	}
+
+	if id, ok := claims["client_id"]; ok && id != clientID {
+		return nil, ErrInvalidRequestObject
+	}
+
+	if s.rdb != nil {
+		ttl := time.Until(standard.Expiry.Time()) + 30*time.Second
+		fresh, err := s.rdb.SetNX(ctx, redisJARJTIPrefix+clientID+":"+standard.ID, 1, ttl).Result()
+		if err != nil {
+			return nil, err
+		} else if !fresh {
+			return nil, ErrInvalidRequestObject
+		}
+	}
+	return claims, nil
+}
+
+// fetchRequestObject retrieves a request object from a registered request_uri
+func (s *Server) fetchRequestObject(r *http.Request, requestURI string) (string, error) {
+	u, err := url.Parse(requestURI)
+	if err != nil || u.Scheme != "https" {
+		return "", fmt.Errorf("request_uri must use https")
+	}
+
+	client := &http.Client{Timeout: time.Duration(s.config.OAuth2Server.JAR.RequestURITimeout) * time.Second}
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, requestURI, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/oauth-authz-req+jwt")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("request_uri responded with status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRequestObjectSize))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// requestObjectRequired reports whether the client must sign its authorization requests
+func (s *Server) requestObjectRequired(clientID string) bool {
+	client, err := s.queries.GetClient(clientID)
+	if err != nil {
+		return false
+	}
+	return client.RequireSignedRequestObject
+}
+
+// claimValues converts a request object claim to form values. Arrays of strings become
+// repeated parameters, objects are passed on JSON encoded.
+func claimValues(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		strs := true
+		for _, item := range v {
+			str, ok := item.(string)
+			if !ok {
+				strs = false
+				break
+			}
+			values = append(values, str)
+		}
+		if strs {
+			return values, nil
+		}
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return []string{string(data)}, nil
+}
@@ -0,0 +1,99 @@
+package oauth2
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+// requestObjectSigner returns a signer of request objects and the JWKS a client registers
+func requestObjectSigner(t *testing.T) (jose.Signer, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: key.Public(), Algorithm: "ES256", Use: "sig"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, string(jwks)
+}
+
+// signRequestObject signs a request object of the client with the given jti
+func signRequestObject(t *testing.T, s *Server, signer jose.Signer, clientID string, jti string) string {
+	t.Helper()
+	now := time.Now()
+	request, err := jwt.Signed(signer).Claims(jwt.Claims{
+		Issuer:   clientID,
+		Audience: jwt.Audience{strings.TrimRight(s.config.OAuth2Server.Issuer, "/")},
+		Expiry:   jwt.NewNumericDate(now.Add(5 * time.Minute)),
+		IssuedAt: jwt.NewNumericDate(now),
+		ID:       jti,
+	}).Claims(map[string]interface{}{"client_id": clientID, "scope": "openid"}).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return request
+}
+
+func TestRequestObjectAcceptedOnce(t *testing.T) {
+	s, _ := newTestServer(t)
+	signer, jwks := requestObjectSigner(t)
+	ctx := context.Background()
+	request := signRequestObject(t, s, signer, "client", "jti-1")
+
+	claims, err := s.verifyRequestObject(ctx, request, "client", jwks)
+	if err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if claims["scope"] != "openid" {
+		t.Errorf("scope = %v, want openid", claims["scope"])
+	}
+	if _, err := s.verifyRequestObject(ctx, request, "client", jwks); !errors.Is(err, ErrInvalidRequestObject) {
+		t.Fatalf("replay error = %v, want %v", err, ErrInvalidRequestObject)
+	}
+
+	// The jti is scoped to the client that signed it
+	other := signRequestObject(t, s, signer, "other", "jti-1")
+	if _, err := s.verifyRequestObject(ctx, other, "other", jwks); err != nil {
+		t.Fatalf("same jti of another client: %v", err)
+	}
+}
+
+func TestRequestObjectRequiresJTI(t *testing.T) {
+	s, _ := newTestServer(t)
+	signer, jwks := requestObjectSigner(t)
+	request := signRequestObject(t, s, signer, "client", "")
+
+	if _, err := s.verifyRequestObject(context.Background(), request, "client", jwks); !errors.Is(err, ErrInvalidRequestObject) {
+		t.Fatalf("error = %v, want %v", err, ErrInvalidRequestObject)
+	}
+}
+
+func TestRequestObjectJTIExpires(t *testing.T) {
+	s, redisServer := newTestServer(t)
+	signer, jwks := requestObjectSigner(t)
+	request := signRequestObject(t, s, signer, "client", "jti-1")
+
+	if _, err := s.verifyRequestObject(context.Background(), request, "client", jwks); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	ttl := redisServer.TTL(redisJARJTIPrefix + "client:jti-1")
+	if ttl <= 5*time.Minute || ttl > 6*time.Minute {
+		t.Errorf("jti kept for %v, want until the request object expires", ttl)
+	}
+}
@@ -0,0 +1,78 @@
+package oauth2
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/google/uuid"
+)
+
+// jwtAccessTokenType is the typ header of JWT access tokens (RFC 9068 section 2.1)
+const jwtAccessTokenType = "at+jwt"
+
+// jwtAccessToken signs a JWT access token (RFC 9068) for the token being generated
+func (g *tokenGenerate) jwtAccessToken(ctx context.Context, data *oauth2.GenerateBasic) (string, error) {
+	key, err := g.keys.SigningKey(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: key.alg, Key: jose.JSONWebKey{Key: key.key, KeyID: key.kid}},
+		(&jose.SignerOptions{}).WithType(jwtAccessTokenType),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return jwt.Signed(signer).Claims(g.jwtClaims(data)).Serialize()
+}
+
+// jwtClaims builds the claims of a JWT access token. Client credentials tokens have the
+// client as subject, tokens issued without a resource the default audience.
+func (g *tokenGenerate) jwtClaims(data *oauth2.GenerateBasic) map[string]interface{} {
+	ti := data.TokenInfo
+	issuedAt := ti.GetAccessCreateAt()
+
+	subject := subjectIdentifier(data.Client, ti.GetUserID(), g.config.OAuth2Server.PairwiseSalt)
+	if subject == "" {
+		subject = ti.GetClientID()
+	}
+
+	var audience interface{} = g.config.OAuth2Server.JWTAccessToken.DefaultAudience
+	if resources := tokenResources(ti); len(resources) == 1 {
+		audience = resources[0]
+	} else if len(resources) > 1 {
+		audience = resources
+	}
+
+	claims := map[string]interface{}{
+		"iss":       strings.TrimRight(g.config.OAuth2Server.Issuer, "/"),
+		"sub":       subject,
+		"aud":       audience,
+		"client_id": ti.GetClientID(),
+		"iat":       issuedAt.Unix(),
+		"jti":       uuid.New().String(),
+	}
+	if exp := ti.GetAccessExpiresIn(); exp > 0 {
+		claims["exp"] = issuedAt.Add(exp).Unix()
+	}
+	if scope := ti.GetScope(); scope != "" {
+		claims["scope"] = scope
+	}
+	if details := tokenAuthorizationDetails(ti); details != nil {
+		claims["authorization_details"] = details
+	}
+	if jkt := tokenJKT(ti); jkt != "" {
+		claims["cnf"] = map[string]interface{}{"jkt": jkt}
+	}
+	return claims
+}
+
+// JWKS returns the public keys verifying JWT access tokens
+func (s *Server) JWKS() (*jose.JSONWebKeySet, error) {
+	return s.keys.JWKS()
+}
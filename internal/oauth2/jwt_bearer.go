@@ -0,0 +1,340 @@
+package oauth2
+
+import (
+	"context"
+	database "core-auth/db"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/errors"
+)
+
+// GrantTypeJWTBearer is the JWT bearer assertion grant type (RFC 7523 section 2.1)
+const GrantTypeJWTBearer oauth2.GrantType = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+// Limits on fetching the keys of trusted issuers
+const (
+	maxJWKSSize          = 256 << 10
+	jwksMinRefreshPeriod = time.Minute // shortest interval between fetches for unknown key IDs
+)
+
+// issuerKeySet caches the keys of the trusted issuers
+type issuerKeySet struct {
+	mu      sync.Mutex
+	entries map[string]*issuerKeys
+}
+
+type issuerKeys struct {
+	keys      jose.JSONWebKeySet
+	fetchedAt time.Time     // zero until the keys were fetched once
+	fetching  chan struct{} // closed when the fetch in flight completes
+}
+
+// newIssuerKeySet creates an empty cache of trusted issuer keys
+func newIssuerKeySet() *issuerKeySet {
+	return &issuerKeySet{entries: make(map[string]*issuerKeys)}
+}
+
+// validateJWTBearerRequest verifies the assertion of a JWT bearer grant request against the
+// trusted issuers and resolves the client and scopes from the first matching trust policy.
+// A client_id parameter restricts the policies to those of that client.
+func (s *Server) validateJWTBearerRequest(r *http.Request) (*oauth2.TokenGenerateRequest, error) {
+	if r.Method != http.MethodPost {
+		return nil, errors.ErrInvalidRequest
+	}
+	assertion := r.FormValue("assertion")
+	if assertion == "" {
+		return nil, errors.ErrInvalidRequest
+	}
+
+	claims, issuer, err := s.verifyAssertion(r.Context(), assertion)
+	if err != nil {
+		return nil, err
+	}
+
+	policies, err := s.queries.GetTrustPolicies(issuer.ID)
+	if err != nil {
+		return nil, err
+	}
+	clientID := r.FormValue("client_id")
+	var policy *database.OAuth2TrustPolicy
+	for i := range policies {
+		if clientID != "" && policies[i].ClientID != clientID {
+			continue
+		}
+		if matchesTrustPolicy(&policies[i], claims) {
+			policy = &policies[i]
+			break
+		}
+	}
+	if policy == nil {
+		return nil, errors.ErrInvalidGrant
+	}
+
+	scope, err := trustPolicyScope(policy, r.FormValue("scope"))
+	if err != nil {
+		return nil, err
+	}
+
+	cli, err := s.Manager.GetClient(r.Context(), policy.ClientID)
+	if err != nil {
+		return nil, errors.ErrInvalidGrant
+	}
+	if secret := r.FormValue("client_secret"); secret != "" && !verifyClientSecret(cli, secret) {
+		return nil, errors.ErrInvalidClient
+	}
+
+	return &oauth2.TokenGenerateRequest{
+		ClientID: policy.ClientID,
+		Scope:    scope,
+		Request:  r,
+	}, nil
+}
+
+// assertionClientContextKey carries the client a JWT bearer assertion authenticated
+const assertionClientContextKey contextKey = "assertion_client_id"
+
+// issueJWTBearerToken issues the token of a validated JWT bearer grant request, which the
+// oauth2 server does not know of. Refresh tokens are never issued to this grant.
+func (s *Server) issueJWTBearerToken(ctx context.Context, tgr *oauth2.TokenGenerateRequest) (oauth2.TokenInfo, error) {
+	if !s.CheckGrantType(GrantTypeJWTBearer) {
+		return nil, errors.ErrUnsupportedGrantType
+	}
+	// The assertion authenticates the client, the manager must not ask for its secret
+	ctx = context.WithValue(ctx, assertionClientContextKey, tgr.ClientID)
+	return s.Manager.GenerateAccessToken(ctx, GrantTypeJWTBearer, tgr)
+}
+
+// verifyAssertion checks the signature of an assertion against the keys of its issuer and
+// validates its claims (RFC 7523 section 3). A jti can be used only once.
+func (s *Server) verifyAssertion(ctx context.Context, assertion string) (map[string]interface{}, *database.OAuth2TrustedIssuer, error) {
+	algs := make([]jose.SignatureAlgorithm, 0, len(s.config.OAuth2Server.JWTBearer.SigningAlgs))
+	for _, alg := range s.config.OAuth2Server.JWTBearer.SigningAlgs {
+		algs = append(algs, jose.SignatureAlgorithm(alg))
+	}
+	token, err := jwt.ParseSigned(assertion, algs)
+	if err != nil {
+		return nil, nil, errors.ErrInvalidGrant
+	}
+
+	var unverified jwt.Claims
+	if err := token.UnsafeClaimsWithoutVerification(&unverified); err != nil || unverified.Issuer == "" {
+		return nil, nil, errors.ErrInvalidGrant
+	}
+	issuer, err := s.queries.GetTrustedIssuer(unverified.Issuer)
+	if err != nil {
+		return nil, nil, errors.ErrInvalidGrant
+	}
+
+	keys, err := s.trustedIssuerKeys(ctx, issuer, token.Headers[0].KeyID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var claims map[string]interface{}
+	var standard jwt.Claims
+	verified := false
+	for _, key := range keys {
+		if err := token.Claims(key.Public(), &claims, &standard); err == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, nil, errors.ErrInvalidGrant
+	}
+
+	// sub and exp are required, the assertion may not be valid for longer than the configured lifetime
+	if standard.Subject == "" || standard.Expiry == nil {
+		return nil, nil, errors.ErrInvalidGrant
+	}
+	audience := jwt.Audience{issuer.Audience}
+	if issuer.Audience == "" {
+		base := strings.TrimRight(s.config.OAuth2Server.Issuer, "/")
+		audience = jwt.Audience{base, base + "/", base + metadataEndpoints["token_endpoint"]}
+	}
+	if err := standard.ValidateWithLeeway(jwt.Expected{
+		Issuer:      issuer.Issuer,
+		AnyAudience: audience,
+		Time:        time.Now(),
+	}, 30*time.Second); err != nil {
+		return nil, nil, errors.ErrInvalidGrant
+	}
+	start := time.Now()
+	if standard.IssuedAt != nil {
+		start = standard.IssuedAt.Time()
+	}
+	if standard.Expiry.Time().Sub(start) > time.Duration(s.config.OAuth2Server.JWTBearer.MaxLifetime)*time.Second {
+		return nil, nil, errors.ErrInvalidGrant
+	}
+
+	if standard.ID != "" && s.rdb != nil {
+		ttl := time.Until(standard.Expiry.Time()) + 30*time.Second
+		fresh, err := s.rdb.SetNX(ctx, redisJWTBearerJTIPrefix+issuer.Issuer+":"+standard.ID, 1, ttl).Result()
+		if err != nil {
+			return nil, nil, err
+		} else if !fresh {
+			return nil, nil, errors.ErrInvalidGrant
+		}
+	}
+
+	return claims, issuer, nil
+}
+
+// trustedIssuerKeys returns the keys of a trusted issuer that may have signed a token with the
+// given key ID. Cached keys are reloaded when stale or when the key ID is unknown, so that
+// rotated keys are picked up. The keys are fetched without holding the cache lock, and a single
+// fetch per issuer is in flight while concurrent requests wait for its result.
+func (s *Server) trustedIssuerKeys(ctx context.Context, issuer *database.OAuth2TrustedIssuer, kid string) ([]jose.JSONWebKey, error) {
+	ttl := time.Duration(s.config.OAuth2Server.JWTBearer.JWKSCacheTTL) * time.Second
+
+	s.issuerKeys.mu.Lock()
+	entry, ok := s.issuerKeys.entries[issuer.Issuer]
+	if !ok {
+		entry = &issuerKeys{}
+		s.issuerKeys.entries[issuer.Issuer] = entry
+	}
+	loaded := !entry.fetchedAt.IsZero()
+	stale := !loaded || time.Since(entry.fetchedAt) > ttl
+	unknown := loaded && kid != "" && len(entry.keys.Key(kid)) == 0 && time.Since(entry.fetchedAt) > jwksMinRefreshPeriod
+	if stale || unknown {
+		fetching, leader := entry.fetching, entry.fetching == nil
+		if leader {
+			fetching = make(chan struct{})
+			entry.fetching = fetching
+		}
+		s.issuerKeys.mu.Unlock()
+
+		if leader {
+			// Waiting requests share the fetch, it is not cancelled with the request starting it
+			keys, err := s.loadIssuerJWKS(context.WithoutCancel(ctx), issuer)
+			s.issuerKeys.mu.Lock()
+			if err != nil {
+				log.Printf("Failed to fetch the keys of trusted issuer %s: %v", issuer.Issuer, err)
+				if loaded {
+					// Keep using the cached keys while the issuer is unreachable
+					entry.fetchedAt = time.Now()
+				}
+			} else {
+				entry.keys, entry.fetchedAt = *keys, time.Now()
+			}
+			entry.fetching = nil
+			close(fetching)
+		} else {
+			select {
+			case <-fetching:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			s.issuerKeys.mu.Lock()
+		}
+	}
+	defer s.issuerKeys.mu.Unlock()
+
+	if entry.fetchedAt.IsZero() {
+		return nil, errors.ErrInvalidGrant
+	}
+	if kid != "" {
+		return entry.keys.Key(kid), nil
+	}
+	return entry.keys.Keys, nil
+}
+
+// loadIssuerJWKS reads the keys of a trusted issuer from its static file or its JWKS URL
+func (s *Server) loadIssuerJWKS(ctx context.Context, issuer *database.OAuth2TrustedIssuer) (*jose.JSONWebKeySet, error) {
+	var data []byte
+	switch {
+	case issuer.JWKSFile != "":
+		var err error
+		if data, err = os.ReadFile(issuer.JWKSFile); err != nil {
+			return nil, err
+		}
+	case issuer.JWKSURI != "":
+		if u, err := url.Parse(issuer.JWKSURI); err != nil || u.Scheme != "https" {
+			return nil, fmt.Errorf("jwks_uri of trusted issuer %s must use https", issuer.Issuer)
+		}
+		client := &http.Client{Timeout: time.Duration(s.config.OAuth2Server.JWTBearer.JWKSTimeout) * time.Second}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer.JWKSURI, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/json")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("jwks_uri responded with status %d", resp.StatusCode)
+		}
+		if data, err = io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("trusted issuer %s has no keys", issuer.Issuer)
+	}
+
+	var jwks jose.JSONWebKeySet
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, err
+	}
+	return &jwks, nil
+}
+
+// matchesTrustPolicy reports whether every claim condition of a policy is met. A condition
+// is met when the claim, or one of its values for array claims, is an accepted value.
+// The token's subject is the policy's client, which the policy maps the assertion's subject
+// to: a policy must list the accepted sub values, policies without a sub condition match nothing.
+func matchesTrustPolicy(policy *database.OAuth2TrustPolicy, claims map[string]interface{}) bool {
+	var conditions map[string][]string
+	if err := json.Unmarshal([]byte(policy.Claims), &conditions); err != nil || len(conditions["sub"]) == 0 {
+		return false
+	}
+
+	for claim, accepted := range conditions {
+		values, err := claimValues(claims[claim])
+		if claims[claim] == nil || err != nil {
+			return false
+		}
+		matched := false
+		for _, value := range values {
+			if contains(accepted, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// trustPolicyScope returns the scope granted by a policy, all its scopes when none is requested
+func trustPolicyScope(policy *database.OAuth2TrustPolicy, requested string) (string, error) {
+	var scopes []string
+	if err := json.Unmarshal([]byte(policy.Scopes), &scopes); err != nil {
+		return "", errors.ErrInvalidScope
+	}
+	if requested == "" {
+		return strings.Join(scopes, " "), nil
+	}
+	for _, scope := range strings.Fields(requested) {
+		if !contains(scopes, scope) {
+			return "", errors.ErrInvalidScope
+		}
+	}
+	return requested, nil
+}
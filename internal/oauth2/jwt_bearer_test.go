@@ -0,0 +1,210 @@
+package oauth2
+
+import (
+	"context"
+	database "core-auth/db"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4/jwt"
+	oerrors "github.com/go-oauth2/oauth2/v4/errors"
+)
+
+// useTLSTransport makes the JWKS fetches trust the certificate of a test server
+func useTLSTransport(t *testing.T, srv *httptest.Server) {
+	t.Helper()
+	transport := http.DefaultTransport
+	http.DefaultTransport = srv.Client().Transport
+	t.Cleanup(func() { http.DefaultTransport = transport })
+}
+
+func TestIssuerJWKSRequiresHTTPS(t *testing.T) {
+	s, _ := newTestServer(t)
+	issuer := &database.OAuth2TrustedIssuer{Issuer: "https://ci.example.com", JWKSURI: "http://ci.example.com/jwks"}
+	if _, err := s.loadIssuerJWKS(context.Background(), issuer); err == nil {
+		t.Fatal("fetched keys over http")
+	}
+}
+
+func TestIssuerJWKSFetchedOncePerIssuer(t *testing.T) {
+	s, _ := newTestServer(t)
+	_, jwks := requestObjectSigner(t)
+
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		w.Write([]byte(jwks))
+	}))
+	defer srv.Close()
+	useTLSTransport(t, srv)
+	issuer := &database.OAuth2TrustedIssuer{Issuer: "https://ci.example.com", JWKSURI: srv.URL}
+
+	var wg sync.WaitGroup
+	results := make(chan int, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			keys, err := s.trustedIssuerKeys(context.Background(), issuer, "")
+			if err != nil {
+				t.Error(err)
+			}
+			results <- len(keys)
+		}()
+	}
+
+	// The cache stays usable for other issuers while the fetch is in flight
+	dir := t.TempDir()
+	file := filepath.Join(dir, "jwks.json")
+	if err := os.WriteFile(file, []byte(jwks), 0600); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.trustedIssuerKeys(context.Background(), &database.OAuth2TrustedIssuer{Issuer: "https://k8s.example.com", JWKSFile: file}, "")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("another issuer waited for the fetch in flight")
+	}
+
+	close(release)
+	wg.Wait()
+	close(results)
+	for n := range results {
+		if n != 1 {
+			t.Errorf("keys = %d, want 1", n)
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("fetches = %d, want 1", n)
+	}
+}
+
+func TestIssuerJWKSFailureKeepsCachedKeys(t *testing.T) {
+	s, _ := newTestServer(t)
+	_, jwks := requestObjectSigner(t)
+
+	var fail atomic.Bool
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(jwks))
+	}))
+	defer srv.Close()
+	useTLSTransport(t, srv)
+	issuer := &database.OAuth2TrustedIssuer{Issuer: "https://ci.example.com", JWKSURI: srv.URL}
+
+	if _, err := s.trustedIssuerKeys(context.Background(), issuer, ""); err != nil {
+		t.Fatal(err)
+	}
+	fail.Store(true)
+	s.issuerKeys.entries[issuer.Issuer].fetchedAt = time.Now().Add(-time.Hour)
+	keys, err := s.trustedIssuerKeys(context.Background(), issuer, "")
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("keys = %d, %v, want the cached key", len(keys), err)
+	}
+
+	unreachable := &database.OAuth2TrustedIssuer{Issuer: "https://down.example.com", JWKSURI: srv.URL}
+	if _, err := s.trustedIssuerKeys(context.Background(), unreachable, ""); !errors.Is(err, oerrors.ErrInvalidGrant) {
+		t.Fatalf("error = %v, want %v", err, oerrors.ErrInvalidGrant)
+	}
+}
+
+func TestTrustPolicyRequiresSubject(t *testing.T) {
+	claims := map[string]interface{}{"sub": "system:serviceaccount:batch:report", "aud": "core-auth"}
+	tests := []struct {
+		name       string
+		conditions map[string][]string
+		want       bool
+	}{
+		{"subject accepted", map[string][]string{"sub": {"system:serviceaccount:batch:report"}, "aud": {"core-auth"}}, true},
+		{"subject not accepted", map[string][]string{"sub": {"system:serviceaccount:batch:other"}}, false},
+		{"no subject condition", map[string][]string{"aud": {"core-auth"}}, false},
+		{"no conditions", map[string][]string{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := json.Marshal(tt.conditions)
+			if got := matchesTrustPolicy(&database.OAuth2TrustPolicy{Claims: string(data)}, claims); got != tt.want {
+				t.Errorf("matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	s, _ := newTestServer(t)
+	signer, jwks := requestObjectSigner(t)
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(file, []byte(jwks), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.db.Create(&database.OAuth2TrustedIssuer{Issuer: "https://k8s.example.com", JWKSFile: file, IsActive: true}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	assertion := func(subject string, jti string) string {
+		now := time.Now()
+		base := strings.TrimRight(s.config.OAuth2Server.Issuer, "/")
+		token, err := jwt.Signed(signer).Claims(jwt.Claims{
+			Issuer:   "https://k8s.example.com",
+			Subject:  subject,
+			Audience: jwt.Audience{base},
+			Expiry:   jwt.NewNumericDate(now.Add(5 * time.Minute)),
+			IssuedAt: jwt.NewNumericDate(now),
+			ID:       jti,
+		}).Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	ctx := context.Background()
+	claims, _, err := s.verifyAssertion(ctx, assertion("system:serviceaccount:batch:report", "jti-1"))
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if claims["sub"] != "system:serviceaccount:batch:report" {
+		t.Errorf("sub = %v", claims["sub"])
+	}
+	if _, _, err := s.verifyAssertion(ctx, assertion("system:serviceaccount:batch:report", "jti-1")); !errors.Is(err, oerrors.ErrInvalidGrant) {
+		t.Errorf("replayed jti error = %v, want %v", err, oerrors.ErrInvalidGrant)
+	}
+	if _, _, err := s.verifyAssertion(ctx, assertion("", "jti-2")); !errors.Is(err, oerrors.ErrInvalidGrant) {
+		t.Errorf("missing sub error = %v, want %v", err, oerrors.ErrInvalidGrant)
+	}
+
+	other, _ := requestObjectSigner(t)
+	forged, err := jwt.Signed(other).Claims(jwt.Claims{
+		Issuer:   "https://k8s.example.com",
+		Subject:  "system:serviceaccount:batch:report",
+		Audience: jwt.Audience{strings.TrimRight(s.config.OAuth2Server.Issuer, "/")},
+		Expiry:   jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.verifyAssertion(ctx, forged); !errors.Is(err, oerrors.ErrInvalidGrant) {
+		t.Errorf("forged assertion error = %v, want %v", err, oerrors.ErrInvalidGrant)
+	}
+}
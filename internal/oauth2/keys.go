@@ -0,0 +1,289 @@
+package oauth2
+
+import (
+	"context"
+	"core-auth/config"
+	database "core-auth/db"
+	"core-auth/internal/maintenance"
+	"core-auth/internal/utils"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// keySetReloadInterval is how long the signing keys are cached before being reloaded from the database
+const keySetReloadInterval = time.Minute
+
+// keyRotationLeaseTTL bounds how long an instance may take to generate a signing key
+const keyRotationLeaseTTL = time.Minute
+
+// keyWaitInterval is how often an instance without any signing key checks whether the
+// instance holding the rotation lease stored the first one
+const keyWaitInterval = 200 * time.Millisecond
+
+// signingKey is a decrypted signing key
+type signingKey struct {
+	kid      string
+	alg      jose.SignatureAlgorithm
+	key      crypto.Signer
+	activeAt time.Time
+}
+
+// KeySet holds the rotating keys signing JWT access tokens. Keys are shared by every
+// instance through the database, a new key is generated once the active key is older than
+// the rotation interval and rotated keys stay published until the tokens they signed expired.
+type KeySet struct {
+	rdb      *redis.Client
+	queries  *database.OAuth2Queries
+	config   *config.Config
+	instance string
+
+	mu       sync.RWMutex
+	keys     []signingKey // newest first
+	loadedAt time.Time
+}
+
+// NewKeySet creates the signing key set
+func NewKeySet(rdb *redis.Client, queries *database.OAuth2Queries, config *config.Config) *KeySet {
+	hostname, _ := os.Hostname()
+	return &KeySet{
+		rdb:      rdb,
+		queries:  queries,
+		config:   config,
+		instance: fmt.Sprintf("%s-%s", hostname, uuid.New().String()[:8]),
+	}
+}
+
+// SigningKey returns the key signing new tokens, rotating it when it is due
+func (k *KeySet) SigningKey(ctx context.Context) (*signingKey, error) {
+	keys, err := k.current()
+	if err != nil {
+		return nil, err
+	}
+
+	interval := time.Duration(k.config.OAuth2Server.JWTAccessToken.KeyRotationInterval) * time.Hour
+	if len(keys) == 0 || time.Since(keys[0].activeAt) >= interval {
+		if err := k.rotate(ctx, len(keys) > 0); err != nil {
+			return nil, err
+		}
+		if keys, err = k.current(); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	for i := range keys {
+		if !keys[i].activeAt.After(now) {
+			return &keys[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no signing key available")
+}
+
+// JWKS returns the public keys of every published signing key
+func (k *KeySet) JWKS() (*jose.JSONWebKeySet, error) {
+	keys, err := k.current()
+	if err != nil {
+		return nil, err
+	}
+
+	jwks := &jose.JSONWebKeySet{Keys: make([]jose.JSONWebKey, 0, len(keys))}
+	for _, key := range keys {
+		jwks.Keys = append(jwks.Keys, jose.JSONWebKey{
+			Key:       key.key.Public(),
+			KeyID:     key.kid,
+			Algorithm: string(key.alg),
+			Use:       "sig",
+		})
+	}
+	return jwks, nil
+}
+
+// current returns the cached keys, reloading them when stale
+func (k *KeySet) current() ([]signingKey, error) {
+	k.mu.RLock()
+	keys, loadedAt := k.keys, k.loadedAt
+	k.mu.RUnlock()
+	if time.Since(loadedAt) < keySetReloadInterval {
+		return keys, nil
+	}
+	return k.load()
+}
+
+// load reads and decrypts the unexpired keys from the database, including keys
+// published ahead of their activation
+func (k *KeySet) load() ([]signingKey, error) {
+	now := time.Now()
+	rows, err := k.queries.GetSigningKeys(now)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]signingKey, 0, len(rows))
+	for _, row := range rows {
+		der, err := utils.Decrypt(k.config.OAuth2Server.JWTAccessToken.KeyEncryptionSecret, row.PrivateKey)
+		if err != nil {
+			log.Printf("Warning: failed to decrypt signing key %s: %v", row.KID, err)
+			continue
+		}
+		privateKey, err := x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			log.Printf("Warning: failed to parse signing key %s: %v", row.KID, err)
+			continue
+		}
+		signer, ok := privateKey.(crypto.Signer)
+		if !ok {
+			continue
+		}
+		keys = append(keys, signingKey{
+			kid:      row.KID,
+			alg:      jose.SignatureAlgorithm(row.Algorithm),
+			key:      signer,
+			activeAt: row.ActiveAt,
+		})
+	}
+
+	k.mu.Lock()
+	k.keys, k.loadedAt = keys, now
+	k.mu.Unlock()
+	return keys, nil
+}
+
+// rotate generates a new signing key. When Redis is available a lease ensures that a
+// single instance rotates. A key replacing a usable one is activated after the reload
+// interval, so that every instance publishes it before tokens are signed with it.
+func (k *KeySet) rotate(ctx context.Context, published bool) error {
+	if k.rdb != nil {
+		lease := maintenance.NewLease(k.rdb, "signing_key_rotation", k.instance, keyRotationLeaseTTL)
+		acquired, err := lease.Acquire(ctx)
+		if err != nil {
+			return err
+		}
+		if !acquired && !published {
+			// Another instance is generating the first key, there is nothing to sign with meanwhile
+			if acquired, err = k.awaitLease(ctx, lease); err != nil || !acquired {
+				return err
+			}
+		}
+		if !acquired {
+			// Another instance is rotating, keep signing with the previous key meanwhile
+			k.mu.Lock()
+			k.loadedAt = time.Time{}
+			k.mu.Unlock()
+			return nil
+		}
+		defer lease.Release(ctx)
+
+		// The key may have been rotated while waiting for the lease
+		keys, err := k.load()
+		if err != nil {
+			return err
+		}
+		interval := time.Duration(k.config.OAuth2Server.JWTAccessToken.KeyRotationInterval) * time.Hour
+		if len(keys) > 0 && time.Since(keys[0].activeAt) < interval {
+			return nil
+		}
+	}
+
+	key, err := k.generate()
+	if err != nil {
+		return err
+	}
+	if published {
+		key.ActiveAt = key.ActiveAt.Add(keySetReloadInterval)
+	}
+	if err := k.queries.CreateSigningKey(key); err != nil {
+		return err
+	}
+	if err := k.queries.DeleteExpiredSigningKeys(time.Now()); err != nil {
+		log.Printf("Warning: failed to delete expired signing keys: %v", err)
+	}
+	log.Printf("Rotated JWT access token signing key, new key %s", key.KID)
+
+	_, err = k.load()
+	return err
+}
+
+// awaitLease waits while another instance generates the first signing key. It returns false
+// once that key is stored, and true when the lease was acquired because the other instance
+// released it or expired without storing a key.
+func (k *KeySet) awaitLease(ctx context.Context, lease *maintenance.Lease) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*keyRotationLeaseTTL)
+	defer cancel()
+	ticker := time.NewTicker(keyWaitInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false, fmt.Errorf("waiting for a signing key: %w", ctx.Err())
+		case <-ticker.C:
+		}
+		keys, err := k.load()
+		if err != nil {
+			return false, err
+		}
+		if len(keys) > 0 {
+			return false, nil
+		}
+		if acquired, err := lease.Acquire(ctx); err != nil || acquired {
+			return acquired, err
+		}
+	}
+}
+
+// generate creates a new encrypted signing key for the configured algorithm
+func (k *KeySet) generate() (*database.OAuth2SigningKey, error) {
+	cfg := k.config.OAuth2Server.JWTAccessToken
+
+	var privateKey crypto.Signer
+	var err error
+	switch jose.SignatureAlgorithm(cfg.SigningAlg) {
+	case jose.RS256:
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	case jose.ES256:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported JWT signing algorithm %q", cfg.SigningAlg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := utils.Encrypt(cfg.KeyEncryptionSecret, der)
+	if err != nil {
+		return nil, err
+	}
+
+	jwk := jose.JSONWebKey{Key: privateKey.Public()}
+	thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	lifetime := time.Duration(cfg.KeyRotationInterval+cfg.KeyRetention) * time.Hour
+	return &database.OAuth2SigningKey{
+		KID:        base64.RawURLEncoding.EncodeToString(thumbprint),
+		Algorithm:  cfg.SigningAlg,
+		PrivateKey: encrypted,
+		ActiveAt:   now,
+		ExpiresAt:  now.Add(lifetime),
+	}, nil
+}
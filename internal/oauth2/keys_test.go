@@ -0,0 +1,133 @@
+package oauth2
+
+import (
+	"context"
+	"core-auth/config"
+	database "core-auth/db"
+	"core-auth/internal/maintenance"
+	"core-auth/internal/testutil"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// newTestKeySets returns two instances sharing the signing keys of one database
+func newTestKeySets(t *testing.T) (*KeySet, *KeySet, *redis.Client) {
+	t.Helper()
+	testutil.SetSecrets(t)
+	cfg, err := config.LoadFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.OAuth2Server.JWTAccessToken.SigningAlg = "ES256"
+	rdb, _ := testutil.NewRedis(t)
+	queries := database.NewOAuth2Queries(testutil.NewDB(t))
+	return NewKeySet(rdb, queries, cfg), NewKeySet(rdb, queries, cfg), rdb
+}
+
+func TestSigningKeyWaitsForFirstKey(t *testing.T) {
+	a, b, rdb := newTestKeySets(t)
+	ctx := context.Background()
+
+	// a holds the rotation lease while generating the first key
+	lease := maintenance.NewLease(rdb, "signing_key_rotation", a.instance, keyRotationLeaseTTL)
+	if acquired, err := lease.Acquire(ctx); err != nil || !acquired {
+		t.Fatalf("acquire lease: %v", err)
+	}
+	stored := make(chan string, 1)
+	go func() {
+		time.Sleep(3 * keyWaitInterval)
+		key, err := a.generate()
+		if err == nil {
+			err = a.queries.CreateSigningKey(key)
+		}
+		if err != nil {
+			t.Error(err)
+			stored <- ""
+			return
+		}
+		stored <- key.KID
+	}()
+
+	key, err := b.SigningKey(ctx)
+	if err != nil {
+		t.Fatalf("signing key: %v", err)
+	}
+	if kid := <-stored; key.kid != kid {
+		t.Errorf("signing key = %s, want the key stored by the lease holder %s", key.kid, kid)
+	}
+}
+
+func TestSigningKeyGeneratedWhenHolderGivesUp(t *testing.T) {
+	a, b, rdb := newTestKeySets(t)
+	ctx := context.Background()
+
+	lease := maintenance.NewLease(rdb, "signing_key_rotation", a.instance, keyRotationLeaseTTL)
+	if acquired, err := lease.Acquire(ctx); err != nil || !acquired {
+		t.Fatalf("acquire lease: %v", err)
+	}
+	go func() {
+		time.Sleep(3 * keyWaitInterval)
+		lease.Release(ctx)
+	}()
+
+	if _, err := b.SigningKey(ctx); err != nil {
+		t.Fatalf("signing key: %v", err)
+	}
+	keys, err := b.queries.GetSigningKeys(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 {
+		t.Errorf("stored keys = %d, want 1", len(keys))
+	}
+}
+
+func TestSigningKeyDecryptsWithDedicatedSecret(t *testing.T) {
+	a, b, _ := newTestKeySets(t)
+	ctx := context.Background()
+
+	key, err := a.SigningKey(ctx)
+	if err != nil {
+		t.Fatalf("signing key: %v", err)
+	}
+	other, err := b.SigningKey(ctx)
+	if err != nil {
+		t.Fatalf("signing key of the second instance: %v", err)
+	}
+	if key.kid != other.kid {
+		t.Errorf("instances sign with %s and %s, want one shared key", key.kid, other.kid)
+	}
+
+	b.config.OAuth2Server.JWTAccessToken.KeyEncryptionSecret = b.config.JWT.Secret
+	if keys, err := b.load(); err != nil || len(keys) != 0 {
+		t.Errorf("keys decrypted with the JWT secret: %d, %v", len(keys), err)
+	}
+}
+
+func TestJWTAccessTokensCannotOutliveSigningKey(t *testing.T) {
+	t.Setenv("OAUTH2_JWT_KEY_RETENTION", "24")
+	s, _ := newTestServer(t)
+	ctx := context.Background()
+
+	client := &database.OAuth2Client{Name: "api", IsActive: true, AccessTokenFormat: AccessTokenFormatJWT, AccessTokenLifetime: 25 * 3600}
+	if _, err := s.CreateClient(ctx, client, true); !errors.Is(err, ErrAccessTokenOutlivesKey) {
+		t.Fatalf("create error = %v, want %v", err, ErrAccessTokenOutlivesKey)
+	}
+
+	// Opaque tokens do not depend on the signing keys
+	client.AccessTokenFormat = AccessTokenFormatOpaque
+	if _, err := s.CreateClient(ctx, client, true); err != nil {
+		t.Fatalf("opaque client: %v", err)
+	}
+	client.AccessTokenFormat = AccessTokenFormatJWT
+	if err := s.UpdateClient(ctx, client); !errors.Is(err, ErrAccessTokenOutlivesKey) {
+		t.Fatalf("update error = %v, want %v", err, ErrAccessTokenOutlivesKey)
+	}
+	client.AccessTokenLifetime = 24 * 3600
+	if err := s.UpdateClient(ctx, client); err != nil {
+		t.Fatalf("lifetime within the retention: %v", err)
+	}
+}
@@ -0,0 +1,385 @@
+package oauth2
+
+import (
+	"context"
+	database "core-auth/db"
+	"core-auth/internal/utils"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/errors"
+	"github.com/google/uuid"
+)
+
+const (
+	// logoutTokenType is the typ header of logout tokens (OpenID Connect Back-Channel Logout section 2.4)
+	logoutTokenType = "logout+jwt"
+	// backchannelLogoutEvent is the event identifying logout tokens
+	backchannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+	// logoutTokenLifetime is how long a logout token is accepted by the client
+	logoutTokenLifetime = 2 * time.Minute
+)
+
+// sessionContextKey carries the login session a consent decision was made in
+const sessionContextKey contextKey = "session_id"
+
+// extensionSessionID is the token extension holding the login session of the authorization
+const extensionSessionID = "sid"
+
+// logoutRequest is a validated RP-initiated logout waiting for the user's confirmation
+type logoutRequest struct {
+	ClientID              string
+	PostLogoutRedirectURI string
+	State                 string
+	ExpiresAt             time.Time
+}
+
+// backchannelLogout is a logout token delivery to a client
+type backchannelLogout struct {
+	clientID  string
+	logoutURI string
+	subject   string
+	sessionID string
+	attempt   int
+}
+
+// LogoutResult tells the user agent where to go after a logout and which front-channel
+// logout URIs to render in iframes
+type LogoutResult struct {
+	RedirectTo             string   `json:"redirect_to,omitempty"`
+	FrontchannelLogoutURIs []string `json:"frontchannel_logout_uris"`
+}
+
+// HandleEndSessionRequest validates an RP-initiated logout request, parks it and sends the
+// user to the logout page. No ID tokens are issued, so the client is identified by client_id
+// and cannot be authenticated: the logout page must ask the user to confirm the logout.
+func (s *Server) HandleEndSessionRequest(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return errors.ErrInvalidRequest
+	}
+	if s.rdb == nil {
+		return errors.ErrTemporarilyUnavailable
+	}
+
+	req := &logoutRequest{
+		ClientID:              r.Form.Get("client_id"),
+		PostLogoutRedirectURI: r.Form.Get("post_logout_redirect_uri"),
+		State:                 r.Form.Get("state"),
+		ExpiresAt:             time.Now().Add(time.Duration(s.config.OAuth2Server.LogoutLifetime) * time.Second),
+	}
+	if req.ClientID == "" {
+		if req.PostLogoutRedirectURI != "" {
+			return errors.ErrInvalidRequest
+		}
+	} else {
+		client, err := s.queries.GetClient(req.ClientID)
+		if err != nil {
+			return errors.ErrInvalidClient
+		}
+		if req.PostLogoutRedirectURI != "" && validateRedirectURI(client.PostLogoutRedirectURIs, req.PostLogoutRedirectURI) != nil {
+			return ErrInvalidPostLogoutRedirectURI
+		}
+	}
+
+	challenge, err := utils.GenerateRandomString(32)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	if err := s.rdb.SetEX(r.Context(), redisLogoutPrefix+challenge, data, time.Until(req.ExpiresAt)).Err(); err != nil {
+		return err
+	}
+
+	u, err := url.Parse(s.config.OAuth2Server.LogoutURL)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	q.Set("logout_challenge", challenge)
+	u.RawQuery = q.Encode()
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Location", u.String())
+	w.WriteHeader(http.StatusFound)
+	return nil
+}
+
+// LogoutRequest returns the details of a parked RP-initiated logout for the logout page to
+// show when asking the user to confirm it
+func (s *Server) LogoutRequest(ctx context.Context, challenge string) (map[string]interface{}, error) {
+	req, err := s.loadLogout(ctx, challenge, false)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"logout_challenge": challenge,
+		"expires_at":       req.ExpiresAt,
+	}
+	if req.ClientID != "" {
+		client, err := s.queries.GetClient(req.ClientID)
+		if err != nil {
+			return nil, errors.ErrInvalidClient
+		}
+		data["client_id"] = client.ClientID
+		data["client_name"] = client.Name
+		data["post_logout_redirect_uri"] = req.PostLogoutRedirectURI
+	}
+	return data, nil
+}
+
+// RejectLogout discards a parked RP-initiated logout the user declined, the user stays
+// logged in and is not redirected to the client
+func (s *Server) RejectLogout(ctx context.Context, challenge string) error {
+	_, err := s.loadLogout(ctx, challenge, true)
+	return err
+}
+
+// Logout ends the OAuth2 side of a login session: the tokens issued in the session are
+// revoked and the clients the user authorized in it are notified, through back-channel
+// logout tokens sent in the background and front-channel logout URIs returned for the
+// user agent. A logout challenge completes an RP-initiated logout the user confirmed.
+func (s *Server) Logout(ctx context.Context, userID string, sessionID string, challenge string) (*LogoutResult, error) {
+	result := &LogoutResult{FrontchannelLogoutURIs: []string{}}
+	if challenge != "" {
+		req, err := s.loadLogout(ctx, challenge, true)
+		if err != nil {
+			return nil, err
+		}
+		if req.PostLogoutRedirectURI != "" {
+			u, err := url.Parse(req.PostLogoutRedirectURI)
+			if err != nil {
+				return nil, ErrInvalidPostLogoutRedirectURI
+			}
+			if req.State != "" {
+				q := u.Query()
+				q.Set("state", req.State)
+				u.RawQuery = q.Encode()
+			}
+			result.RedirectTo = u.String()
+		}
+	}
+
+	if sessionID == "" {
+		return result, nil
+	}
+
+	clientIDs, err := s.queries.GetSessionClientIDs(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := s.queries.RevokeSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	evictTokens(ctx, s.rdb, tokens)
+
+	issuer := strings.TrimRight(s.config.OAuth2Server.Issuer, "/")
+	for _, clientID := range clientIDs {
+		client, err := s.queries.GetClient(clientID)
+		if err != nil {
+			continue
+		}
+		if client.BackchannelLogoutURI != "" {
+			s.queueBackchannelLogout(backchannelLogout{
+				clientID:  client.ClientID,
+				logoutURI: client.BackchannelLogoutURI,
+				subject:   s.subject(ctx, client.ClientID, userID),
+				sessionID: sessionID,
+			})
+		}
+		if client.FrontchannelLogoutURI != "" {
+			u, err := url.Parse(client.FrontchannelLogoutURI)
+			if err != nil {
+				continue
+			}
+			q := u.Query()
+			q.Set("iss", issuer)
+			q.Set("sid", sessionID)
+			u.RawQuery = q.Encode()
+			result.FrontchannelLogoutURIs = append(result.FrontchannelLogoutURIs, u.String())
+		}
+	}
+	return result, nil
+}
+
+// RevokeUserSessions ends every login session of a user except keepSessionID, notifying the
+// clients of the ended sessions, and revokes the user's tokens not issued in the kept session.
+// Used when the password changes, an empty keepSessionID ends all sessions.
+func (s *Server) RevokeUserSessions(ctx context.Context, userID uint, keepSessionID string) error {
+	sessions, err := database.GetActiveUserSessions(s.db, userID)
+	if err != nil {
+		return err
+	}
+	subject := strconv.FormatUint(uint64(userID), 10)
+	for _, session := range sessions {
+		if session.SessionID == keepSessionID {
+			continue
+		}
+		if _, err := s.Logout(ctx, subject, session.SessionID, ""); err != nil {
+			return err
+		}
+	}
+	if err := database.RevokeUserSessions(s.db, userID, keepSessionID); err != nil {
+		return err
+	}
+
+	tokens, err := s.queries.RevokeUserTokens(userID, keepSessionID)
+	if err != nil {
+		return err
+	}
+	evictTokens(ctx, s.rdb, tokens)
+	return nil
+}
+
+// startBackchannelLogout starts the workers delivering back-channel logout tokens. At most
+// QueueSize deliveries are pending at once, counting those waiting for a retry.
+func (s *Server) startBackchannelLogout() {
+	cfg := s.config.OAuth2Server.BackchannelLogout
+	s.logouts = make(chan backchannelLogout, cfg.QueueSize)
+	s.logoutSlots = make(chan struct{}, cfg.QueueSize)
+	s.logoutClient = &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second}
+	for i := 0; i < cfg.Workers; i++ {
+		go func() {
+			for logout := range s.logouts {
+				s.sendBackchannelLogout(logout)
+			}
+		}()
+	}
+}
+
+// queueBackchannelLogout hands a delivery to the workers, dropping it when the queue is full
+func (s *Server) queueBackchannelLogout(logout backchannelLogout) {
+	select {
+	case s.logoutSlots <- struct{}{}:
+		// A slot is held per pending delivery, so the queue has room
+		s.logouts <- logout
+	default:
+		log.Printf("Back-channel logout queue full, dropped session %s for client %s", logout.sessionID, logout.clientID)
+	}
+}
+
+// sendBackchannelLogout posts a logout token to a client. A failed delivery is retried with an
+// exponential backoff until the client acknowledges it or the attempts are exhausted.
+func (s *Server) sendBackchannelLogout(logout backchannelLogout) {
+	cfg := s.config.OAuth2Server.BackchannelLogout
+	logout.attempt++
+	err := s.postLogoutToken(logout)
+	if err != nil && logout.attempt < cfg.MaxAttempts {
+		// The slot stays held while the retry waits
+		backoff := time.Duration(cfg.RetryInterval) * time.Second << (logout.attempt - 1)
+		time.AfterFunc(backoff, func() { s.logouts <- logout })
+		return
+	}
+	<-s.logoutSlots
+	if err != nil {
+		log.Printf("Back-channel logout of session %s failed for client %s: %v", logout.sessionID, logout.clientID, err)
+	}
+}
+
+// postLogoutToken makes one back-channel logout delivery attempt
+func (s *Server) postLogoutToken(logout backchannelLogout) error {
+	// A fresh token for every attempt, the client may have seen the jti of a timed out request
+	token, err := s.logoutToken(context.Background(), logout.clientID, logout.subject, logout.sessionID)
+	if err != nil {
+		return err
+	}
+	resp, err := s.logoutClient.PostForm(logout.logoutURI, url.Values{"logout_token": {token}})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// logoutToken signs a logout token for a client (OpenID Connect Back-Channel Logout section 2.4)
+func (s *Server) logoutToken(ctx context.Context, clientID string, subject string, sessionID string) (string, error) {
+	key, err := s.keys.SigningKey(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: key.alg, Key: jose.JSONWebKey{Key: key.key, KeyID: key.kid}},
+		(&jose.SignerOptions{}).WithType(logoutTokenType),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":    strings.TrimRight(s.config.OAuth2Server.Issuer, "/"),
+		"aud":    clientID,
+		"iat":    now.Unix(),
+		"exp":    now.Add(logoutTokenLifetime).Unix(),
+		"jti":    uuid.New().String(),
+		"sub":    subject,
+		"sid":    sessionID,
+		"events": map[string]interface{}{backchannelLogoutEvent: map[string]interface{}{}},
+	}
+	return jwt.Signed(signer).Claims(claims).Serialize()
+}
+
+// loadLogout reads a parked logout request, consuming it if requested
+func (s *Server) loadLogout(ctx context.Context, challenge string, consume bool) (*logoutRequest, error) {
+	if s.rdb == nil || challenge == "" {
+		return nil, ErrInvalidLogoutRequest
+	}
+	key := redisLogoutPrefix + challenge
+	cmd := s.rdb.Get(ctx, key)
+	if consume {
+		cmd = s.rdb.GetDel(ctx, key)
+	}
+	data, err := cmd.Bytes()
+	if err != nil {
+		return nil, ErrInvalidLogoutRequest
+	}
+
+	var req logoutRequest
+	if err := json.Unmarshal(data, &req); err != nil || time.Now().After(req.ExpiresAt) {
+		return nil, ErrInvalidLogoutRequest
+	}
+	return &req, nil
+}
+
+// sessionExtensionHandler records the login session the user approved the authorization in,
+// so that the tokens issued from it are revoked when the session logs out
+func sessionExtensionHandler(tgr *oauth2.TokenGenerateRequest, ti oauth2.ExtendableTokenInfo) {
+	if tgr.Request == nil {
+		return
+	}
+	sessionID, _ := tgr.Request.Context().Value(sessionContextKey).(string)
+	if sessionID == "" {
+		return
+	}
+	ext := ti.GetExtension()
+	if ext == nil {
+		ext = url.Values{}
+	}
+	ext.Set(extensionSessionID, sessionID)
+	ti.SetExtension(ext)
+}
+
+// tokenSessionID returns the login session a code or token was issued in, if any
+func tokenSessionID(ti oauth2.TokenInfo) string {
+	if eti, ok := ti.(oauth2.ExtendableTokenInfo); ok && eti.GetExtension() != nil {
+		return eti.GetExtension().Get(extensionSessionID)
+	}
+	return ""
+}
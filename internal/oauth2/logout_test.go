@@ -0,0 +1,159 @@
+package oauth2
+
+import (
+	"context"
+	database "core-auth/db"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testPostLogoutRedirectURI = "https://app.example.com/signed-out"
+
+// newLogoutServer returns a server with a client allowed to redirect to testPostLogoutRedirectURI
+func newLogoutServer(t *testing.T) *Server {
+	t.Helper()
+	s, _ := newTestServer(t)
+	if err := s.queries.CreateClient(&database.OAuth2Client{
+		ClientID:               testClientID,
+		IsActive:               true,
+		PostLogoutRedirectURIs: `["` + testPostLogoutRedirectURI + `"]`,
+	}); err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	return s
+}
+
+// endSession starts an RP-initiated logout and returns its logout challenge
+func endSession(t *testing.T, s *Server, params url.Values) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	if err := s.HandleEndSessionRequest(w, httptest.NewRequest(http.MethodGet, "/oauth2/logout?"+params.Encode(), nil)); err != nil {
+		t.Fatalf("end session: %v", err)
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge := location.Query().Get("logout_challenge")
+	if challenge == "" {
+		t.Fatalf("no logout challenge in redirect %q", location)
+	}
+	return challenge
+}
+
+func TestEndSessionRejectsUnregisteredRedirectURI(t *testing.T) {
+	s := newLogoutServer(t)
+	params := url.Values{"client_id": {testClientID}, "post_logout_redirect_uri": {"https://evil.example.com/"}}
+	err := s.HandleEndSessionRequest(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/oauth2/logout?"+params.Encode(), nil))
+	if !errors.Is(err, ErrInvalidPostLogoutRedirectURI) {
+		t.Fatalf("error = %v, want %v", err, ErrInvalidPostLogoutRedirectURI)
+	}
+}
+
+func TestLogoutRequestShownBeforeConfirmation(t *testing.T) {
+	s := newLogoutServer(t)
+	ctx := context.Background()
+	challenge := endSession(t, s, url.Values{
+		"client_id":                {testClientID},
+		"post_logout_redirect_uri": {testPostLogoutRedirectURI},
+		"state":                    {"xyz"},
+	})
+
+	// Showing the prompt does not consume the challenge
+	for i := 0; i < 2; i++ {
+		data, err := s.LogoutRequest(ctx, challenge)
+		if err != nil {
+			t.Fatalf("logout request: %v", err)
+		}
+		if data["client_id"] != testClientID || data["post_logout_redirect_uri"] != testPostLogoutRedirectURI {
+			t.Errorf("logout request = %v", data)
+		}
+	}
+
+	result, err := s.Logout(ctx, "1", "", challenge)
+	if err != nil {
+		t.Fatalf("logout: %v", err)
+	}
+	if result.RedirectTo != testPostLogoutRedirectURI+"?state=xyz" {
+		t.Errorf("redirect_to = %q", result.RedirectTo)
+	}
+	if _, err := s.Logout(ctx, "1", "", challenge); !errors.Is(err, ErrInvalidLogoutRequest) {
+		t.Errorf("second logout error = %v, want %v", err, ErrInvalidLogoutRequest)
+	}
+}
+
+func TestRejectedLogoutCannotComplete(t *testing.T) {
+	s := newLogoutServer(t)
+	ctx := context.Background()
+	challenge := endSession(t, s, url.Values{
+		"client_id":                {testClientID},
+		"post_logout_redirect_uri": {testPostLogoutRedirectURI},
+	})
+
+	if err := s.RejectLogout(ctx, challenge); err != nil {
+		t.Fatalf("reject logout: %v", err)
+	}
+	if _, err := s.Logout(ctx, "1", "", challenge); !errors.Is(err, ErrInvalidLogoutRequest) {
+		t.Errorf("logout after rejection error = %v, want %v", err, ErrInvalidLogoutRequest)
+	}
+}
+
+func TestBackchannelLogoutRetries(t *testing.T) {
+	t.Setenv("OAUTH2_BACKCHANNEL_LOGOUT_RETRY_INTERVAL", "0")
+	var attempts atomic.Int32
+	delivered := make(chan struct{})
+	client := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("logout_token") == "" {
+			t.Error("delivery without a logout token")
+		}
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		close(delivered)
+	}))
+	defer client.Close()
+
+	s, _ := newTestServer(t)
+	s.queueBackchannelLogout(backchannelLogout{clientID: testClientID, logoutURI: client.URL, subject: "1", sessionID: "laptop"})
+
+	select {
+	case <-delivered:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("logout token not delivered after %d attempts", attempts.Load())
+	}
+}
+
+func TestBackchannelLogoutQueueIsBounded(t *testing.T) {
+	t.Setenv("OAUTH2_BACKCHANNEL_LOGOUT_WORKERS", "1")
+	t.Setenv("OAUTH2_BACKCHANNEL_LOGOUT_QUEUE_SIZE", "2")
+	received := make(chan struct{}, 10)
+	release := make(chan struct{})
+	client := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+		<-release
+	}))
+	defer client.Close()
+	defer close(release)
+
+	s, _ := newTestServer(t)
+	logout := backchannelLogout{clientID: testClientID, logoutURI: client.URL, subject: "1", sessionID: "laptop"}
+	s.queueBackchannelLogout(logout)
+	<-received
+
+	// The worker is busy and one delivery waits, the rest are dropped instead of piling up
+	for i := 0; i < 5; i++ {
+		s.queueBackchannelLogout(logout)
+	}
+	if pending := len(s.logoutSlots); pending != 2 {
+		t.Errorf("pending deliveries = %d, want 2", pending)
+	}
+	if queued := len(s.logouts); queued != 1 {
+		t.Errorf("queued deliveries = %d, want 1", queued)
+	}
+}
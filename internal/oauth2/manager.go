@@ -14,7 +14,16 @@ const (
 	redisAuthCodePrefix    = "oauth2:authcode:"
 	redisAccessTokenPrefix = "oauth2:accesstoken:"
 	redisRefreshTokenPrefix = "oauth2:refreshtoken:"
-	redisClientPrefix      = "oauth2:client:"
+	redisClientPrefix      = "oauth2:client:v2:" // v2: token policy, older entries lack IssueRefreshTokens
+	redisClientVersionPrefix = "oauth2:clientversion:" // bumped on every change, part of the cache key
+	redisTokenUsedPrefix   = "oauth2:tokenused:"
+	redisPARPrefix         = "oauth2:par:"
+	redisConsentPrefix     = "oauth2:consent:"
+	redisLogoutPrefix      = "oauth2:logout:"
+	redisDPoPJTIPrefix     = "oauth2:dpop:jti:"
+	redisDPoPNoncePrefix   = "oauth2:dpop:nonce:"
+	redisJWTBearerJTIPrefix = "oauth2:jwtbearer:jti:"
+	redisJARJTIPrefix       = "oauth2:jar:jti:"
 )
 
 type authorizeData struct {
@@ -0,0 +1,91 @@
+package oauth2
+
+import (
+	"strings"
+)
+
+// MetadataPath is the well-known path of the authorization server metadata document (RFC 8414)
+const MetadataPath = "/.well-known/oauth-authorization-server"
+
+// metadataEndpoints maps metadata endpoint keys to the route paths serving them
+var metadataEndpoints = map[string]string{
+	"authorization_endpoint":                "/oauth2/authorize",
+	"token_endpoint":                        "/oauth2/token",
+	"pushed_authorization_request_endpoint": "/oauth2/par",
+	"introspection_endpoint":                "/oauth2/introspect",
+	"jwks_uri":                              "/oauth2/jwks",
+	"end_session_endpoint":                  "/oauth2/logout",
+	"userinfo_endpoint":                     "/oauth2/userinfo",
+}
+
+// tokenEndpointAuthMethods lists the client authentication methods accepted by server.ClientFormHandler
+var tokenEndpointAuthMethods = []string{"client_secret_post", "none"}
+
+// Metadata builds the authorization server metadata document from the live server configuration.
+// routes are the paths registered on the router, only endpoints that are served are listed.
+func (s *Server) Metadata(routes []string) map[string]interface{} {
+	issuer := strings.TrimRight(s.config.OAuth2Server.Issuer, "/")
+
+	metadata := map[string]interface{}{
+		"issuer":                                issuer,
+		"token_endpoint_auth_methods_supported": tokenEndpointAuthMethods,
+		"scopes_supported":                      s.config.OAuth2Server.Scopes,
+		"subject_types_supported":               []string{SubjectTypePublic, SubjectTypePairwise},
+	}
+
+	registered := make(map[string]bool, len(routes))
+	for _, route := range routes {
+		registered[route] = true
+	}
+	for key, path := range metadataEndpoints {
+		if registered[path] {
+			metadata[key] = issuer + path
+		}
+	}
+
+	responseTypes := make([]string, 0, len(s.Config.AllowedResponseTypes))
+	for _, rt := range s.Config.AllowedResponseTypes {
+		responseTypes = append(responseTypes, rt.String())
+	}
+	metadata["response_types_supported"] = responseTypes
+
+	grantTypes := make([]string, 0, len(s.Config.AllowedGrantTypes))
+	for _, gt := range s.Config.AllowedGrantTypes {
+		grantTypes = append(grantTypes, gt.String())
+	}
+	metadata["grant_types_supported"] = grantTypes
+
+	codeChallengeMethods := make([]string, 0, len(s.Config.AllowedCodeChallengeMethods))
+	for _, ccm := range s.Config.AllowedCodeChallengeMethods {
+		codeChallengeMethods = append(codeChallengeMethods, ccm.String())
+	}
+	metadata["code_challenge_methods_supported"] = codeChallengeMethods
+
+	if _, ok := metadata["introspection_endpoint"]; ok {
+		metadata["introspection_endpoint_auth_methods_supported"] = []string{"client_secret_post"}
+	}
+
+	if s.DPoPEnabled() {
+		metadata["dpop_signing_alg_values_supported"] = s.config.OAuth2Server.DPoP.SigningAlgs
+	}
+
+	if s.JAREnabled() {
+		metadata["request_parameter_supported"] = true
+		metadata["request_uri_parameter_supported"] = true
+		metadata["require_request_uri_registration"] = true
+		metadata["request_object_signing_alg_values_supported"] = s.config.OAuth2Server.JAR.SigningAlgs
+	}
+
+	if _, ok := metadata["end_session_endpoint"]; ok {
+		metadata["backchannel_logout_supported"] = true
+		metadata["backchannel_logout_session_supported"] = true
+		metadata["frontchannel_logout_supported"] = true
+		metadata["frontchannel_logout_session_supported"] = true
+	}
+
+	if s.PAREnabled() {
+		metadata["require_pushed_authorization_requests"] = s.config.OAuth2Server.PAR.Required
+	}
+
+	return metadata
+}
@@ -0,0 +1,70 @@
+package oauth2
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMetadataListsServedEndpoints(t *testing.T) {
+	t.Setenv("OAUTH2_ISSUER", "https://auth.example.com/")
+	s, _ := newTestServer(t)
+
+	metadata := s.Metadata([]string{"/oauth2/authorize", "/oauth2/token", "/oauth2/jwks"})
+	if metadata["issuer"] != "https://auth.example.com" {
+		t.Errorf("issuer = %v", metadata["issuer"])
+	}
+	if metadata["token_endpoint"] != "https://auth.example.com/oauth2/token" {
+		t.Errorf("token_endpoint = %v", metadata["token_endpoint"])
+	}
+	for _, key := range []string{"introspection_endpoint", "introspection_endpoint_auth_methods_supported", "end_session_endpoint", "backchannel_logout_supported", "pushed_authorization_request_endpoint"} {
+		if _, ok := metadata[key]; ok {
+			t.Errorf("%s listed without its route", key)
+		}
+	}
+
+	metadata = s.Metadata([]string{"/oauth2/introspect", "/oauth2/logout", "/oauth2/par"})
+	for _, key := range []string{"introspection_endpoint", "introspection_endpoint_auth_methods_supported", "end_session_endpoint", "backchannel_logout_supported", "pushed_authorization_request_endpoint"} {
+		if _, ok := metadata[key]; !ok {
+			t.Errorf("%s not listed", key)
+		}
+	}
+}
+
+func TestMetadataFollowsConfiguration(t *testing.T) {
+	t.Setenv("OAUTH2_RESPONSE_TYPES", "code")
+	t.Setenv("OAUTH2_GRANT_TYPES", "authorization_code,refresh_token")
+	t.Setenv("OAUTH2_PAR_REQUIRED", "true")
+	t.Setenv("OAUTH2_DPOP_SIGNING_ALGS", "ES256")
+	s, _ := newTestServer(t)
+
+	metadata := s.Metadata(nil)
+	if got := metadata["response_types_supported"]; !reflect.DeepEqual(got, []string{"code"}) {
+		t.Errorf("response_types_supported = %v", got)
+	}
+	if got := metadata["grant_types_supported"]; !reflect.DeepEqual(got, []string{"authorization_code", "refresh_token"}) {
+		t.Errorf("grant_types_supported = %v", got)
+	}
+	if metadata["require_pushed_authorization_requests"] != true {
+		t.Errorf("require_pushed_authorization_requests = %v", metadata["require_pushed_authorization_requests"])
+	}
+	if got := metadata["dpop_signing_alg_values_supported"]; !reflect.DeepEqual(got, []string{"ES256"}) {
+		t.Errorf("dpop_signing_alg_values_supported = %v", got)
+	}
+
+	// The password grant is not supported and never advertised
+	t.Setenv("OAUTH2_GRANT_TYPES", "authorization_code,password")
+	s, _ = newTestServer(t)
+	if got := s.Metadata(nil)["grant_types_supported"]; !reflect.DeepEqual(got, []string{"authorization_code"}) {
+		t.Errorf("grant_types_supported = %v", got)
+	}
+
+	t.Setenv("OAUTH2_PAR_ENABLED", "false")
+	t.Setenv("OAUTH2_DPOP_ENABLED", "false")
+	s, _ = newTestServer(t)
+	metadata = s.Metadata(nil)
+	for _, key := range []string{"require_pushed_authorization_requests", "dpop_signing_alg_values_supported"} {
+		if _, ok := metadata[key]; ok {
+			t.Errorf("%s listed while disabled", key)
+		}
+	}
+}
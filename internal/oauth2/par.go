@@ -0,0 +1,177 @@
+package oauth2
+
+import (
+	database "core-auth/db"
+	"core-auth/internal/utils"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/errors"
+)
+
+// parRequestURIPrefix is the URN prefix of request_uri values issued by the PAR endpoint (RFC 9126)
+const parRequestURIPrefix = "urn:ietf:params:oauth:request_uri:"
+
+// pushedAuthorizationRequest is the authorization request stored in Redis until it is redeemed
+type pushedAuthorizationRequest struct {
+	ClientID  string
+	Params    url.Values
+	ExpiresAt time.Time
+	Signed    bool // pushed as a signed request object
+}
+
+// PAREnabled reports whether the pushed authorization request endpoint is enabled
+func (s *Server) PAREnabled() bool {
+	return s.config.OAuth2Server.PAR.Enabled
+}
+
+// HandlePushedAuthorizationRequest authenticates the client, validates the pushed
+// authorization parameters and responds with a short-lived request_uri
+func (s *Server) HandlePushedAuthorizationRequest(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return s.WriteError(w, errors.ErrInvalidRequest)
+	}
+	if err := r.ParseForm(); err != nil {
+		return s.WriteError(w, errors.ErrInvalidRequest)
+	}
+
+	cli, err := s.authenticateClient(r)
+	if err != nil {
+		return s.WriteError(w, err)
+	}
+
+	// request_uri must not be pushed itself
+	if r.PostForm.Get("request_uri") != "" {
+		return s.WriteError(w, errors.ErrInvalidRequest)
+	}
+	r.Form.Set("client_id", cli.GetID())
+	signed, err := s.resolveRequestObject(r)
+	if err != nil {
+		return s.WriteError(w, err)
+	}
+
+	req, err := s.validateAuthorizeRequest(r)
+	if err != nil {
+		return s.WriteError(w, err)
+	}
+	if req.RedirectURI == "" {
+		return s.WriteError(w, errors.ErrInvalidRequest)
+	}
+	if err := validateRedirectURI(cli.GetDomain(), req.RedirectURI); err != nil {
+		return s.WriteError(w, errors.ErrInvalidRequest)
+	}
+
+	if s.rdb == nil {
+		return s.WriteError(w, errors.ErrTemporarilyUnavailable)
+	}
+
+	id, err := utils.GenerateRandomString(32)
+	if err != nil {
+		return s.WriteError(w, err)
+	}
+
+	params := url.Values{}
+	for key, values := range r.Form {
+		if key == "client_secret" {
+			continue
+		}
+		params[key] = values
+	}
+	params.Set("client_id", cli.GetID())
+
+	expiresIn := time.Duration(s.config.OAuth2Server.PAR.ExpiresIn) * time.Second
+	data, err := json.Marshal(&pushedAuthorizationRequest{
+		ClientID:  cli.GetID(),
+		Params:    params,
+		ExpiresAt: time.Now().Add(expiresIn),
+		Signed:    signed,
+	})
+	if err != nil {
+		return s.WriteError(w, err)
+	}
+	if err := s.rdb.SetEX(r.Context(), redisPARPrefix+id, data, expiresIn).Err(); err != nil {
+		return s.WriteError(w, err)
+	}
+
+	return writeJSON(w, map[string]interface{}{
+		"request_uri": parRequestURIPrefix + id,
+		"expires_in":  int64(expiresIn / time.Second),
+	}, nil, http.StatusCreated)
+}
+
+// resolvePushedAuthorizationRequest replaces the request form with the pushed parameters.
+// A request_uri can be redeemed only once and only by the client it was issued to.
+// It reports whether the pushed request was a signed request object.
+func (s *Server) resolvePushedAuthorizationRequest(r *http.Request) (bool, error) {
+	if err := r.ParseForm(); err != nil {
+		return false, errors.ErrInvalidRequest
+	}
+
+	clientID := r.Form.Get("client_id")
+	requestURI := r.Form.Get("request_uri")
+	if !strings.HasPrefix(requestURI, parRequestURIPrefix) {
+		if s.parRequired(clientID) {
+			return false, ErrPushedAuthorizationRequired
+		}
+		// Other request_uri values reference request objects (RFC 9101)
+		return false, nil
+	}
+
+	if !s.PAREnabled() || s.rdb == nil {
+		return false, ErrInvalidRequestURI
+	}
+
+	key := redisPARPrefix + strings.TrimPrefix(requestURI, parRequestURIPrefix)
+	data, err := s.rdb.GetDel(r.Context(), key).Bytes()
+	if err != nil {
+		return false, ErrInvalidRequestURI
+	}
+
+	var par pushedAuthorizationRequest
+	if err := json.Unmarshal(data, &par); err != nil {
+		return false, ErrInvalidRequestURI
+	}
+	if par.ClientID != clientID || time.Now().After(par.ExpiresAt) {
+		return false, ErrInvalidRequestURI
+	}
+
+	r.Form = par.Params
+	return par.Signed, nil
+}
+
+// parRequired reports whether the global or per-client policy requires PAR
+func (s *Server) parRequired(clientID string) bool {
+	if !s.PAREnabled() {
+		return false
+	}
+	if s.config.OAuth2Server.PAR.Required {
+		return true
+	}
+	client, err := database.GetClientByID(s.db, clientID)
+	if err != nil {
+		return false
+	}
+	return client.RequirePushedAuthorizationRequests
+}
+
+// authenticateClient resolves the client from the request and verifies its secret
+func (s *Server) authenticateClient(r *http.Request) (oauth2.ClientInfo, error) {
+	clientID, clientSecret, err := s.ClientInfoHandler(r)
+	if err != nil {
+		return nil, err
+	}
+
+	cli, err := s.Manager.GetClient(r.Context(), clientID)
+	if err != nil {
+		return nil, errors.ErrInvalidClient
+	}
+
+	if !verifyClientSecret(cli, clientSecret) {
+		return nil, errors.ErrInvalidClient
+	}
+	return cli, nil
+}
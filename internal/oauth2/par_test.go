@@ -0,0 +1,173 @@
+package oauth2
+
+import (
+	"context"
+	database "core-auth/db"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newConfidentialClient creates a client redirecting to testRedirectURI and returns its ID and secret
+func newConfidentialClient(t *testing.T, s *Server, client *database.OAuth2Client) (string, string) {
+	t.Helper()
+	client.Name = "app"
+	client.IsActive = true
+	client.RedirectURIs = `["` + testRedirectURI + `"]`
+	secret, err := s.CreateClient(context.Background(), client, true)
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	return client.ClientID, secret
+}
+
+// postForm sends a form to a handler of the server and returns the response
+func postForm(t *testing.T, handler func(http.ResponseWriter, *http.Request) error, path string, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := handler(w, r); err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return w
+}
+
+// pushAuthorizationRequest pushes an authorization request of the client and returns its request_uri
+func pushAuthorizationRequest(t *testing.T, s *Server, clientID, secret string) string {
+	t.Helper()
+	w := postForm(t, s.HandlePushedAuthorizationRequest, "/oauth2/par", url.Values{
+		"client_id":     {clientID},
+		"client_secret": {secret},
+		"response_type": {"code"},
+		"redirect_uri":  {testRedirectURI},
+		"scope":         {"openid"},
+		"state":         {"xyz"},
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("push status = %d: %s", w.Code, w.Body)
+	}
+	var response struct {
+		RequestURI string `json:"request_uri"`
+		ExpiresIn  int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(response.RequestURI, parRequestURIPrefix) || response.ExpiresIn != 60 {
+		t.Fatalf("push response = %s", w.Body)
+	}
+	return response.RequestURI
+}
+
+// authorizeRequest returns an authorization request referencing a pushed request
+func authorizeRequest(clientID, requestURI string) *http.Request {
+	params := url.Values{"client_id": {clientID}, "request_uri": {requestURI}}
+	return httptest.NewRequest(http.MethodGet, "/oauth2/authorize?"+params.Encode(), nil)
+}
+
+func TestPushedAuthorizationRequestRedeemedOnce(t *testing.T) {
+	s, redisServer := newTestServer(t)
+	clientID, secret := newConfidentialClient(t, s, &database.OAuth2Client{})
+	requestURI := pushAuthorizationRequest(t, s, clientID, secret)
+
+	key := redisPARPrefix + strings.TrimPrefix(requestURI, parRequestURIPrefix)
+	if ttl := redisServer.TTL(key); ttl != time.Minute {
+		t.Errorf("pushed request kept for %v, want %v", ttl, time.Minute)
+	}
+
+	r := authorizeRequest(clientID, requestURI)
+	if _, err := s.resolvePushedAuthorizationRequest(r); err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if r.Form.Get("state") != "xyz" || r.Form.Get("redirect_uri") != testRedirectURI {
+		t.Errorf("resolved form = %v", r.Form)
+	}
+	if r.Form.Has("client_secret") {
+		t.Error("client secret stored with the pushed request")
+	}
+
+	if _, err := s.resolvePushedAuthorizationRequest(authorizeRequest(clientID, requestURI)); !errors.Is(err, ErrInvalidRequestURI) {
+		t.Errorf("second use error = %v, want %v", err, ErrInvalidRequestURI)
+	}
+}
+
+func TestPushedAuthorizationRequestBoundToClient(t *testing.T) {
+	s, _ := newTestServer(t)
+	clientID, secret := newConfidentialClient(t, s, &database.OAuth2Client{})
+	requestURI := pushAuthorizationRequest(t, s, clientID, secret)
+
+	if _, err := s.resolvePushedAuthorizationRequest(authorizeRequest("other", requestURI)); !errors.Is(err, ErrInvalidRequestURI) {
+		t.Errorf("other client error = %v, want %v", err, ErrInvalidRequestURI)
+	}
+}
+
+func TestPushedAuthorizationRequestValidated(t *testing.T) {
+	s, _ := newTestServer(t)
+	clientID, secret := newConfidentialClient(t, s, &database.OAuth2Client{})
+
+	tests := []struct {
+		name   string
+		form   url.Values
+		status int
+		error  string
+	}{
+		{"wrong secret", url.Values{"client_secret": {"wrong"}}, http.StatusUnauthorized, "invalid_client"},
+		{"unregistered redirect_uri", url.Values{"redirect_uri": {"https://evil.example.com/"}}, http.StatusBadRequest, "invalid_request"},
+		{"missing redirect_uri", url.Values{"redirect_uri": {""}}, http.StatusBadRequest, "invalid_request"},
+		{"request_uri", url.Values{"request_uri": {parRequestURIPrefix + "pushed"}}, http.StatusBadRequest, "invalid_request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{
+				"client_id":     {clientID},
+				"client_secret": {secret},
+				"response_type": {"code"},
+				"redirect_uri":  {testRedirectURI},
+			}
+			for key, values := range tt.form {
+				form[key] = values
+			}
+			w := postForm(t, s.HandlePushedAuthorizationRequest, "/oauth2/par", form)
+			var response map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != tt.status || response["error"] != tt.error {
+				t.Errorf("response = %d %s, want %d %s", w.Code, w.Body, tt.status, tt.error)
+			}
+		})
+	}
+}
+
+func TestPushedAuthorizationRequired(t *testing.T) {
+	s, _ := newTestServer(t)
+	clientID, _ := newConfidentialClient(t, s, &database.OAuth2Client{RequirePushedAuthorizationRequests: true})
+	otherID, _ := newConfidentialClient(t, s, &database.OAuth2Client{})
+
+	params := url.Values{"client_id": {clientID}, "response_type": {"code"}, "redirect_uri": {testRedirectURI}}
+	r := httptest.NewRequest(http.MethodGet, "/oauth2/authorize?"+params.Encode(), nil)
+	if _, err := s.resolvePushedAuthorizationRequest(r); !errors.Is(err, ErrPushedAuthorizationRequired) {
+		t.Errorf("client requiring PAR error = %v, want %v", err, ErrPushedAuthorizationRequired)
+	}
+
+	params.Set("client_id", otherID)
+	r = httptest.NewRequest(http.MethodGet, "/oauth2/authorize?"+params.Encode(), nil)
+	if _, err := s.resolvePushedAuthorizationRequest(r); err != nil {
+		t.Errorf("other client error = %v, want none", err)
+	}
+
+	t.Setenv("OAUTH2_PAR_REQUIRED", "true")
+	s, _ = newTestServer(t)
+	otherID, _ = newConfidentialClient(t, s, &database.OAuth2Client{})
+	params.Set("client_id", otherID)
+	r = httptest.NewRequest(http.MethodGet, "/oauth2/authorize?"+params.Encode(), nil)
+	if _, err := s.resolvePushedAuthorizationRequest(r); !errors.Is(err, ErrPushedAuthorizationRequired) {
+		t.Errorf("required globally error = %v, want %v", err, ErrPushedAuthorizationRequired)
+	}
+}
@@ -0,0 +1,184 @@
+package oauth2
+
+import (
+	"context"
+	"core-auth/config"
+	database "core-auth/db"
+	"crypto/subtle"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/errors"
+	"github.com/go-oauth2/oauth2/v4/models"
+)
+
+// Access token formats
+const (
+	AccessTokenFormatOpaque = "opaque"
+	AccessTokenFormatJWT    = "jwt"
+)
+
+// extensionSessionStart is the token extension holding when the refresh token chain started
+const extensionSessionStart = "session_start"
+
+// Client is the client information used by the oauth2 server
+type Client struct {
+	models.Client
+	TokenPolicy
+	SubjectType      string // public or pairwise
+	SectorIdentifier string // host pairwise subjects are derived for
+	Internal         bool   // trusted internal client, may resolve pairwise subjects
+
+	assertionAuthenticated bool // authenticated by a JWT bearer assertion, needs no secret
+}
+
+// VerifyPassword implements oauth2.ClientPasswordVerifier interface. The secret is compared
+// with the stored hash, public clients accept any secret.
+func (c *Client) VerifyPassword(secret string) bool {
+	if c.Secret == "" || c.assertionAuthenticated {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(database.HashClientSecret(secret)), []byte(c.Secret)) == 1
+}
+
+// verifyClientSecret checks the secret a client presented
+func verifyClientSecret(cli oauth2.ClientInfo, secret string) bool {
+	if verifier, ok := cli.(oauth2.ClientPasswordVerifier); ok {
+		return verifier.VerifyPassword(secret)
+	}
+	return cli.GetSecret() == "" || subtle.ConstantTimeCompare([]byte(cli.GetSecret()), []byte(secret)) == 1
+}
+
+// TokenPolicy overrides the server token lifetimes for a client, zero values use the server configuration
+type TokenPolicy struct {
+	AccessTokenLifetime    time.Duration
+	RefreshTokenLifetime   time.Duration
+	RefreshSessionLifetime time.Duration // absolute lifetime of a refresh token chain
+	SlidingRefreshExpiry   *bool         // restart the refresh token lifetime on every use
+	IssueRefreshTokens     bool
+	AccessTokenFormat      string // opaque or jwt
+}
+
+// tokenGenerate applies the client's token policy to every token issued, whatever the grant
+// type, before delegating the generation of the token values
+type tokenGenerate struct {
+	oauth2.AccessGenerate
+	keys   *KeySet
+	config *config.Config
+}
+
+// newTokenGenerate creates a token generator applying the client token policies
+func newTokenGenerate(generate oauth2.AccessGenerate, keys *KeySet, config *config.Config) *tokenGenerate {
+	return &tokenGenerate{
+		AccessGenerate: generate,
+		keys:           keys,
+		config:         config,
+	}
+}
+
+// policy resolves the token policy of a client against the server configuration
+func (g *tokenGenerate) policy(cli oauth2.ClientInfo) TokenPolicy {
+	policy := TokenPolicy{
+		AccessTokenLifetime:    time.Duration(g.config.OAuth2Server.AccessTokenDuration) * time.Minute,
+		RefreshTokenLifetime:   time.Duration(g.config.OAuth2Server.RefreshTokenDuration) * time.Hour,
+		RefreshSessionLifetime: time.Duration(g.config.OAuth2Server.RefreshSessionDuration) * time.Hour,
+		SlidingRefreshExpiry:   &g.config.OAuth2Server.SlidingRefreshExpiry,
+		IssueRefreshTokens:     true,
+		AccessTokenFormat:      AccessTokenFormatOpaque,
+	}
+
+	client, ok := cli.(*Client)
+	if !ok {
+		return policy
+	}
+	if client.AccessTokenLifetime > 0 {
+		policy.AccessTokenLifetime = client.AccessTokenLifetime
+	}
+	if client.RefreshTokenLifetime > 0 {
+		policy.RefreshTokenLifetime = client.RefreshTokenLifetime
+	}
+	if client.RefreshSessionLifetime > 0 {
+		policy.RefreshSessionLifetime = client.RefreshSessionLifetime
+	}
+	if client.SlidingRefreshExpiry != nil {
+		policy.SlidingRefreshExpiry = client.SlidingRefreshExpiry
+	}
+	if client.AccessTokenFormat != "" {
+		policy.AccessTokenFormat = client.AccessTokenFormat
+	}
+	policy.IssueRefreshTokens = client.IssueRefreshTokens
+	return policy
+}
+
+// Token sets the lifetimes of the token from the client policy and generates its values.
+// A refresh token is issued only when the grant type allows it and the policy does, a
+// refreshed token never outlives the absolute session lifetime.
+func (g *tokenGenerate) Token(ctx context.Context, data *oauth2.GenerateBasic, isGenRefresh bool) (string, string, error) {
+	policy := g.policy(data.Client)
+	ti := data.TokenInfo
+	refreshing := ti.GetRefresh() != ""
+	if refreshing && !policy.IssueRefreshTokens {
+		return "", "", errors.ErrInvalidGrant
+	}
+
+	ti.SetAccessExpiresIn(policy.AccessTokenLifetime)
+
+	isGenRefresh = isGenRefresh && policy.IssueRefreshTokens
+	if !isGenRefresh {
+		ti.SetRefreshExpiresIn(0)
+		return g.generate(ctx, data, policy, false)
+	}
+
+	start := sessionStart(ti, data.CreateAt)
+	if !refreshing || *policy.SlidingRefreshExpiry {
+		ti.SetRefreshCreateAt(data.CreateAt)
+		ti.SetRefreshExpiresIn(policy.RefreshTokenLifetime)
+	}
+	if policy.RefreshSessionLifetime > 0 {
+		end := start.Add(policy.RefreshSessionLifetime)
+		if !data.CreateAt.Before(end) {
+			return "", "", errors.ErrInvalidGrant
+		}
+		remaining := end.Sub(ti.GetRefreshCreateAt())
+		if exp := ti.GetRefreshExpiresIn(); exp == 0 || exp > remaining {
+			ti.SetRefreshExpiresIn(remaining)
+		}
+	}
+
+	return g.generate(ctx, data, policy, true)
+}
+
+// generate generates the token values in the format of the client policy
+func (g *tokenGenerate) generate(ctx context.Context, data *oauth2.GenerateBasic, policy TokenPolicy, isGenRefresh bool) (string, string, error) {
+	access, refresh, err := g.AccessGenerate.Token(ctx, data, isGenRefresh)
+	if err != nil || policy.AccessTokenFormat != AccessTokenFormatJWT {
+		return access, refresh, err
+	}
+	access, err = g.jwtAccessToken(ctx, data)
+	return access, refresh, err
+}
+
+// sessionStart returns when the refresh token chain of a token started, recording it on the
+// first token of the chain
+func sessionStart(ti oauth2.TokenInfo, now time.Time) time.Time {
+	eti, ok := ti.(oauth2.ExtendableTokenInfo)
+	if !ok {
+		return now
+	}
+	ext := eti.GetExtension()
+	if ext == nil {
+		ext = url.Values{}
+	}
+	if start, err := strconv.ParseInt(ext.Get(extensionSessionStart), 10, 64); err == nil {
+		return time.Unix(start, 0)
+	}
+	if ti.GetRefresh() != "" {
+		// Tokens issued before the session start was recorded
+		return ti.GetRefreshCreateAt()
+	}
+	ext.Set(extensionSessionStart, strconv.FormatInt(now.Unix(), 10))
+	eti.SetExtension(ext)
+	return now
+}
@@ -0,0 +1,212 @@
+package oauth2
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+
+	"github.com/go-oauth2/oauth2/v4"
+)
+
+// extensionAuthorizationDetails is the token extension holding the granted authorization details
+const extensionAuthorizationDetails = "authorization_details"
+
+// commonDetailFields are the fields every authorization details type may use (RFC 9396 section 2.2)
+var commonDetailFields = map[string]bool{
+	"type":       true,
+	"locations":  true,
+	"actions":    true,
+	"datatypes":  true,
+	"identifier": true,
+	"privileges": true,
+}
+
+// detailField describes a type specific field of a registered authorization details type, as in
+// {"instructedAmount": {"type": "object", "required": true, "properties": {...}}}
+type detailField struct {
+	Type       string                 `json:"type"` // string, number, integer, boolean, array or object
+	Required   bool                   `json:"required"`
+	Enum       []string               `json:"enum"`       // allowed values of a string
+	Items      *detailField           `json:"items"`      // schema of the elements of an array
+	Properties map[string]detailField `json:"properties"` // fields of an object, any when empty
+}
+
+// valid reports whether a decoded JSON value matches the field
+func (f *detailField) valid(value interface{}) bool {
+	switch f.Type {
+	case "string":
+		s, ok := value.(string)
+		return ok && (len(f.Enum) == 0 || contains(f.Enum, s))
+	case "number":
+		_, ok := value.(json.Number)
+		return ok
+	case "integer":
+		n, ok := value.(json.Number)
+		if !ok {
+			return false
+		}
+		_, err := n.Int64()
+		return err == nil
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "array":
+		elements, ok := value.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, element := range elements {
+			if f.Items != nil && !f.Items.valid(element) {
+				return false
+			}
+		}
+		return true
+	case "object":
+		object, ok := value.(map[string]interface{})
+		return ok && (len(f.Properties) == 0 || validDetailObject(f.Properties, object))
+	}
+	return false
+}
+
+// validDetailObject reports whether an object has only the given fields, of the right types,
+// and all required ones
+func validDetailObject(fields map[string]detailField, object map[string]interface{}) bool {
+	for name, value := range object {
+		field, ok := fields[name]
+		if !ok || !field.valid(value) {
+			return false
+		}
+	}
+	for name, field := range fields {
+		if _, ok := object[name]; field.Required && !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// validateAuthorizationDetails checks that the authorization_details parameter is an array of
+// objects of types registered on the server and allowed for the client, using only the common
+// fields and the fields of their type as described by the type's registered schema. The
+// compact JSON of the details is returned.
+func (s *Server) validateAuthorizationDetails(clientID string, raw string) (string, error) {
+	var details []map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(raw), &details); err != nil || len(details) == 0 {
+		return "", ErrInvalidAuthorizationDetails
+	}
+
+	client, err := s.queries.GetClient(clientID)
+	if err != nil {
+		return "", ErrInvalidAuthorizationDetails
+	}
+	var allowedTypes []string
+	if client.AuthorizationDetailsTypes != "" {
+		if err := json.Unmarshal([]byte(client.AuthorizationDetailsTypes), &allowedTypes); err != nil {
+			return "", ErrInvalidAuthorizationDetails
+		}
+	}
+
+	for _, detail := range details {
+		var detailType string
+		if err := json.Unmarshal(detail["type"], &detailType); err != nil || !contains(allowedTypes, detailType) {
+			return "", ErrInvalidAuthorizationDetails
+		}
+
+		registered, err := s.queries.GetAuthorizationDetailType(detailType)
+		if err != nil {
+			return "", ErrInvalidAuthorizationDetails
+		}
+		fields := map[string]detailField{}
+		if registered.Fields != "" {
+			if err := json.Unmarshal([]byte(registered.Fields), &fields); err != nil {
+				return "", ErrInvalidAuthorizationDetails
+			}
+		}
+
+		specific := map[string]interface{}{}
+		for field, value := range detail {
+			switch {
+			case field == "type":
+			case field == "identifier":
+				var identifier string
+				if err := json.Unmarshal(value, &identifier); err != nil {
+					return "", ErrInvalidAuthorizationDetails
+				}
+			case commonDetailFields[field]:
+				var values []string
+				if err := json.Unmarshal(value, &values); err != nil {
+					return "", ErrInvalidAuthorizationDetails
+				}
+			default:
+				decoder := json.NewDecoder(bytes.NewReader(value))
+				decoder.UseNumber()
+				var decoded interface{}
+				if err := decoder.Decode(&decoded); err != nil {
+					return "", ErrInvalidAuthorizationDetails
+				}
+				specific[field] = decoded
+			}
+		}
+		if !validDetailObject(fields, specific) {
+			return "", ErrInvalidAuthorizationDetails
+		}
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, []byte(raw)); err != nil {
+		return "", ErrInvalidAuthorizationDetails
+	}
+	return compact.String(), nil
+}
+
+// validateTokenAuthorizationDetails validates the authorization details requested at the token
+// endpoint. Only grants without a prior authorization may request them, codes and refresh
+// tokens keep the details the user approved.
+func (s *Server) validateTokenAuthorizationDetails(gt oauth2.GrantType, tgr *oauth2.TokenGenerateRequest) error {
+	raw := tgr.Request.Form.Get("authorization_details")
+	if raw == "" {
+		return nil
+	}
+	if gt == oauth2.AuthorizationCode || gt == oauth2.Refreshing {
+		return ErrInvalidAuthorizationDetails
+	}
+
+	details, err := s.validateAuthorizationDetails(tgr.ClientID, raw)
+	if err != nil {
+		return err
+	}
+	tgr.Request.Form.Set("authorization_details", details)
+	return nil
+}
+
+// authorizationDetailsExtensionHandler records the authorization details requested with a
+// code or token. Codes carry their details to the tokens issued from them.
+func authorizationDetailsExtensionHandler(tgr *oauth2.TokenGenerateRequest, ti oauth2.ExtendableTokenInfo) {
+	if tgr.Request == nil || tgr.Code != "" {
+		return
+	}
+	details := tgr.Request.Form.Get("authorization_details")
+	if details == "" {
+		return
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, []byte(details)); err != nil {
+		return
+	}
+	ext := ti.GetExtension()
+	if ext == nil {
+		ext = url.Values{}
+	}
+	ext.Set(extensionAuthorizationDetails, compact.String())
+	ti.SetExtension(ext)
+}
+
+// tokenAuthorizationDetails returns the authorization details granted with a token, if any
+func tokenAuthorizationDetails(ti oauth2.TokenInfo) json.RawMessage {
+	if eti, ok := ti.(oauth2.ExtendableTokenInfo); ok && eti.GetExtension() != nil {
+		if details := eti.GetExtension().Get(extensionAuthorizationDetails); details != "" {
+			return json.RawMessage(details)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package oauth2
+
+import (
+	database "core-auth/db"
+	"errors"
+	"testing"
+)
+
+// paymentFields is the schema of the payment_initiation type used by the tests
+const paymentFields = `{
+	"instructedAmount": {"type": "object", "required": true, "properties": {
+		"currency": {"type": "string", "required": true, "enum": ["EUR", "USD"]},
+		"amount": {"type": "number", "required": true}
+	}},
+	"creditorName": {"type": "string"},
+	"remittanceInformation": {"type": "array", "items": {"type": "string"}}
+}`
+
+func TestValidateAuthorizationDetails(t *testing.T) {
+	s, _ := newTestServer(t)
+	if err := s.db.Create(&database.OAuth2AuthorizationDetailType{Type: "payment_initiation", Fields: paymentFields, IsActive: true}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := s.db.Create(&database.OAuth2Client{
+		ClientID:                  "client",
+		IsActive:                  true,
+		AuthorizationDetailsTypes: `["payment_initiation"]`,
+	}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		details string
+		valid   bool
+	}{
+		{"valid", `[{"type":"payment_initiation","actions":["initiate"],"instructedAmount":{"currency":"EUR","amount":100.5},"remittanceInformation":["invoice 1"]}]`, true},
+		{"number as string", `[{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":"100"}}]`, false},
+		{"missing required field", `[{"type":"payment_initiation","creditorName":"Merchant"}]`, false},
+		{"missing required property", `[{"type":"payment_initiation","instructedAmount":{"currency":"EUR"}}]`, false},
+		{"value outside enum", `[{"type":"payment_initiation","instructedAmount":{"currency":"BTC","amount":1}}]`, false},
+		{"unknown property", `[{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":1,"fee":1}}]`, false},
+		{"wrong field type", `[{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":1},"creditorName":42}]`, false},
+		{"wrong element type", `[{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":1},"remittanceInformation":[1]}]`, false},
+		{"unknown field", `[{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":1},"debtor":"x"}]`, false},
+		{"unregistered type", `[{"type":"account_information"}]`, false},
+		{"common field of wrong type", `[{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":1},"actions":"initiate"}]`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.validateAuthorizationDetails("client", tt.details)
+			if tt.valid && err != nil {
+				t.Errorf("rejected valid details: %v", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidAuthorizationDetails) {
+				t.Errorf("error = %v, want %v", err, ErrInvalidAuthorizationDetails)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package oauth2
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/errors"
+)
+
+// extensionResource is the token extension holding the resource servers a token is issued for
+const extensionResource = "resource"
+
+// requestedResources returns the distinct resource parameters of a request (RFC 8707 section 2)
+func requestedResources(form url.Values) []string {
+	var resources []string
+	seen := make(map[string]bool)
+	for _, resource := range form["resource"] {
+		if !seen[resource] {
+			seen[resource] = true
+			resources = append(resources, resource)
+		}
+	}
+	return resources
+}
+
+// validateResources checks that every resource is a registered resource server and that
+// each requested scope is accepted by at least one of them
+func (s *Server) validateResources(resources []string, scope string) error {
+	accepted := make(map[string]bool)
+	for _, resource := range resources {
+		u, err := url.Parse(resource)
+		if err != nil || !u.IsAbs() || u.Fragment != "" {
+			return ErrInvalidTarget
+		}
+
+		rs, err := s.queries.GetResourceServer(resource)
+		if err != nil {
+			return ErrInvalidTarget
+		}
+		var scopes []string
+		if err := json.Unmarshal([]byte(rs.Scopes), &scopes); err != nil {
+			return ErrInvalidTarget
+		}
+		for _, sc := range scopes {
+			accepted[sc] = true
+		}
+	}
+
+	for _, sc := range strings.Fields(scope) {
+		if !accepted[sc] {
+			return errors.ErrInvalidScope
+		}
+	}
+	return nil
+}
+
+// validateTokenResources validates the resource parameters of a token request. Resources
+// requested when redeeming a code or refresh token must have been granted with it. A code
+// exchange narrows the audience to the requested resources, refreshed tokens keep the
+// audience of the grant.
+func (s *Server) validateTokenResources(ctx context.Context, gt oauth2.GrantType, tgr *oauth2.TokenGenerateRequest) error {
+	resources := requestedResources(tgr.Request.Form)
+	if len(resources) == 0 {
+		return nil
+	}
+
+	scope := tgr.Scope
+	var granted []string
+	switch gt {
+	case oauth2.AuthorizationCode:
+		auth, err := s.queries.GetAuthorizationCode(tgr.Code)
+		if err != nil {
+			// Let the code grant report the invalid or replayed code
+			return nil
+		}
+		ti, err := tokenInfo(auth.Data)
+		if err != nil {
+			return err
+		}
+		scope, granted = ti.GetScope(), tokenResources(ti)
+		if len(granted) == 0 {
+			granted = resources
+		}
+	case oauth2.Refreshing:
+		ti, err := s.Manager.LoadRefreshToken(ctx, tgr.Refresh)
+		if err != nil {
+			return nil
+		}
+		if scope == "" {
+			scope = ti.GetScope()
+		}
+		granted = tokenResources(ti)
+	default:
+		granted = resources
+	}
+
+	for _, resource := range resources {
+		if !contains(granted, resource) {
+			return ErrInvalidTarget
+		}
+	}
+	return s.validateResources(resources, scope)
+}
+
+// resourceExtensionHandler restricts newly issued codes and tokens to the requested resources
+func resourceExtensionHandler(tgr *oauth2.TokenGenerateRequest, ti oauth2.ExtendableTokenInfo) {
+	if tgr.Request == nil {
+		return
+	}
+	resources := requestedResources(tgr.Request.Form)
+	if len(resources) == 0 {
+		return
+	}
+	ext := ti.GetExtension()
+	if ext == nil {
+		ext = url.Values{}
+	}
+	ext[extensionResource] = resources
+	ti.SetExtension(ext)
+}
+
+// tokenResources returns the resource servers a token is issued for, none when it is unrestricted
+func tokenResources(ti oauth2.TokenInfo) []string {
+	if eti, ok := ti.(oauth2.ExtendableTokenInfo); ok && eti.GetExtension() != nil {
+		return eti.GetExtension()[extensionResource]
+	}
+	return nil
+}
+
+// issuedFor reports whether the token may be presented to the resource server the client
+// authenticates as. Tokens without a resource restriction are valid at every resource server.
+// Clients that are not resource servers, or whose lookup fails, are not issued any token.
+func (s *Server) issuedFor(ti oauth2.TokenInfo, clientID string) bool {
+	rs, err := s.queries.GetResourceServerByClientID(clientID)
+	if err != nil {
+		return false
+	}
+	resources := tokenResources(ti)
+	return len(resources) == 0 || contains(resources, rs.Identifier)
+}
+
+// contains reports whether values contains value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package oauth2
+
+import (
+	"context"
+	database "core-auth/db"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	oerrors "github.com/go-oauth2/oauth2/v4/errors"
+	"github.com/go-oauth2/oauth2/v4/models"
+)
+
+const (
+	testResource      = "https://api.example.com"
+	testOtherResource = "https://files.example.com"
+)
+
+// newResourceServer returns a server with resource servers accepting the openid and files scopes
+func newResourceServer(t *testing.T) *Server {
+	t.Helper()
+	s, _ := newTestServer(t)
+	for identifier, scopes := range map[string]string{testResource: `["openid"]`, testOtherResource: `["openid","files"]`} {
+		if err := s.db.Create(&database.OAuth2ResourceServer{
+			Identifier: identifier,
+			Name:       identifier,
+			Scopes:     scopes,
+			IsActive:   true,
+		}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+// tokenRequest returns a token request asking for the resources
+func tokenRequest(resources ...string) *oauth2.TokenGenerateRequest {
+	form := url.Values{"resource": resources}
+	r := httptest.NewRequest(http.MethodPost, "/oauth2/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	_ = r.ParseForm()
+	return &oauth2.TokenGenerateRequest{ClientID: testClientID, Request: r}
+}
+
+func TestRequestedResourcesAreDistinct(t *testing.T) {
+	form := url.Values{"resource": {testResource, testOtherResource, testResource}}
+	if got := requestedResources(form); !reflect.DeepEqual(got, []string{testResource, testOtherResource}) {
+		t.Errorf("resources = %v", got)
+	}
+}
+
+func TestValidateResources(t *testing.T) {
+	s := newResourceServer(t)
+
+	tests := []struct {
+		name      string
+		resources []string
+		scope     string
+		want      error
+	}{
+		{"registered", []string{testResource}, "openid", nil},
+		{"scope of another resource", []string{testResource, testOtherResource}, "openid files", nil},
+		{"unknown", []string{"https://unknown.example.com"}, "openid", ErrInvalidTarget},
+		{"relative", []string{"/api"}, "openid", ErrInvalidTarget},
+		{"fragment", []string{testResource + "#section"}, "openid", ErrInvalidTarget},
+		{"scope not accepted", []string{testResource}, "openid files", oerrors.ErrInvalidScope},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.validateResources(tt.resources, tt.scope); !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenResourcesNarrowTheGrant(t *testing.T) {
+	s := newResourceServer(t)
+	ctx := context.Background()
+
+	// A code granted for both resources is exchanged for a token of one of them
+	ti := models.NewToken()
+	ti.SetClientID(testClientID)
+	ti.SetUserID("1")
+	ti.SetScope("openid")
+	ti.SetCode("code-1")
+	ti.SetCodeCreateAt(time.Now())
+	ti.SetCodeExpiresIn(time.Minute)
+	ti.SetExtension(url.Values{extensionResource: {testResource, testOtherResource}})
+	if err := NewStorage(s.rdb, s.db).Create(ctx, ti); err != nil {
+		t.Fatalf("store code: %v", err)
+	}
+
+	tgr := tokenRequest(testResource)
+	tgr.Code = "code-1"
+	if err := s.validateTokenResources(ctx, oauth2.AuthorizationCode, tgr); err != nil {
+		t.Errorf("granted resource: %v", err)
+	}
+	tgr = tokenRequest("https://unknown.example.com")
+	tgr.Code = "code-1"
+	if err := s.validateTokenResources(ctx, oauth2.AuthorizationCode, tgr); !errors.Is(err, ErrInvalidTarget) {
+		t.Errorf("resource not granted error = %v, want %v", err, ErrInvalidTarget)
+	}
+
+	issued := models.NewToken()
+	resourceExtensionHandler(tokenRequest(testResource, testResource), issued)
+	if got := tokenResources(issued); !reflect.DeepEqual(got, []string{testResource}) {
+		t.Errorf("issued token resources = %v", got)
+	}
+	unrestricted := models.NewToken()
+	resourceExtensionHandler(tokenRequest(), unrestricted)
+	if got := tokenResources(unrestricted); got != nil {
+		t.Errorf("token without resource parameter restricted to %v", got)
+	}
+}
+
+func TestRefreshKeepsGrantedResources(t *testing.T) {
+	s := newResourceServer(t)
+	ctx := context.Background()
+	ti := models.NewToken()
+	ti.SetClientID(testClientID)
+	ti.SetUserID("1")
+	ti.SetScope("openid")
+	ti.SetAccess("access-1")
+	ti.SetAccessCreateAt(time.Now())
+	ti.SetAccessExpiresIn(time.Hour)
+	ti.SetRefresh("refresh-1")
+	ti.SetRefreshCreateAt(time.Now())
+	ti.SetRefreshExpiresIn(24 * time.Hour)
+	ti.SetExtension(url.Values{extensionResource: {testResource}})
+	if err := NewStorage(s.rdb, s.db).Create(ctx, ti); err != nil {
+		t.Fatalf("store token: %v", err)
+	}
+
+	tgr := tokenRequest(testResource)
+	tgr.Refresh = "refresh-1"
+	if err := s.validateTokenResources(ctx, oauth2.Refreshing, tgr); err != nil {
+		t.Errorf("granted resource: %v", err)
+	}
+	tgr = tokenRequest(testOtherResource)
+	tgr.Refresh = "refresh-1"
+	if err := s.validateTokenResources(ctx, oauth2.Refreshing, tgr); !errors.Is(err, ErrInvalidTarget) {
+		t.Errorf("resource not granted error = %v, want %v", err, ErrInvalidTarget)
+	}
+}
@@ -1,15 +1,17 @@
 package oauth2
 
 import (
+	"context"
 	"core-auth/config"
+	database "core-auth/db"
 	"log"
+	"net/http"
 	"time"
 
-	"github.com/go-oauth2/oauth2/v4/errors"
+	"github.com/go-oauth2/oauth2/v4"
 	"github.com/go-oauth2/oauth2/v4/generates"
 	"github.com/go-oauth2/oauth2/v4/manage"
 	"github.com/go-oauth2/oauth2/v4/server"
-	oredis "github.com/go-oauth2/redis/v4"
 	"github.com/go-redis/redis/v8"
 	"gorm.io/gorm"
 )
@@ -17,6 +19,18 @@ import (
 // Server wraps the oauth2 server with our configuration
 type Server struct {
 	*server.Server
+	rdb     *redis.Client
+	db      *gorm.DB
+	queries *database.OAuth2Queries
+	keys    *KeySet
+	config  *config.Config
+
+	issuerKeys *issuerKeySet
+
+	// Back-channel logout deliveries and the slots bounding the pending ones
+	logouts      chan backchannelLogout
+	logoutSlots  chan struct{}
+	logoutClient *http.Client
 }
 
 // NewServer creates a new OAuth2 server with Redis storage
@@ -28,38 +42,205 @@ func NewServer(rdb *redis.Client, db *gorm.DB) *Server {
 	// Create manager
 	manager := manage.NewDefaultManager()
 
-	// Use Redis token store
-	manager.MapTokenStorage(oredis.NewRedisStore(&redis.Options{
-		Addr:     config.Redis.Addr,
-		Password: config.Redis.Password,
-		DB:       config.Redis.DB,
-		DialTimeout: 10 * time.Second,
-		ReadTimeout: 30 * time.Second,
-		WriteTimeout: 30 * time.Second,
-	}))
-
-	// Set token configuration
+	// Use Redis and database backed client and token stores
+	storage := NewStorage(rdb, db)
+	manager.MapClientStorage(storage)
+	manager.MapTokenStorage(storage)
+	manager.SetValidateURIHandler(validateRedirectURI)
+
+	// Set token configuration, lifetimes are overridden per client by the token generator.
+	// Refresh tokens are never issued to the implicit and client credentials grants.
+	accessTokenExp := time.Duration(config.OAuth2Server.AccessTokenDuration) * time.Minute
+	refreshTokenExp := time.Duration(config.OAuth2Server.RefreshTokenDuration) * time.Hour
 	manager.SetAuthorizeCodeTokenCfg(&manage.Config{
-		AccessTokenExp:    time.Duration(config.OAuth2Server.AccessTokenDuration) * time.Minute,
-		RefreshTokenExp:   time.Duration(config.OAuth2Server.RefreshTokenDuration) * time.Hour,
+		AccessTokenExp:    accessTokenExp,
+		RefreshTokenExp:   refreshTokenExp,
 		IsGenerateRefresh: true,
 	})
+	manager.SetPasswordTokenCfg(&manage.Config{
+		AccessTokenExp:    accessTokenExp,
+		RefreshTokenExp:   refreshTokenExp,
+		IsGenerateRefresh: true,
+	})
+	manager.SetImplicitTokenCfg(&manage.Config{AccessTokenExp: accessTokenExp})
+	manager.SetClientTokenCfg(&manage.Config{AccessTokenExp: accessTokenExp})
+	manager.SetRefreshTokenCfg(&manage.RefreshingConfig{
+		IsGenerateRefresh:  true,
+		IsRemoveAccess:     true,
+		IsRemoveRefreshing: true,
+	})
 
-	// Set token generator
-	manager.MapAccessGenerate(generates.NewAccessGenerate())
+	// Set token generator, issuing opaque or JWT access tokens per client
+	queries := database.NewOAuth2Queries(db)
+	keys := NewKeySet(rdb, queries, config)
+	manager.MapAccessGenerate(newTokenGenerate(generates.NewAccessGenerate(), keys, config))
+
+	// Bind tokens to the DPoP proof key, restrict them to the requested resources
+	// and authorization details and tie them to the user's login session
+	manager.SetExtractExtensionHandler(func(tgr *oauth2.TokenGenerateRequest, ti oauth2.ExtendableTokenInfo) {
+		dpopExtensionHandler(tgr, ti)
+		resourceExtensionHandler(tgr, ti)
+		authorizationDetailsExtensionHandler(tgr, ti)
+		sessionExtensionHandler(tgr, ti)
+	})
 
 	// Create server
 	srv := server.NewDefaultServer(manager)
 	srv.SetAllowGetAccessRequest(true)
 	srv.SetClientInfoHandler(server.ClientFormHandler)
 
+	// Enable the configured grant and response types
+	grantTypes := make([]oauth2.GrantType, 0, len(config.OAuth2Server.GrantTypes))
+	for _, gt := range config.OAuth2Server.GrantTypes {
+		// No handler checks resource owner credentials, the password grant would always fail
+		if oauth2.GrantType(gt) == oauth2.PasswordCredentials {
+			log.Printf("Warning: the password grant is not supported, ignoring it in OAUTH2_GRANT_TYPES")
+			continue
+		}
+		grantTypes = append(grantTypes, oauth2.GrantType(gt))
+	}
+	srv.SetAllowedGrantType(grantTypes...)
+
+	responseTypes := make([]oauth2.ResponseType, 0, len(config.OAuth2Server.ResponseTypes))
+	for _, rt := range config.OAuth2Server.ResponseTypes {
+		responseTypes = append(responseTypes, oauth2.ResponseType(rt))
+	}
+	srv.SetAllowedResponseType(responseTypes...)
+
+	s := &Server{
+		Server:  srv,
+		rdb:     rdb,
+		db:      db,
+		queries: queries,
+		keys:    keys,
+		config:  config,
+
+		issuerKeys: newIssuerKeySet(),
+	}
+	s.startBackchannelLogout()
+
+	// Users approve authorization requests at the consent page
+	srv.SetUserAuthorizationHandler(s.userAuthorizationHandler)
+
 	// Set error handlers
-	srv.SetInternalErrorHandler(func(err error) (re *errors.Response) {
-		return
-	})
+	srv.SetInternalErrorHandler(internalErrorHandler)
+	srv.SetResponseErrorHandler(s.responseErrorHandler)
+	srv.SetPreRedirectErrorHandler(s.redirectError)
 
-	srv.SetResponseErrorHandler(func(re *errors.Response) {
-	})
+	return s
+}
+
+// HandleAuthorizeRequest resolves pushed requests and request objects and validates the
+// authorization request before delegating to the oauth2 server, so that validation errors
+// reach the client
+func (s *Server) HandleAuthorizeRequest(w http.ResponseWriter, r *http.Request) error {
+	signed, err := s.resolvePushedAuthorizationRequest(r)
+	if err != nil {
+		return err
+	}
+	if !signed {
+		if signed, err = s.resolveRequestObject(r); err != nil {
+			return err
+		}
+	}
+	if !signed && s.requestObjectRequired(r.Form.Get("client_id")) {
+		return ErrRequestObjectRequired
+	}
+
+	if _, err := s.validateAuthorizeRequest(r); err != nil {
+		return s.redirectError(w, &server.AuthorizeRequest{
+			ResponseType: oauth2.ResponseType(r.FormValue("response_type")),
+			ClientID:     r.FormValue("client_id"),
+			RedirectURI:  r.FormValue("redirect_uri"),
+			State:        r.FormValue("state"),
+			Request:      r,
+		}, err)
+	}
 
-	return &Server{srv}
-} 
\ No newline at end of file
+	return s.Server.HandleAuthorizeRequest(w, r)
+} 
+
+// validateAuthorizeRequest validates an authorization request, including its resource
+// indicators and authorization details
+func (s *Server) validateAuthorizeRequest(r *http.Request) (*server.AuthorizeRequest, error) {
+	req, err := s.ValidationAuthorizeRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if resources := requestedResources(r.Form); len(resources) > 0 {
+		if err := s.validateResources(resources, req.Scope); err != nil {
+			return req, err
+		}
+	}
+
+	if raw := r.Form.Get("authorization_details"); raw != "" {
+		details, err := s.validateAuthorizationDetails(req.ClientID, raw)
+		if err != nil {
+			return req, err
+		}
+		r.Form.Set("authorization_details", details)
+	}
+	return req, nil
+}
+
+// HandleTokenRequest verifies the DPoP proof, if any, before issuing tokens so that
+// they are bound to the proof key (RFC 9449 section 5)
+func (s *Server) HandleTokenRequest(w http.ResponseWriter, r *http.Request) error {
+	var jkt string
+	if s.DPoPEnabled() && r.Header.Get("DPoP") != "" {
+		var err error
+		if jkt, err = s.verifyDPoPProof(r, ""); err != nil {
+			s.setDPoPNonce(w, r, err)
+			return s.WriteError(w, err)
+		}
+		r = r.WithContext(context.WithValue(r.Context(), dpopJKTContextKey, jkt))
+	}
+
+	var gt oauth2.GrantType
+	var tgr *oauth2.TokenGenerateRequest
+	var err error
+	if oauth2.GrantType(r.FormValue("grant_type")) == GrantTypeJWTBearer {
+		gt = GrantTypeJWTBearer
+		tgr, err = s.validateJWTBearerRequest(r)
+	} else {
+		gt, tgr, err = s.ValidationTokenRequest(r)
+	}
+	if err != nil {
+		return s.WriteError(w, err)
+	}
+	if err := s.validateTokenResources(r.Context(), gt, tgr); err != nil {
+		return s.WriteError(w, err)
+	}
+	if err := s.validateTokenAuthorizationDetails(gt, tgr); err != nil {
+		return s.WriteError(w, err)
+	}
+
+	// A DPoP-bound refresh token can only be used with a proof for the same key
+	if gt == oauth2.Refreshing {
+		if rti, err := s.Manager.LoadRefreshToken(r.Context(), tgr.Refresh); err == nil {
+			if bound := tokenJKT(rti); bound != "" && bound != jkt {
+				return s.WriteError(w, ErrInvalidDPoPProof)
+			}
+		}
+	}
+
+	var ti oauth2.TokenInfo
+	if gt == GrantTypeJWTBearer {
+		ti, err = s.issueJWTBearerToken(r.Context(), tgr)
+	} else {
+		ti, err = s.GetAccessToken(r.Context(), gt, tgr)
+	}
+	if err != nil {
+		return s.WriteError(w, err)
+	}
+
+	data := s.GetTokenData(ti)
+	if tokenJKT(ti) != "" {
+		data["token_type"] = "DPoP"
+	}
+	if details := tokenAuthorizationDetails(ti); details != nil {
+		data["authorization_details"] = details
+	}
+	return writeJSON(w, data, nil, http.StatusOK)
+}
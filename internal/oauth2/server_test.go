@@ -0,0 +1,21 @@
+package oauth2
+
+import (
+	"core-auth/internal/testutil"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+const (
+	testClientID    = "client"
+	testRedirectURI = "https://app.example.com/callback"
+)
+
+// newTestServer returns a server backed by an empty database and an in-memory Redis
+func newTestServer(t *testing.T) (*Server, *miniredis.Miniredis) {
+	t.Helper()
+	testutil.SetSecrets(t)
+	rdb, redisServer := testutil.NewRedis(t)
+	return NewServer(rdb, testutil.NewDB(t)), redisServer
+}
@@ -5,9 +5,11 @@ import (
 	database "core-auth/db"
 	"encoding/json"
 	"errors"
+	"strconv"
 	"time"
 
 	"github.com/go-oauth2/oauth2/v4"
+	oerrors "github.com/go-oauth2/oauth2/v4/errors"
 	"github.com/go-oauth2/oauth2/v4/models"
 	"github.com/go-redis/redis/v8"
 	"gorm.io/gorm"
@@ -15,28 +17,44 @@ import (
 
 // Storage implements the oauth2.Server.Storage interface
 type Storage struct {
-	rdb    *redis.Client
-	db     *gorm.DB
-	ctx    context.Context
+	rdb     *redis.Client
+	db      *gorm.DB
+	queries *database.OAuth2Queries
+	ctx     context.Context
 }
 
 // NewStorage creates a new OAuth2 storage implementation
 func NewStorage(rdb *redis.Client, db *gorm.DB) *Storage {
 	return &Storage{
-		rdb: rdb,
-		db:  db,
-		ctx: context.Background(),
+		rdb:     rdb,
+		db:      db,
+		queries: database.NewOAuth2Queries(db),
+		ctx:     context.Background(),
 	}
 }
 
+// clientCacheKey returns the Redis key of the current version of a cached client. Changing
+// a client bumps its version, so that an old row read before the change and cached after it
+// is never served. The key is empty when the version is unknown.
+func (s *Storage) clientCacheKey(clientID string) string {
+	version, err := s.rdb.Get(s.ctx, redisClientVersionPrefix+clientID).Int64()
+	if err != nil && err != redis.Nil {
+		return ""
+	}
+	return redisClientPrefix + clientID + ":" + strconv.FormatInt(version, 10)
+}
+
 // GetClient implements oauth2.Server.Storage interface
 func (s *Storage) GetClient(clientID string) (oauth2.ClientInfo, error) {
 	// Try Redis first if available
+	key := ""
 	if s.rdb != nil {
-		key := redisClientPrefix + clientID
+		key = s.clientCacheKey(clientID)
+	}
+	if key != "" {
 		data, err := s.rdb.Get(s.ctx, key).Bytes()
 		if err == nil {
-			var client models.Client
+			var client Client
 			if err := json.Unmarshal(data, &client); err == nil {
 				return &client, nil
 			}
@@ -47,24 +65,36 @@ func (s *Storage) GetClient(clientID string) (oauth2.ClientInfo, error) {
 	var client database.OAuth2Client
 	if err := s.db.Where("client_id = ? AND is_active = ?", clientID, true).First(&client).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("client not found")
+			return nil, oerrors.ErrInvalidClient
 		}
 		return nil, err
 	}
 
 	// Convert to oauth2.ClientInfo
-	clientInfo := &models.Client{
-		ID:     client.ClientID,
-		Secret: client.ClientSecret,
-		Domain: client.RedirectURIs,
-		UserID: "",
+	clientInfo := &Client{
+		Client: models.Client{
+			ID:     client.ClientID,
+			Secret: client.ClientSecret,
+			Domain: client.RedirectURIs,
+			UserID: "",
+		},
+		TokenPolicy: TokenPolicy{
+			AccessTokenLifetime:    time.Duration(client.AccessTokenLifetime) * time.Second,
+			RefreshTokenLifetime:   time.Duration(client.RefreshTokenLifetime) * time.Second,
+			RefreshSessionLifetime: time.Duration(client.RefreshSessionLifetime) * time.Second,
+			SlidingRefreshExpiry:   client.SlidingRefreshExpiry,
+			IssueRefreshTokens:     client.IssueRefreshTokens == nil || *client.IssueRefreshTokens,
+			AccessTokenFormat:      client.AccessTokenFormat,
+		},
+		SubjectType:      client.SubjectType,
+		SectorIdentifier: sectorIdentifier(&client),
+		Internal:         client.Internal,
 	}
 
 	// Cache in Redis if available
-	if s.rdb != nil {
+	if key != "" {
 		data, err := json.Marshal(clientInfo)
 		if err == nil {
-			key := redisClientPrefix + clientID
 			s.rdb.Set(s.ctx, key, data, 24*time.Hour)
 		}
 	}
@@ -72,6 +102,20 @@ func (s *Storage) GetClient(clientID string) (oauth2.ClientInfo, error) {
 	return clientInfo, nil
 }
 
+// GetByID implements oauth2.ClientStore interface
+func (s *Storage) GetByID(ctx context.Context, id string) (oauth2.ClientInfo, error) {
+	cli, err := s.GetClient(id)
+	if err != nil {
+		return nil, err
+	}
+	if assertionClientID, _ := ctx.Value(assertionClientContextKey).(string); assertionClientID == id {
+		client := *cli.(*Client)
+		client.assertionAuthenticated = true
+		return &client, nil
+	}
+	return cli, nil
+}
+
 // SaveAuthorize implements oauth2.Server.Storage interface
 func (s *Storage) SaveAuthorize(data *authorizeData) error {
 	auth := &database.OAuth2Authorization{
@@ -229,6 +273,20 @@ func (s *Storage) GetRefresh(token string) (*database.OAuth2Token, error) {
 }
 
 // helper functions
+// validateRedirectURI checks redirectURI against the JSON array of URIs registered for the client
+func validateRedirectURI(registered string, redirectURI string) error {
+	var uris []string
+	if err := json.Unmarshal([]byte(registered), &uris); err != nil {
+		return oerrors.ErrInvalidRedirectURI
+	}
+	for _, uri := range uris {
+		if uri == redirectURI {
+			return nil
+		}
+	}
+	return oerrors.ErrInvalidRedirectURI
+}
+
 func (s *Storage) convertToAuthorizeData(auth *database.OAuth2Authorization) (*authorizeData, error) {
 	client, err := s.GetClient(auth.ClientID)
 	if err != nil {
@@ -0,0 +1,63 @@
+package oauth2
+
+import (
+	database "core-auth/db"
+	"core-auth/internal/testutil"
+	"testing"
+)
+
+func TestClientIssueRefreshTokens(t *testing.T) {
+	disabled := false
+	tests := []struct {
+		name  string
+		value *bool
+		want  bool
+	}{
+		{"default", nil, true},
+		{"disabled", &disabled, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rdb, _ := testutil.NewRedis(t)
+			storage := NewStorage(rdb, testutil.NewDB(t))
+			client := &database.OAuth2Client{
+				ClientID:           "client-" + tt.name,
+				IsActive:           true,
+				IssueRefreshTokens: tt.value,
+			}
+			if err := storage.queries.CreateClient(client); err != nil {
+				t.Fatalf("create client: %v", err)
+			}
+
+			// The first lookup reads the database, the second the Redis cache
+			for i := 0; i < 2; i++ {
+				info, err := storage.GetClient(client.ClientID)
+				if err != nil {
+					t.Fatalf("get client: %v", err)
+				}
+				if got := info.(*Client).TokenPolicy.IssueRefreshTokens; got != tt.want {
+					t.Errorf("lookup %d: IssueRefreshTokens = %v, want %v", i+1, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestClientCacheIgnoresUnversionedEntries(t *testing.T) {
+	rdb, server := testutil.NewRedis(t)
+	storage := NewStorage(rdb, testutil.NewDB(t))
+	client := &database.OAuth2Client{ClientID: "client", IsActive: true}
+	if err := storage.queries.CreateClient(client); err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	// Cached before the token policy had the flag, it would disable refresh tokens
+	server.Set("oauth2:client:client", `{"ID":"client","TokenPolicy":{}}`)
+
+	info, err := storage.GetClient("client")
+	if err != nil {
+		t.Fatalf("get client: %v", err)
+	}
+	if !info.(*Client).TokenPolicy.IssueRefreshTokens {
+		t.Error("client read from an unversioned cache entry")
+	}
+}
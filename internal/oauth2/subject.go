@@ -0,0 +1,80 @@
+package oauth2
+
+import (
+	"context"
+	database "core-auth/db"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+
+	"github.com/go-oauth2/oauth2/v4"
+)
+
+// Subject identifier types (OpenID Connect Core section 8)
+const (
+	SubjectTypePublic   = "public"
+	SubjectTypePairwise = "pairwise"
+)
+
+// subjectIdentifier returns the subject a client sees for a user: the user ID for public
+// clients, a value derived from the client's sector for pairwise clients so that clients of
+// different sectors cannot correlate users (OpenID Connect Core section 8.1). The pairwise
+// value is an HMAC keyed by the salt, the NUL delimiter keeps sector and user apart.
+func subjectIdentifier(cli oauth2.ClientInfo, userID string, salt string) string {
+	client, ok := cli.(*Client)
+	if !ok || userID == "" || client.SubjectType != SubjectTypePairwise {
+		return userID
+	}
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(client.SectorIdentifier))
+	mac.Write([]byte{0})
+	mac.Write([]byte(userID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// subject returns the subject a client sees for a user, empty when the client is unknown
+func (s *Server) subject(ctx context.Context, clientID string, userID string) string {
+	cli, err := s.Manager.GetClient(ctx, clientID)
+	if err != nil {
+		return ""
+	}
+	return subjectIdentifier(cli, userID, s.config.OAuth2Server.PairwiseSalt)
+}
+
+// RedirectHosts returns the distinct hosts of a client's redirect URIs. Pairwise clients
+// redirecting to several hosts must register a sector identifier (OpenID Connect Core
+// section 8.1).
+func RedirectHosts(uris []string) []string {
+	seen := make(map[string]bool)
+	var hosts []string
+	for _, uri := range uris {
+		u, err := url.Parse(uri)
+		if err != nil || seen[u.Hostname()] {
+			continue
+		}
+		seen[u.Hostname()] = true
+		hosts = append(hosts, u.Hostname())
+	}
+	return hosts
+}
+
+// sectorIdentifier resolves the sector of a client: the host of its sector identifier, or of
+// its redirect URIs when none is registered. Clients without either form their own sector.
+func sectorIdentifier(client *database.OAuth2Client) string {
+	if client.SectorIdentifier != "" {
+		if u, err := url.Parse(client.SectorIdentifier); err == nil && u.Host != "" {
+			return u.Hostname()
+		}
+		return client.SectorIdentifier
+	}
+
+	var uris []string
+	if err := json.Unmarshal([]byte(client.RedirectURIs), &uris); err == nil && len(uris) > 0 {
+		if u, err := url.Parse(uris[0]); err == nil && u.Host != "" {
+			return u.Hostname()
+		}
+	}
+	return client.ClientID
+}
@@ -0,0 +1,44 @@
+package oauth2
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPairwiseSubjects(t *testing.T) {
+	client := func(sector string) *Client {
+		return &Client{SubjectType: SubjectTypePairwise, SectorIdentifier: sector}
+	}
+	subject := subjectIdentifier(client("app.example.com"), "42", "salt")
+
+	if subject == "42" {
+		t.Fatal("pairwise subject is the user ID")
+	}
+	if got := subjectIdentifier(client("app.example.com"), "42", "salt"); got != subject {
+		t.Error("pairwise subject is not stable")
+	}
+	if got := subjectIdentifier(client("other.example.com"), "42", "salt"); got == subject {
+		t.Error("sectors share a pairwise subject")
+	}
+	if got := subjectIdentifier(client("app.example.com"), "42", "other-salt"); got == subject {
+		t.Error("pairwise subject does not depend on the salt")
+	}
+	// Without a delimiter, moving characters between sector and user would collide
+	if subjectIdentifier(client("a.example.com1"), "2", "salt") == subjectIdentifier(client("a.example.com"), "12", "salt") {
+		t.Error("sector and user ID are not delimited")
+	}
+	if got := subjectIdentifier(&Client{SubjectType: SubjectTypePublic}, "42", "salt"); got != "42" {
+		t.Errorf("public subject = %q, want the user ID", got)
+	}
+}
+
+func TestRedirectHosts(t *testing.T) {
+	got := RedirectHosts([]string{
+		"https://app.example.com/callback",
+		"https://app.example.com/other",
+		"https://admin.example.com/callback",
+	})
+	if want := []string{"app.example.com", "admin.example.com"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("RedirectHosts = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,330 @@
+package oauth2
+
+import (
+	"context"
+	database "core-auth/db"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	oerrors "github.com/go-oauth2/oauth2/v4/errors"
+	"github.com/go-oauth2/oauth2/v4/models"
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// extensionAuthorizationID is the token extension holding the authorization the token was issued from
+const extensionAuthorizationID = "authorization_id"
+
+// extensionRedeemCode marks the first token issued from an authorization code, whose creation
+// consumes the code. It is removed before the token is stored.
+const extensionRedeemCode = "redeem_code"
+
+// Create implements oauth2.TokenStore interface
+func (s *Storage) Create(ctx context.Context, info oauth2.TokenInfo) error {
+	redeem, err := s.redeemCode(info)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	// Authorization code
+	if info.GetCode() != "" && info.GetAccess() == "" {
+		auth := &database.OAuth2Authorization{
+			Code:        info.GetCode(),
+			ClientID:    info.GetClientID(),
+			UserID:      parseUserID(info.GetUserID()),
+			RedirectURI: info.GetRedirectURI(),
+			Scope:       info.GetScope(),
+			ExpiresAt:   expiresAt(info.GetCodeCreateAt(), info.GetCodeExpiresIn()),
+			SessionID:   tokenSessionID(info),
+			Data:        string(data),
+
+			AuthorizationDetails: string(tokenAuthorizationDetails(info)),
+		}
+		if err := s.db.Create(auth).Error; err != nil {
+			return err
+		}
+		s.cache(ctx, redisAuthCodePrefix+auth.Code, auth, auth.ExpiresAt)
+		return nil
+	}
+
+	token := &database.OAuth2Token{
+		AccessToken:     accessTokenKey(info.GetAccess()),
+		RefreshToken:    info.GetRefresh(),
+		ClientID:        info.GetClientID(),
+		UserID:          parseUserID(info.GetUserID()),
+		Scope:           info.GetScope(),
+		AccessExpiresAt: expiresAt(info.GetAccessCreateAt(), info.GetAccessExpiresIn()),
+		AuthorizationID: authorizationID(info),
+		SessionID:       tokenSessionID(info),
+		Data:            string(data),
+
+		AuthorizationDetails: string(tokenAuthorizationDetails(info)),
+	}
+	if token.RefreshToken != "" {
+		refreshExpiresAt := expiresAt(info.GetRefreshCreateAt(), info.GetRefreshExpiresIn())
+		token.RefreshExpiresAt = &refreshExpiresAt
+	}
+	if redeem != nil {
+		if err := s.consumeCode(ctx, redeem); err != nil {
+			return err
+		}
+	}
+	if err := s.db.Create(token).Error; err != nil {
+		return err
+	}
+
+	// Checked after the insert so that a concurrent replay revokes this token as well
+	if token.AuthorizationID != nil {
+		revoked, err := s.queries.IsAuthorizationRevoked(*token.AuthorizationID)
+		if err != nil {
+			return err
+		}
+		if revoked {
+			s.removeToken(ctx, token)
+			return oerrors.ErrInvalidGrant
+		}
+	}
+
+	s.cache(ctx, redisAccessTokenPrefix+token.AccessToken, token, token.AccessExpiresAt)
+	if token.RefreshToken != "" {
+		s.cache(ctx, redisRefreshTokenPrefix+token.RefreshToken, token, *token.RefreshExpiresAt)
+	}
+	return nil
+}
+
+// GetByCode implements oauth2.TokenStore interface. The code is only looked up here: the
+// manager validates the client, redirect URI and PKCE verifier afterwards, and the code is
+// consumed when the token issued from it is created, so a failed redemption does not burn it.
+// Presenting a used code again revokes every token issued from it (RFC 6749 section 4.1.2).
+func (s *Storage) GetByCode(ctx context.Context, code string) (oauth2.TokenInfo, error) {
+	auth, err := s.queries.FindAuthorizationCode(code)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if auth.Used {
+		s.revokeAuthorization(ctx, auth)
+		return nil, nil
+	}
+
+	ti, err := tokenInfo(auth.Data)
+	if err != nil {
+		return nil, err
+	}
+	ext := ti.GetExtension()
+	if ext == nil {
+		ext = url.Values{}
+	}
+	ext.Set(extensionAuthorizationID, strconv.FormatUint(uint64(auth.ID), 10))
+	ext.Set(extensionRedeemCode, "1")
+	ti.SetExtension(ext)
+	return ti, nil
+}
+
+// RemoveByCode implements oauth2.TokenStore interface. The manager calls it before the PKCE
+// verifier is checked, so the code is only evicted from Redis here and consumed by Create.
+func (s *Storage) RemoveByCode(ctx context.Context, code string) error {
+	if s.rdb != nil {
+		s.rdb.Del(ctx, redisAuthCodePrefix+code)
+	}
+	return nil
+}
+
+// redeemCode returns the authorization a token is the first one issued from, removing the marker
+// so that tokens refreshed from it do not redeem the code again
+func (s *Storage) redeemCode(info oauth2.TokenInfo) (*uint, error) {
+	eti, ok := info.(oauth2.ExtendableTokenInfo)
+	if !ok || eti.GetExtension() == nil || eti.GetExtension().Get(extensionRedeemCode) == "" {
+		return nil, nil
+	}
+	eti.GetExtension().Del(extensionRedeemCode)
+	authID := authorizationID(info)
+	if authID == nil {
+		return nil, oerrors.ErrInvalidAuthorizeCode
+	}
+	return authID, nil
+}
+
+// consumeCode marks the authorization code a token is issued from as used. Only one concurrent
+// redemption succeeds, the others are treated as a replay.
+func (s *Storage) consumeCode(ctx context.Context, authorizationID *uint) error {
+	err := s.queries.ConsumeAuthorization(*authorizationID)
+	if errors.Is(err, database.ErrAuthorizationCodeUsed) {
+		var auth database.OAuth2Authorization
+		if err := s.db.First(&auth, *authorizationID).Error; err == nil {
+			s.revokeAuthorization(ctx, &auth)
+		}
+		return oerrors.ErrInvalidAuthorizeCode
+	}
+	return err
+}
+
+// RemoveByAccess implements oauth2.TokenStore interface
+func (s *Storage) RemoveByAccess(ctx context.Context, access string) error {
+	var token database.OAuth2Token
+	if err := s.db.Where("access_token = ?", accessTokenKey(access)).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	return s.removeToken(ctx, &token)
+}
+
+// RemoveByRefresh implements oauth2.TokenStore interface
+func (s *Storage) RemoveByRefresh(ctx context.Context, refresh string) error {
+	var token database.OAuth2Token
+	if err := s.db.Where("refresh_token = ?", refresh).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	return s.removeToken(ctx, &token)
+}
+
+// GetByAccess implements oauth2.TokenStore interface
+func (s *Storage) GetByAccess(ctx context.Context, access string) (oauth2.TokenInfo, error) {
+	key := accessTokenKey(access)
+	return s.getToken(ctx, redisAccessTokenPrefix+key, "access_token = ?", key)
+}
+
+// GetByRefresh implements oauth2.TokenStore interface
+func (s *Storage) GetByRefresh(ctx context.Context, refresh string) (oauth2.TokenInfo, error) {
+	return s.getToken(ctx, redisRefreshTokenPrefix+refresh, "refresh_token = ?", refresh)
+}
+
+// getToken loads a token from Redis, falling back to the database
+func (s *Storage) getToken(ctx context.Context, key string, query string, value string) (oauth2.TokenInfo, error) {
+	var token database.OAuth2Token
+	if s.rdb != nil {
+		if data, err := s.rdb.Get(ctx, key).Bytes(); err == nil {
+			if err := json.Unmarshal(data, &token); err == nil {
+				return tokenInfo(token.Data)
+			}
+		}
+	}
+
+	if err := s.db.Where(query, value).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return tokenInfo(token.Data)
+}
+
+// removeToken deletes a token from the database and evicts it from Redis
+func (s *Storage) removeToken(ctx context.Context, token *database.OAuth2Token) error {
+	if s.rdb != nil {
+		keys := []string{redisAccessTokenPrefix + token.AccessToken}
+		if token.RefreshToken != "" {
+			keys = append(keys, redisRefreshTokenPrefix+token.RefreshToken)
+		}
+		s.rdb.Del(ctx, keys...)
+	}
+	return s.db.Delete(token).Error
+}
+
+// revokeAuthorization revokes the tokens issued from a replayed authorization code
+func (s *Storage) revokeAuthorization(ctx context.Context, auth *database.OAuth2Authorization) {
+	tokens, err := s.queries.RevokeAuthorization(auth.ID)
+	if err != nil {
+		log.Printf("Failed to revoke tokens of replayed authorization code for client %s: %v", auth.ClientID, err)
+		return
+	}
+
+	evictTokens(ctx, s.rdb, tokens)
+	log.Printf("Authorization code replay detected for client %s, revoked %d tokens", auth.ClientID, len(tokens))
+}
+
+// evictTokens removes revoked tokens from the Redis cache
+func evictTokens(ctx context.Context, rdb *redis.Client, tokens []database.OAuth2Token) {
+	if rdb == nil {
+		return
+	}
+	for _, token := range tokens {
+		keys := []string{redisAccessTokenPrefix + token.AccessToken}
+		if token.RefreshToken != "" {
+			keys = append(keys, redisRefreshTokenPrefix+token.RefreshToken)
+		}
+		rdb.Del(ctx, keys...)
+	}
+}
+
+// cache stores value in Redis until expiry
+func (s *Storage) cache(ctx context.Context, key string, value interface{}, expiry time.Time) {
+	if s.rdb == nil {
+		return
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	if ttl := time.Until(expiry); ttl > 0 {
+		s.rdb.SetEX(ctx, key, data, ttl)
+	}
+}
+
+// tokenInfo decodes the token info stored with a code or token
+func tokenInfo(data string) (*models.Token, error) {
+	ti := models.NewToken()
+	if err := json.Unmarshal([]byte(data), ti); err != nil {
+		return nil, err
+	}
+	return ti, nil
+}
+
+// authorizationID returns the authorization a token was issued from, if any
+func authorizationID(ti oauth2.TokenInfo) *uint {
+	eti, ok := ti.(oauth2.ExtendableTokenInfo)
+	if !ok || eti.GetExtension() == nil {
+		return nil
+	}
+	id, err := strconv.ParseUint(eti.GetExtension().Get(extensionAuthorizationID), 10, 64)
+	if err != nil {
+		return nil
+	}
+	authID := uint(id)
+	return &authID
+}
+
+// parseUserID converts an oauth2 user ID to a database user ID, 0 for client-only tokens
+func parseUserID(userID string) uint {
+	id, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return uint(id)
+}
+
+// expiresAt returns the expiry time of a token, a zero duration never expires
+func expiresAt(createdAt time.Time, expiresIn time.Duration) time.Time {
+	if expiresIn == 0 {
+		return createdAt.AddDate(100, 0, 0)
+	}
+	return createdAt.Add(expiresIn)
+}
+
+// accessTokenKey returns the value a token is stored under. JWT access tokens are
+// stored by their SHA-256 hash as they do not fit the token columns.
+func accessTokenKey(access string) string {
+	if len(access) <= 100 {
+		return access
+	}
+	sum := sha256.Sum256([]byte(access))
+	return "sha256:" + base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,169 @@
+package oauth2
+
+import (
+	"context"
+	database "core-auth/db"
+	"core-auth/internal/testutil"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	oerrors "github.com/go-oauth2/oauth2/v4/errors"
+	"github.com/go-oauth2/oauth2/v4/manage"
+	"github.com/go-oauth2/oauth2/v4/models"
+)
+
+// testVerifier is the PKCE code verifier of the codes issued by issueCode
+const testVerifier = "dBjftJeZ4CVP-mJ0tqjN1YwGmPt6GJfJgrJ3BzRcJtM"
+
+// newCodeManager returns a manager redeeming codes from a fresh token store
+func newCodeManager(t *testing.T) (*manage.Manager, *Storage) {
+	t.Helper()
+	rdb, _ := testutil.NewRedis(t)
+	storage := NewStorage(rdb, testutil.NewDB(t))
+
+	manager := manage.NewDefaultManager()
+	manager.MapTokenStorage(storage)
+	manager.MapClientStorage(testClients{testClientID: &models.Client{ID: testClientID, Domain: testRedirectURI}})
+	return manager, storage
+}
+
+// testClients is an in-memory client store
+type testClients map[string]oauth2.ClientInfo
+
+// GetByID implements oauth2.ClientStore interface
+func (c testClients) GetByID(ctx context.Context, id string) (oauth2.ClientInfo, error) {
+	if cli, ok := c[id]; ok {
+		return cli, nil
+	}
+	return nil, oerrors.ErrInvalidClient
+}
+
+// issueCode stores an authorization code bound to the PKCE verifier testVerifier
+func issueCode(t *testing.T, storage *Storage, code string) {
+	t.Helper()
+	sum := sha256.Sum256([]byte(testVerifier))
+	ti := models.NewToken()
+	ti.SetClientID(testClientID)
+	ti.SetUserID("1")
+	ti.SetRedirectURI(testRedirectURI)
+	ti.SetScope("openid")
+	ti.SetCode(code)
+	ti.SetCodeCreateAt(time.Now())
+	ti.SetCodeExpiresIn(time.Minute)
+	ti.SetCodeChallenge(base64.RawURLEncoding.EncodeToString(sum[:]))
+	ti.SetCodeChallengeMethod(oauth2.CodeChallengeS256)
+	if err := storage.Create(context.Background(), ti); err != nil {
+		t.Fatalf("store code: %v", err)
+	}
+}
+
+// redeem exchanges a code for a token
+func redeem(manager *manage.Manager, code string, verifier string) (oauth2.TokenInfo, error) {
+	return manager.GenerateAccessToken(context.Background(), oauth2.AuthorizationCode, &oauth2.TokenGenerateRequest{
+		ClientID:     testClientID,
+		RedirectURI:  testRedirectURI,
+		Code:         code,
+		CodeVerifier: verifier,
+	})
+}
+
+func TestCodeRedeemsOnce(t *testing.T) {
+	manager, storage := newCodeManager(t)
+	issueCode(t, storage, "code-1")
+
+	ti, err := redeem(manager, "code-1", testVerifier)
+	if err != nil {
+		t.Fatalf("redeem: %v", err)
+	}
+	if ext := ti.(oauth2.ExtendableTokenInfo).GetExtension(); ext.Get(extensionRedeemCode) != "" {
+		t.Error("issued token kept the redeem marker")
+	}
+
+	auth, err := storage.queries.FindAuthorizationCode("code-1")
+	if err != nil {
+		t.Fatalf("find code: %v", err)
+	}
+	if !auth.Used {
+		t.Error("code not marked used after redemption")
+	}
+}
+
+func TestCodeReplayRevokesTokens(t *testing.T) {
+	manager, storage := newCodeManager(t)
+	issueCode(t, storage, "code-1")
+
+	ti, err := redeem(manager, "code-1", testVerifier)
+	if err != nil {
+		t.Fatalf("redeem: %v", err)
+	}
+	if _, err := redeem(manager, "code-1", testVerifier); !errors.Is(err, oerrors.ErrInvalidAuthorizeCode) {
+		t.Fatalf("replay error = %v, want %v", err, oerrors.ErrInvalidAuthorizeCode)
+	}
+
+	got, err := storage.GetByAccess(context.Background(), ti.GetAccess())
+	if err != nil {
+		t.Fatalf("get token: %v", err)
+	}
+	if got != nil {
+		t.Error("token issued from the replayed code is still valid")
+	}
+}
+
+func TestCodeSurvivesFailedPKCE(t *testing.T) {
+	manager, storage := newCodeManager(t)
+	issueCode(t, storage, "code-1")
+
+	if _, err := redeem(manager, "code-1", "wrong-verifier-wrong-verifier-wrong-verifier"); !errors.Is(err, oerrors.ErrInvalidCodeChallenge) {
+		t.Fatalf("wrong verifier error = %v, want %v", err, oerrors.ErrInvalidCodeChallenge)
+	}
+	if _, err := redeem(manager, "code-1", testVerifier); err != nil {
+		t.Fatalf("redeem after failed verifier: %v", err)
+	}
+}
+
+func TestCodeSurvivesWrongRedirectURI(t *testing.T) {
+	manager, storage := newCodeManager(t)
+	issueCode(t, storage, "code-1")
+
+	_, err := manager.GenerateAccessToken(context.Background(), oauth2.AuthorizationCode, &oauth2.TokenGenerateRequest{
+		ClientID:     testClientID,
+		RedirectURI:  testRedirectURI + "/other",
+		Code:         "code-1",
+		CodeVerifier: testVerifier,
+	})
+	if err == nil {
+		t.Fatal("redeemed with a different redirect URI")
+	}
+	if _, err := redeem(manager, "code-1", testVerifier); err != nil {
+		t.Fatalf("redeem after wrong redirect URI: %v", err)
+	}
+}
+
+func TestRefreshDoesNotRedeemCode(t *testing.T) {
+	manager, storage := newCodeManager(t)
+	issueCode(t, storage, "code-1")
+
+	ti, err := redeem(manager, "code-1", testVerifier)
+	if err != nil {
+		t.Fatalf("redeem: %v", err)
+	}
+	if ti.GetRefresh() == "" {
+		t.Skip("manager issued no refresh token")
+	}
+	if _, err := manager.RefreshAccessToken(context.Background(), &oauth2.TokenGenerateRequest{
+		ClientID: testClientID,
+		Refresh:  ti.GetRefresh(),
+	}); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+
+	var tokens int64
+	storage.db.Model(&database.OAuth2Token{}).Count(&tokens)
+	if tokens == 0 {
+		t.Error("refresh revoked the authorization")
+	}
+}
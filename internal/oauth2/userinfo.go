@@ -0,0 +1,35 @@
+package oauth2
+
+import (
+	"context"
+	database "core-auth/db"
+	"strings"
+
+	"github.com/go-oauth2/oauth2/v4"
+)
+
+// UserInfo returns the claims about the user of an access token (OpenID Connect Core
+// section 5.3), limited to the scopes granted. The subject is the one the client sees.
+func (s *Server) UserInfo(ctx context.Context, ti oauth2.TokenInfo) (map[string]interface{}, error) {
+	userID := parseUserID(ti.GetUserID())
+	if userID == 0 {
+		return nil, ErrInvalidToken
+	}
+	user, err := database.GetUserByID(s.db, userID)
+	if err != nil || !user.IsActive {
+		return nil, ErrInvalidToken
+	}
+
+	claims := map[string]interface{}{
+		"sub": s.subject(ctx, ti.GetClientID(), ti.GetUserID()),
+	}
+	scopes := strings.Fields(ti.GetScope())
+	if contains(scopes, "profile") {
+		claims["preferred_username"] = user.Username
+		claims["updated_at"] = user.UpdatedAt.Unix()
+	}
+	if contains(scopes, "email") {
+		claims["email"] = user.Email
+	}
+	return claims, nil
+}
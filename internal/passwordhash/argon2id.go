@@ -0,0 +1,90 @@
+package passwordhash
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// argon2idPrefix starts argon2id hashes in the PHC string format
+const argon2idPrefix = "$argon2id$"
+
+// maxArgon2idMemory bounds the memory, in KiB, of the hashes verified
+const maxArgon2idMemory = 1 << 20
+
+// argon2idScheme hashes with argon2id (RFC 9106) into PHC strings:
+// $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>
+type argon2idScheme struct {
+	time       uint32
+	memory     uint32
+	threads    uint8
+	keyLength  uint32
+	saltLength int
+}
+
+// argon2idParams are the parameters of an encoded argon2id hash
+type argon2idParams struct {
+	version int
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func (s *argon2idScheme) hash(password string) (string, error) {
+	salt, err := salt(s.saltLength)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, s.time, s.memory, s.threads, s.keyLength)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version, s.memory, s.time, s.threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (s *argon2idScheme) identifies(encoded string) bool {
+	return strings.HasPrefix(encoded, argon2idPrefix)
+}
+
+func (s *argon2idScheme) verify(password string, encoded string) (bool, error) {
+	p, err := parseArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	key := argon2.IDKey([]byte(password), p.salt, p.time, p.memory, p.threads, uint32(len(p.key)))
+	return subtle.ConstantTimeCompare(key, p.key) == 1, nil
+}
+
+func (s *argon2idScheme) current(encoded string) bool {
+	p, err := parseArgon2id(encoded)
+	return err == nil && p.version == argon2.Version && p.memory == s.memory && p.time == s.time &&
+		p.threads == s.threads && uint32(len(p.key)) == s.keyLength && len(p.salt) == s.saltLength
+}
+
+// parseArgon2id decodes an argon2id PHC string
+func parseArgon2id(encoded string) (*argon2idParams, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return nil, ErrUnknownHash
+	}
+	p := &argon2idParams{}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &p.version); err != nil || p.version != argon2.Version {
+		return nil, ErrUnknownHash
+	}
+	// argon2.IDKey panics without a pass or a thread
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil ||
+		p.time == 0 || p.threads == 0 || p.memory < 8*uint32(p.threads) || p.memory > maxArgon2idMemory {
+		return nil, ErrUnknownHash
+	}
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, ErrUnknownHash
+	}
+	if p.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(p.key) == 0 {
+		return nil, ErrUnknownHash
+	}
+	return p, nil
+}
@@ -0,0 +1,144 @@
+// Package passwordhash hashes passwords with the configured algorithm and verifies hashes of
+// every supported algorithm, so that older hashes keep working until they are upgraded
+package passwordhash
+
+import (
+	"core-auth/config"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// ErrUnknownHash is returned for hashes of no supported algorithm
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+// scheme hashes passwords with one algorithm
+type scheme interface {
+	// hash hashes a password with the scheme's parameters
+	hash(password string) (string, error)
+	// identifies reports whether an encoded hash is of the scheme's algorithm
+	identifies(encoded string) bool
+	// verify checks a password against an encoded hash of the scheme's algorithm
+	verify(password string, encoded string) (bool, error)
+	// current reports whether an encoded hash was made with the scheme's parameters
+	current(encoded string) bool
+}
+
+// Hasher hashes passwords with the configured scheme and verifies hashes of any scheme. At most
+// the configured number of hashes are computed at once, others wait, so that a burst of logins
+// cannot take more memory than that many argon2id hashes.
+type Hasher struct {
+	current scheme
+	schemes []scheme
+	slots   chan struct{}
+}
+
+var (
+	defaultHasher *Hasher
+	defaultOnce   sync.Once
+)
+
+// New creates a hasher for the configured algorithm and parameters
+func New(cfg *config.Config) (*Hasher, error) {
+	settings := cfg.PasswordHash
+	argon := &argon2idScheme{
+		time:       uint32(settings.Argon2.Time),
+		memory:     uint32(settings.Argon2.Memory),
+		threads:    uint8(settings.Argon2.Threads),
+		keyLength:  uint32(settings.Argon2.KeyLength),
+		saltLength: settings.Argon2.SaltLength,
+	}
+	bcryptHasher := &bcryptScheme{cost: settings.BcryptCost}
+	pbkdf := &pbkdf2Scheme{
+		digest:     settings.PBKDF2.Digest,
+		iterations: settings.PBKDF2.Iterations,
+		keyLength:  settings.PBKDF2.KeyLength,
+		saltLength: settings.PBKDF2.SaltLength,
+	}
+	if _, ok := pbkdf2Digests[pbkdf.digest]; !ok {
+		return nil, fmt.Errorf("unsupported PBKDF2 digest %q", pbkdf.digest)
+	}
+
+	h := &Hasher{schemes: []scheme{argon, bcryptHasher, pbkdf}, slots: make(chan struct{}, settings.MaxConcurrent)}
+	switch settings.Algorithm {
+	case "argon2id":
+		h.current = argon
+	case "bcrypt":
+		h.current = bcryptHasher
+	case "pbkdf2":
+		h.current = pbkdf
+	default:
+		return nil, fmt.Errorf("unsupported password hash algorithm %q", settings.Algorithm)
+	}
+	return h, nil
+}
+
+// MaxBytes returns the length in bytes of the longest password the configured algorithm
+// hashes, 0 when it hashes passwords of any length
+func MaxBytes(cfg *config.Config) int {
+	if cfg.PasswordHash.Algorithm == "bcrypt" {
+		return bcryptMaxBytes
+	}
+	return 0
+}
+
+// Default returns the hasher of the configuration loaded from the environment
+func Default() *Hasher {
+	defaultOnce.Do(func() {
+		cfg, err := config.LoadFromEnv()
+		if err != nil {
+			log.Fatalf("Failed to load configuration: %v", err)
+		}
+		if defaultHasher, err = New(cfg); err != nil {
+			log.Fatalf("Failed to create password hasher: %v", err)
+		}
+	})
+	return defaultHasher
+}
+
+// Hash hashes a password with the configured algorithm and parameters
+func (h *Hasher) Hash(password string) (string, error) {
+	h.slots <- struct{}{}
+	defer func() { <-h.slots }()
+	return h.current.hash(password)
+}
+
+// Verify checks a password against an encoded hash of any supported algorithm. When the
+// password matches, rehash reports whether the hash should be replaced by one made with the
+// configured algorithm and parameters.
+func (h *Hasher) Verify(password string, encoded string) (ok bool, rehash bool, err error) {
+	for _, s := range h.schemes {
+		if !s.identifies(encoded) {
+			continue
+		}
+		h.slots <- struct{}{}
+		ok, err := s.verify(password, encoded)
+		<-h.slots
+		if err != nil || !ok {
+			return false, false, err
+		}
+		return true, s != h.current || !s.current(encoded), nil
+	}
+	return false, false, ErrUnknownHash
+}
+
+// Hash hashes a password with the default hasher
+func Hash(password string) (string, error) {
+	return Default().Hash(password)
+}
+
+// Verify checks a password with the default hasher
+func Verify(password string, encoded string) (ok bool, rehash bool, err error) {
+	return Default().Verify(password, encoded)
+}
+
+// salt returns n random bytes
+func salt(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
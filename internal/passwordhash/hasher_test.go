@@ -0,0 +1,163 @@
+package passwordhash
+
+import (
+	"core-auth/config"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testConfig returns cheap settings of an algorithm
+func testConfig(algorithm string) *config.Config {
+	cfg := &config.Config{}
+	settings := &cfg.PasswordHash
+	settings.Algorithm = algorithm
+	settings.MaxConcurrent = 2
+	settings.Argon2.Time = 1
+	settings.Argon2.Memory = 64
+	settings.Argon2.Threads = 1
+	settings.Argon2.KeyLength = 32
+	settings.Argon2.SaltLength = 16
+	settings.BcryptCost = bcrypt.MinCost
+	settings.PBKDF2.Digest = "sha256"
+	settings.PBKDF2.Iterations = 1000
+	settings.PBKDF2.KeyLength = 32
+	settings.PBKDF2.SaltLength = 16
+	return cfg
+}
+
+func newTestHasher(t *testing.T, cfg *config.Config) *Hasher {
+	t.Helper()
+	h, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestHashRoundTrip(t *testing.T) {
+	for _, algorithm := range []string{"argon2id", "bcrypt", "pbkdf2"} {
+		t.Run(algorithm, func(t *testing.T) {
+			h := newTestHasher(t, testConfig(algorithm))
+			encoded, err := h.Hash("correct horse")
+			if err != nil {
+				t.Fatalf("hash: %v", err)
+			}
+			if other, _ := h.Hash("correct horse"); other == encoded {
+				t.Error("hashes of the same password are equal, salt not random")
+			}
+
+			ok, rehash, err := h.Verify("correct horse", encoded)
+			if err != nil || !ok || rehash {
+				t.Errorf("verify = %v, rehash %v, error %v, want match without rehash", ok, rehash, err)
+			}
+			if ok, _, err := h.Verify("wrong horse", encoded); err != nil || ok {
+				t.Errorf("wrong password verify = %v, error %v", ok, err)
+			}
+		})
+	}
+}
+
+func TestVerifyRequestsRehash(t *testing.T) {
+	argon := newTestHasher(t, testConfig("argon2id"))
+
+	// Hashes of another algorithm
+	for _, algorithm := range []string{"bcrypt", "pbkdf2"} {
+		encoded, err := newTestHasher(t, testConfig(algorithm)).Hash("correct horse")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, rehash, err := argon.Verify("correct horse", encoded); err != nil || !ok || !rehash {
+			t.Errorf("%s hash verify = %v, rehash %v, error %v, want match with rehash", algorithm, ok, rehash, err)
+		}
+		// A wrong password never asks for a rehash
+		if _, rehash, _ := argon.Verify("wrong horse", encoded); rehash {
+			t.Errorf("%s hash rehash requested for a wrong password", algorithm)
+		}
+	}
+
+	// Hashes of older parameters
+	weaker := testConfig("argon2id")
+	weaker.PasswordHash.Argon2.Memory = 32
+	encoded, err := newTestHasher(t, weaker).Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, rehash, err := argon.Verify("correct horse", encoded); err != nil || !ok || !rehash {
+		t.Errorf("older parameters verify = %v, rehash %v, error %v, want match with rehash", ok, rehash, err)
+	}
+}
+
+func TestVerifyRejectsMalformedArgon2idParameters(t *testing.T) {
+	h := newTestHasher(t, testConfig("argon2id"))
+	const tail = "$c29tZXNhbHRzb21lc2FsdA$a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5"
+	for _, params := range []string{
+		"m=64,t=0,p=1",      // no pass, argon2.IDKey panics
+		"m=64,t=1,p=0",      // no thread, argon2.IDKey panics
+		"m=4,t=1,p=1",       // under 8 KiB per thread
+		"m=4194304,t=1,p=1", // 4 GiB
+		"m=64,t=1,p=256",    // threads overflow
+		"m=64,t=1",          // missing threads
+		"m=-1,t=1,p=1",      // negative memory
+	} {
+		encoded := "$argon2id$v=19$" + params + tail
+		ok, _, err := h.Verify("correct horse", encoded)
+		if ok || !errors.Is(err, ErrUnknownHash) {
+			t.Errorf("%s: verify = %v, error %v, want %v", params, ok, err, ErrUnknownHash)
+		}
+	}
+	if _, _, err := h.Verify("correct horse", "plaintext"); !errors.Is(err, ErrUnknownHash) {
+		t.Errorf("unknown format error = %v, want %v", err, ErrUnknownHash)
+	}
+}
+
+func TestHashingConcurrencyIsBounded(t *testing.T) {
+	cfg := testConfig("argon2id")
+	cfg.PasswordHash.MaxConcurrent = 1
+	h := newTestHasher(t, cfg)
+
+	// With the only slot taken, hashing waits for it
+	h.slots <- struct{}{}
+	done := make(chan string)
+	go func() {
+		encoded, _ := h.Hash("correct horse")
+		done <- encoded
+	}()
+	select {
+	case <-done:
+		t.Fatal("hash computed while the only slot was taken")
+	case <-time.After(50 * time.Millisecond):
+	}
+	<-h.slots
+	select {
+	case encoded := <-done:
+		if !strings.HasPrefix(encoded, argon2idPrefix) {
+			t.Errorf("hash = %q", encoded)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("hash not computed after the slot was released")
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	if got := MaxBytes(testConfig("bcrypt")); got != 72 {
+		t.Errorf("bcrypt MaxBytes = %d, want 72", got)
+	}
+	for _, algorithm := range []string{"argon2id", "pbkdf2"} {
+		if got := MaxBytes(testConfig(algorithm)); got != 0 {
+			t.Errorf("%s MaxBytes = %d, want 0", algorithm, got)
+		}
+	}
+
+	// Longer passwords are what bcrypt rejects
+	h := newTestHasher(t, testConfig("bcrypt"))
+	if _, err := h.Hash(strings.Repeat("a", 72)); err != nil {
+		t.Errorf("hash of %d bytes: %v", 72, err)
+	}
+	if _, err := h.Hash(strings.Repeat("a", 73)); err == nil {
+		t.Error("hash of 73 bytes succeeded")
+	}
+}
@@ -0,0 +1,113 @@
+package passwordhash
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// pbkdf2Digests are the digests of PBKDF2 hashes by their modular crypt format identifier.
+// SHA-1 hashes are verified but never produced.
+var pbkdf2Digests = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// pbkdf2Identifiers maps the modular crypt format identifiers to their digest
+var pbkdf2Identifiers = map[string]func() hash.Hash{
+	"$pbkdf2$":        sha1.New,
+	"$pbkdf2-sha256$": sha256.New,
+	"$pbkdf2-sha512$": sha512.New,
+}
+
+// ab64Encoding is the adapted base64 of the passlib modular crypt format, "." instead of "+"
+var ab64Encoding = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789./").WithPadding(base64.NoPadding)
+
+// pbkdf2Scheme hashes with PBKDF2 into passlib modular crypt format strings:
+// $pbkdf2-<digest>$<iterations>$<salt>$<key>
+type pbkdf2Scheme struct {
+	digest     string
+	iterations int
+	keyLength  int
+	saltLength int
+}
+
+// pbkdf2Params are the parameters of an encoded PBKDF2 hash
+type pbkdf2Params struct {
+	identifier string
+	digest     func() hash.Hash
+	iterations int
+	salt       []byte
+	key        []byte
+}
+
+func (s *pbkdf2Scheme) hash(password string) (string, error) {
+	salt, err := salt(s.saltLength)
+	if err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(pbkdf2Digests[s.digest], password, salt, s.iterations, s.keyLength)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("$pbkdf2-%s$%d$%s$%s", s.digest, s.iterations,
+		ab64Encoding.EncodeToString(salt), ab64Encoding.EncodeToString(key)), nil
+}
+
+func (s *pbkdf2Scheme) identifies(encoded string) bool {
+	for identifier := range pbkdf2Identifiers {
+		if strings.HasPrefix(encoded, identifier) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *pbkdf2Scheme) verify(password string, encoded string) (bool, error) {
+	p, err := parsePBKDF2(encoded)
+	if err != nil {
+		return false, err
+	}
+	key, err := pbkdf2.Key(p.digest, password, p.salt, p.iterations, len(p.key))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(key, p.key) == 1, nil
+}
+
+func (s *pbkdf2Scheme) current(encoded string) bool {
+	p, err := parsePBKDF2(encoded)
+	return err == nil && p.identifier == "$pbkdf2-"+s.digest+"$" && p.iterations == s.iterations &&
+		len(p.key) == s.keyLength && len(p.salt) == s.saltLength
+}
+
+// parsePBKDF2 decodes a PBKDF2 modular crypt format string
+func parsePBKDF2(encoded string) (*pbkdf2Params, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 {
+		return nil, ErrUnknownHash
+	}
+	p := &pbkdf2Params{identifier: "$" + parts[1] + "$"}
+	var ok bool
+	if p.digest, ok = pbkdf2Identifiers[p.identifier]; !ok {
+		return nil, ErrUnknownHash
+	}
+	var err error
+	if p.iterations, err = strconv.Atoi(parts[2]); err != nil || p.iterations < 1 {
+		return nil, ErrUnknownHash
+	}
+	if p.salt, err = ab64Encoding.DecodeString(parts[3]); err != nil {
+		return nil, ErrUnknownHash
+	}
+	if p.key, err = ab64Encoding.DecodeString(parts[4]); err != nil || len(p.key) == 0 {
+		return nil, ErrUnknownHash
+	}
+	return p, nil
+}
@@ -0,0 +1,152 @@
+package passwordpolicy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// hashPrefixLength is the length of the SHA-1 prefixes HIBP range files are named after
+const hashPrefixLength = 5
+
+// breachedPasswords looks up passwords in local Have I Been Pwned data, either a directory of
+// range files named after a 5 character SHA-1 prefix and holding SUFFIX:COUNT lines, as served
+// by the range API, or a single file of HASH:COUNT lines ordered by hash, as downloaded
+type breachedPasswords struct {
+	path     string
+	minCount int
+}
+
+// contains reports whether a password appears in at least minCount breaches. Data that cannot
+// be read is logged and the password accepted, so that a missing file does not block sign ups.
+func (b *breachedPasswords) contains(password string) bool {
+	found, err := b.find(password)
+	if err != nil {
+		log.Printf("Failed to look up breached password data, password accepted: %v", err)
+		return false
+	}
+	return found
+}
+
+// find looks up the SHA-1 hash of a password in the range file of its prefix, or in the
+// single ordered file
+func (b *breachedPasswords) find(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	info, err := os.Stat(b.path)
+	if err != nil {
+		return false, err
+	}
+	if !info.IsDir() {
+		return b.search(b.path, hash)
+	}
+
+	prefix, suffix := hash[:hashPrefixLength], hash[hashPrefixLength:]
+	for _, name := range []string{prefix + ".txt", prefix} {
+		path := filepath.Join(b.path, name)
+		if _, err := os.Stat(path); err == nil {
+			return b.scan(path, suffix)
+		}
+	}
+	// Every prefix has a range file, a missing one means incomplete data
+	return false, errors.New("no range file for prefix " + prefix)
+}
+
+// scan reads a range file for a SUFFIX:COUNT line matching suffix
+func (b *breachedPasswords) scan(path string, suffix string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if candidate, count, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":"); strings.EqualFold(candidate, suffix) {
+			return b.breached(count), nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// search binary searches a file of HASH:COUNT lines ordered by hash for hash, reading about
+// a line per halving instead of the whole file
+func (b *breachedPasswords) search(path string, hash string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	// lo is always the start of a line, lines starting at hi or after are past the hash
+	lo, hi := int64(0), info.Size()
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		start, line, err := lineAfter(f, mid, info.Size())
+		if err != nil {
+			return false, err
+		}
+		if start >= hi {
+			hi = mid
+			continue
+		}
+		candidate, count, _ := strings.Cut(strings.TrimSpace(line), ":")
+		switch candidate = strings.ToUpper(candidate); {
+		case candidate == hash:
+			return b.breached(count), nil
+		case candidate < hash:
+			lo = start + int64(len(line))
+		default:
+			hi = mid
+		}
+	}
+	return false, nil
+}
+
+// lineAfter returns the first line of a file starting at offset or after, with its offset.
+// The line keeps its newline, it is empty at the end of the file.
+func lineAfter(f *os.File, offset int64, size int64) (int64, string, error) {
+	start := offset
+	if offset > 0 {
+		start--
+	}
+	r := bufio.NewReader(io.NewSectionReader(f, start, size-start))
+	if offset > 0 {
+		// Skip the rest of the line holding the byte before offset
+		skipped, err := r.ReadString('\n')
+		if err == io.EOF {
+			return size, "", nil
+		}
+		if err != nil {
+			return 0, "", err
+		}
+		start += int64(len(skipped))
+	}
+	line, err := r.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return 0, "", err
+	}
+	return start, line, nil
+}
+
+// breached reports whether the count of a matching line reaches minCount
+func (b *breachedPasswords) breached(count string) bool {
+	n, err := strconv.Atoi(count)
+	if err != nil {
+		// Lists without counts name breached passwords only
+		n = 1
+	}
+	return n >= b.minCount
+}
@@ -0,0 +1,126 @@
+package passwordpolicy
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// sha1Hex returns the uppercase SHA-1 hash of a password, as in HIBP data
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// writeOrderedFile writes a single HASH:COUNT file ordered by hash, with the breach count of
+// each password
+func writeOrderedFile(t *testing.T, counts map[string]int) string {
+	t.Helper()
+	lines := make([]string, 0, len(counts))
+	for password, count := range counts {
+		lines = append(lines, fmt.Sprintf("%s:%d\r\n", sha1Hex(password), count))
+	}
+	sort.Strings(lines)
+	path := filepath.Join(t.TempDir(), "pwned-passwords-sha1-ordered-by-hash.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBreachedPasswordsOrderedFile(t *testing.T) {
+	counts := map[string]int{"rare": 1}
+	for i := 0; i < 2000; i++ {
+		counts[fmt.Sprintf("password-%d", i)] = i + 1
+	}
+	b := &breachedPasswords{path: writeOrderedFile(t, counts), minCount: 2}
+
+	for _, password := range []string{"password-0", "password-1", "password-999", "password-1999", "rare"} {
+		want := counts[password] >= 2
+		if got := b.contains(password); got != want {
+			t.Errorf("contains(%q) = %v, want %v", password, got, want)
+		}
+	}
+	for _, password := range []string{"", "not breached", "password-2000"} {
+		if b.contains(password) {
+			t.Errorf("contains(%q) = true, want false", password)
+		}
+	}
+}
+
+func TestBreachedPasswordsOrderedFileEdges(t *testing.T) {
+	// Hashes sorting first and last, in a file without a trailing newline
+	passwords := []string{"alpha", "bravo", "charlie"}
+	sort.Slice(passwords, func(i, j int) bool { return sha1Hex(passwords[i]) < sha1Hex(passwords[j]) })
+	var data bytes.Buffer
+	for i, password := range passwords {
+		if i > 0 {
+			data.WriteString("\n")
+		}
+		data.WriteString(sha1Hex(password) + ":3")
+	}
+	path := filepath.Join(t.TempDir(), "ordered.txt")
+	if err := os.WriteFile(path, data.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	b := &breachedPasswords{path: path, minCount: 1}
+	for _, password := range passwords {
+		if !b.contains(password) {
+			t.Errorf("contains(%q) = false, want true", password)
+		}
+	}
+	if b.contains("delta") {
+		t.Error(`contains("delta") = true, want false`)
+	}
+}
+
+func TestBreachedPasswordsRangeDirectory(t *testing.T) {
+	dir := t.TempDir()
+	hash := sha1Hex("hunter2")
+	other := sha1Hex("correct horse")
+	if err := os.WriteFile(filepath.Join(dir, hash[:5]+".txt"), []byte("0000000000000000000000000000000000A:1\n"+hash[5:]+":17\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, other[:5]), []byte("0000000000000000000000000000000000A:1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	b := &breachedPasswords{path: dir, minCount: 10}
+	if !b.contains("hunter2") {
+		t.Error("breached password accepted")
+	}
+	if b.contains("correct horse") {
+		t.Error("password missing from its range file rejected")
+	}
+	b.minCount = 20
+	if b.contains("hunter2") {
+		t.Error("password below the minimum count rejected")
+	}
+}
+
+func TestBreachedPasswordsLogFailures(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	for name, path := range map[string]string{
+		"missing data":       filepath.Join(t.TempDir(), "missing"),
+		"missing range file": t.TempDir(),
+	} {
+		logs.Reset()
+		b := &breachedPasswords{path: path, minCount: 1}
+		if b.contains("hunter2") {
+			t.Errorf("%s: password rejected", name)
+		}
+		if !strings.Contains(logs.String(), "Failed to look up breached password data") {
+			t.Errorf("%s: failure not logged, logs %q", name, logs.String())
+		}
+	}
+}
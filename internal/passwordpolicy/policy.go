@@ -0,0 +1,175 @@
+// Package passwordpolicy checks new passwords against the configured password policy
+package passwordpolicy
+
+import (
+	"bufio"
+	"core-auth/config"
+	"core-auth/internal/passwordhash"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Reasons a password is rejected
+const (
+	ReasonTooShort         = "too_short"
+	ReasonTooLong          = "too_long"
+	ReasonMissingUppercase = "missing_uppercase"
+	ReasonMissingLowercase = "missing_lowercase"
+	ReasonMissingDigit     = "missing_digit"
+	ReasonMissingSymbol    = "missing_symbol"
+	ReasonTooFewClasses    = "too_few_character_classes"
+	ReasonCommonPassword   = "common_password"
+	ReasonSimilarToAccount = "similar_to_account"
+	ReasonBreachedPassword = "breached_password"
+)
+
+// minSimilarLength is the shortest username or email part a password is compared with, shorter
+// values would reject too many unrelated passwords
+const minSimilarLength = 3
+
+// commonPasswords is the built-in denylist, extended by the configured denylist file
+var commonPasswords = []string{
+	"123456", "123456789", "12345678", "1234567890", "12345", "1234567", "111111", "123123",
+	"000000", "654321", "password", "password1", "password123", "passw0rd", "qwerty", "qwerty123",
+	"qwertyuiop", "abc123", "abcd1234", "iloveyou", "admin", "admin123", "welcome", "welcome1",
+	"letmein", "monkey", "dragon", "football", "baseball", "sunshine", "princess", "master",
+	"trustno1", "changeme", "secret", "login", "zaq12wsx", "1q2w3e4r", "asdfghjkl", "starwars",
+}
+
+// Violation is a rule a password breaks
+type Violation struct {
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// Account is the account a password is for, passwords similar to it are rejected
+type Account struct {
+	Username string
+	Email    string
+}
+
+// Policy checks passwords against the configured rules
+type Policy struct {
+	config   *config.Config
+	denylist map[string]bool
+	breached *breachedPasswords
+}
+
+// New creates the password policy of the configuration, loading its denylist file
+func New() *Policy {
+	config, err := config.LoadFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	denylist := make(map[string]bool, len(commonPasswords))
+	for _, password := range commonPasswords {
+		denylist[password] = true
+	}
+	if path := config.PasswordPolicy.DenylistFile; path != "" {
+		if err := loadDenylist(path, denylist); err != nil {
+			log.Fatalf("Failed to load password denylist: %v", err)
+		}
+	}
+	policy := &Policy{config: config, denylist: denylist}
+	if path := config.PasswordPolicy.BreachedPath; path != "" {
+		policy.breached = &breachedPasswords{path: path, minCount: config.PasswordPolicy.BreachedMinCount}
+	}
+	return policy
+}
+
+// Check returns the rules a new password for an account breaks, none when it is accepted
+func (p *Policy) Check(password string, account Account) []Violation {
+	rules := p.config.PasswordPolicy
+	violations := []Violation{}
+
+	length := utf8.RuneCountInString(password)
+	if length < rules.MinLength {
+		violations = append(violations, Violation{ReasonTooShort, fmt.Sprintf("Password must be at least %d characters long", rules.MinLength)})
+	}
+	if rules.MaxLength > 0 && length > rules.MaxLength {
+		violations = append(violations, Violation{ReasonTooLong, fmt.Sprintf("Password must be at most %d characters long", rules.MaxLength)})
+	} else if max := passwordhash.MaxBytes(p.config); max > 0 && len(password) > max {
+		// bcrypt hashes at most 72 bytes, which are fewer characters when they are not ASCII
+		violations = append(violations, Violation{ReasonTooLong, fmt.Sprintf("Password must be at most %d bytes long, non-ASCII characters take several", max)})
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	if rules.RequireUppercase && !upper {
+		violations = append(violations, Violation{ReasonMissingUppercase, "Password must contain an uppercase letter"})
+	}
+	if rules.RequireLowercase && !lower {
+		violations = append(violations, Violation{ReasonMissingLowercase, "Password must contain a lowercase letter"})
+	}
+	if rules.RequireDigit && !digit {
+		violations = append(violations, Violation{ReasonMissingDigit, "Password must contain a digit"})
+	}
+	if rules.RequireSymbol && !symbol {
+		violations = append(violations, Violation{ReasonMissingSymbol, "Password must contain a symbol"})
+	}
+	classes := 0
+	for _, present := range []bool{upper, lower, digit, symbol} {
+		if present {
+			classes++
+		}
+	}
+	if classes < rules.MinCharacterClasses {
+		violations = append(violations, Violation{ReasonTooFewClasses, fmt.Sprintf("Password must mix at least %d of uppercase letters, lowercase letters, digits and symbols", rules.MinCharacterClasses)})
+	}
+
+	folded := strings.ToLower(password)
+	if p.denylist[folded] {
+		violations = append(violations, Violation{ReasonCommonPassword, "Password is too common"})
+	}
+	if rules.RejectSimilar && similar(folded, account) {
+		violations = append(violations, Violation{ReasonSimilarToAccount, "Password must not contain the username or email address"})
+	}
+	if p.breached != nil && p.breached.contains(password) {
+		violations = append(violations, Violation{ReasonBreachedPassword, "Password has appeared in a data breach"})
+	}
+	return violations
+}
+
+// similar reports whether a lowercased password contains the username or the local part of the
+// email of an account. The domain is shared by many accounts and not compared.
+func similar(folded string, account Account) bool {
+	local, _, _ := strings.Cut(strings.ToLower(account.Email), "@")
+	for _, value := range []string{strings.ToLower(account.Username), local} {
+		if utf8.RuneCountInString(value) >= minSimilarLength && strings.Contains(folded, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadDenylist adds the passwords of a file, one per line, to a denylist
+func loadDenylist(path string, denylist map[string]bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if password := strings.TrimSpace(scanner.Text()); password != "" {
+			denylist[strings.ToLower(password)] = true
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,143 @@
+package passwordpolicy
+
+import (
+	"core-auth/internal/testutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSimilarComparesUsernameAndEmailLocalPart(t *testing.T) {
+	account := Account{Username: "alice", Email: "bob.smith@gmail.com"}
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"my name is alice", true},
+		{"hello bob.smith!", true},
+		{"gmailpassword42", false},
+		{"example.com rocks", false},
+		{"unrelated passphrase", false},
+	}
+	for _, tt := range tests {
+		if got := similar(tt.password, account); got != tt.want {
+			t.Errorf("similar(%q) = %v, want %v", tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestSimilarIgnoresShortValues(t *testing.T) {
+	if similar("the best password", Account{Username: "be", Email: "st@example.com"}) {
+		t.Error("password rejected for containing a two character username")
+	}
+}
+
+// newTestPolicy returns the policy of the environment with the given settings
+func newTestPolicy(t *testing.T, env map[string]string) *Policy {
+	t.Helper()
+	testutil.SetSecrets(t)
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+	return New()
+}
+
+// reasons returns the reasons of violations
+func reasons(violations []Violation) []string {
+	reasons := make([]string, 0, len(violations))
+	for _, violation := range violations {
+		reasons = append(reasons, violation.Reason)
+	}
+	return reasons
+}
+
+func TestCheck(t *testing.T) {
+	denylist := filepath.Join(t.TempDir(), "denylist.txt")
+	if err := os.WriteFile(denylist, []byte("Corporate2024\n\n  hunter22  \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	account := Account{Username: "alice", Email: "alice.smith@example.com"}
+
+	tests := []struct {
+		name     string
+		env      map[string]string
+		password string
+		want     []string
+	}{
+		{"accepted", nil, "correct horse battery", []string{}},
+		{"too short", nil, "Sh0rt!", []string{ReasonTooShort}},
+		{"minimum length", map[string]string{"PASSWORD_MIN_LENGTH": "6"}, "Sh0rt!", []string{}},
+		{"too long", map[string]string{"PASSWORD_MAX_LENGTH": "10"}, "correct horse battery", []string{ReasonTooLong}},
+		{"length in characters", map[string]string{"PASSWORD_MAX_LENGTH": "10"}, "éééééééééé", []string{}},
+		{"uppercase", map[string]string{"PASSWORD_REQUIRE_UPPERCASE": "true"}, "correct horse battery", []string{ReasonMissingUppercase}},
+		{"lowercase", map[string]string{"PASSWORD_REQUIRE_LOWERCASE": "true"}, "CORRECT HORSE BATTERY", []string{ReasonMissingLowercase}},
+		{"digit", map[string]string{"PASSWORD_REQUIRE_DIGIT": "true"}, "correct horse battery", []string{ReasonMissingDigit}},
+		{"symbol", map[string]string{"PASSWORD_REQUIRE_SYMBOL": "true"}, "correcthorsebattery", []string{ReasonMissingSymbol}},
+		{"all classes present", map[string]string{
+			"PASSWORD_REQUIRE_UPPERCASE": "true",
+			"PASSWORD_REQUIRE_LOWERCASE": "true",
+			"PASSWORD_REQUIRE_DIGIT":     "true",
+			"PASSWORD_REQUIRE_SYMBOL":    "true",
+		}, "Correct horse 4 battery", []string{}},
+		{"too few classes", map[string]string{"PASSWORD_MIN_CHARACTER_CLASSES": "3"}, "correct horse battery", []string{ReasonTooFewClasses}},
+		{"enough classes", map[string]string{"PASSWORD_MIN_CHARACTER_CLASSES": "3"}, "correct horse battery 4", []string{}},
+		{"built-in denylist", nil, "Password123", []string{ReasonCommonPassword}},
+		{"denylist file", map[string]string{"PASSWORD_DENYLIST_FILE": denylist}, "corporate2024", []string{ReasonCommonPassword}},
+		{"denylist file trimmed", map[string]string{"PASSWORD_DENYLIST_FILE": denylist}, "HUNTER22", []string{ReasonCommonPassword}},
+		{"similar to username", nil, "Alice in wonderland", []string{ReasonSimilarToAccount}},
+		{"similar to email", nil, "alice.smith rocks", []string{ReasonSimilarToAccount}},
+		{"similar allowed", map[string]string{"PASSWORD_REJECT_SIMILAR": "false"}, "Alice in wonderland", []string{}},
+		{"several rules", map[string]string{
+			"PASSWORD_REQUIRE_UPPERCASE":     "true",
+			"PASSWORD_REQUIRE_DIGIT":         "true",
+			"PASSWORD_MIN_CHARACTER_CLASSES": "2",
+		}, "alice", []string{ReasonTooShort, ReasonMissingUppercase, ReasonMissingDigit, ReasonTooFewClasses, ReasonSimilarToAccount}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations := newTestPolicy(t, tt.env).Check(tt.password, account)
+			if got := reasons(violations); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check(%q) = %v, want %v", tt.password, got, tt.want)
+			}
+			for _, violation := range violations {
+				if violation.Message == "" {
+					t.Errorf("violation %s has no message", violation.Reason)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckBreachedPassword(t *testing.T) {
+	policy := newTestPolicy(t, map[string]string{
+		"PASSWORD_BREACHED_PATH":      writeOrderedFile(t, map[string]int{"correct horse battery": 3, "rarely seen phrase": 1}),
+		"PASSWORD_BREACHED_MIN_COUNT": "2",
+	})
+
+	if got := reasons(policy.Check("correct horse battery", Account{})); !reflect.DeepEqual(got, []string{ReasonBreachedPassword}) {
+		t.Errorf("breached password reasons = %v", got)
+	}
+	if got := policy.Check("rarely seen phrase", Account{}); len(got) != 0 {
+		t.Errorf("password below the breach count rejected: %v", reasons(got))
+	}
+}
+
+func TestCheckBcryptLength(t *testing.T) {
+	// 40 characters within the length limit, but 80 bytes bcrypt cannot hash
+	password := strings.Repeat("é", 40)
+	if got := newTestPolicy(t, nil).Check(password, Account{}); len(got) != 0 {
+		t.Errorf("argon2id reasons = %v, want none", reasons(got))
+	}
+	env := map[string]string{"PASSWORD_HASH_ALGORITHM": "bcrypt"}
+	if got := reasons(newTestPolicy(t, env).Check(password, Account{})); !reflect.DeepEqual(got, []string{ReasonTooLong}) {
+		t.Errorf("bcrypt reasons = %v, want %v", got, []string{ReasonTooLong})
+	}
+
+	// Passwords over the limit in characters are not reported twice
+	env["PASSWORD_MAX_LENGTH"] = "30"
+	if got := reasons(newTestPolicy(t, env).Check(password, Account{})); !reflect.DeepEqual(got, []string{ReasonTooLong}) {
+		t.Errorf("bcrypt reasons over the character limit = %v, want %v", got, []string{ReasonTooLong})
+	}
+}
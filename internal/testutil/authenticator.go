@@ -0,0 +1,107 @@
+package testutil
+
+import (
+	"core-auth/internal/webauthn"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+)
+
+// Authenticator flags of the responses of an Authenticator
+const (
+	FlagUserPresent  = 0x01
+	FlagUserVerified = 0x04
+)
+
+// Authenticator is a software WebAuthn authenticator holding one ES256 passkey
+type Authenticator struct {
+	RPID         string
+	Origin       string
+	CredentialID []byte
+	UserHandle   []byte // returned by assertions when set
+	Flags        byte   // of the next responses, user present and verified by default
+	SignCount    uint32 // incremented by every assertion
+	key          *ecdsa.PrivateKey
+}
+
+// NewAuthenticator returns an authenticator with a new passkey for a relying party
+func NewAuthenticator(t testing.TB, rpID, origin string) *Authenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		t.Fatal(err)
+	}
+	return &Authenticator{RPID: rpID, Origin: origin, CredentialID: id, Flags: FlagUserPresent | FlagUserVerified, key: key}
+}
+
+// PublicKey returns the COSE_Key of the passkey
+func (a *Authenticator) PublicKey() []byte {
+	key := []byte{0xa5, 0x01, 0x02, 0x03, 0x26, 0x20, 0x01, 0x21, 0x58, 0x20}
+	key = append(key, a.key.X.FillBytes(make([]byte, 32))...)
+	key = append(key, 0x22, 0x58, 0x20)
+	return append(key, a.key.Y.FillBytes(make([]byte, 32))...)
+}
+
+// Register answers a registration ceremony with attestation "none"
+func (a *Authenticator) Register(challenge string) *webauthn.AttestationResponse {
+	authData := a.authData(0x40)
+	authData = append(authData, make([]byte, 16)...) // AAGUID
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.CredentialID)))
+	authData = append(authData, a.CredentialID...)
+	authData = append(authData, a.PublicKey()...)
+
+	// {"fmt": "none", "attStmt": {}, "authData": authData}
+	attestation := []byte{0xa3, 0x63, 'f', 'm', 't', 0x64, 'n', 'o', 'n', 'e', 0x67, 'a', 't', 't', 'S', 't', 'm', 't', 0xa0,
+		0x68, 'a', 'u', 't', 'h', 'D', 'a', 't', 'a', 0x59}
+	attestation = binary.BigEndian.AppendUint16(attestation, uint16(len(authData)))
+	attestation = append(attestation, authData...)
+
+	resp := &webauthn.AttestationResponse{ID: webauthn.EncodeID(a.CredentialID), RawID: webauthn.EncodeID(a.CredentialID), Type: "public-key"}
+	resp.Response.ClientDataJSON = webauthn.EncodeID(a.clientData("webauthn.create", challenge))
+	resp.Response.AttestationObject = webauthn.EncodeID(attestation)
+	resp.Response.Transports = []string{"internal"}
+	return resp
+}
+
+// Assert answers an authentication ceremony, signing with the passkey
+func (a *Authenticator) Assert(challenge string) *webauthn.AssertionResponse {
+	a.SignCount++
+	authData := a.authData(0)
+	clientData := a.clientData("webauthn.get", challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		panic(err)
+	}
+
+	resp := &webauthn.AssertionResponse{ID: webauthn.EncodeID(a.CredentialID), RawID: webauthn.EncodeID(a.CredentialID), Type: "public-key"}
+	resp.Response.ClientDataJSON = webauthn.EncodeID(clientData)
+	resp.Response.AuthenticatorData = webauthn.EncodeID(authData)
+	resp.Response.Signature = webauthn.EncodeID(sig)
+	if a.UserHandle != nil {
+		resp.Response.UserHandle = webauthn.EncodeID(a.UserHandle)
+	}
+	return resp
+}
+
+// authData returns the authenticator data without attested credential data
+func (a *Authenticator) authData(flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.RPID))
+	authData := append(rpIDHash[:], a.Flags|flags)
+	return binary.BigEndian.AppendUint32(authData, a.SignCount)
+}
+
+// clientData returns the client data a browser collects for a ceremony
+func (a *Authenticator) clientData(ceremony string, challenge string) []byte {
+	b, _ := json.Marshal(map[string]string{"type": ceremony, "challenge": challenge, "origin": a.Origin})
+	return b
+}
@@ -0,0 +1,53 @@
+// Package testutil provides the stores of tests: a migrated SQLite database in place of MySQL
+// and an in-memory Redis server, and a software WebAuthn authenticator
+package testutil
+
+import (
+	database "core-auth/db"
+	"path/filepath"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// NewDB returns an empty database with the application schema, removed when the test ends
+func NewDB(t testing.TB) *gorm.DB {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "test.db") + "?_busy_timeout=5000"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	if err := database.AutoMigrate(db); err != nil {
+		t.Fatalf("migrate database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// SetSecrets sets the secrets that have no default to test values for the duration of the test
+func SetSecrets(t testing.TB) {
+	t.Helper()
+	t.Setenv("OAUTH2_JWT_KEY_ENCRYPTION_SECRET", "test-key-encryption-secret")
+	t.Setenv("OAUTH2_PAIRWISE_SALT", "test-pairwise-salt")
+	t.Setenv("EMAIL_VERIFICATION_SECRET", "test-email-verification-secret")
+	t.Setenv("MFA_ENCRYPTION_SECRET", "test-mfa-encryption-secret")
+}
+
+// NewRedis returns a client of an in-memory Redis server stopped when the test ends. The
+// server controls time, expiring keys with FastForward.
+func NewRedis(t testing.TB) (*redis.Client, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return rdb, server
+}
@@ -3,25 +3,8 @@ package utils
 import (
 	"crypto/rand"
 	"encoding/base64"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
-// HashPassword creates a bcrypt hash of the password
-func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
-	}
-	return string(bytes), nil
-}
-
-// CheckPassword checks if the provided password matches the hash
-func CheckPassword(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
-}
-
 // GenerateRandomString generates a random string of the specified length
 func GenerateRandomString(length int) (string, error) {
 	bytes := make([]byte, length)
@@ -30,14 +13,3 @@ func GenerateRandomString(length int) (string, error) {
 	}
 	return base64.URLEncoding.EncodeToString(bytes)[:length], nil
 }
-
-// ValidatePassword checks if a password meets minimum requirements
-func ValidatePassword(password string) bool {
-	// Password must be at least 8 characters long
-	if len(password) < 8 {
-		return false
-	}
-	
-	// Add more password validation rules as needed
-	return true
-} 
\ No newline at end of file
@@ -0,0 +1,158 @@
+package verification
+
+import (
+	"context"
+	"core-auth/config"
+	database "core-auth/db"
+	"core-auth/internal/mailer"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrInvalidLink is returned for verification links that are malformed, tampered with, expired
+// or issued for an email address the account no longer has
+var ErrInvalidLink = errors.New("invalid or expired verification link")
+
+// emailVerificationPurpose separates the MAC of verification links from other signed values
+const emailVerificationPurpose = "email_verification"
+
+// linkClaims is the signed content of a verification link
+type linkClaims struct {
+	UserID    uint   `json:"uid"`
+	Email     string `json:"email"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// EmailVerifier sends and checks the signed links confirming the email address of new accounts
+type EmailVerifier struct {
+	db     *gorm.DB
+	mailer mailer.Mailer
+	config *config.Config
+}
+
+// NewEmailVerifier creates an email verifier sending links with the given mailer
+func NewEmailVerifier(db *gorm.DB, m mailer.Mailer) *EmailVerifier {
+	config, err := config.LoadFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	return &EmailVerifier{
+		db:     db,
+		mailer: m,
+		config: config,
+	}
+}
+
+// Send mails a verification link to a pending account. Nothing is sent when a link was sent
+// to the account less than the resend interval ago.
+func (v *EmailVerifier) Send(ctx context.Context, user *database.User) error {
+	interval := time.Duration(v.config.EmailVerification.ResendInterval) * time.Second
+	allowed, err := database.ClaimVerificationSend(v.db, user.ID, interval)
+	if err != nil || !allowed {
+		return err
+	}
+
+	token, err := v.sign(&linkClaims{
+		UserID:    user.ID,
+		Email:     user.Email,
+		ExpiresAt: time.Now().Add(time.Duration(v.config.EmailVerification.LinkLifetime) * time.Hour).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	link, err := url.Parse(v.config.EmailVerification.URL)
+	if err != nil {
+		return err
+	}
+	q := link.Query()
+	q.Set("token", token)
+	link.RawQuery = q.Encode()
+
+	return v.mailer.Send(ctx, &mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hello %s,\n\nConfirm your email address to activate your account:\n\n%s\n\nThe link expires in %d hours. If you did not create an account, ignore this email.\n",
+			user.Username, link.String(), v.config.EmailVerification.LinkLifetime),
+	})
+}
+
+// Resend mails a new verification link to the pending account of an email address. Unknown
+// and verified addresses are ignored so that callers cannot tell accounts apart.
+func (v *EmailVerifier) Resend(ctx context.Context, email string) error {
+	user, err := database.GetUserByEmail(v.db, email)
+	if err != nil || !user.PendingEmailVerification {
+		return nil
+	}
+	return v.Send(ctx, user)
+}
+
+// Verify checks a verification link and activates the account it was issued for
+func (v *EmailVerifier) Verify(token string) (*database.User, error) {
+	claims, err := v.parse(token)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().Unix() > claims.ExpiresAt {
+		return nil, ErrInvalidLink
+	}
+
+	user, err := database.GetUserByID(v.db, claims.UserID)
+	if err != nil || user.Email != claims.Email {
+		return nil, ErrInvalidLink
+	}
+	if !user.PendingEmailVerification {
+		return user, nil
+	}
+	if err := database.MarkEmailVerified(v.db, user.ID, claims.Email); err != nil {
+		return nil, err
+	}
+	user.PendingEmailVerification = false
+	return user, nil
+}
+
+// sign encodes the claims of a link followed by their HMAC-SHA256
+func (v *EmailVerifier) sign(claims *linkClaims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(v.mac(encoded)), nil
+}
+
+// parse verifies the MAC of a link and decodes its claims
+func (v *EmailVerifier) parse(token string) (*linkClaims, error) {
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidLink
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, v.mac(encoded)) {
+		return nil, ErrInvalidLink
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidLink
+	}
+	var claims linkClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidLink
+	}
+	return &claims, nil
+}
+
+func (v *EmailVerifier) mac(encoded string) []byte {
+	h := hmac.New(sha256.New, []byte(v.config.EmailVerification.Secret))
+	h.Write([]byte(emailVerificationPurpose + "." + encoded))
+	return h.Sum(nil)
+}
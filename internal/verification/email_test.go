@@ -0,0 +1,102 @@
+package verification
+
+import (
+	"context"
+	database "core-auth/db"
+	"core-auth/internal/mailer"
+	"core-auth/internal/testutil"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// recordingMailer keeps the messages sent
+type recordingMailer struct {
+	messages []*mailer.Message
+}
+
+// Send implements mailer.Mailer
+func (m *recordingMailer) Send(ctx context.Context, msg *mailer.Message) error {
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// linkToken extracts the token of the verification link in a message
+func linkToken(t *testing.T, msg *mailer.Message) string {
+	t.Helper()
+	for _, field := range strings.Fields(msg.Body) {
+		if u, err := url.Parse(field); err == nil && u.Query().Get("token") != "" {
+			return u.Query().Get("token")
+		}
+	}
+	t.Fatalf("no link in %q", msg.Body)
+	return ""
+}
+
+func TestVerificationLink(t *testing.T) {
+	testutil.SetSecrets(t)
+	db := testutil.NewDB(t)
+	m := &recordingMailer{}
+	v := NewEmailVerifier(db, m)
+	user := &database.User{Username: "alice", Email: "alice@example.com", Password: "-", PendingEmailVerification: true}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v.Send(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.messages) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(m.messages))
+	}
+	token := linkToken(t, m.messages[0])
+
+	// A link signed with another secret, such as the JWT secret, is rejected
+	other := NewEmailVerifier(db, m)
+	other.config.EmailVerification.Secret = other.config.JWT.Secret
+	if _, err := other.Verify(token); !errors.Is(err, ErrInvalidLink) {
+		t.Errorf("link verified with another secret: %v", err)
+	}
+	// Swap a character before the last, whose low bits base64 decoding may ignore
+	i := len(token) - 2
+	swap := byte('A')
+	if token[i] == 'A' {
+		swap = 'B'
+	}
+	tampered := token[:i] + string(swap) + token[i+1:]
+	if _, err := v.Verify(tampered); !errors.Is(err, ErrInvalidLink) {
+		t.Errorf("tampered link error = %v, want %v", err, ErrInvalidLink)
+	}
+
+	verified, err := v.Verify(token)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if verified.PendingEmailVerification {
+		t.Error("account still pending after verification")
+	}
+}
+
+func TestResendIsThrottled(t *testing.T) {
+	testutil.SetSecrets(t)
+	db := testutil.NewDB(t)
+	m := &recordingMailer{}
+	v := NewEmailVerifier(db, m)
+	user := &database.User{Username: "alice", Email: "alice@example.com", Password: "-", PendingEmailVerification: true}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := v.Resend(context.Background(), "alice@example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := v.Resend(context.Background(), "nobody@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.messages) != 1 {
+		t.Errorf("sent %d messages, want 1", len(m.messages))
+	}
+}
@@ -0,0 +1,129 @@
+package verification
+
+import (
+	"context"
+	"core-auth/config"
+	database "core-auth/db"
+	"core-auth/internal/mailer"
+	"core-auth/internal/utils"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrInvalidResetToken is returned for password reset tokens that are unknown, expired,
+// already used or superseded by a newer token
+var ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+
+// resetTokenLength is the length of generated password reset tokens
+const resetTokenLength = 43
+
+// PasswordResetter emails single-use tokens to reset forgotten passwords and redeems them
+type PasswordResetter struct {
+	db     *gorm.DB
+	mailer mailer.Mailer
+	config *config.Config
+}
+
+// NewPasswordResetter creates a password resetter sending tokens with the given mailer
+func NewPasswordResetter(db *gorm.DB, m mailer.Mailer) *PasswordResetter {
+	config, err := config.LoadFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	return &PasswordResetter{
+		db:     db,
+		mailer: m,
+		config: config,
+	}
+}
+
+// Request mails a password reset link to the account of an email address. Unknown and inactive
+// addresses are ignored so that callers cannot tell accounts apart, and nothing is sent when a
+// token was created for the account less than the request interval ago.
+func (r *PasswordResetter) Request(ctx context.Context, email string) error {
+	user, err := database.GetUserByEmail(r.db, email)
+	if err != nil || !user.IsActive {
+		return nil
+	}
+
+	token, err := utils.GenerateRandomString(resetTokenLength)
+	if err != nil {
+		return err
+	}
+	lifetime := time.Duration(r.config.PasswordReset.TokenLifetime) * time.Minute
+	created, err := database.CreatePasswordResetToken(r.db, &database.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: hashResetToken(token),
+		ExpiresAt: time.Now().Add(lifetime),
+	}, time.Duration(r.config.PasswordReset.RequestInterval)*time.Second)
+	if err != nil || !created {
+		return err
+	}
+
+	link, err := url.Parse(r.config.PasswordReset.URL)
+	if err != nil {
+		return err
+	}
+	q := link.Query()
+	q.Set("token", token)
+	link.RawQuery = q.Encode()
+
+	return r.mailer.Send(ctx, &mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\nUse this link to choose a new password:\n\n%s\n\nThe link can be used once and expires in %d minutes. If you did not ask to reset your password, ignore this email.\n",
+			user.Username, link.String(), r.config.PasswordReset.TokenLifetime),
+	})
+}
+
+// Lookup returns the account a password reset token was issued for without consuming it, so
+// that the new password can be checked against the account first
+func (r *PasswordResetter) Lookup(token string) (*database.User, error) {
+	if token == "" {
+		return nil, ErrInvalidResetToken
+	}
+	reset, err := database.GetPasswordResetToken(r.db, hashResetToken(token))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidResetToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	user, err := database.GetUserByID(r.db, reset.UserID)
+	if err != nil {
+		return nil, ErrInvalidResetToken
+	}
+	return user, nil
+}
+
+// Redeem consumes a password reset token and returns the account it was issued for
+func (r *PasswordResetter) Redeem(token string) (*database.User, error) {
+	if token == "" {
+		return nil, ErrInvalidResetToken
+	}
+	reset, err := database.ConsumePasswordResetToken(r.db, hashResetToken(token))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidResetToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	user, err := database.GetUserByID(r.db, reset.UserID)
+	if err != nil {
+		return nil, ErrInvalidResetToken
+	}
+	return user, nil
+}
+
+// hashResetToken returns the stored form of a password reset token
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,95 @@
+package verification
+
+import (
+	"context"
+	database "core-auth/db"
+	"core-auth/internal/testutil"
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// newTestResetter returns a password resetter and an active account
+func newTestResetter(t *testing.T) (*PasswordResetter, *recordingMailer, *gorm.DB) {
+	t.Helper()
+	testutil.SetSecrets(t)
+	db := testutil.NewDB(t)
+	m := &recordingMailer{}
+	if err := db.Create(&database.User{Username: "alice", Email: "alice@example.com", Password: "-", IsActive: true}).Error; err != nil {
+		t.Fatal(err)
+	}
+	return NewPasswordResetter(db, m), m, db
+}
+
+func TestResetTokenIsSingleUse(t *testing.T) {
+	r, m, _ := newTestResetter(t)
+	if err := r.Request(context.Background(), "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.messages) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(m.messages))
+	}
+	token := linkToken(t, m.messages[0])
+
+	// Looking the token up does not consume it
+	if _, err := r.Lookup(token); err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+	user, err := r.Redeem(token)
+	if err != nil {
+		t.Fatalf("redeem: %v", err)
+	}
+	if user.Username != "alice" {
+		t.Errorf("token redeemed for %q", user.Username)
+	}
+	if _, err := r.Redeem(token); !errors.Is(err, ErrInvalidResetToken) {
+		t.Errorf("second redemption error = %v, want %v", err, ErrInvalidResetToken)
+	}
+	if _, err := r.Lookup(token); !errors.Is(err, ErrInvalidResetToken) {
+		t.Errorf("lookup of a used token error = %v, want %v", err, ErrInvalidResetToken)
+	}
+}
+
+func TestResetRequestsAreRateLimited(t *testing.T) {
+	r, m, db := newTestResetter(t)
+	for i := 0; i < 3; i++ {
+		if err := r.Request(context.Background(), "alice@example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var tokens int64
+	db.Model(&database.PasswordResetToken{}).Count(&tokens)
+	if tokens != 1 || len(m.messages) != 1 {
+		t.Errorf("stored %d tokens and sent %d messages, want 1 each", tokens, len(m.messages))
+	}
+}
+
+func TestNewResetTokenSupersedesPrevious(t *testing.T) {
+	r, m, _ := newTestResetter(t)
+	r.config.PasswordReset.RequestInterval = 0
+	for i := 0; i < 2; i++ {
+		if err := r.Request(context.Background(), "alice@example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(m.messages) != 2 {
+		t.Fatalf("sent %d messages, want 2", len(m.messages))
+	}
+	if _, err := r.Redeem(linkToken(t, m.messages[0])); !errors.Is(err, ErrInvalidResetToken) {
+		t.Errorf("superseded token error = %v, want %v", err, ErrInvalidResetToken)
+	}
+	if _, err := r.Redeem(linkToken(t, m.messages[1])); err != nil {
+		t.Errorf("latest token: %v", err)
+	}
+}
+
+func TestResetRequestForUnknownAddressSendsNothing(t *testing.T) {
+	r, m, _ := newTestResetter(t)
+	if err := r.Request(context.Background(), "nobody@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.messages) != 0 {
+		t.Errorf("sent %d messages to an unknown address", len(m.messages))
+	}
+}
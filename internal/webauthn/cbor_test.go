@@ -0,0 +1,150 @@
+package webauthn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// cborPair is a key and value of a cborMap
+type cborPair struct {
+	key   interface{}
+	value interface{}
+}
+
+// cborMap is a CBOR map encoded with its pairs in order
+type cborMap []cborPair
+
+// encodeCBOR encodes the values used by WebAuthn, the inverse of decodeCBOR
+func encodeCBOR(v interface{}) []byte {
+	switch v := v.(type) {
+	case int:
+		return encodeCBOR(int64(v))
+	case int64:
+		if v < 0 {
+			return cborHead(1, uint64(-1-v))
+		}
+		return cborHead(0, uint64(v))
+	case []byte:
+		return append(cborHead(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHead(3, uint64(len(v))), v...)
+	case []interface{}:
+		b := cborHead(4, uint64(len(v)))
+		for _, item := range v {
+			b = append(b, encodeCBOR(item)...)
+		}
+		return b
+	case cborMap:
+		b := cborHead(5, uint64(len(v)))
+		for _, pair := range v {
+			b = append(b, encodeCBOR(pair.key)...)
+			b = append(b, encodeCBOR(pair.value)...)
+		}
+		return b
+	case bool:
+		if v {
+			return []byte{0xf5}
+		}
+		return []byte{0xf4}
+	case nil:
+		return []byte{0xf6}
+	}
+	panic("unsupported CBOR value")
+}
+
+// cborHead encodes the head of a CBOR item with the shortest argument
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= 0xff:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	case n <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	}
+	return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, n)
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want interface{}
+	}{
+		{"small int", []byte{0x17}, int64(23)},
+		{"one byte int", []byte{0x18, 0x18}, int64(24)},
+		{"two byte int", []byte{0x19, 0x01, 0x00}, int64(256)},
+		{"four byte int", []byte{0x1a, 0x00, 0x01, 0x00, 0x00}, int64(65536)},
+		{"eight byte int", []byte{0x1b, 0, 0, 0, 1, 0, 0, 0, 0}, int64(1 << 32)},
+		{"negative int", []byte{0x26}, int64(-7)},
+		{"two byte negative int", []byte{0x39, 0x01, 0x00}, int64(-257)},
+		{"byte string", []byte{0x43, 1, 2, 3}, []byte{1, 2, 3}},
+		{"text string", []byte{0x64, 'f', 'm', 't', '!'}, "fmt!"},
+		{"array", []byte{0x82, 0x01, 0x20}, []interface{}{int64(1), int64(-1)}},
+		{"map", []byte{0xa2, 0x01, 0x02, 0x61, 'a', 0xf5}, map[interface{}]interface{}{int64(1): int64(2), "a": true}},
+		{"false", []byte{0xf4}, false},
+		{"null", []byte{0xf6}, nil},
+		{"undefined", []byte{0xf7}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, n, err := decodeCBOR(tt.data)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if n != len(tt.data) {
+				t.Errorf("decoded %d bytes, want %d", n, len(tt.data))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decoded %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeCBORReturnsLengthOfFirstItem(t *testing.T) {
+	data := append(encodeCBOR(cborMap{{int64(1), []byte{0xaa}}}), 0xff, 0xff)
+	_, n, err := decodeCBOR(data)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if n != len(data)-2 {
+		t.Errorf("decoded %d bytes, want %d", n, len(data)-2)
+	}
+}
+
+func TestDecodeCBORRejectsMalformedData(t *testing.T) {
+	nested := bytes.Repeat([]byte{0x81}, maxCBORDepth+2)
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"truncated argument", []byte{0x19, 0x01}},
+		{"truncated byte string", []byte{0x45, 1, 2}},
+		{"text string longer than data", []byte{0x7a, 0xff, 0xff, 0xff, 0xff, 'a'}},
+		{"array longer than data", []byte{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"map longer than data", []byte{0xa3, 0x01, 0x02}},
+		{"missing map value", []byte{0xa1, 0x01}},
+		{"array map key", []byte{0xa1, 0x80, 0x01}},
+		{"indefinite length", []byte{0x5f, 0x41, 0x00, 0xff}},
+		{"reserved argument", []byte{0x1c}},
+		{"unsigned overflow", []byte{0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"negative overflow", []byte{0x3b, 0x80, 0, 0, 0, 0, 0, 0, 0}},
+		{"tag", []byte{0xc0, 0x60}},
+		{"float", []byte{0xf9, 0x3c, 0x00}},
+		{"too deeply nested", append(nested, 0x00)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := decodeCBOR(tt.data); !errors.Is(err, errInvalidCBOR) {
+				t.Errorf("error = %v, want %v", err, errInvalidCBOR)
+			}
+		})
+	}
+}
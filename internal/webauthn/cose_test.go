@@ -0,0 +1,160 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+// ec2Key encodes a P-256 public key as an ES256 COSE_Key
+func ec2Key(key *ecdsa.PublicKey) []byte {
+	return encodeCBOR(cborMap{
+		{int64(coseKeyType), int64(coseKtyEC2)},
+		{int64(coseKeyAlg), int64(AlgES256)},
+		{int64(coseCurve), int64(coseCrvP256)},
+		{int64(coseX), key.X.FillBytes(make([]byte, 32))},
+		{int64(coseY), key.Y.FillBytes(make([]byte, 32))},
+	})
+}
+
+func TestPublicKeyVerifiesSignatures(t *testing.T) {
+	data := []byte("authenticator data and client data hash")
+	digest := sha256.Sum256(data)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecSig, err := ecdsa.SignASN1(rand.Reader, ecKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaSig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		key  []byte
+		sig  []byte
+	}{
+		{"ES256", ec2Key(&ecKey.PublicKey), ecSig},
+		{"EdDSA", encodeCBOR(cborMap{
+			{int64(coseKeyType), int64(coseKtyOKP)},
+			{int64(coseKeyAlg), int64(AlgEdDSA)},
+			{int64(coseCurve), int64(coseCrvEd25519)},
+			{int64(coseX), []byte(edPublic)},
+		}), ed25519.Sign(edPrivate, data)},
+		{"RS256", encodeCBOR(cborMap{
+			{int64(coseKeyType), int64(coseKtyRSA)},
+			{int64(coseKeyAlg), int64(AlgRS256)},
+			{int64(coseRSAN), rsaKey.N.Bytes()},
+			{int64(coseRSAE), big.NewInt(int64(rsaKey.E)).Bytes()},
+		}), rsaSig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := parsePublicKey(tt.key)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if !key.verify(data, tt.sig) {
+				t.Error("valid signature rejected")
+			}
+			if key.verify([]byte("other data"), tt.sig) {
+				t.Error("signature of other data accepted")
+			}
+			tampered := append([]byte(nil), tt.sig...)
+			tampered[len(tampered)/2] ^= 0x01
+			if key.verify(data, tampered) {
+				t.Error("tampered signature accepted")
+			}
+		})
+	}
+}
+
+func TestParsePublicKeyRejectsUnsupportedKeys(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := ecKey.X.FillBytes(make([]byte, 32))
+	offCurve := new(big.Int).Add(ecKey.Y, big.NewInt(1)).FillBytes(make([]byte, 32))
+	shortRSA, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		key  []byte
+	}{
+		{"not a map", encodeCBOR([]interface{}{int64(1)})},
+		{"unknown key type", encodeCBOR(cborMap{{int64(coseKeyType), int64(4)}, {int64(coseKeyAlg), int64(AlgES256)}})},
+		{"algorithm of another key type", encodeCBOR(cborMap{
+			{int64(coseKeyType), int64(coseKtyEC2)},
+			{int64(coseKeyAlg), int64(AlgRS256)},
+		})},
+		{"other curve", encodeCBOR(cborMap{
+			{int64(coseKeyType), int64(coseKtyEC2)},
+			{int64(coseKeyAlg), int64(AlgES256)},
+			{int64(coseCurve), int64(2)},
+			{int64(coseX), x},
+			{int64(coseY), ecKey.Y.FillBytes(make([]byte, 32))},
+		})},
+		{"point not on the curve", encodeCBOR(cborMap{
+			{int64(coseKeyType), int64(coseKtyEC2)},
+			{int64(coseKeyAlg), int64(AlgES256)},
+			{int64(coseCurve), int64(coseCrvP256)},
+			{int64(coseX), x},
+			{int64(coseY), offCurve},
+		})},
+		{"short coordinate", encodeCBOR(cborMap{
+			{int64(coseKeyType), int64(coseKtyEC2)},
+			{int64(coseKeyAlg), int64(AlgES256)},
+			{int64(coseCurve), int64(coseCrvP256)},
+			{int64(coseX), x[1:]},
+			{int64(coseY), ecKey.Y.FillBytes(make([]byte, 32))},
+		})},
+		{"short Ed25519 key", encodeCBOR(cborMap{
+			{int64(coseKeyType), int64(coseKtyOKP)},
+			{int64(coseKeyAlg), int64(AlgEdDSA)},
+			{int64(coseCurve), int64(coseCrvEd25519)},
+			{int64(coseX), make([]byte, 31)},
+		})},
+		{"RSA key under 2048 bits", encodeCBOR(cborMap{
+			{int64(coseKeyType), int64(coseKtyRSA)},
+			{int64(coseKeyAlg), int64(AlgRS256)},
+			{int64(coseRSAN), shortRSA.N.Bytes()},
+			{int64(coseRSAE), big.NewInt(int64(shortRSA.E)).Bytes()},
+		})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parsePublicKey(tt.key); !errors.Is(err, ErrUnsupportedKey) {
+				t.Errorf("error = %v, want %v", err, ErrUnsupportedKey)
+			}
+		})
+	}
+	if _, err := parsePublicKey([]byte{0xa1, 0x01}); !errors.Is(err, errInvalidCBOR) {
+		t.Errorf("malformed key error = %v, want %v", err, errInvalidCBOR)
+	}
+}
@@ -0,0 +1,388 @@
+// Package webauthn verifies WebAuthn registration and authentication ceremonies (W3C Web
+// Authentication Level 2). Attestation "none" is requested and attestation statements are not
+// verified, so credentials are trusted for the account that registered them only.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Authenticator data flags (section 6.1)
+const (
+	flagUserPresent         = 0x01
+	flagUserVerified        = 0x04
+	flagAttestedCredential  = 0x40
+	authDataMinLength       = 37
+	credentialIDMaxLength   = 1023
+	challengeSize           = 32
+	publicKeyCredentialType = "public-key"
+	clientDataTypeCreate    = "webauthn.create"
+	clientDataTypeGet       = "webauthn.get"
+)
+
+// User verification requirements
+const (
+	UserVerificationRequired  = "required"
+	UserVerificationPreferred = "preferred"
+)
+
+var (
+	// ErrInvalidResponse is returned for ceremony responses that do not verify
+	ErrInvalidResponse = errors.New("invalid WebAuthn response")
+	// ErrSignCountRegressed is returned when an authenticator's signature counter did not
+	// increase, a sign that the credential was cloned
+	ErrSignCountRegressed = errors.New("authenticator signature counter did not increase")
+)
+
+// RelyingParty runs ceremonies for the passkeys of one domain
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+	Timeout time.Duration
+}
+
+// CredentialDescriptor identifies a credential in ceremony options
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+// CreationOptions are the options of navigator.credentials.create, in their JSON form
+type CreationOptions struct {
+	RP struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+	} `json:"user"`
+	Challenge        string `json:"challenge"`
+	PubKeyCredParams []struct {
+		Type string `json:"type"`
+		Alg  int64  `json:"alg"`
+	} `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"` // in milliseconds
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection struct {
+		ResidentKey      string `json:"residentKey"`
+		UserVerification string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+	Attestation string `json:"attestation"`
+}
+
+// RequestOptions are the options of navigator.credentials.get, in their JSON form
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int64                  `json:"timeout"` // in milliseconds
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// AttestationResponse is a registration ceremony's PublicKeyCredential, in its JSON form
+type AttestationResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string   `json:"clientDataJSON"`
+		AttestationObject string   `json:"attestationObject"`
+		Transports        []string `json:"transports"`
+	} `json:"response"`
+}
+
+// AssertionResponse is an authentication ceremony's PublicKeyCredential, in its JSON form
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle"`
+	} `json:"response"`
+}
+
+// Credential is a registered public key credential
+type Credential struct {
+	ID         []byte
+	PublicKey  []byte // COSE_Key
+	SignCount  uint32
+	AAGUID     []byte
+	Transports []string
+}
+
+// clientData is the client data collected by the browser (section 5.8.1)
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// authenticatorData is the parsed authenticator data (section 6.1)
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	aaguid       []byte
+	credentialID []byte
+	publicKey    []byte
+}
+
+// NewChallenge returns a random ceremony challenge
+func NewChallenge() (string, error) {
+	b := make([]byte, challengeSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return EncodeID(b), nil
+}
+
+// EncodeID encodes binary ceremony values the way browsers serialize them
+func EncodeID(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeID decodes a base64url value, with or without padding
+func DecodeID(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// CreationOptions returns the options registering a credential for a user, excluding the
+// credentials the user already has. Discoverable credentials are preferred so that the
+// credential can also log in without a password.
+func (rp *RelyingParty) CreationOptions(challenge string, userHandle []byte, name string, exclude []CredentialDescriptor) *CreationOptions {
+	opts := &CreationOptions{
+		Challenge:          challenge,
+		Timeout:            rp.Timeout.Milliseconds(),
+		ExcludeCredentials: exclude,
+		Attestation:        "none",
+	}
+	opts.RP.ID = rp.ID
+	opts.RP.Name = rp.Name
+	opts.User.ID = EncodeID(userHandle)
+	opts.User.Name = name
+	opts.User.DisplayName = name
+	for _, alg := range SupportedAlgorithms {
+		opts.PubKeyCredParams = append(opts.PubKeyCredParams, struct {
+			Type string `json:"type"`
+			Alg  int64  `json:"alg"`
+		}{publicKeyCredentialType, alg})
+	}
+	opts.AuthenticatorSelection.ResidentKey = "preferred"
+	opts.AuthenticatorSelection.UserVerification = UserVerificationPreferred
+	if opts.ExcludeCredentials == nil {
+		opts.ExcludeCredentials = []CredentialDescriptor{}
+	}
+	return opts
+}
+
+// RequestOptions returns the options asserting one of the allowed credentials, any
+// discoverable credential when none are listed
+func (rp *RelyingParty) RequestOptions(challenge string, allow []CredentialDescriptor, userVerification string) *RequestOptions {
+	if allow == nil {
+		allow = []CredentialDescriptor{}
+	}
+	return &RequestOptions{
+		Challenge:        challenge,
+		Timeout:          rp.Timeout.Milliseconds(),
+		RPID:             rp.ID,
+		AllowCredentials: allow,
+		UserVerification: userVerification,
+	}
+}
+
+// ChallengeOf returns the challenge a response was made for, so that the ceremony it belongs
+// to can be found before the response is verified
+func ChallengeOf(clientDataJSON string) (string, error) {
+	raw, err := DecodeID(clientDataJSON)
+	if err != nil {
+		return "", ErrInvalidResponse
+	}
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil || cd.Challenge == "" {
+		return "", ErrInvalidResponse
+	}
+	return cd.Challenge, nil
+}
+
+// VerifyRegistration verifies the response of a registration ceremony (section 7.1) and
+// returns the new credential
+func (rp *RelyingParty) VerifyRegistration(challenge string, resp *AttestationResponse, requireUV bool) (*Credential, error) {
+	if resp.Type != publicKeyCredentialType {
+		return nil, ErrInvalidResponse
+	}
+	if _, err := rp.verifyClientData(resp.Response.ClientDataJSON, clientDataTypeCreate, challenge); err != nil {
+		return nil, err
+	}
+
+	raw, err := DecodeID(resp.Response.AttestationObject)
+	if err != nil {
+		return nil, ErrInvalidResponse
+	}
+	v, _, err := decodeCBOR(raw)
+	if err != nil {
+		return nil, ErrInvalidResponse
+	}
+	attestation, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrInvalidResponse
+	}
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, ErrInvalidResponse
+	}
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyAuthenticatorData(authData, requireUV); err != nil {
+		return nil, err
+	}
+	if authData.flags&flagAttestedCredential == 0 {
+		return nil, ErrInvalidResponse
+	}
+	if _, err := parsePublicKey(authData.publicKey); err != nil {
+		return nil, err
+	}
+	if rawID, err := DecodeID(resp.RawID); err != nil || !bytes.Equal(rawID, authData.credentialID) {
+		return nil, ErrInvalidResponse
+	}
+
+	return &Credential{
+		ID:         authData.credentialID,
+		PublicKey:  authData.publicKey,
+		SignCount:  authData.signCount,
+		AAGUID:     authData.aaguid,
+		Transports: resp.Response.Transports,
+	}, nil
+}
+
+// VerifyAssertion verifies the response of an authentication ceremony (section 7.2) made with
+// a registered credential and returns the authenticator's new signature counter
+func (rp *RelyingParty) VerifyAssertion(challenge string, resp *AssertionResponse, credential *Credential, requireUV bool) (uint32, error) {
+	if resp.Type != publicKeyCredentialType {
+		return 0, ErrInvalidResponse
+	}
+	clientDataJSON, err := rp.verifyClientData(resp.Response.ClientDataJSON, clientDataTypeGet, challenge)
+	if err != nil {
+		return 0, err
+	}
+
+	rawAuthData, err := DecodeID(resp.Response.AuthenticatorData)
+	if err != nil {
+		return 0, ErrInvalidResponse
+	}
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return 0, err
+	}
+	if err := rp.verifyAuthenticatorData(authData, requireUV); err != nil {
+		return 0, err
+	}
+
+	key, err := parsePublicKey(credential.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	sig, err := DecodeID(resp.Response.Signature)
+	if err != nil {
+		return 0, ErrInvalidResponse
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	if !key.verify(append(rawAuthData, clientDataHash[:]...), sig) {
+		return 0, ErrInvalidResponse
+	}
+
+	// Authenticators without a counter always report zero
+	if (authData.signCount != 0 || credential.SignCount != 0) && authData.signCount <= credential.SignCount {
+		return 0, ErrSignCountRegressed
+	}
+	return authData.signCount, nil
+}
+
+// verifyClientData checks the type, challenge and origin of a response's client data and
+// returns its raw JSON
+func (rp *RelyingParty) verifyClientData(encoded string, ceremony string, challenge string) ([]byte, error) {
+	raw, err := DecodeID(encoded)
+	if err != nil {
+		return nil, ErrInvalidResponse
+	}
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return nil, ErrInvalidResponse
+	}
+	if cd.Type != ceremony || subtle.ConstantTimeCompare([]byte(cd.Challenge), []byte(challenge)) != 1 {
+		return nil, ErrInvalidResponse
+	}
+	for _, origin := range rp.Origins {
+		if cd.Origin == origin {
+			return raw, nil
+		}
+	}
+	return nil, ErrInvalidResponse
+}
+
+// verifyAuthenticatorData checks that authenticator data is scoped to the relying party and
+// that the user was present, and verified when required
+func (rp *RelyingParty) verifyAuthenticatorData(authData *authenticatorData, requireUV bool) error {
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if subtle.ConstantTimeCompare(authData.rpIDHash, rpIDHash[:]) != 1 {
+		return ErrInvalidResponse
+	}
+	if authData.flags&flagUserPresent == 0 {
+		return ErrInvalidResponse
+	}
+	if requireUV && authData.flags&flagUserVerified == 0 {
+		return ErrInvalidResponse
+	}
+	return nil
+}
+
+// parseAuthenticatorData parses authenticator data and its attested credential data
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < authDataMinLength {
+		return nil, ErrInvalidResponse
+	}
+	authData := &authenticatorData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	if authData.flags&flagAttestedCredential == 0 {
+		return authData, nil
+	}
+
+	rest := data[authDataMinLength:]
+	if len(rest) < 18 {
+		return nil, ErrInvalidResponse
+	}
+	authData.aaguid = rest[:16]
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if idLength > credentialIDMaxLength || len(rest) < idLength {
+		return nil, ErrInvalidResponse
+	}
+	authData.credentialID = rest[:idLength]
+	rest = rest[idLength:]
+	_, n, err := decodeCBOR(rest)
+	if err != nil {
+		return nil, ErrInvalidResponse
+	}
+	authData.publicKey = rest[:n]
+	return authData, nil
+}
@@ -0,0 +1,196 @@
+package webauthn_test
+
+import (
+	"core-auth/internal/testutil"
+	"core-auth/internal/webauthn"
+	"errors"
+	"testing"
+	"time"
+)
+
+const testOrigin = "https://auth.example.com"
+
+func newTestRelyingParty() *webauthn.RelyingParty {
+	return &webauthn.RelyingParty{ID: "auth.example.com", Name: "Example", Origins: []string{testOrigin}, Timeout: time.Minute}
+}
+
+// register registers the passkey of an authenticator and returns its credential
+func register(t *testing.T, rp *webauthn.RelyingParty, a *testutil.Authenticator) *webauthn.Credential {
+	t.Helper()
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential, err := rp.VerifyRegistration(challenge, a.Register(challenge), false)
+	if err != nil {
+		t.Fatalf("verify registration: %v", err)
+	}
+	return credential
+}
+
+func TestRegistrationAndAssertion(t *testing.T) {
+	rp := newTestRelyingParty()
+	a := testutil.NewAuthenticator(t, rp.ID, testOrigin)
+	credential := register(t, rp, a)
+	if string(credential.ID) != string(a.CredentialID) || string(credential.PublicKey) != string(a.PublicKey()) {
+		t.Fatalf("registered credential = %+v", credential)
+	}
+
+	challenge, _ := webauthn.NewChallenge()
+	resp := a.Assert(challenge)
+	if got, err := webauthn.ChallengeOf(resp.Response.ClientDataJSON); err != nil || got != challenge {
+		t.Errorf("challenge of response = %q, %v", got, err)
+	}
+	signCount, err := rp.VerifyAssertion(challenge, resp, credential, true)
+	if err != nil {
+		t.Fatalf("verify assertion: %v", err)
+	}
+	if signCount != a.SignCount {
+		t.Errorf("sign count = %d, want %d", signCount, a.SignCount)
+	}
+}
+
+func TestVerifyRegistrationRejectsInvalidResponses(t *testing.T) {
+	rp := newTestRelyingParty()
+	tests := []struct {
+		name      string
+		tamper    func(a *testutil.Authenticator, resp *webauthn.AttestationResponse, challenge string) *webauthn.AttestationResponse
+		requireUV bool
+	}{
+		{"other challenge", func(a *testutil.Authenticator, _ *webauthn.AttestationResponse, _ string) *webauthn.AttestationResponse {
+			other, _ := webauthn.NewChallenge()
+			return a.Register(other)
+		}, false},
+		{"other origin", func(a *testutil.Authenticator, _ *webauthn.AttestationResponse, challenge string) *webauthn.AttestationResponse {
+			a.Origin = "https://evil.example.com"
+			return a.Register(challenge)
+		}, false},
+		{"other relying party", func(a *testutil.Authenticator, _ *webauthn.AttestationResponse, challenge string) *webauthn.AttestationResponse {
+			a.RPID = "evil.example.com"
+			return a.Register(challenge)
+		}, false},
+		{"user not present", func(a *testutil.Authenticator, _ *webauthn.AttestationResponse, challenge string) *webauthn.AttestationResponse {
+			a.Flags = 0
+			return a.Register(challenge)
+		}, false},
+		{"user not verified", func(a *testutil.Authenticator, _ *webauthn.AttestationResponse, challenge string) *webauthn.AttestationResponse {
+			a.Flags = testutil.FlagUserPresent
+			return a.Register(challenge)
+		}, true},
+		{"raw ID of another credential", func(_ *testutil.Authenticator, resp *webauthn.AttestationResponse, _ string) *webauthn.AttestationResponse {
+			resp.RawID = webauthn.EncodeID([]byte("another credential"))
+			return resp
+		}, false},
+		{"wrong type", func(_ *testutil.Authenticator, resp *webauthn.AttestationResponse, _ string) *webauthn.AttestationResponse {
+			resp.Type = "password"
+			return resp
+		}, false},
+		{"assertion client data", func(a *testutil.Authenticator, resp *webauthn.AttestationResponse, challenge string) *webauthn.AttestationResponse {
+			resp.Response.ClientDataJSON = a.Assert(challenge).Response.ClientDataJSON
+			return resp
+		}, false},
+		{"malformed client data", func(_ *testutil.Authenticator, resp *webauthn.AttestationResponse, _ string) *webauthn.AttestationResponse {
+			resp.Response.ClientDataJSON = webauthn.EncodeID([]byte("{"))
+			return resp
+		}, false},
+		{"malformed attestation object", func(_ *testutil.Authenticator, resp *webauthn.AttestationResponse, _ string) *webauthn.AttestationResponse {
+			raw, _ := webauthn.DecodeID(resp.Response.AttestationObject)
+			resp.Response.AttestationObject = webauthn.EncodeID(raw[:len(raw)/2])
+			return resp
+		}, false},
+		{"attestation object not base64url", func(_ *testutil.Authenticator, resp *webauthn.AttestationResponse, _ string) *webauthn.AttestationResponse {
+			resp.Response.AttestationObject = "not base64url!"
+			return resp
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := testutil.NewAuthenticator(t, rp.ID, testOrigin)
+			challenge, _ := webauthn.NewChallenge()
+			resp := tt.tamper(a, a.Register(challenge), challenge)
+			if _, err := rp.VerifyRegistration(challenge, resp, tt.requireUV); err == nil {
+				t.Error("invalid registration accepted")
+			}
+		})
+	}
+}
+
+func TestVerifyAssertionRejectsInvalidResponses(t *testing.T) {
+	rp := newTestRelyingParty()
+	tests := []struct {
+		name      string
+		tamper    func(a *testutil.Authenticator, resp *webauthn.AssertionResponse, challenge string) *webauthn.AssertionResponse
+		requireUV bool
+	}{
+		{"other challenge", func(a *testutil.Authenticator, _ *webauthn.AssertionResponse, _ string) *webauthn.AssertionResponse {
+			other, _ := webauthn.NewChallenge()
+			return a.Assert(other)
+		}, false},
+		{"other origin", func(a *testutil.Authenticator, _ *webauthn.AssertionResponse, challenge string) *webauthn.AssertionResponse {
+			a.Origin = "https://evil.example.com"
+			return a.Assert(challenge)
+		}, false},
+		{"other relying party", func(a *testutil.Authenticator, _ *webauthn.AssertionResponse, challenge string) *webauthn.AssertionResponse {
+			a.RPID = "evil.example.com"
+			return a.Assert(challenge)
+		}, false},
+		{"user not verified", func(a *testutil.Authenticator, _ *webauthn.AssertionResponse, challenge string) *webauthn.AssertionResponse {
+			a.Flags = testutil.FlagUserPresent
+			return a.Assert(challenge)
+		}, true},
+		{"signed by another passkey", func(a *testutil.Authenticator, resp *webauthn.AssertionResponse, challenge string) *webauthn.AssertionResponse {
+			other := testutil.NewAuthenticator(t, a.RPID, a.Origin)
+			other.SignCount = a.SignCount
+			return other.Assert(challenge)
+		}, false},
+		{"tampered signature", func(_ *testutil.Authenticator, resp *webauthn.AssertionResponse, _ string) *webauthn.AssertionResponse {
+			sig, _ := webauthn.DecodeID(resp.Response.Signature)
+			sig[len(sig)-1] ^= 0x01
+			resp.Response.Signature = webauthn.EncodeID(sig)
+			return resp
+		}, false},
+		{"tampered authenticator data", func(_ *testutil.Authenticator, resp *webauthn.AssertionResponse, _ string) *webauthn.AssertionResponse {
+			authData, _ := webauthn.DecodeID(resp.Response.AuthenticatorData)
+			authData[len(authData)-1]++
+			resp.Response.AuthenticatorData = webauthn.EncodeID(authData)
+			return resp
+		}, false},
+		{"truncated authenticator data", func(_ *testutil.Authenticator, resp *webauthn.AssertionResponse, _ string) *webauthn.AssertionResponse {
+			authData, _ := webauthn.DecodeID(resp.Response.AuthenticatorData)
+			resp.Response.AuthenticatorData = webauthn.EncodeID(authData[:36])
+			return resp
+		}, false},
+		{"registration client data", func(a *testutil.Authenticator, resp *webauthn.AssertionResponse, challenge string) *webauthn.AssertionResponse {
+			resp.Response.ClientDataJSON = a.Register(challenge).Response.ClientDataJSON
+			return resp
+		}, false},
+		{"wrong type", func(_ *testutil.Authenticator, resp *webauthn.AssertionResponse, _ string) *webauthn.AssertionResponse {
+			resp.Type = "password"
+			return resp
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := testutil.NewAuthenticator(t, rp.ID, testOrigin)
+			credential := register(t, rp, a)
+			challenge, _ := webauthn.NewChallenge()
+			resp := tt.tamper(a, a.Assert(challenge), challenge)
+			if _, err := rp.VerifyAssertion(challenge, resp, credential, tt.requireUV); !errors.Is(err, webauthn.ErrInvalidResponse) {
+				t.Errorf("error = %v, want %v", err, webauthn.ErrInvalidResponse)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertionRejectsRegressedSignCount(t *testing.T) {
+	rp := newTestRelyingParty()
+	a := testutil.NewAuthenticator(t, rp.ID, testOrigin)
+	credential := register(t, rp, a)
+	credential.SignCount = 5
+	a.SignCount = 4 // the next assertion counts 5, no more than the stored counter
+
+	challenge, _ := webauthn.NewChallenge()
+	if _, err := rp.VerifyAssertion(challenge, a.Assert(challenge), credential, false); !errors.Is(err, webauthn.ErrSignCountRegressed) {
+		t.Errorf("error = %v, want %v", err, webauthn.ErrSignCountRegressed)
+	}
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"core-auth/api"
 	"core-auth/config"
 	"core-auth/credentials"
 	database "core-auth/db"
 	cache "core-auth/internal/cache"
+	"core-auth/internal/maintenance"
 	"log"
 	"os"
 )
@@ -52,6 +54,11 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Start background maintenance
+	if cfg.Maintenance.Enabled {
+		go maintenance.NewSweeper(db, rdb).Start(context.Background())
+	}
+
 	// Initialize routes
 	if err := api.InitRoutes(db, rdb); err != nil {
 		log.Fatal("Failed to start server:", err)